@@ -0,0 +1,347 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Bitcask: a log-structured hash table
+
+/*
+*
+Every write is appended to the active data file. The keydir, a hash map in memory, points
+every live key to the file and offset of its latest value, so a read is one map lookup and
+one ReadAt.
+
+When the active file reaches MaxFileSize it becomes read only and a new one is started.
+Old records stay in the files until Merge rewrites the live ones into new files.
+
+Opening rebuilds the keydir by reading the data files in order. Files written by Merge have
+a hint file next to them with only the keys and offsets, so they are loaded without reading
+the values.
+
+	dir/
+	  000001.data   data files, the highest number is the active one
+	  000002.data
+	  000002.hint   keydir of a merged data file
+
+The whole keydir has to fit in memory, and scans sort its keys since a hash map has no order.
+*/
+type Options struct {
+	MaxFileSize int64 // start a new data file after this many bytes
+	NoSync      bool  // don't fsync on every write
+}
+
+var DefaultOptions = Options{
+	MaxFileSize: 64 << 20,
+}
+
+var ErrEmptyKey = errors.New("bitcask: empty key")
+var ErrClosed = errors.New("bitcask: database is closed")
+
+// where the latest value of a key is
+type keydirEntry struct {
+	file     uint64
+	valuePos int64
+	vlen     uint32
+}
+
+type DB struct {
+	dir    string
+	opts   Options
+	mu     sync.Mutex
+	keydir map[string]keydirEntry
+	files  map[uint64]*os.File // every data file, open for reading
+	active uint64              // the data file being appended to
+	size   int64               // size of the active file
+	closed bool
+}
+
+func dataName(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.data", num))
+}
+
+func hintName(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.hint", num))
+}
+
+// the numbers of the files with this extension, in order
+func listFiles(dir, ext string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ext)
+		if !ok {
+			continue
+		}
+		if num, err := strconv.ParseUint(name, 10, 64); err == nil {
+			nums = append(nums, num)
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+// open or create a database in dir, nil opts means DefaultOptions
+func Open(dir string, opts *Options) (*DB, error) {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db := &DB{dir: dir, opts: *opts, keydir: map[string]keydirEntry{}, files: map[uint64]*os.File{}}
+
+	nums, err := listFiles(dir, ".data")
+	if err != nil {
+		return nil, err
+	}
+	for _, num := range nums {
+		if err := db.loadFile(num); err != nil {
+			db.closeFiles()
+			return nil, err
+		}
+	}
+
+	// keep appending to the last file, unless it was written by Merge: its hint file
+	// wouldn't have the new records
+	db.active = 1
+	if len(nums) > 0 {
+		db.active = nums[len(nums)-1]
+		if _, err := os.Stat(hintName(dir, db.active)); err == nil {
+			db.active++
+		}
+	}
+	if err := db.openActive(); err != nil {
+		db.closeFiles()
+		return nil, err
+	}
+	return db, nil
+}
+
+// add the records of a data file to the keydir, from its hint file if it has one
+func (db *DB) loadFile(num uint64) error {
+	fd, err := os.OpenFile(dataName(db.dir, num), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	db.files[num] = fd
+
+	hints, err := readHints(hintName(db.dir, num))
+	if err == nil {
+		for _, rec := range hints {
+			db.apply(num, rec)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	size, err := readRecords(fd, func(rec record) { db.apply(num, rec) })
+	if err != nil {
+		return fmt.Errorf("%s: %w", fd.Name(), err)
+	}
+	// cut off the write we crashed in
+	return fd.Truncate(size)
+}
+
+func (db *DB) apply(num uint64, rec record) {
+	if rec.deleted {
+		delete(db.keydir, string(rec.key))
+		return
+	}
+	db.keydir[string(rec.key)] = keydirEntry{file: num, valuePos: rec.valuePos(), vlen: rec.vlen}
+}
+
+func (db *DB) openActive() error {
+	fd, ok := db.files[db.active]
+	if !ok {
+		var err error
+		fd, err = os.OpenFile(dataName(db.dir, db.active), os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		db.files[db.active] = fd
+	}
+	size, err := fd.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	db.size = size
+	return nil
+}
+
+// make the active file read only and start a new one
+func (db *DB) rotate() error {
+	if err := db.files[db.active].Sync(); err != nil {
+		return err
+	}
+	db.active++
+	return db.openActive()
+}
+
+func (db *DB) closeFiles() {
+	for _, fd := range db.files {
+		fd.Close()
+	}
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	err := db.files[db.active].Sync()
+	db.closeFiles()
+	return err
+}
+
+func (db *DB) readValue(e keydirEntry) ([]byte, error) {
+	val := make([]byte, e.vlen)
+	if _, err := db.files[e.file].ReadAt(val, e.valuePos); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func (db *DB) Get(key []byte) ([]byte, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, false, ErrClosed
+	}
+	e, ok := db.keydir[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	val, err := db.readValue(e)
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+// append a record to the active file
+func (db *DB) write(key, val []byte, deleted bool) (keydirEntry, error) {
+	rec := encodeRecord(key, val, deleted)
+	if db.size > 0 && db.size+int64(len(rec)) > db.opts.MaxFileSize {
+		if err := db.rotate(); err != nil {
+			return keydirEntry{}, err
+		}
+	}
+	fd := db.files[db.active]
+	if _, err := fd.WriteAt(rec, db.size); err != nil {
+		return keydirEntry{}, err
+	}
+	if !db.opts.NoSync {
+		if err := fd.Sync(); err != nil {
+			return keydirEntry{}, err
+		}
+	}
+	e := keydirEntry{file: db.active, valuePos: db.size + HEADER_SIZE + int64(len(key)), vlen: uint32(len(val))}
+	db.size += int64(len(rec))
+	return e, nil
+}
+
+func (db *DB) Set(key, val []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	e, err := db.write(key, val, false)
+	if err != nil {
+		return err
+	}
+	db.keydir[string(key)] = e
+	return nil
+}
+
+// returns false if the key doesn't exist
+func (db *DB) Delete(key []byte) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return false, ErrClosed
+	}
+	if _, ok := db.keydir[string(key)]; !ok {
+		return false, nil
+	}
+	if _, err := db.write(key, nil, true); err != nil {
+		return false, err
+	}
+	delete(db.keydir, string(key))
+	return true, nil
+}
+
+// call fn for the keys in [start, end) in order until it returns false, nil end means no
+// upper bound. The keydir has no order so the matching keys are sorted first. The database
+// is locked during the scan, fn must not call back into it.
+func (db *DB) Scan(start, end []byte, fn func(key, val []byte) bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	var keys []string
+	for k := range db.keydir {
+		if bytes.Compare([]byte(k), start) >= 0 && (end == nil || bytes.Compare([]byte(k), end) < 0) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		val, err := db.readValue(db.keydir[k])
+		if err != nil {
+			return err
+		}
+		if !fn([]byte(k), val) {
+			break
+		}
+	}
+	return nil
+}
+
+// number of live keys
+func (db *DB) Len() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return len(db.keydir)
+}
+
+type Stats struct {
+	Keys  int
+	Files int
+	Bytes int64 // size of the data files, live and stale records
+}
+
+func (db *DB) Stats() (Stats, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s := Stats{Keys: len(db.keydir), Files: len(db.files)}
+	for _, fd := range db.files {
+		info, err := fd.Stat()
+		if err != nil {
+			return s, err
+		}
+		s.Bytes += info.Size()
+	}
+	return s, nil
+}
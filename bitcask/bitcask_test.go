@@ -0,0 +1,277 @@
+package bitcask
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Small files so that a few hundred writes use several of them
+var smallOptions = Options{MaxFileSize: 1024, NoSync: true}
+
+// Helper: Open a database in a fresh directory
+func openDB(t *testing.T, opts *Options) (*DB, string) {
+	dir := t.TempDir()
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db, dir
+}
+
+// Helper: Collect every key-value pair in [start, end)
+func scanAll(t *testing.T, db *DB, start, end []byte) map[string]string {
+	out := map[string]string{}
+	var prev string
+	err := db.Scan(start, end, func(key, val []byte) bool {
+		assert.True(t, prev < string(key), "Keys are in order")
+		prev = string(key)
+		out[string(key)] = string(val)
+		return true
+	})
+	assert.NoError(t, err)
+	return out
+}
+
+// Helper: Random sets and deletes, mirrored in the returned map
+func randomWrites(t *testing.T, db *DB, n int) map[string]string {
+	expected := map[string]string{}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%03d", rnd.Intn(200))
+		if rnd.Intn(4) == 0 {
+			_, exists := expected[key]
+			deleted, err := db.Delete([]byte(key))
+			assert.NoError(t, err)
+			assert.Equal(t, exists, deleted)
+			delete(expected, key)
+		} else {
+			val := fmt.Sprintf("val%d", i)
+			assert.NoError(t, db.Set([]byte(key), []byte(val)))
+			expected[key] = val
+		}
+	}
+	return expected
+}
+
+func dataFiles(t *testing.T, dir string) []uint64 {
+	nums, err := listFiles(dir, ".data")
+	assert.NoError(t, err)
+	return nums
+}
+
+func TestDB(t *testing.T) {
+	t.Run("Set Get Delete", func(t *testing.T) {
+		db, _ := openDB(t, nil)
+
+		assert.NoError(t, db.Set([]byte("k1"), []byte("v1")))
+		val, ok, err := db.Get([]byte("k1"))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("v1"), val)
+
+		deleted, err := db.Delete([]byte("k1"))
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		_, ok, _ = db.Get([]byte("k1"))
+		assert.False(t, ok)
+
+		deleted, err = db.Delete([]byte("k1"))
+		assert.NoError(t, err)
+		assert.False(t, deleted, "Already deleted")
+	})
+
+	t.Run("Reopen rebuilds the keydir", func(t *testing.T) {
+		db, dir := openDB(t, &smallOptions)
+		expected := randomWrites(t, db, 1000)
+		assert.Greater(t, len(dataFiles(t, dir)), 1, "Files were rotated")
+		assert.NoError(t, db.Close())
+
+		db, err := Open(dir, &smallOptions)
+		assert.NoError(t, err)
+		defer db.Close()
+		assert.Equal(t, expected, scanAll(t, db, nil, nil))
+		assert.Equal(t, len(expected), db.Len())
+	})
+
+	t.Run("Scan ranges", func(t *testing.T) {
+		db, _ := openDB(t, nil)
+		for i := 0; i < 100; i++ {
+			db.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("%d", i)))
+		}
+		db.Delete([]byte("key050"))
+
+		got := scanAll(t, db, []byte("key048"), []byte("key053"))
+		assert.Equal(t, map[string]string{"key048": "48", "key049": "49", "key051": "51", "key052": "52"}, got)
+
+		var keys []string
+		db.Scan([]byte("key090"), nil, func(key, val []byte) bool {
+			keys = append(keys, string(key))
+			return len(keys) < 3
+		})
+		assert.Equal(t, []string{"key090", "key091", "key092"}, keys, "Stops when fn returns false")
+	})
+
+	// Edge cases
+	t.Run("Empty keys", func(t *testing.T) {
+		db, _ := openDB(t, nil)
+		assert.ErrorIs(t, db.Set(nil, []byte("v")), ErrEmptyKey)
+		_, ok, err := db.Get(nil)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Closed database", func(t *testing.T) {
+		db, _ := openDB(t, nil)
+		assert.NoError(t, db.Close())
+		assert.ErrorIs(t, db.Set([]byte("k"), nil), ErrClosed)
+		_, _, err := db.Get([]byte("k"))
+		assert.ErrorIs(t, err, ErrClosed)
+	})
+
+	t.Run("Torn tail is dropped", func(t *testing.T) {
+		db, dir := openDB(t, nil)
+		db.Set([]byte("a"), []byte("1"))
+		db.Set([]byte("b"), []byte("2"))
+		db.Close()
+
+		// cut the last record in half
+		path := dataName(dir, 1)
+		info, _ := os.Stat(path)
+		assert.NoError(t, os.Truncate(path, info.Size()-1))
+
+		db, err := Open(dir, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"a": "1"}, scanAll(t, db, nil, nil))
+
+		// new writes go after the good records
+		db.Set([]byte("c"), []byte("3"))
+		db.Close()
+		db, err = Open(dir, nil)
+		assert.NoError(t, err)
+		defer db.Close()
+		assert.Equal(t, map[string]string{"a": "1", "c": "3"}, scanAll(t, db, nil, nil))
+	})
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("Merge keeps the live values", func(t *testing.T) {
+		db, dir := openDB(t, &smallOptions)
+		expected := randomWrites(t, db, 1000)
+		before := dataFiles(t, dir)
+
+		assert.NoError(t, db.Merge())
+		after := dataFiles(t, dir)
+		assert.Less(t, len(after), len(before), "Stale records are gone")
+		assert.Greater(t, after[0], before[len(before)-1], "Old files are deleted")
+		assert.Equal(t, expected, scanAll(t, db, nil, nil))
+
+		// writes after the merge win over the merged values
+		db.Set([]byte("key000"), []byte("new"))
+		expected["key000"] = "new"
+		assert.NoError(t, db.Close())
+
+		db, err := Open(dir, &smallOptions)
+		assert.NoError(t, err)
+		defer db.Close()
+		assert.Equal(t, expected, scanAll(t, db, nil, nil))
+	})
+
+	t.Run("Merged files have hint files", func(t *testing.T) {
+		db, dir := openDB(t, &smallOptions)
+		randomWrites(t, db, 300)
+		assert.NoError(t, db.Merge())
+		active := db.active
+		db.Close()
+
+		for _, num := range dataFiles(t, dir) {
+			_, err := os.Stat(hintName(dir, num))
+			if num == active {
+				assert.True(t, os.IsNotExist(err), "The active file has no hints")
+			} else {
+				assert.NoError(t, err, "file %d", num)
+			}
+		}
+	})
+
+	t.Run("Open loads hints without reading the data files", func(t *testing.T) {
+		db, dir := openDB(t, nil)
+		db.Set([]byte("k"), []byte("v"))
+		assert.NoError(t, db.Merge())
+		db.Close()
+
+		// break the data file records, the hint still points at the value
+		nums := dataFiles(t, dir)
+		path := dataName(dir, nums[0])
+		data, _ := os.ReadFile(path)
+		data[0] ^= 0xff
+		os.WriteFile(path, data, 0644)
+
+		db, err := Open(dir, nil)
+		assert.NoError(t, err)
+		defer db.Close()
+		val, ok, _ := db.Get([]byte("k"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("v"), val)
+	})
+
+	t.Run("Merge of an empty database", func(t *testing.T) {
+		db, dir := openDB(t, nil)
+		db.Set([]byte("k"), []byte("v"))
+		db.Delete([]byte("k"))
+		assert.NoError(t, db.Merge())
+		assert.Equal(t, 1, len(dataFiles(t, dir)), "Only the new active file")
+		assert.Empty(t, scanAll(t, db, nil, nil))
+	})
+
+	t.Run("Crash after each removal of an old file", func(t *testing.T) {
+		for n := 0; ; n++ {
+			db, dir := openDB(t, &smallOptions)
+			expected := randomWrites(t, db, 1000)
+
+			// the crash stops Merge after n removals
+			removed := 0
+			removeFile = func(path string) error {
+				if removed == n {
+					panic("crash")
+				}
+				removed++
+				return os.Remove(path)
+			}
+			crashed := func() (crashed bool) {
+				defer func() { crashed = recover() != nil }()
+				db.Merge()
+				return false
+			}()
+			removeFile = os.Remove
+			db.Close()
+
+			reopened, err := Open(dir, &smallOptions)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, scanAll(t, reopened, nil, nil), "crash after %d removals", n)
+			reopened.Close()
+			if !crashed {
+				break
+			}
+		}
+	})
+
+	t.Run("Corrupted hint files are rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "000001.hint")
+		hints := encodeHint(nil, []byte("k"), 1, 0)
+		assert.NoError(t, writeHints(path, hints))
+		recs, err := readHints(path)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(recs))
+
+		data, _ := os.ReadFile(path)
+		data[0] ^= 0xff
+		os.WriteFile(path, data, 0644)
+		_, err = readHints(path)
+		assert.ErrorIs(t, err, ErrCorrupted)
+	})
+}
@@ -0,0 +1,207 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"slices"
+	"sort"
+)
+
+// Merge: rewrite the live records and drop the rest
+
+/*
+*
+Every live value is copied into new data files numbered after the active file, then writes
+continue in a new active file after those, so the file order still says which record is newer.
+A crash while they are written leaves the old files and some merged ones, which hold the same
+values, so loading both gives the same keydir.
+
+The old files are deleted last, oldest first. The merged files have no tombstones, so a crash
+that deleted the file with the tombstone of a key but kept an older one with its value would
+bring the key back. Deleting in file order always leaves the newest old files, where every
+value that is left still has the records that replaced or deleted it.
+
+Each merged file gets a hint file, written to a temp file and renamed once complete:
+
+	| klen | vlen | offset | key | ... | crc32 |
+	|  4B  |  4B  |   8B   | ... | ... |  4B   |
+*/
+
+func encodeHint(out []byte, key []byte, vlen uint32, offset int64) []byte {
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(key)))
+	out = binary.LittleEndian.AppendUint32(out, vlen)
+	out = binary.LittleEndian.AppendUint64(out, uint64(offset))
+	return append(out, key...)
+}
+
+func readHints(path string) ([]record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ErrCorrupted
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, ErrCorrupted
+	}
+	var recs []record
+	for len(body) > 0 {
+		if len(body) < 16 {
+			return nil, ErrCorrupted
+		}
+		klen := binary.LittleEndian.Uint32(body[0:])
+		vlen := binary.LittleEndian.Uint32(body[4:])
+		offset := int64(binary.LittleEndian.Uint64(body[8:]))
+		body = body[16:]
+		if uint64(len(body)) < uint64(klen) {
+			return nil, ErrCorrupted
+		}
+		recs = append(recs, record{key: body[:klen], offset: offset, vlen: vlen})
+		body = body[klen:]
+	}
+	return recs, nil
+}
+
+func writeHints(path string, hints []byte) error {
+	hints = binary.LittleEndian.AppendUint32(hints, crc32.ChecksumIEEE(hints))
+	tmp := path + ".tmp"
+	fd, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(hints); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// a data file being written by Merge
+type mergeFile struct {
+	num   uint64
+	fd    *os.File
+	size  int64
+	hints []byte
+}
+
+func (mf *mergeFile) finish(dir string) error {
+	if err := mf.fd.Sync(); err != nil {
+		return err
+	}
+	return writeHints(hintName(dir, mf.num), mf.hints)
+}
+
+// rewrite the live records into new files and delete the old ones
+func (db *DB) Merge() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+
+	keys := make([]string, 0, len(db.keydir))
+	for k := range db.keydir {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	keydir := make(map[string]keydirEntry, len(keys))
+	var merged []*mergeFile
+	var cur *mergeFile
+	next := db.active + 1
+	abort := func() {
+		for _, mf := range merged {
+			mf.fd.Close()
+			os.Remove(mf.fd.Name())
+			os.Remove(hintName(db.dir, mf.num))
+		}
+	}
+
+	for _, k := range keys {
+		val, err := db.readValue(db.keydir[k])
+		if err != nil {
+			abort()
+			return err
+		}
+		rec := encodeRecord([]byte(k), val, false)
+		if cur == nil || (cur.size > 0 && cur.size+int64(len(rec)) > db.opts.MaxFileSize) {
+			if cur != nil {
+				if err := cur.finish(db.dir); err != nil {
+					abort()
+					return err
+				}
+			}
+			fd, err := os.OpenFile(dataName(db.dir, next), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				abort()
+				return err
+			}
+			cur = &mergeFile{num: next, fd: fd}
+			merged = append(merged, cur)
+			next++
+		}
+		if _, err := cur.fd.WriteAt(rec, cur.size); err != nil {
+			abort()
+			return err
+		}
+		keydir[k] = keydirEntry{file: cur.num, valuePos: cur.size + HEADER_SIZE + int64(len(k)), vlen: uint32(len(val))}
+		cur.hints = encodeHint(cur.hints, []byte(k), uint32(len(val)), cur.size)
+		cur.size += int64(len(rec))
+	}
+	if cur != nil {
+		if err := cur.finish(db.dir); err != nil {
+			abort()
+			return err
+		}
+	}
+	if err := syncDir(db.dir); err != nil {
+		abort()
+		return err
+	}
+
+	// switch to the merged files, then drop the old ones
+	old := db.files
+	db.files = map[uint64]*os.File{}
+	for _, mf := range merged {
+		db.files[mf.num] = mf.fd
+	}
+	db.keydir = keydir
+	db.active = next
+	if err := db.openActive(); err != nil {
+		return err
+	}
+	nums := make([]uint64, 0, len(old))
+	for num, fd := range old {
+		fd.Close()
+		nums = append(nums, num)
+	}
+	slices.Sort(nums)
+	for _, num := range nums {
+		removeFile(dataName(db.dir, num))
+		removeFile(hintName(db.dir, num))
+	}
+	return syncDir(db.dir)
+}
+
+// deletes the old files of a merge, tests replace it to crash in the middle
+var removeFile = os.Remove
+
+// make renames and deletes durable
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
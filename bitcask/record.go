@@ -0,0 +1,88 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Data file records
+
+/*
+*
+Record format:
+
+	| crc32 | flag | klen | vlen | key | val |
+	|  4B   |  1B  |  4B  |  4B  | ... | ... |
+
+crc32 covers everything after itself. A delete is a record with FLAG_DELETE and no value,
+it hides the older records of the key until a merge drops them all.
+*/
+const HEADER_SIZE = 13
+
+const (
+	FLAG_PUT    = 0
+	FLAG_DELETE = 1
+)
+
+var ErrCorrupted = errors.New("bitcask: corrupted file")
+
+func encodeRecord(key, val []byte, deleted bool) []byte {
+	rec := make([]byte, HEADER_SIZE, HEADER_SIZE+len(key)+len(val))
+	if deleted {
+		rec[4] = FLAG_DELETE
+	}
+	binary.LittleEndian.PutUint32(rec[5:], uint32(len(key)))
+	binary.LittleEndian.PutUint32(rec[9:], uint32(len(val)))
+	rec = append(rec, key...)
+	rec = append(rec, val...)
+	binary.LittleEndian.PutUint32(rec[0:], crc32.ChecksumIEEE(rec[4:]))
+	return rec
+}
+
+type record struct {
+	key     []byte
+	deleted bool
+	offset  int64 // where the record starts in the file
+	vlen    uint32
+}
+
+// the value comes right after the header and the key
+func (r record) valuePos() int64 {
+	return r.offset + HEADER_SIZE + int64(len(r.key))
+}
+
+// read records from a data file, stop at the end or at the first torn or corrupted record.
+// Returns the size of the good part of the file.
+func readRecords(r io.Reader, fn func(rec record)) (int64, error) {
+	br := bufio.NewReader(r)
+	var offset int64
+	header := make([]byte, HEADER_SIZE)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, nil
+			}
+			return offset, err
+		}
+		klen := binary.LittleEndian.Uint32(header[5:])
+		vlen := binary.LittleEndian.Uint32(header[9:])
+		body := make([]byte, int(klen)+int(vlen))
+		if _, err := io.ReadFull(br, body); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return offset, nil
+			}
+			return offset, err
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.LittleEndian.Uint32(header[0:]) {
+			return offset, nil
+		}
+		fn(record{key: body[:klen], deleted: header[4] == FLAG_DELETE, offset: offset, vlen: vlen})
+		offset += HEADER_SIZE + int64(len(body))
+	}
+}
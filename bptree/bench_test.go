@@ -0,0 +1,75 @@
+package bptree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// Benchmarks, run with: go test ./bptree -run '^$' -bench . -benchmem
+
+/*
+*
+Keys are ints and the fanout is the MAX_SIZE constant, so unlike db there are no key size
+or fanout variants. The tree holds at most MAX_SIZE * MAX_SIZE keys when they are inserted
+in ascending order, the benchmarks work on trees of that size. There is no Delete to measure.
+*/
+const BENCH_KEYS = MAX_SIZE * MAX_SIZE
+
+// Helper: A full tree with the keys 0, 10, 20...
+func benchTree(b *testing.B) *BpTreeRootNode {
+	tree := NewBpTree()
+	for i := 0; i < BENCH_KEYS; i++ {
+		if err := tree.Insert(i*10, "value"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return tree
+}
+
+// fills a new tree every BENCH_KEYS inserts
+func BenchmarkInsert(b *testing.B) {
+	tree := NewBpTree()
+	for i := 0; i < b.N; i++ {
+		if i%BENCH_KEYS == 0 {
+			tree = NewBpTree()
+		}
+		if err := tree.Insert(i%BENCH_KEYS, "value"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	tree := benchTree(b)
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tree.Get(rnd.Intn(BENCH_KEYS) * 10); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	tree := benchTree(b)
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := tree.Update(rnd.Intn(BENCH_KEYS)*10, "new"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// a quarter of the keys, through the Next pointers
+func BenchmarkGetRange(b *testing.B) {
+	tree := benchTree(b)
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := rnd.Intn(BENCH_KEYS-BENCH_KEYS/4) * 10
+		if _, err := tree.GetRange(start, start+BENCH_KEYS/4*10); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -114,6 +114,7 @@ func safelyManageNextBoundaryLeafNodes(leafidx, internalidx int, leafNode *BpTre
 }
 
 func (t *BpTreeRootNode) Insert(key int, val string) error {
+	defer t.debugValidate("Insert")
 	inodeidx := t.findInternalPredecessor(key)
 
 	if inodeidx == -1 {
@@ -358,6 +359,7 @@ func (t *BpTreeRootNode) GetRange(start int, end int) ([]string, error) {
 
 // TODO: We need to have update result struct whcih says this many matched, this many updated , upserted etc
 func (t *BpTreeRootNode) Update(key int, newVal string) error {
+	defer t.debugValidate("Update")
 	lnode, err := t.get_leaf_node_by_key(key)
 	if err != nil {
 		return fmt.Errorf("Could not find key=(%d)", key)
@@ -0,0 +1,117 @@
+package bptree
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+*
+ExportDOT and ExportJSON write the structure of the tree for Graphviz or other visualizers,
+PrettyPrint gets unreadable beyond a few leaves.
+
+Nodes have no IDs in memory, so they are numbered in key order: internal nodes i0, i1...
+and leaves l0, l1... across the whole tree. The JSON looks like:
+
+	{"max_size": 4, "internal_nodes": [
+	  {"id": 0, "key": 10, "leaves": [
+	    {"id": 0, "key": 10, "value": "a", "next": 1},
+	    {"id": 1, "key": 20, "value": "b", "next": null}]}]}
+
+next is the ID of the Next leaf, null for nil and -1 for a leaf that is not in the tree.
+In DOT the Next links are dashed edges.
+*/
+type exportLeaf struct {
+	ID    int    `json:"id"`
+	Key   int    `json:"key"`
+	Value string `json:"value"`
+	Next  *int   `json:"next"`
+}
+
+type exportInode struct {
+	ID     int          `json:"id"`
+	Key    int          `json:"key"`
+	Leaves []exportLeaf `json:"leaves"`
+}
+
+type exportTree struct {
+	MaxSize int           `json:"max_size"`
+	Inodes  []exportInode `json:"internal_nodes"`
+}
+
+func (t *BpTreeRootNode) export() exportTree {
+	ids := map[*BpTreeLeafNode]int{}
+	for _, inode := range t.Children {
+		for _, leaf := range inode.Children {
+			ids[leaf] = len(ids)
+		}
+	}
+
+	tree := exportTree{MaxSize: MAX_SIZE, Inodes: []exportInode{}}
+	for i, inode := range t.Children {
+		in := exportInode{ID: i, Key: inode.Key, Leaves: []exportLeaf{}}
+		for _, leaf := range inode.Children {
+			l := exportLeaf{ID: ids[leaf], Key: leaf.Key, Value: leaf.Value}
+			if leaf.Next != nil {
+				next, ok := ids[leaf.Next]
+				if !ok {
+					next = -1
+				}
+				l.Next = &next
+			}
+			in.Leaves = append(in.Leaves, l)
+		}
+		tree.Inodes = append(tree.Inodes, in)
+	}
+	return tree
+}
+
+func (t *BpTreeRootNode) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t.export())
+}
+
+// escape a string for a DOT record label
+func dotLabel(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+func (t *BpTreeRootNode) ExportDOT(w io.Writer) error {
+	tree := t.export()
+	var b strings.Builder
+	b.WriteString("digraph bptree {\n\tnode [shape=record, fontname=monospace];\n")
+
+	fields := []string{"root"}
+	for _, inode := range tree.Inodes {
+		fields = append(fields, fmt.Sprintf("<c%d> %d", inode.ID, inode.Key))
+	}
+	fmt.Fprintf(&b, "\troot [label=\"%s\"];\n", strings.Join(fields, "|"))
+
+	var leaves []string
+	for _, inode := range tree.Inodes {
+		fmt.Fprintf(&b, "\ti%d [label=\"i%d|%d\"];\n", inode.ID, inode.ID, inode.Key)
+		fmt.Fprintf(&b, "\troot:c%d -> i%d;\n", inode.ID, inode.ID)
+		for _, leaf := range inode.Leaves {
+			fmt.Fprintf(&b, "\tl%d [label=\"l%d|%d = %s\"];\n", leaf.ID, leaf.ID, leaf.Key, dotLabel(leaf.Value))
+			fmt.Fprintf(&b, "\ti%d -> l%d;\n", inode.ID, leaf.ID)
+			leaves = append(leaves, fmt.Sprintf("l%d", leaf.ID))
+		}
+	}
+	for _, inode := range tree.Inodes {
+		for _, leaf := range inode.Leaves {
+			if leaf.Next != nil && *leaf.Next >= 0 {
+				fmt.Fprintf(&b, "\tl%d -> l%d [style=dashed, constraint=false];\n", leaf.ID, *leaf.Next)
+			}
+		}
+	}
+	if len(leaves) > 0 {
+		fmt.Fprintf(&b, "\t{rank=same; %s;}\n", strings.Join(leaves, "; "))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
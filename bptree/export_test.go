@@ -0,0 +1,87 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExportJSON(t *testing.T) {
+	tree := validTree()
+	var buf bytes.Buffer
+	if err := tree.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got exportTree
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	if got.MaxSize != MAX_SIZE || len(got.Inodes) != MAX_SIZE {
+		t.Fatalf("Expected %d internal nodes of max size %d, got %d and %d", MAX_SIZE, MAX_SIZE, len(got.Inodes), got.MaxSize)
+	}
+	// leaves are numbered in key order and linked by next
+	id := 0
+	for _, inode := range got.Inodes {
+		if inode.Key != inode.Leaves[0].Key {
+			t.Errorf("Internal node %d key %d, first leaf %d", inode.ID, inode.Key, inode.Leaves[0].Key)
+		}
+		for _, leaf := range inode.Leaves {
+			if leaf.ID != id || leaf.Key != id*10 {
+				t.Errorf("Leaf %d has id %d and key %d", id, leaf.ID, leaf.Key)
+			}
+			if id < MAX_SIZE*MAX_SIZE-1 && (leaf.Next == nil || *leaf.Next != id+1) {
+				t.Errorf("Leaf %d next is %v", id, leaf.Next)
+			}
+			id++
+		}
+	}
+	if !strings.Contains(buf.String(), `"next": null`) {
+		t.Errorf("The last leaf should have a null next:\n%s", buf.String())
+	}
+
+	// Edge cases
+	buf.Reset()
+	NewBpTree().ExportJSON(&buf)
+	if !strings.Contains(buf.String(), `"internal_nodes": []`) {
+		t.Errorf("Empty tree: %s", buf.String())
+	}
+
+	tree.Children[0].Children[0].Next = &BpTreeLeafNode{Key: 99}
+	got = tree.export()
+	if next := got.Inodes[0].Leaves[0].Next; next == nil || *next != -1 {
+		t.Errorf("Next outside the tree should be -1, got %v", next)
+	}
+}
+
+func TestExportDOT(t *testing.T) {
+	tree := NewBpTree()
+	tree.Insert(10, "a")
+	tree.Insert(20, `"quoted" {b|c}`)
+	var buf bytes.Buffer
+	if err := tree.ExportDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+
+	for _, want := range []string{
+		"digraph bptree {",
+		`root [label="root|<c0> 10"];`,
+		"root:c0 -> i0;",
+		`l1 [label="l1|20 = \"quoted\" \{b\|c\}"];`,
+		"l0 -> l1 [style=dashed, constraint=false];",
+		"{rank=same; l0; l1;}",
+	} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected %q in:\n%s", want, dot)
+		}
+	}
+
+	// Edge cases
+	buf.Reset()
+	NewBpTree().ExportDOT(&buf)
+	if strings.Contains(buf.String(), "rank") {
+		t.Errorf("Empty tree has no leaves:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,48 @@
+package bptree
+
+/*
+*
+Stats counts the nodes of the tree per level: the root, the internal nodes and the leaves.
+Entries are children for the root and internal nodes and KVs for the leaves, so the fill of
+a level is Entries / (Nodes * capacity), the capacity is MAX_SIZE for the root and internal
+nodes and 1 for a leaf, which holds a single KV.
+
+The root always exists, so an empty tree has a height of 1.
+*/
+type Stats struct {
+	Height int
+	Levels []LevelStats // Levels[0] is the root, the last level has the leaves
+	Keys   int
+}
+
+type LevelStats struct {
+	Nodes    int
+	Entries  int
+	Capacity int // entries a node of the level can hold
+}
+
+// average fraction of a node in use at this level
+func (l LevelStats) Fill() float64 {
+	if l.Nodes == 0 {
+		return 0
+	}
+	return float64(l.Entries) / float64(l.Nodes*l.Capacity)
+}
+
+func (t *BpTreeRootNode) Stats() Stats {
+	root := LevelStats{Nodes: 1, Entries: len(t.Children), Capacity: MAX_SIZE}
+	stats := Stats{Levels: []LevelStats{root}}
+	if len(t.Children) > 0 {
+		inodes := LevelStats{Nodes: len(t.Children), Capacity: MAX_SIZE}
+		leaves := LevelStats{Capacity: 1}
+		for _, inode := range t.Children {
+			inodes.Entries += len(inode.Children)
+			leaves.Nodes += len(inode.Children)
+		}
+		leaves.Entries = leaves.Nodes
+		stats.Levels = append(stats.Levels, inodes, leaves)
+		stats.Keys = leaves.Entries
+	}
+	stats.Height = len(stats.Levels)
+	return stats
+}
@@ -0,0 +1,51 @@
+package bptree
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	stats := validTree().Stats()
+	if stats.Height != 3 || len(stats.Levels) != 3 {
+		t.Fatalf("Expected 3 levels, got height %d and %d levels", stats.Height, len(stats.Levels))
+	}
+	if stats.Keys != MAX_SIZE*MAX_SIZE {
+		t.Errorf("Expected %d keys, got %d", MAX_SIZE*MAX_SIZE, stats.Keys)
+	}
+	// the full tree has every level full
+	for i, want := range []int{1, MAX_SIZE, MAX_SIZE * MAX_SIZE} {
+		level := stats.Levels[i]
+		if level.Nodes != want || level.Fill() != 1 {
+			t.Errorf("Level %d: expected %d full nodes, got %d with fill %v", i, want, level.Nodes, level.Fill())
+		}
+	}
+
+	t.Run("Partly filled tree", func(t *testing.T) {
+		tree := NewBpTree()
+		for _, key := range []int{10, 20, 30, 40, 50} {
+			if err := tree.Insert(key, "v"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stats := tree.Stats()
+		if stats.Keys != 5 {
+			t.Errorf("Expected 5 keys, got %d", stats.Keys)
+		}
+		// the 5th key splits the first internal node
+		if fill := stats.Levels[0].Fill(); fill != 0.5 {
+			t.Errorf("Expected the root half full, got %v", fill)
+		}
+		if inodes := stats.Levels[1]; inodes.Nodes != 2 || inodes.Fill() != 5.0/8 {
+			t.Errorf("Expected 2 internal nodes with fill 5/8, got %d with %v", inodes.Nodes, inodes.Fill())
+		}
+	})
+
+	// Edge cases
+	t.Run("Empty tree", func(t *testing.T) {
+		stats := NewBpTree().Stats()
+		if stats.Height != 1 || stats.Keys != 0 || stats.Levels[0].Fill() != 0 {
+			t.Errorf("Expected only an empty root, got %+v", stats)
+		}
+		if (LevelStats{}).Fill() != 0 {
+			t.Errorf("A level without nodes has no fill")
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// when set, Insert and Update validate the tree after the mutation and panic if it is broken
+var DEBUG = false
+
+/*
+*
+Validate checks the invariants of the tree and returns all the violations joined:
+
+  - the root and every internal node have at most MAX_SIZE children, internal nodes at least 1
+  - internal node keys are in order and each is the key of its first leaf
+  - the leaves of internal node i are sorted, >= its key and <= the key of node i+1
+  - the Next chain starts at the first leaf and visits every leaf once, in order, then nil
+
+Duplicate keys are allowed, so a run of them can straddle 2 internal nodes: that is why a leaf
+may be equal to the key of the next internal node.
+The tree always has 2 levels, so every leaf is at the same depth as long as no internal node
+is empty.
+*/
+func (t *BpTreeRootNode) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(t.Children) > MAX_SIZE {
+		fail("root has %d children, the max is %d", len(t.Children), MAX_SIZE)
+	}
+
+	var leaves []*BpTreeLeafNode // in key order
+	for i, inode := range t.Children {
+		if inode == nil {
+			fail("internal node %d is nil", i)
+			continue
+		}
+		if len(inode.Children) == 0 {
+			fail("internal node %d (key %d) has no leaves", i, inode.Key)
+			continue
+		}
+		if len(inode.Children) > MAX_SIZE {
+			fail("internal node %d (key %d) has %d leaves, the max is %d", i, inode.Key, len(inode.Children), MAX_SIZE)
+		}
+		if i > 0 && t.Children[i-1] != nil && t.Children[i-1].Key > inode.Key {
+			fail("internal node %d key %d is before the key %d of node %d", i, inode.Key, t.Children[i-1].Key, i-1)
+		}
+
+		for j, leaf := range inode.Children {
+			if leaf == nil {
+				fail("leaf %d of internal node %d is nil", j, i)
+				continue
+			}
+			if j == 0 && leaf.Key != inode.Key {
+				fail("internal node %d key %d differs from its first leaf %d", i, inode.Key, leaf.Key)
+			}
+			if leaf.Key < inode.Key {
+				fail("leaf %d key %d of internal node %d is below the node key %d", j, leaf.Key, i, inode.Key)
+			}
+			if i+1 < len(t.Children) && t.Children[i+1] != nil && leaf.Key > t.Children[i+1].Key {
+				fail("leaf %d key %d of internal node %d is above the next node key %d", j, leaf.Key, i, t.Children[i+1].Key)
+			}
+			if len(leaves) > 0 && leaves[len(leaves)-1].Key > leaf.Key {
+				fail("leaf %d key %d of internal node %d is before the previous leaf %d", j, leaf.Key, i, leaves[len(leaves)-1].Key)
+			}
+			leaves = append(leaves, leaf)
+		}
+	}
+
+	// the Next chain must be the leaves in order, a cycle shows up as a wrong leaf
+	if len(leaves) > 0 {
+		leaf := leaves[0]
+		for i := 0; i < len(leaves); i++ {
+			if leaf != leaves[i] {
+				if leaf == nil {
+					fail("Next chain ends after %d of %d leaves", i, len(leaves))
+				} else {
+					fail("Next chain position %d is the leaf with key %d, expected key %d", i, leaf.Key, leaves[i].Key)
+				}
+				break
+			}
+			leaf = leaf.Next
+		}
+		if last := leaves[len(leaves)-1]; last.Next != nil {
+			fail("the last leaf (key %d) has Next key %d instead of nil", last.Key, last.Next.Key)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// panic if DEBUG is set and the tree is broken
+func (t *BpTreeRootNode) debugValidate(op string) {
+	if !DEBUG {
+		return
+	}
+	if err := t.Validate(); err != nil {
+		panic(fmt.Sprintf("bptree: invalid tree after %s: %v", op, err))
+	}
+}
@@ -0,0 +1,162 @@
+package bptree
+
+import (
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+// every test of the package validates the tree after each Insert and Update
+func TestMain(m *testing.M) {
+	DEBUG = true
+	os.Exit(m.Run())
+}
+
+// Helper: A full tree with the keys 0, 10, 20...
+func validTree() *BpTreeRootNode {
+	tree := NewBpTree()
+	for i := 0; i < MAX_SIZE*MAX_SIZE; i++ {
+		tree.Insert(i*10, "value")
+	}
+	return tree
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("Valid trees", func(t *testing.T) {
+		if err := NewBpTree().Validate(); err != nil {
+			t.Errorf("Empty tree: %v", err)
+		}
+		if err := validTree().Validate(); err != nil {
+			t.Errorf("Full tree: %v", err)
+		}
+
+		// random inserts until full, duplicates included
+		for seed := int64(0); seed < 50; seed++ {
+			rnd := rand.New(rand.NewSource(seed))
+			tree := NewBpTree()
+			for i := 0; i < 100; i++ {
+				if tree.Insert(rnd.Intn(30), "v") != nil {
+					break
+				}
+			}
+			if err := tree.Validate(); err != nil {
+				t.Errorf("Seed %d: %v", seed, err)
+			}
+		}
+	})
+
+	tests := []struct {
+		name    string
+		corrupt func(tree *BpTreeRootNode)
+		want    string
+	}{
+		{
+			name:    "Too many internal nodes",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children = append(tree.Children, tree.Children[0]) },
+			want:    "root has 5 children",
+		},
+		{
+			name: "Too many leaves",
+			corrupt: func(tree *BpTreeRootNode) {
+				inode := tree.Children[3]
+				leaf := &BpTreeLeafNode{Key: 1000}
+				inode.lastChild().Next = leaf
+				inode.Children = append(inode.Children, leaf)
+			},
+			want: "has 5 leaves",
+		},
+		{
+			name:    "Empty internal node",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[3].Children = nil },
+			want:    "has no leaves",
+		},
+		{
+			name:    "Internal nodes out of order",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[1].Key = 200 },
+			want:    "is before the key 200",
+		},
+		{
+			name:    "Separator differs from the first leaf",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[2].Key = 75 },
+			want:    "differs from its first leaf",
+		},
+		{
+			name:    "Leaf above the next separator",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[0].Children[3].Key = 45 },
+			want:    "is above the next node key 40",
+		},
+		{
+			name:    "Leaves out of order",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[0].Children[2].Key = 5 },
+			want:    "is before the previous leaf",
+		},
+		{
+			name:    "Next skips a leaf",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[0].Children[0].Next = tree.Children[0].Children[2] },
+			want:    "Next chain position 1",
+		},
+		{
+			name:    "Next chain broken across internal nodes",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[1].lastChild().Next = nil },
+			want:    "Next chain ends after 8 of 16 leaves",
+		},
+		{
+			name:    "Next chain cycle",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[3].lastChild().Next = tree.Children[0].Children[0] },
+			want:    "the last leaf (key 150) has Next key 0",
+		},
+		{
+			name:    "Nil leaf",
+			corrupt: func(tree *BpTreeRootNode) { tree.Children[1].Children[1] = nil },
+			want:    "leaf 1 of internal node 1 is nil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := validTree()
+			tt.corrupt(tree)
+			err := tree.Validate()
+			if err == nil {
+				t.Fatalf("Expected an error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got: %v", tt.want, err)
+			}
+		})
+	}
+
+	// Edge cases
+	t.Run("Every violation is reported", func(t *testing.T) {
+		tree := validTree()
+		tree.Children[0].Key = 1
+		tree.Children[2].Key = 81
+		err := tree.Validate()
+		if err == nil || strings.Count(err.Error(), "differs from its first leaf") != 2 {
+			t.Errorf("Expected 2 violations, got: %v", err)
+		}
+	})
+
+	t.Run("Duplicates straddling internal nodes", func(t *testing.T) {
+		tree := NewBpTree()
+		for i := 0; i < MAX_SIZE*2; i++ {
+			tree.Insert(5, "dup")
+		}
+		if err := tree.Validate(); err != nil {
+			t.Errorf("Duplicates: %v", err)
+		}
+	})
+
+	t.Run("Debug mode panics after a bad mutation", func(t *testing.T) {
+		tree := validTree()
+		tree.Children[0].Children[1].Next = nil
+		defer func() {
+			r := recover()
+			if r == nil || !strings.Contains(r.(string), "invalid tree after Update") {
+				t.Errorf("Expected a panic from Update, got %v", r)
+			}
+		}()
+		tree.Update(10, "new")
+	})
+}
@@ -0,0 +1,91 @@
+// dbbackup copies a database to a backup stream and back
+//
+//	go run ./cmd/dbbackup backup data.db data.bak    # - writes to stdout
+//	go run ./cmd/dbbackup restore data.bak copy.db   # - reads from stdin
+//
+// backup copies the committed tree, restore creates a new database and refuses to
+// overwrite an existing file.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"building-a-db/db"
+)
+
+const usage = `usage:
+  dbbackup backup <database> <backup file or ->
+  dbbackup restore <backup file or -> <database>
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run a subcommand and return the exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) != 3 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var err error
+	switch args[0] {
+	case "backup":
+		err = backup(args[1], args[2], stdout)
+	case "restore":
+		err = restore(args[1], args[2], stdin)
+	default:
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "dbbackup: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func backup(path, dst string, stdout io.Writer) error {
+	// KV.Open creates missing files
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	kv := &db.KV{Path: path}
+	if err := kv.Open(); err != nil {
+		return err
+	}
+	defer kv.Close()
+
+	if dst == "-" {
+		return kv.Backup(stdout)
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	err = kv.Backup(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+func restore(src, path string, stdin io.Reader) error {
+	if src == "-" {
+		return db.Restore(stdin, path)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return db.Restore(f, path)
+}
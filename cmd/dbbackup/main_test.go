@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"building-a-db/db"
+	"building-a-db/internal/dbtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A database file with n keys
+func newTestDB(t *testing.T, n int) string {
+	return dbtest.NewFile(t, n, []byte("value"))
+}
+
+// Helper: The number of keys in a database file
+func countKeys(t *testing.T, path string) int {
+	kv := &db.KV{Path: path}
+	assert.NoError(t, kv.Open())
+	defer kv.Close()
+	n := 0
+	for iter := kv.Seek(nil, db.CMP_GT); iter.Valid(); iter.Next() {
+		n++
+	}
+	return n
+}
+
+func TestBackupRestore(t *testing.T) {
+	t.Run("Through files", func(t *testing.T) {
+		src := newTestDB(t, 200)
+		dir := t.TempDir()
+		bak, dst := filepath.Join(dir, "test.bak"), filepath.Join(dir, "copy.db")
+		stderr := &bytes.Buffer{}
+
+		assert.Equal(t, 0, run([]string{"backup", src, bak}, nil, nil, stderr), stderr.String())
+		assert.Equal(t, 0, run([]string{"restore", bak, dst}, nil, nil, stderr), stderr.String())
+		assert.Equal(t, 200, countKeys(t, dst))
+	})
+
+	t.Run("Through stdout and stdin", func(t *testing.T) {
+		src := newTestDB(t, 50)
+		dst := filepath.Join(t.TempDir(), "copy.db")
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+		assert.Equal(t, 0, run([]string{"backup", src, "-"}, nil, stdout, stderr))
+		assert.Equal(t, 0, run([]string{"restore", "-", dst}, stdout, nil, stderr))
+		assert.Equal(t, 50, countKeys(t, dst))
+	})
+
+	// Edge cases
+	t.Run("Bad usage", func(t *testing.T) {
+		stderr := &bytes.Buffer{}
+		assert.Equal(t, 2, run([]string{"backup", "a.db"}, nil, nil, stderr))
+		assert.Equal(t, 2, run([]string{"copy", "a", "b"}, nil, nil, stderr))
+		assert.Contains(t, stderr.String(), "usage:")
+	})
+
+	t.Run("Missing database is not created", func(t *testing.T) {
+		dir := t.TempDir()
+		stderr := &bytes.Buffer{}
+		assert.Equal(t, 1, run([]string{"backup", filepath.Join(dir, "missing.db"), "-"}, nil, &bytes.Buffer{}, stderr))
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Existing files are not overwritten", func(t *testing.T) {
+		src := newTestDB(t, 10)
+		stderr := &bytes.Buffer{}
+		assert.Equal(t, 1, run([]string{"backup", src, src}, nil, nil, stderr))
+		assert.Contains(t, stderr.String(), "file exists")
+
+		bak := filepath.Join(t.TempDir(), "test.bak")
+		run([]string{"backup", src, bak}, nil, nil, stderr)
+		assert.Equal(t, 1, run([]string{"restore", bak, src}, nil, nil, stderr))
+		assert.Contains(t, stderr.String(), "already exists")
+		assert.Equal(t, 10, countKeys(t, src))
+	})
+
+	t.Run("Bad backup", func(t *testing.T) {
+		stderr := &bytes.Buffer{}
+		dst := filepath.Join(t.TempDir(), "copy.db")
+		assert.Equal(t, 1, run([]string{"restore", "-", dst}, bytes.NewReader([]byte("junk")), nil, stderr))
+		assert.Contains(t, stderr.String(), "bad backup")
+		_, err := os.Stat(dst)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
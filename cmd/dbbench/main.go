@@ -0,0 +1,94 @@
+// dbbench loads a database and runs YCSB style workloads on it, reporting the throughput
+// and latency percentiles of each operation
+//
+//	go run ./cmd/dbbench -workload A,C -records 10000 -ops 10000 bench.db
+//	go run ./cmd/dbbench -engine lsm bench-lsm/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"building-a-db/kv"
+)
+
+func main() {
+	engine := flag.String("engine", "db", fmt.Sprintf("storage engine, one of %v", ENGINES))
+	workloads := flag.String("workload", "A,B,C,D,E,F", "comma separated workloads to run in order")
+	records := flag.Int("records", 10000, "keys loaded before the workloads")
+	ops := flag.Int("ops", 10000, "operations per workload")
+	valSize := flag.Int("valsize", 100, "value size in bytes")
+	scanLen := flag.Int("scanlen", 100, "max keys per scan in workload E")
+	seed := flag.Int64("seed", 1, "random seed")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dbbench [flags] [database path]")
+		names := make([]string, 0, len(WORKLOADS))
+		for name := range WORKLOADS {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "workloads: %s, see ycsb.go\n", strings.Join(names, " "))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	path := "bench.db"
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+
+	var run []string
+	for _, name := range strings.Split(*workloads, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if _, ok := WORKLOADS[name]; !ok {
+			fmt.Fprintf(os.Stderr, "dbbench: unknown workload %q\n", name)
+			os.Exit(2)
+		}
+		run = append(run, name)
+	}
+	if *records < 1 || *ops < 0 || *valSize < 0 || *scanLen < 1 {
+		fmt.Fprintln(os.Stderr, "dbbench: -records and -scanlen must be positive, -ops and -valsize not negative")
+		os.Exit(2)
+	}
+
+	e, err := openEngine(*engine, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbbench: %v\n", err)
+		os.Exit(1)
+	}
+	defer e.Close()
+
+	b := newBench(e, config{records: *records, ops: *ops, valSize: *valSize, scanLen: *scanLen, seed: *seed})
+	fmt.Printf("engine %s on %s\n\n", *engine, path)
+	res, err := b.load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dbbench: %v\n", err)
+		os.Exit(1)
+	}
+	report(os.Stdout, "load", res)
+
+	for _, name := range run {
+		res, err := b.run(WORKLOADS[name])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dbbench: workload %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Println()
+		report(os.Stdout, "workload "+name, res)
+	}
+}
+
+// the engines of kv that can run the workloads. bptree is left out: its keys are ints, not
+// the "user" keys of YCSB, and its two levels hold only bptree.MAX_SIZE^2 keys
+var ENGINES = slices.DeleteFunc(slices.Clone(kv.ENGINES), func(name string) bool { return name == "bptree" })
+
+func openEngine(name, path string) (kv.Engine, error) {
+	if !slices.Contains(ENGINES, name) {
+		return nil, fmt.Errorf("%w %q, expected one of %v", kv.ErrUnknownEngine, name, ENGINES)
+	}
+	return kv.Open(name, path)
+}
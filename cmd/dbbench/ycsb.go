@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"building-a-db/kv"
+)
+
+// YCSB style workloads
+
+/*
+*
+The core workloads of YCSB, the Yahoo! Cloud Serving Benchmark:
+
+	A  update heavy   50% read, 50% update, zipfian keys
+	B  read mostly    95% read, 5% update, zipfian keys
+	C  read only      100% read, zipfian keys
+	D  read latest    95% read, 5% insert, reads favor the newest keys
+	E  short ranges   95% scan, 5% insert, zipfian start keys, 1 to scanLen keys per scan
+	F  read-modify-write  50% read, 50% read then update of the same key, zipfian keys
+
+Zipfian keys are scrambled with a hash so the hot keys are spread over the key space instead
+of being the first keys. Keys are "user" + 12 digits, inserts append after the last key.
+*/
+type workload struct {
+	read, update, insert, scan, rmw float64 // proportions of the operations, sum to 1
+	latest                          bool    // reads favor the newest keys (D)
+}
+
+var WORKLOADS = map[string]workload{
+	"A": {read: 0.5, update: 0.5},
+	"B": {read: 0.95, update: 0.05},
+	"C": {read: 1},
+	"D": {read: 0.95, insert: 0.05, latest: true},
+	"E": {scan: 0.95, insert: 0.05},
+	"F": {read: 0.5, rmw: 0.5},
+}
+
+// operation names in report order
+var OPS = []string{"read", "update", "insert", "scan", "rmw"}
+
+// zipfian skew, YCSB uses a constant of 0.99 but rand.Zipf needs s > 1
+const ZIPF_S = 1.01
+
+type config struct {
+	records int // keys loaded before the workloads
+	ops     int // operations per workload
+	valSize int
+	scanLen int // max keys per scan
+	seed    int64
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("user%012d", i))
+}
+
+type bench struct {
+	e       kv.Engine
+	cfg     config
+	rnd     *rand.Rand
+	zipf    *rand.Zipf
+	records int // keys in the engine, grows with inserts
+	val     []byte
+}
+
+func newBench(e kv.Engine, cfg config) *bench {
+	rnd := rand.New(rand.NewSource(cfg.seed))
+	return &bench{
+		e:    e,
+		cfg:  cfg,
+		rnd:  rnd,
+		zipf: rand.NewZipf(rnd, ZIPF_S, 1, uint64(max(cfg.records-1, 0))),
+		val:  make([]byte, cfg.valSize),
+	}
+}
+
+// the latency of every operation by name
+type result struct {
+	elapsed time.Duration
+	lat     map[string][]time.Duration
+}
+
+func (r *result) count() int {
+	n := 0
+	for _, l := range r.lat {
+		n += len(l)
+	}
+	return n
+}
+
+// insert the records, every Set is timed as an insert
+func (b *bench) load() (*result, error) {
+	res := &result{lat: map[string][]time.Duration{}}
+	start := time.Now()
+	for i := 0; i < b.cfg.records; i++ {
+		if err := b.timed(res, "insert", func() error { return b.e.Set(key(i), b.randVal()) }); err != nil {
+			return nil, fmt.Errorf("load key %d: %w", i, err)
+		}
+	}
+	b.records = b.cfg.records
+	res.elapsed = time.Since(start)
+	return res, nil
+}
+
+func (b *bench) run(w workload) (*result, error) {
+	res := &result{lat: map[string][]time.Duration{}}
+	start := time.Now()
+	for i := 0; i < b.cfg.ops; i++ {
+		op := b.pick(w)
+		var err error
+		switch op {
+		case "read":
+			err = b.timed(res, op, func() error {
+				_, _, err := b.e.Get(key(b.nextKey(w)))
+				return err
+			})
+		case "update":
+			err = b.timed(res, op, func() error { return b.e.Set(key(b.nextKey(w)), b.randVal()) })
+		case "insert":
+			err = b.timed(res, op, func() error { return b.e.Set(key(b.records), b.randVal()) })
+			b.records++
+		case "scan":
+			err = b.timed(res, op, func() error {
+				n := 1 + b.rnd.Intn(b.cfg.scanLen)
+				return b.e.Scan(key(b.nextKey(w)), nil, func(key, val []byte) bool {
+					n--
+					return n > 0
+				})
+			})
+		case "rmw":
+			err = b.timed(res, op, func() error {
+				k := key(b.nextKey(w))
+				if _, _, err := b.e.Get(k); err != nil {
+					return err
+				}
+				return b.e.Set(k, b.randVal())
+			})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+	res.elapsed = time.Since(start)
+	return res, nil
+}
+
+func (b *bench) timed(res *result, op string, fn func() error) error {
+	start := time.Now()
+	if err := fn(); err != nil {
+		return err
+	}
+	res.lat[op] = append(res.lat[op], time.Since(start))
+	return nil
+}
+
+// choose an operation by the proportions of the workload
+func (b *bench) pick(w workload) string {
+	x := b.rnd.Float64()
+	for i, p := range []float64{w.read, w.update, w.insert, w.scan, w.rmw} {
+		if x < p {
+			return OPS[i]
+		}
+		x -= p
+	}
+	return "read" // rounding
+}
+
+// an existing key: scrambled zipfian, or counting back from the newest key for latest
+func (b *bench) nextKey(w workload) int {
+	if b.records == 0 {
+		return 0
+	}
+	rank := int(b.zipf.Uint64())
+	if w.latest {
+		return max(b.records-1-rank, 0)
+	}
+	h := fnv.New64a()
+	fmt.Fprint(h, rank)
+	return int(h.Sum64() % uint64(b.records))
+}
+
+func (b *bench) randVal() []byte {
+	b.rnd.Read(b.val)
+	return b.val
+}
+
+// the p-th percentile, 0 <= p <= 100, of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p / 100 * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+func report(w io.Writer, name string, res *result) {
+	n := res.count()
+	fmt.Fprintf(w, "%s: %d ops in %v, %.0f ops/s\n", name, n, res.elapsed.Round(time.Millisecond), float64(n)/res.elapsed.Seconds())
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\tcount\tp50\tp95\tp99\tp99.9\tmax\t")
+	for _, op := range OPS {
+		lat := res.lat[op]
+		if len(lat) == 0 {
+			continue
+		}
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		cols := []string{op, fmt.Sprint(len(lat))}
+		for _, p := range []float64{50, 95, 99, 99.9, 100} {
+			cols = append(cols, percentile(lat, p).String())
+		}
+		fmt.Fprintln(tw, strings.Join(cols, "\t")+"\t")
+	}
+	tw.Flush()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"building-a-db/kv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A loaded bench on an engine in memory
+func newTestBench(t *testing.T, cfg config) *bench {
+	b := newBench(kv.NewTemp(), cfg)
+	res, err := b.load()
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.records, len(res.lat["insert"]))
+	return b
+}
+
+func TestWorkloads(t *testing.T) {
+	cfg := config{records: 200, ops: 500, valSize: 10, scanLen: 10, seed: 1}
+
+	t.Run("Proportions add up", func(t *testing.T) {
+		for name, w := range WORKLOADS {
+			assert.InDelta(t, 1.0, w.read+w.update+w.insert+w.scan+w.rmw, 1e-9, "workload %s", name)
+		}
+	})
+
+	t.Run("Every workload runs its operations", func(t *testing.T) {
+		for name, w := range WORKLOADS {
+			b := newTestBench(t, cfg)
+			res, err := b.run(w)
+			assert.NoError(t, err, "workload %s", name)
+			assert.Equal(t, cfg.ops, res.count(), "workload %s", name)
+
+			// each operation shows up with roughly its proportion
+			for i, p := range []float64{w.read, w.update, w.insert, w.scan, w.rmw} {
+				got := float64(len(res.lat[OPS[i]])) / float64(cfg.ops)
+				assert.InDelta(t, p, got, 0.1, "workload %s, %s", name, OPS[i])
+			}
+		}
+	})
+
+	t.Run("Inserts add keys", func(t *testing.T) {
+		b := newTestBench(t, cfg)
+		res, err := b.run(WORKLOADS["D"])
+		assert.NoError(t, err)
+		stats, _ := b.e.Stats()
+		assert.Equal(t, cfg.records+len(res.lat["insert"]), stats.Keys)
+		assert.Equal(t, stats.Keys, b.records)
+	})
+
+	t.Run("Keys are skewed", func(t *testing.T) {
+		b := newTestBench(t, cfg)
+		counts := map[int]int{}
+		for i := 0; i < 10000; i++ {
+			k := b.nextKey(WORKLOADS["A"])
+			assert.True(t, k >= 0 && k < cfg.records)
+			counts[k]++
+		}
+		hottest := 0
+		for _, n := range counts {
+			hottest = max(hottest, n)
+		}
+		assert.Greater(t, hottest, 10000/cfg.records*5, "The hottest key is far above uniform")
+
+		// latest counts back from the newest key
+		newest := 0
+		for i := 0; i < 1000; i++ {
+			if b.nextKey(WORKLOADS["D"]) == cfg.records-1 {
+				newest++
+			}
+		}
+		assert.Greater(t, newest, 50)
+	})
+
+	t.Run("Runs on every engine", func(t *testing.T) {
+		for _, name := range ENGINES {
+			e, err := openEngine(name, filepath.Join(t.TempDir(), "bench"))
+			assert.NoError(t, err, name)
+			b := newBench(e, config{records: 20, ops: 20, valSize: 10, scanLen: 5, seed: 1})
+			_, err = b.load()
+			assert.NoError(t, err, name)
+			_, err = b.run(WORKLOADS["E"])
+			assert.NoError(t, err, name)
+			assert.NoError(t, e.Close(), name)
+		}
+	})
+
+	// Edge cases
+	t.Run("Engines that can't run the workloads", func(t *testing.T) {
+		assert.NotContains(t, ENGINES, "bptree")
+		_, err := openEngine("bptree", "")
+		assert.ErrorIs(t, err, kv.ErrUnknownEngine)
+		_, err = openEngine("nope", "")
+		assert.ErrorIs(t, err, kv.ErrUnknownEngine)
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	var lat []time.Duration
+	for i := 1; i <= 100; i++ {
+		lat = append(lat, time.Duration(i))
+	}
+	assert.Equal(t, time.Duration(1), percentile(lat, 0))
+	assert.Equal(t, time.Duration(50), percentile(lat, 50))
+	assert.Equal(t, time.Duration(99), percentile(lat, 99))
+	assert.Equal(t, time.Duration(100), percentile(lat, 100))
+
+	// Edge cases
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+	assert.Equal(t, time.Duration(7), percentile([]time.Duration{7}, 99.9))
+}
+
+func TestReport(t *testing.T) {
+	res := &result{elapsed: time.Second, lat: map[string][]time.Duration{
+		"read":   {3 * time.Millisecond, time.Millisecond, 2 * time.Millisecond},
+		"update": {time.Microsecond},
+	}}
+	out := &bytes.Buffer{}
+	report(out, "workload A", res)
+
+	assert.Contains(t, out.String(), "workload A: 4 ops in 1s, 4 ops/s")
+	assert.Contains(t, out.String(), "read")
+	assert.Contains(t, out.String(), "3ms", "Max read latency")
+	assert.NotContains(t, out.String(), "scan", "Operations that did not run are left out")
+}
@@ -0,0 +1,63 @@
+// dbcheck checks the structure of a database file and reports every problem with its page
+//
+//	go run ./cmd/dbcheck data.db
+//
+// It exits with 1 when the file can't be opened or has violations.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"building-a-db/db"
+)
+
+func main() {
+	verbose := flag.Bool("v", false, "list the pages the tree doesn't reach")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dbcheck [-v] <database file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	os.Exit(check(flag.Arg(0), *verbose, os.Stdout))
+}
+
+// check the file and return the exit code
+func check(path string, verbose bool, out io.Writer) int {
+	// KV.Open creates missing files, a checker must not
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(out, "dbcheck: %v\n", err)
+		return 1
+	}
+	kv := &db.KV{Path: path}
+	if err := kv.Open(); err != nil {
+		fmt.Fprintf(out, "dbcheck: %v\n", err)
+		return 1
+	}
+	defer kv.Close()
+
+	report := kv.Check()
+	fmt.Fprintf(out, "%s: height %d, %d pages, %d leaves, %d keys\n", path, report.Height, report.Pages, report.Leaves, report.Keys)
+	fmt.Fprintf(out, "%d pages not reached from the root, leaked by copy on write\n", len(report.Unreachable))
+	if verbose && len(report.Unreachable) > 0 {
+		fmt.Fprintf(out, "  %v\n", report.Unreachable)
+	}
+	fmt.Fprintln(out, "free list: not implemented, nothing to check")
+	fmt.Fprintln(out, "checksums: pages have none, nothing to check")
+
+	if report.OK() {
+		fmt.Fprintln(out, "OK")
+		return 0
+	}
+	fmt.Fprintf(out, "violations: %d\n", len(report.Violations))
+	for _, v := range report.Violations {
+		fmt.Fprintf(out, "  %v\n", v)
+	}
+	return 1
+}
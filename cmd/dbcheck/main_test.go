@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"building-a-db/db"
+	"building-a-db/internal/dbtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A database file with n keys
+func newTestDB(t *testing.T, n int) string {
+	return dbtest.NewFile(t, n, []byte("value"))
+}
+
+func TestCheck(t *testing.T) {
+	t.Run("Valid file", func(t *testing.T) {
+		path := newTestDB(t, 500)
+		out := &bytes.Buffer{}
+		assert.Equal(t, 0, check(path, false, out))
+		assert.Contains(t, out.String(), "500 keys")
+		assert.Contains(t, out.String(), "free list: not implemented")
+		assert.Contains(t, out.String(), "OK\n")
+	})
+
+	t.Run("Corrupt page", func(t *testing.T) {
+		path := newTestDB(t, 500)
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		// the last page written is the root, make its type invalid
+		data[len(data)-db.BTREE_PAGE_SIZE] = 9
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+
+		out := &bytes.Buffer{}
+		assert.Equal(t, 1, check(path, false, out))
+		assert.Contains(t, out.String(), "violations: 1")
+		assert.Contains(t, out.String(), "bad node type 9")
+	})
+
+	// Edge cases
+	t.Run("Missing file is not created", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing.db")
+		out := &bytes.Buffer{}
+		assert.Equal(t, 1, check(path, false, out))
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Not a database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "junk.db")
+		os.WriteFile(path, make([]byte, db.BTREE_PAGE_SIZE), 0644)
+		out := &bytes.Buffer{}
+		assert.Equal(t, 1, check(path, false, out))
+		assert.Contains(t, out.String(), "bad signature")
+	})
+}
@@ -0,0 +1,43 @@
+// dbhttp serves a database file over an HTTP/JSON API
+//
+//	go run ./cmd/dbhttp -addr :8080 data.db
+//	curl -X PUT --data world localhost:8080/kv/hello
+//	curl 'localhost:8080/scan?start=a&limit=10'
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"building-a-db/db"
+	"building-a-db/httpapi"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dbhttp [-addr host:port] [database file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	path := "data.db"
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+
+	kv := &db.KV{Path: path}
+	if err := kv.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "dbhttp: %v\n", err)
+		os.Exit(1)
+	}
+	defer kv.Close()
+
+	fmt.Printf("serving %s on %s\n", path, *addr)
+	if err := http.ListenAndServe(*addr, httpapi.NewHandler(kv)); err != nil {
+		fmt.Fprintf(os.Stderr, "dbhttp: %v\n", err)
+		os.Exit(1)
+	}
+}
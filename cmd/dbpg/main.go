@@ -0,0 +1,50 @@
+// dbpg serves a database file over the Postgres wire protocol
+//
+//	go run ./cmd/dbpg -addr :5432 data.db
+//	psql -h localhost -p 5432 -c "SELECT * FROM users"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"building-a-db/db"
+	"building-a-db/pgwire"
+)
+
+func main() {
+	addr := flag.String("addr", ":5432", "address to listen on")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dbpg [-addr host:port] [database file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	path := "data.db"
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+
+	kv := &db.KV{Path: path}
+	if err := kv.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "dbpg: %v\n", err)
+		os.Exit(1)
+	}
+	defer kv.Close()
+
+	srv := pgwire.NewServer(kv)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		srv.Close()
+	}()
+
+	fmt.Printf("serving %s on %s\n", path, *addr)
+	if err := srv.ListenAndServe(*addr); err != nil && err != pgwire.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "dbpg: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,50 @@
+// dbredis serves a database file over the Redis protocol
+//
+//	go run ./cmd/dbredis -addr :6379 data.db
+//	redis-cli -p 6379 set hello world
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"building-a-db/db"
+	"building-a-db/resp"
+)
+
+func main() {
+	addr := flag.String("addr", ":6379", "address to listen on")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dbredis [-addr host:port] [database file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	path := "data.db"
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+
+	kv := &db.KV{Path: path}
+	if err := kv.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "dbredis: %v\n", err)
+		os.Exit(1)
+	}
+	defer kv.Close()
+
+	srv := resp.NewServer(kv)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		srv.Close()
+	}()
+
+	fmt.Printf("serving %s on %s\n", path, *addr)
+	if err := srv.ListenAndServe(*addr); err != nil && err != resp.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "dbredis: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Line editing for a terminal
+
+/*
+*
+When the input is a terminal the shell puts it in raw mode and edits the line itself:
+
+  - left and right arrows, ctrl-a and ctrl-e (home and end) move the cursor
+  - backspace and delete remove a character, ctrl-u everything before the cursor
+  - up and down arrows walk the history
+  - tab completes the word before the cursor, a second tab lists the choices
+  - ctrl-c drops the line, ctrl-d on an empty line ends the input
+
+Input that is not a terminal (a file or a pipe) is read line by line without any of this.
+*/
+type lineEditor struct {
+	in  *bufio.Reader
+	out io.Writer
+	// the start of the word being completed and the words it can become
+	complete func(line string) (start int, choices []string)
+}
+
+// keys without a single control character, from the escape sequences of the terminal
+const (
+	KEY_UP = -1 - iota
+	KEY_DOWN
+	KEY_LEFT
+	KEY_RIGHT
+	KEY_HOME
+	KEY_END
+	KEY_DELETE
+	KEY_UNKNOWN
+)
+
+func newLineEditor(in io.Reader, out io.Writer, complete func(string) (int, []string)) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), out: out, complete: complete}
+}
+
+// the next key, a rune or one of the KEY_ constants
+func (e *lineEditor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != 0x1b {
+		return r, err
+	}
+	// ESC [ x, ESC O x or ESC [ n ~
+	next, _, err := e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if next != '[' && next != 'O' {
+		return KEY_UNKNOWN, nil
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch r {
+	case 'A':
+		return KEY_UP, nil
+	case 'B':
+		return KEY_DOWN, nil
+	case 'C':
+		return KEY_RIGHT, nil
+	case 'D':
+		return KEY_LEFT, nil
+	case 'H':
+		return KEY_HOME, nil
+	case 'F':
+		return KEY_END, nil
+	}
+	if r < '0' || r > '9' {
+		return KEY_UNKNOWN, nil
+	}
+	num := string(r)
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if r < '0' || r > '9' {
+			break
+		}
+		num += string(r)
+	}
+	if r != '~' {
+		return KEY_UNKNOWN, nil
+	}
+	switch num {
+	case "1", "7":
+		return KEY_HOME, nil
+	case "4", "8":
+		return KEY_END, nil
+	case "3":
+		return KEY_DELETE, nil
+	}
+	return KEY_UNKNOWN, nil
+}
+
+// the line being edited
+type editState struct {
+	prompt string
+	buf    []rune
+	pos    int // cursor, an index into buf
+}
+
+// draw the prompt and the line again and put the cursor back
+func (e *lineEditor) refresh(s *editState) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", s.prompt, string(s.buf))
+	if back := len(s.buf) - s.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func (s *editState) insert(text []rune) {
+	s.buf = append(s.buf[:s.pos], append(text, s.buf[s.pos:]...)...)
+	s.pos += len(text)
+}
+
+// read a line, history is walked with the arrows, io.EOF at the end of the input
+func (e *lineEditor) readLine(prompt string, history []string) (string, error) {
+	s := &editState{prompt: prompt}
+	hpos := len(history) // len(history) is the line being typed
+	var typed []rune     // the line being typed while the history is shown
+	lastTab := false
+	e.refresh(s)
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			if err == io.EOF && len(s.buf) > 0 {
+				break
+			}
+			return "", err
+		}
+		tab := false
+		switch key {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(s.buf), nil
+		case 0x04: // ctrl-d
+			if len(s.buf) == 0 {
+				return "", io.EOF
+			}
+			if s.pos < len(s.buf) {
+				s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+			}
+		case 0x03: // ctrl-c
+			fmt.Fprint(e.out, "^C\r\n")
+			s.buf, s.pos = nil, 0
+			hpos = len(history)
+		case 0x7f, 0x08: // backspace
+			if s.pos > 0 {
+				s.buf = append(s.buf[:s.pos-1], s.buf[s.pos:]...)
+				s.pos--
+			}
+		case KEY_DELETE:
+			if s.pos < len(s.buf) {
+				s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+			}
+		case 0x15: // ctrl-u
+			s.buf = append([]rune(nil), s.buf[s.pos:]...)
+			s.pos = 0
+		case KEY_LEFT, 0x02: // ctrl-b
+			s.pos = max(s.pos-1, 0)
+		case KEY_RIGHT, 0x06: // ctrl-f
+			s.pos = min(s.pos+1, len(s.buf))
+		case KEY_HOME, 0x01: // ctrl-a
+			s.pos = 0
+		case KEY_END, 0x05: // ctrl-e
+			s.pos = len(s.buf)
+		case KEY_UP, 0x10, KEY_DOWN, 0x0e: // ctrl-p, ctrl-n
+			next := hpos - 1
+			if key == KEY_DOWN || key == 0x0e {
+				next = hpos + 1
+			}
+			if next < 0 || next > len(history) {
+				break
+			}
+			if hpos == len(history) {
+				typed = append([]rune(nil), s.buf...)
+			}
+			hpos = next
+			if hpos == len(history) {
+				s.buf = typed
+			} else {
+				s.buf = []rune(history[hpos])
+			}
+			s.pos = len(s.buf)
+		case '\t':
+			tab = true
+			e.completeWord(s, lastTab)
+		default:
+			if key >= 0 && unicode.IsPrint(key) {
+				s.insert([]rune{key})
+			}
+		}
+		lastTab = tab
+		e.refresh(s)
+	}
+	fmt.Fprint(e.out, "\r\n")
+	return string(s.buf), nil
+}
+
+// complete the word before the cursor, or list the choices on a second tab
+func (e *lineEditor) completeWord(s *editState, list bool) {
+	if e.complete == nil {
+		return
+	}
+	start, choices := e.complete(string(s.buf[:s.pos]))
+	if len(choices) == 0 {
+		return
+	}
+	word := []rune(string(s.buf[:s.pos]))[start:]
+	common := []rune(choices[0])
+	for _, c := range choices[1:] {
+		common = commonPrefix(common, []rune(c))
+	}
+	if len(common) > len(word) {
+		s.insert(common[len(word):])
+		return
+	}
+	if list && len(choices) > 1 {
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(choices, "  "))
+	}
+}
+
+func commonPrefix(a, b []rune) []rune {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return a[:n]
+}
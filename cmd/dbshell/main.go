@@ -0,0 +1,66 @@
+// dbshell is an interactive shell to inspect and modify a database file
+//
+//	go run ./cmd/dbshell data.db
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"building-a-db/db"
+)
+
+func main() {
+	pageSize := flag.Int("pagesize", 0, "page size of a new database file, a power of 2 from 4096 to 65536 (default 4096), an existing file keeps its own")
+	order := flag.String("order", "", "key order of a new database file: bytes, numeric, nocase or reverse, an existing file keeps its own")
+	history := flag.String("history", defaultHistory(), "file of the command history, empty to keep it in memory")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dbshell [-pagesize n] [-order name] [-history file] [database file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	path := "data.db"
+	if flag.NArg() > 0 {
+		path = flag.Arg(0)
+	}
+
+	kv := &db.KV{Path: path, PageSize: *pageSize}
+	if *order != "" {
+		if kv.Comparator = db.LookupComparator(*order); kv.Comparator == nil {
+			fmt.Fprintf(os.Stderr, "dbshell: unknown order %q\n", *order)
+			os.Exit(2)
+		}
+	}
+	if err := kv.Open(); err != nil {
+		fmt.Fprintf(os.Stderr, "dbshell: %v\n", err)
+		os.Exit(1)
+	}
+	defer kv.Close()
+
+	fmt.Printf("connected to %s, type .help for the list of commands\n", path)
+	sh := newShell(kv, os.Stdout)
+	if *history != "" {
+		if err := sh.loadHistory(*history); err != nil {
+			fmt.Fprintf(os.Stderr, "dbshell: loading the history: %v\n", err)
+		}
+	}
+	// edit lines when the input is a terminal, else read them as they come
+	if restore, err := makeRaw(int(os.Stdin.Fd())); err == nil {
+		sh.runEditor(os.Stdin)
+		restore()
+	} else {
+		sh.run(os.Stdin)
+	}
+}
+
+// ~/.dbshell_history, or no file when there is no home directory
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".dbshell_history")
+}
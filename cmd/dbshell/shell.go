@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"building-a-db/db"
+	"building-a-db/docstore"
+	"building-a-db/minisql"
+)
+
+const helpText = `commands:
+  get <key>              print the value of a key
+  set <key> <value>      insert or update a key
+  del <key>              delete a key
+  scan [start [end]]     print the keys in [start, end)
+  begin                  start a transaction
+  commit                 commit the transaction
+  rollback               abort the transaction
+  vacuum [fill]          rewrite the file with only the live pages, filled up to fill (default 0.9)
+  <sql statement>        run SELECT, INSERT or DELETE, see below
+  .tables                list the SQL tables, and the docstore collections and their indexes
+  .dump                  print the database as set commands
+  .export <fmt> [file]   write the committed tree as Graphviz dot or json
+  .stats                 print the height, fill and key and value sizes of the committed tree
+  .history               print the command history
+  !<n>                   run command n of the history again
+  .help                  print this help
+  .exit                  quit
+
+keys and values containing spaces can be written as Go strings: set "a key" "a value"
+on a terminal, tab completes commands and keys and the arrows edit the line and walk the history
+
+a table is the keys under "<name>\x00" with the columns key and value:
+  INSERT INTO t VALUES ('k1', 'v1'), ('k2', 'v2')
+  SELECT * | cols FROM t [JOIN t2 ON col = col] [WHERE ...] [GROUP BY col] [HAVING ...] [LIMIT n]
+  DELETE FROM t [WHERE ...]
+`
+
+// commands for tab completion
+var commands = []string{
+	"get", "set", "del", "scan", "begin", "commit", "rollback", "vacuum",
+	".tables", ".dump", ".export", ".stats", ".history", ".help", ".exit", ".quit",
+}
+
+const (
+	MAX_HISTORY     = 1000 // lines kept in the history file
+	MAX_COMPLETIONS = 100  // keys offered by tab completion
+)
+
+// words that start an SQL statement
+var sqlKeywords = map[string]bool{
+	"select": true, "insert": true, "update": true, "delete": true,
+	"create": true, "drop": true, "alter": true,
+}
+
+type shell struct {
+	db      *db.KV
+	tx      *db.KVTX // the transaction started by begin, nil otherwise
+	out     io.Writer
+	history []string
+	// file the history is loaded from and appended to, "" keeps it in memory
+	historyPath string
+}
+
+func newShell(kv *db.KV, out io.Writer) *shell {
+	return &shell{db: kv, out: out}
+}
+
+func (sh *shell) prompt() string {
+	if sh.tx != nil {
+		return "db*> "
+	}
+	return "db> "
+}
+
+// read commands until .exit or the end of the input
+func (sh *shell) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	sh.loop(func(prompt string) (string, error) {
+		fmt.Fprint(sh.out, prompt)
+		if !scanner.Scan() {
+			return "", io.EOF
+		}
+		return scanner.Text(), nil
+	})
+}
+
+// like run, for a terminal in raw mode
+func (sh *shell) runEditor(in io.Reader) {
+	editor := newLineEditor(in, sh.out, sh.complete)
+	sh.loop(func(prompt string) (string, error) {
+		return editor.readLine(prompt, sh.history)
+	})
+}
+
+func (sh *shell) loop(readLine func(prompt string) (string, error)) {
+	for {
+		line, err := readLine(sh.prompt())
+		if err != nil {
+			fmt.Fprintln(sh.out)
+			break
+		}
+		if sh.exec(line) {
+			break
+		}
+	}
+	if sh.tx != nil {
+		sh.tx.Abort()
+		fmt.Fprintln(sh.out, "transaction rolled back")
+	}
+}
+
+// load the history of earlier sessions, commands run from now on are appended to the file
+func (sh *shell) loadHistory(path string) error {
+	sh.historyPath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			sh.history = append(sh.history, line)
+		}
+	}
+	if len(sh.history) > MAX_HISTORY {
+		// keep the file from growing forever
+		sh.history = sh.history[len(sh.history)-MAX_HISTORY:]
+		return os.WriteFile(path, []byte(strings.Join(sh.history, "\n")+"\n"), 0o600)
+	}
+	return nil
+}
+
+func (sh *shell) addHistory(line string) {
+	sh.history = append(sh.history, line)
+	if sh.historyPath == "" {
+		return
+	}
+	f, err := os.OpenFile(sh.historyPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err == nil {
+		_, err = fmt.Fprintln(f, line)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(sh.out, "error: saving the history: %v\n", err)
+		sh.historyPath = "" // don't repeat the error for every command
+	}
+}
+
+// run a single command, returns true when the shell should quit
+func (sh *shell) exec(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(sh.history) {
+			fmt.Fprintf(sh.out, "error: no command %s in history\n", line[1:])
+			return false
+		}
+		line = sh.history[n-1]
+		fmt.Fprintln(sh.out, line)
+	}
+	sh.addHistory(line)
+
+	// SQL has its own quoting, the line goes to the parser as it is
+	if first, _, _ := strings.Cut(line, " "); sqlKeywords[strings.ToLower(first)] {
+		if err := sh.execSQL(line); err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+		return false
+	}
+
+	args, err := splitArgs(line)
+	if err != nil {
+		fmt.Fprintf(sh.out, "error: %v\n", err)
+		return false
+	}
+
+	cmd := strings.ToLower(args[0])
+
+	switch cmd {
+	case ".exit", ".quit":
+		return true
+	case ".help":
+		fmt.Fprint(sh.out, helpText)
+	case ".history":
+		for i, h := range sh.history {
+			fmt.Fprintf(sh.out, "%4d  %s\n", i+1, h)
+		}
+	case ".tables":
+		if err := sh.tables(); err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+	case ".dump":
+		sh.scan(nil, nil, func(key, val []byte) {
+			fmt.Fprintf(sh.out, "set %s %s\n", quote(key), quote(val))
+		})
+	case ".export":
+		if err := sh.export(args[1:]); err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+	case ".stats":
+		sh.stats()
+	default:
+		if err := sh.execKV(cmd, args[1:]); err != nil {
+			fmt.Fprintf(sh.out, "error: %v\n", err)
+		}
+	}
+	return false
+}
+
+// write the tree structure to the output or to a file
+func (sh *shell) export(args []string) error {
+	if err := wantArgs(args, 1, 2, ".export dot|json [file]"); err != nil {
+		return err
+	}
+	export := sh.db.ExportDOT
+	switch args[0] {
+	case "dot":
+	case "json":
+		export = sh.db.ExportJSON
+	default:
+		return fmt.Errorf("unknown format %q, expected dot or json", args[0])
+	}
+	if len(args) == 1 {
+		return export(sh.out)
+	}
+
+	f, err := os.Create(args[1])
+	if err != nil {
+		return err
+	}
+	if err := export(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(sh.out, "written to %s\n", args[1])
+	return nil
+}
+
+func (sh *shell) stats() {
+	s := sh.db.Stats()
+	fmt.Fprintf(sh.out, "height %d, %d internal pages, %d leaf pages, %d keys, fill %.1f%%\n",
+		s.Height, s.InternalPages, s.LeafPages, s.Keys, 100*s.Fill())
+	for i, level := range s.Levels {
+		fmt.Fprintf(sh.out, "  level %d: %d pages, %d keys, fill %.1f%%\n", i, level.Pages, level.Keys, 100*level.Fill())
+	}
+	fmt.Fprintf(sh.out, "key sizes: mean %.1f, max %d, %s\n", s.KeySizes.Mean(), s.KeySizes.Max, s.KeySizes)
+	fmt.Fprintf(sh.out, "value sizes: mean %.1f, max %d, %s\n", s.ValSizes.Mean(), s.ValSizes.Max, s.ValSizes)
+	fmt.Fprintf(sh.out, "file: %d pages of %d bytes, %d free (left by copy on write, there is no free list)\n", s.FilePages, s.PageSize, s.FreePages)
+	p := sh.db.PoolStats()
+	fmt.Fprintf(sh.out, "buffer pool: %d/%d frames used, %d hits, %d misses (%.1f%% hits), %d evictions\n",
+		p.Used, p.Frames, p.Hits, p.Misses, 100*p.HitRate(), p.Evictions)
+}
+
+func wantArgs(args []string, min, max int, usage string) error {
+	if len(args) < min || len(args) > max {
+		return fmt.Errorf("usage: %s", usage)
+	}
+	return nil
+}
+
+// key-value and transaction commands
+func (sh *shell) execKV(cmd string, args []string) error {
+	switch cmd {
+	case "get":
+		if err := wantArgs(args, 1, 1, "get <key>"); err != nil {
+			return err
+		}
+		val, ok := sh.get([]byte(args[0]))
+		if !ok {
+			fmt.Fprintln(sh.out, "(nil)")
+		} else {
+			fmt.Fprintln(sh.out, quote(val))
+		}
+	case "set":
+		if err := wantArgs(args, 2, 2, "set <key> <value>"); err != nil {
+			return err
+		}
+		if err := sh.set([]byte(args[0]), []byte(args[1])); err != nil {
+			return err
+		}
+		fmt.Fprintln(sh.out, "OK")
+	case "del":
+		if err := wantArgs(args, 1, 1, "del <key>"); err != nil {
+			return err
+		}
+		deleted, err := sh.del([]byte(args[0]))
+		if err != nil {
+			return err
+		}
+		if deleted {
+			fmt.Fprintln(sh.out, "(deleted 1)")
+		} else {
+			fmt.Fprintln(sh.out, "(deleted 0)")
+		}
+	case "scan":
+		if err := wantArgs(args, 0, 2, "scan [start [end]]"); err != nil {
+			return err
+		}
+		var start, end []byte
+		if len(args) > 0 {
+			start = []byte(args[0])
+		}
+		if len(args) > 1 {
+			end = []byte(args[1])
+		}
+		n := 0
+		sh.scan(start, end, func(key, val []byte) {
+			fmt.Fprintf(sh.out, "%s = %s\n", quote(key), quote(val))
+			n++
+		})
+		fmt.Fprintf(sh.out, "(%d keys)\n", n)
+	case "begin":
+		if err := wantArgs(args, 0, 0, "begin"); err != nil {
+			return err
+		}
+		tx, err := sh.db.Begin()
+		if err != nil {
+			return err
+		}
+		sh.tx = tx
+		fmt.Fprintln(sh.out, "BEGIN")
+	case "commit":
+		if sh.tx == nil {
+			return errors.New("no transaction in progress")
+		}
+		err := sh.tx.Commit()
+		sh.tx = nil
+		if err != nil {
+			return fmt.Errorf("commit failed, transaction rolled back: %w", err)
+		}
+		fmt.Fprintln(sh.out, "COMMIT")
+	case "rollback":
+		if sh.tx == nil {
+			return errors.New("no transaction in progress")
+		}
+		sh.tx.Abort()
+		sh.tx = nil
+		fmt.Fprintln(sh.out, "ROLLBACK")
+	case "vacuum":
+		if err := wantArgs(args, 0, 1, "vacuum [fill]"); err != nil {
+			return err
+		}
+		fill := db.COMPACT_FILL
+		if len(args) == 1 {
+			f, err := strconv.ParseFloat(args[0], 64)
+			if err != nil {
+				return fmt.Errorf("bad fill %q", args[0])
+			}
+			fill = f
+		}
+		before := sh.db.Stats().FilePages
+		if err := sh.db.Vacuum(fill); err != nil {
+			return err
+		}
+		fmt.Fprintf(sh.out, "VACUUM %d pages -> %d pages\n", before, sh.db.Stats().FilePages)
+	default:
+		return fmt.Errorf("unknown command %q, try .help", cmd)
+	}
+	return nil
+}
+
+// run an SQL statement in the transaction, or in one of its own
+func (sh *shell) execSQL(line string) error {
+	if err := minisql.CheckOrder(sh.db); err != nil {
+		return err
+	}
+	stmt, err := minisql.Parse(line)
+	if err != nil {
+		return err
+	}
+	tx := sh.tx
+	if tx == nil {
+		if tx, err = sh.db.Begin(); err != nil {
+			return err
+		}
+	}
+	res, err := stmt.Run(tx, nil)
+	if sh.tx == nil {
+		if err != nil {
+			tx.Abort()
+		} else {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	switch stmt.Kind() {
+	case minisql.STMT_INSERT:
+		fmt.Fprintf(sh.out, "INSERT %d\n", res.RowsAffected)
+	case minisql.STMT_DELETE:
+		fmt.Fprintf(sh.out, "DELETE %d\n", res.RowsAffected)
+	default:
+		fmt.Fprintln(sh.out, strings.Join(res.Columns, " | "))
+		for _, row := range res.Rows {
+			cells := make([]string, len(row))
+			for i, v := range row {
+				cells[i] = formatValue(v)
+			}
+			fmt.Fprintln(sh.out, strings.Join(cells, " | "))
+		}
+		fmt.Fprintf(sh.out, "(%d rows)\n", len(res.Rows))
+	}
+	return nil
+}
+
+// a value of an SQL result, bytes are quoted like keys
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return quote(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// the key prefixes of the docstore records, they are not SQL tables
+var docstoreKinds = map[string]bool{"doc": true, "seq": true, "idxdef": true, "idx": true}
+
+// list the SQL tables, the distinct key parts before a 0 byte, then the docstore collections
+// and their indexes. The seek after a table name skips its rows
+func (sh *shell) tables() error {
+	n := 0
+	for iter := sh.seek(nil, db.CMP_GE); iter.Valid(); {
+		key, _ := iter.Deref()
+		i := bytes.IndexByte(key, 0)
+		if i < 0 {
+			iter = sh.seek(key, db.CMP_GT)
+			continue
+		}
+		if !docstoreKinds[string(key[:i])] {
+			fmt.Fprintln(sh.out, quote(key[:i]))
+			n++
+		}
+		iter = sh.seek(append(bytes.Clone(key[:i]), 1), db.CMP_GE)
+	}
+
+	// collections need the byte order, there are none in a file of another order
+	var names []string
+	if sh.db.Comparator.Name == db.COMPARE_BYTES.Name {
+		var err error
+		if names, err = docstore.Collections(sh.db); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		c, err := docstore.Open(sh.db, name)
+		if err != nil {
+			return err
+		}
+		if indexes := c.Indexes(); len(indexes) > 0 {
+			fmt.Fprintf(sh.out, "%s (collection, indexes: %s)\n", name, strings.Join(indexes, ", "))
+		} else {
+			fmt.Fprintf(sh.out, "%s (collection)\n", name)
+		}
+		n++
+	}
+	if n == 0 {
+		fmt.Fprintln(sh.out, "no tables")
+	}
+	return nil
+}
+
+// Reads and writes go through the transaction when there is one
+
+func (sh *shell) get(key []byte) ([]byte, bool) {
+	if sh.tx != nil {
+		return sh.tx.Get(key)
+	}
+	return sh.db.Get(key)
+}
+
+func (sh *shell) set(key, val []byte) error {
+	if sh.tx != nil {
+		return sh.tx.Set(key, val)
+	}
+	return sh.db.Set(key, val)
+}
+
+func (sh *shell) del(key []byte) (bool, error) {
+	if sh.tx != nil {
+		return sh.tx.Del(key)
+	}
+	return sh.db.Del(key)
+}
+
+func (sh *shell) seek(key []byte, cmp int) *db.BIter {
+	if sh.tx != nil {
+		return sh.tx.Seek(key, cmp)
+	}
+	return sh.db.Seek(key, cmp)
+}
+
+// call fn for every key in [start, end), a nil end means no upper bound
+func (sh *shell) scan(start, end []byte, fn func(key, val []byte)) {
+	sh.scanUntil(start, func(key, val []byte) bool {
+		if end != nil && sh.db.Compare(key, end) >= 0 {
+			return false
+		}
+		fn(key, val)
+		return true
+	})
+}
+
+// call fn for the keys from start until it returns false
+func (sh *shell) scanUntil(start []byte, fn func(key, val []byte) bool) {
+	for iter := sh.seek(start, db.CMP_GE); iter.Valid(); iter.Next() {
+		if !fn(iter.Deref()) {
+			break
+		}
+	}
+}
+
+// the start of the word before the end of line and the words it can be completed to:
+// commands, the formats of .export and the keys of get, set, del and scan
+func (sh *shell) complete(line string) (int, []string) {
+	start := strings.LastIndexAny(line, " \t") + 1
+	word := line[start:]
+	if strings.HasPrefix(word, "\"") {
+		return start, nil // quoted words are not completed
+	}
+	args := strings.Fields(line[:start])
+	var choices []string
+	switch {
+	case len(args) == 0:
+		for _, cmd := range commands {
+			if strings.HasPrefix(cmd, word) {
+				choices = append(choices, cmd)
+			}
+		}
+	case args[0] == ".export" && len(args) == 1:
+		for _, format := range []string{"dot", "json"} {
+			if strings.HasPrefix(format, word) {
+				choices = append(choices, format)
+			}
+		}
+	case args[0] == "get" || args[0] == "del" || args[0] == "set":
+		if len(args) == 1 {
+			choices = sh.completeKey(word)
+		}
+	case args[0] == "scan":
+		if len(args) <= 2 {
+			choices = sh.completeKey(word)
+		}
+	}
+	return start, choices
+}
+
+// keys starting with prefix that can be typed without quotes
+func (sh *shell) completeKey(prefix string) []string {
+	// keys with a prefix are contiguous in byte order only, other orders are scanned from the start
+	var start []byte
+	contiguous := sh.db.Comparator.Name == db.COMPARE_BYTES.Name
+	if contiguous {
+		start = []byte(prefix)
+	}
+	var keys []string
+	sh.scanUntil(start, func(key, _ []byte) bool {
+		k := string(key)
+		if !strings.HasPrefix(k, prefix) {
+			return !contiguous
+		}
+		if k != "" && quote(key) == `"`+k+`"` && !strings.ContainsAny(k, " \t") {
+			keys = append(keys, k)
+		}
+		return len(keys) < MAX_COMPLETIONS
+	})
+	return keys
+}
+
+// print bytes as a Go string so that they can be pasted back into the shell
+func quote(b []byte) string {
+	return strconv.Quote(string(b))
+}
+
+// split a command line on spaces, "double quoted" words are unquoted like Go strings
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return args, nil
+		}
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+
+		word, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("bad quoted string: %s", line)
+		}
+		unquoted, _ := strconv.Unquote(word)
+		args = append(args, unquoted)
+		line = line[len(word):]
+	}
+}
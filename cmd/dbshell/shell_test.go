@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"building-a-db/db"
+	"building-a-db/docstore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Open a shell on a fresh database file
+func newTestShell(t *testing.T) (*shell, *bytes.Buffer) {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	t.Cleanup(func() { kv.Close() })
+	out := &bytes.Buffer{}
+	return newShell(kv, out), out
+}
+
+// Helper: Run a command and return what it printed
+func run(sh *shell, out *bytes.Buffer, line string) string {
+	out.Reset()
+	sh.exec(line)
+	return out.String()
+}
+
+func TestShellKV(t *testing.T) {
+	t.Run("Set, get, del", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Equal(t, "OK\n", run(sh, out, "set k1 v1"))
+		assert.Equal(t, "\"v1\"\n", run(sh, out, "get k1"))
+		assert.Equal(t, "(deleted 1)\n", run(sh, out, "del k1"))
+		assert.Equal(t, "(nil)\n", run(sh, out, "get k1"))
+		assert.Equal(t, "(deleted 0)\n", run(sh, out, "del k1"))
+	})
+
+	t.Run("Quoted keys and values", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Equal(t, "OK\n", run(sh, out, `set "a key" "a value\n"`))
+		assert.Equal(t, "\"a value\\n\"\n", run(sh, out, `get "a key"`))
+	})
+
+	t.Run("Scan range", func(t *testing.T) {
+		sh, out := newTestShell(t)
+		for _, k := range []string{"d", "a", "c", "b"} {
+			run(sh, out, "set "+k+" val_"+k)
+		}
+
+		assert.Equal(t, "\"b\" = \"val_b\"\n\"c\" = \"val_c\"\n(2 keys)\n", run(sh, out, "scan b d"))
+		assert.Equal(t, "\"c\" = \"val_c\"\n\"d\" = \"val_d\"\n(2 keys)\n", run(sh, out, "scan c"))
+		assert.Contains(t, run(sh, out, "scan"), "(4 keys)")
+	})
+
+	t.Run("Dump prints set commands", func(t *testing.T) {
+		sh, out := newTestShell(t)
+		run(sh, out, `set b "2 2"`)
+		run(sh, out, "set a 1")
+
+		assert.Equal(t, "set \"a\" \"1\"\nset \"b\" \"2 2\"\n", run(sh, out, ".dump"))
+	})
+
+	// Edge cases
+	t.Run("Bad usage", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Contains(t, run(sh, out, "get"), "usage: get <key>")
+		assert.Contains(t, run(sh, out, "set k"), "usage: set <key> <value>")
+		assert.Contains(t, run(sh, out, `set "k v`), "bad quoted string")
+		assert.Contains(t, run(sh, out, "frobnicate"), "unknown command")
+		assert.Contains(t, run(sh, out, "SELECT * FROM"), "syntax error")
+	})
+}
+
+func TestShellSQL(t *testing.T) {
+	t.Run("Insert, select, delete", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Equal(t, "INSERT 3\n", run(sh, out, "INSERT INTO users VALUES ('u1', 'alice'), ('u2', 'bob'), ('u3', 'bob')"))
+		assert.Equal(t, "key | value\n\"u1\" | \"alice\"\n(1 rows)\n", run(sh, out, "select * from users where key = 'u1'"))
+		assert.Equal(t, "value | count\n\"alice\" | 1\n\"bob\" | 2\n(2 rows)\n",
+			run(sh, out, "SELECT value, COUNT(*) FROM users GROUP BY value"))
+		assert.Equal(t, "DELETE 2\n", run(sh, out, "DELETE FROM users WHERE value = 'bob'"))
+		assert.Equal(t, "\"alice\"\n", run(sh, out, `get "users\x00u1"`), "A row is a key of the KV")
+	})
+
+	t.Run("Statements run in the transaction", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		run(sh, out, "begin")
+		run(sh, out, "INSERT INTO t VALUES ('k', 'v')")
+		assert.Contains(t, run(sh, out, "SELECT COUNT(*) FROM t"), "1\n")
+		run(sh, out, "rollback")
+		assert.Contains(t, run(sh, out, "SELECT COUNT(*) FROM t"), "0\n")
+	})
+
+	t.Run("Tables", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Equal(t, "no tables\n", run(sh, out, ".tables"))
+		run(sh, out, "set plain v")
+		run(sh, out, "INSERT INTO b VALUES ('1', 'x'), ('2', 'y')")
+		run(sh, out, "INSERT INTO a VALUES ('1', 'x')")
+		assert.Equal(t, "\"a\"\n\"b\"\n", run(sh, out, ".tables"))
+	})
+
+	// Edge cases
+	t.Run("A failed statement writes nothing", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Contains(t, run(sh, out, "SELECT nope FROM t"), "error: no column")
+		assert.Contains(t, run(sh, out, "UPDATE t SET value = 1"), "syntax error")
+		assert.Equal(t, "no tables\n", run(sh, out, ".tables"))
+	})
+}
+
+func TestShellTransactions(t *testing.T) {
+	t.Run("Commit", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Equal(t, "BEGIN\n", run(sh, out, "begin"))
+		assert.Equal(t, "db*> ", sh.prompt())
+		run(sh, out, "set k v")
+		assert.Equal(t, "\"v\"\n", run(sh, out, "get k"), "Transaction sees its own writes")
+		_, ok := sh.db.Get([]byte("k"))
+		assert.False(t, ok, "Other readers do not see uncommitted writes")
+
+		assert.Equal(t, "COMMIT\n", run(sh, out, "commit"))
+		assert.Equal(t, "db> ", sh.prompt())
+		_, ok = sh.db.Get([]byte("k"))
+		assert.True(t, ok)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		sh, out := newTestShell(t)
+		run(sh, out, "set k old")
+
+		run(sh, out, "begin")
+		run(sh, out, "set k new")
+		run(sh, out, "del k")
+		assert.Equal(t, "ROLLBACK\n", run(sh, out, "rollback"))
+		assert.Equal(t, "\"old\"\n", run(sh, out, "get k"))
+	})
+
+	// Edge cases
+	t.Run("Commit without begin", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		assert.Contains(t, run(sh, out, "commit"), "no transaction in progress")
+		assert.Contains(t, run(sh, out, "rollback"), "no transaction in progress")
+	})
+
+	t.Run("Nested begin", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		run(sh, out, "begin")
+		assert.Contains(t, run(sh, out, "begin"), "already in progress")
+	})
+}
+
+func TestShellRun(t *testing.T) {
+	t.Run("History and re-run", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		sh.run(strings.NewReader("set k v\nget k\n.history\n!2\n.exit\nget k\n"))
+		output := out.String()
+		assert.Contains(t, output, "   1  set k v\n   2  get k\n")
+		assert.Equal(t, 2, strings.Count(output, "\"v\"\n"), "get k runs twice, the second time from the history")
+		assert.Equal(t, []string{"set k v", "get k", ".history", "get k", ".exit"}, sh.history)
+	})
+
+	t.Run("History is kept in a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history")
+		sh, _ := newTestShell(t)
+		assert.NoError(t, sh.loadHistory(path), "No file yet")
+		sh.run(strings.NewReader("set k v\nget k\n"))
+
+		sh, out := newTestShell(t)
+		assert.NoError(t, sh.loadHistory(path))
+		assert.Equal(t, []string{"set k v", "get k"}, sh.history)
+		sh.run(strings.NewReader("!1\n"))
+		assert.Contains(t, out.String(), "set k v\nOK\n", "Commands of the last session can be run again")
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, "set k v\nget k\nset k v\n", string(data))
+	})
+
+	t.Run("History file is trimmed", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history")
+		var lines strings.Builder
+		for i := 0; i < MAX_HISTORY+10; i++ {
+			fmt.Fprintf(&lines, "get k%d\n", i)
+		}
+		os.WriteFile(path, []byte(lines.String()), 0o600)
+
+		sh, _ := newTestShell(t)
+		assert.NoError(t, sh.loadHistory(path))
+		assert.Len(t, sh.history, MAX_HISTORY)
+		assert.Equal(t, "get k10", sh.history[0])
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, MAX_HISTORY, strings.Count(string(data), "\n"))
+	})
+
+	t.Run("Line editor", func(t *testing.T) {
+		sh, out := newTestShell(t)
+		// typo fixed with the arrows and backspace, history, ctrl-u, tab completion, ctrl-c and ctrl-d
+		keys := "set key1 v\rset key2 v\r" +
+			"gxt key1" + strings.Repeat("\x1b[D", 6) + "\x7fe\r" +
+			"\x1b[A\x1b[A\x1b[B\x1b[A\x7fw\r" +
+			"xx\x15get k\t\t1\r" +
+			"se\t k\t1 z\r" +
+			"junk\x03\x04"
+		sh.runEditor(strings.NewReader(keys))
+		assert.Equal(t, []string{"set key1 v", "set key2 v", "get key1", "set key2 w", "get key1", "set key1 z"}, sh.history)
+		output := out.String()
+		assert.Contains(t, output, "key1  key2", "The second tab lists the choices")
+		assert.Contains(t, output, "^C")
+		v, _ := sh.db.Get([]byte("key2"))
+		assert.Equal(t, "w", string(v))
+		v, _ = sh.db.Get([]byte("key1"))
+		assert.Equal(t, "z", string(v))
+	})
+
+	t.Run("Unfinished transaction is rolled back at the end of input", func(t *testing.T) {
+		sh, out := newTestShell(t)
+
+		sh.run(strings.NewReader("begin\nset k v\n"))
+		assert.Contains(t, out.String(), "transaction rolled back")
+		_, ok := sh.db.Get([]byte("k"))
+		assert.False(t, ok)
+	})
+}
+
+func TestShellComplete(t *testing.T) {
+	sh, out := newTestShell(t)
+	for _, k := range []string{"user1", "user2", "order1", "has space", "bin\x00"} {
+		assert.NoError(t, sh.db.Set([]byte(k), []byte("v")))
+	}
+
+	start, choices := sh.complete(".e")
+	assert.Equal(t, 0, start)
+	assert.Equal(t, []string{".export", ".exit"}, choices)
+	_, choices = sh.complete(".export j")
+	assert.Equal(t, []string{"json"}, choices)
+	start, choices = sh.complete("get us")
+	assert.Equal(t, 4, start)
+	assert.Equal(t, []string{"user1", "user2"}, choices)
+	_, choices = sh.complete("scan order1 u")
+	assert.Equal(t, []string{"user1", "user2"}, choices, "Both bounds of scan are keys")
+
+	run(sh, out, "begin")
+	run(sh, out, "set user3 v")
+	_, choices = sh.complete("del user")
+	assert.Equal(t, []string{"user1", "user2", "user3"}, choices, "Keys of the transaction")
+
+	// Edge cases
+	_, choices = sh.complete("get ")
+	assert.Equal(t, []string{"order1", "user1", "user2", "user3"}, choices, "Keys that need quotes are left out")
+	_, choices = sh.complete("set user1 u")
+	assert.Empty(t, choices, "Values are not completed")
+	_, choices = sh.complete(`get "us`)
+	assert.Empty(t, choices)
+	_, choices = sh.complete("nope ")
+	assert.Empty(t, choices)
+}
+
+func TestShellTables(t *testing.T) {
+	sh, out := newTestShell(t)
+	assert.Equal(t, "no tables\n", run(sh, out, ".tables"))
+
+	users, err := docstore.Open(sh.db, "users")
+	assert.NoError(t, err)
+	_, err = users.Insert(map[string]any{"name": "ann"})
+	assert.NoError(t, err)
+	assert.NoError(t, users.CreateIndex("name"))
+	orders, _ := docstore.Open(sh.db, "orders")
+	_, err = orders.Insert(map[string]any{"total": 3})
+	assert.NoError(t, err)
+	assert.Equal(t, "orders (collection)\nusers (collection, indexes: name)\n", run(sh, out, ".tables"))
+}
+
+func TestSplitArgs(t *testing.T) {
+	args, err := splitArgs(`set  "a \"b\""	c`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"set", `a "b"`, "c"}, args)
+
+	args, err = splitArgs("   ")
+	assert.NoError(t, err)
+	assert.Empty(t, args)
+}
+
+func TestShellExport(t *testing.T) {
+	t.Run("To the output", func(t *testing.T) {
+		sh, out := newTestShell(t)
+		run(sh, out, "set a 1")
+
+		assert.Contains(t, run(sh, out, ".export dot"), "digraph btree {")
+		assert.Contains(t, run(sh, out, ".export json"), `"keys": [`)
+	})
+
+	t.Run("To a file", func(t *testing.T) {
+		sh, out := newTestShell(t)
+		run(sh, out, "set a 1")
+		path := filepath.Join(t.TempDir(), "tree.dot")
+
+		assert.Equal(t, "written to "+path+"\n", run(sh, out, ".export dot "+path))
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "digraph btree {")
+	})
+
+	// Edge cases
+	t.Run("Bad usage", func(t *testing.T) {
+		sh, out := newTestShell(t)
+		assert.Contains(t, run(sh, out, ".export"), "usage: .export dot|json [file]")
+		assert.Contains(t, run(sh, out, ".export svg"), `unknown format "svg"`)
+	})
+}
+
+func TestShellStats(t *testing.T) {
+	sh, out := newTestShell(t)
+	run(sh, out, "set a 1")
+	run(sh, out, "set bb 22")
+
+	stats := run(sh, out, ".stats")
+	assert.Contains(t, stats, "height 1, 0 internal pages, 1 leaf pages, 2 keys")
+	assert.Contains(t, stats, "  level 0: 1 pages, 3 keys")
+	assert.Contains(t, stats, "key sizes: mean 1.5, max 2, 1: 1, 2-3: 1")
+	assert.Contains(t, stats, "file: 3 pages of 4096 bytes, 1 free")
+	assert.Regexp(t, `buffer pool: \d+/256 frames used, \d+ hits`, stats)
+}
+
+func TestShellVacuum(t *testing.T) {
+	sh, out := newTestShell(t)
+	for i := 0; i < 20; i++ {
+		run(sh, out, fmt.Sprintf("set k%02d v", i))
+	}
+
+	assert.Equal(t, "VACUUM 21 pages -> 2 pages\n", run(sh, out, "vacuum"))
+	assert.Equal(t, "\"v\"\n", run(sh, out, "get k07"))
+	assert.Equal(t, "VACUUM 2 pages -> 2 pages\n", run(sh, out, "vacuum 0.5"))
+
+	// Edge cases
+	assert.Equal(t, "error: bad fill \"x\"\n", run(sh, out, "vacuum x"))
+	assert.Contains(t, run(sh, out, "vacuum 0"), "fill must be in (0, 1]")
+	run(sh, out, "begin")
+	assert.Contains(t, run(sh, out, "vacuum"), "already in progress")
+}
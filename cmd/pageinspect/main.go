@@ -0,0 +1,164 @@
+// pageinspect prints decoded pages of a database file, it reads the file directly so it
+// also works on files that KV.Open rejects
+//
+//	go run ./cmd/pageinspect data.db            # the meta page
+//	go run ./cmd/pageinspect -page 3 data.db    # header, pointers, offsets and KVs of page 3
+//	go run ./cmd/pageinspect -tree data.db      # every page reached from the meta page
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"building-a-db/db"
+	"building-a-db/dump"
+)
+
+func main() {
+	page := flag.Uint64("page", 0, "page to decode, 0 is the meta page")
+	tree := flag.Bool("tree", false, "walk the tree from the meta page, one line per page")
+	maxBytes := flag.Int("bytes", 32, "bytes of each key and value to show, 0 for all")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: pageinspect [-page n] [-tree] [-bytes n] <database file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pageinspect: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	in, err := newInspector(f, *maxBytes, os.Stdout)
+	if err == nil {
+		switch {
+		case *tree:
+			err = in.tree()
+		case *page == 0:
+			err = in.meta()
+		default:
+			err = in.page(*page)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pageinspect: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type inspector struct {
+	f        io.ReaderAt
+	npages   uint64
+	pageSize int
+	maxBytes int
+	out      io.Writer
+}
+
+func newInspector(f *os.File, maxBytes int, out io.Writer) (*inspector, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// the page size is in the meta page, a bad one is reported by meta()
+	data := make([]byte, db.META_SIZE)
+	n, err := f.ReadAt(data, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	pageSize := db.BTREE_PAGE_SIZE
+	if meta := db.InspectMeta(data[:n]); meta.Valid {
+		pageSize = meta.PageSize
+	}
+	npages := (uint64(fi.Size()) + uint64(pageSize) - 1) / uint64(pageSize)
+	return &inspector{f: f, npages: npages, pageSize: pageSize, maxBytes: maxBytes, out: out}, nil
+}
+
+// read a page, the last one can be short
+func (in *inspector) read(ptr uint64) ([]byte, error) {
+	if ptr >= in.npages {
+		return nil, fmt.Errorf("page %d is outside the file of %d pages", ptr, in.npages)
+	}
+	data := make([]byte, in.pageSize)
+	n, err := in.f.ReadAt(data, int64(ptr)*int64(in.pageSize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+func (in *inspector) meta() error {
+	data, err := in.read(0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(in.out, "file: %d pages of %d bytes\n", in.npages, in.pageSize)
+	dump.FdumpStruct(in.out, db.InspectMeta(data))
+	return nil
+}
+
+func (in *inspector) page(ptr uint64) error {
+	data, err := in.read(ptr)
+	if err != nil {
+		return err
+	}
+	dump.FdumpStruct(in.out, db.InspectPage(ptr, data, in.pageSize, in.maxBytes))
+	return nil
+}
+
+// one line per page reached from the meta page, indented by depth
+func (in *inspector) tree() error {
+	data, err := in.read(0)
+	if err != nil {
+		return err
+	}
+	meta := db.InspectMeta(data)
+	fmt.Fprintf(in.out, "meta: root %d, %d pages used, %d in the file, checksum %s\n", meta.Root, meta.PagesUsed, in.npages, meta.Checksum)
+	for _, e := range meta.Errors {
+		fmt.Fprintf(in.out, "  ! %s\n", e)
+	}
+	if meta.Root == 0 {
+		fmt.Fprintln(in.out, "empty tree")
+		return nil
+	}
+
+	seen := map[uint64]bool{}
+	var walk func(ptr uint64, depth int)
+	walk = func(ptr uint64, depth int) {
+		indent := fmt.Sprintf("%*s", 2*depth, "")
+		if seen[ptr] {
+			fmt.Fprintf(in.out, "%spage %d\n%s  ! reached more than once\n", indent, ptr, indent)
+			return
+		}
+		seen[ptr] = true
+		data, err := in.read(ptr)
+		if err != nil {
+			fmt.Fprintf(in.out, "%spage %d\n%s  ! %v\n", indent, ptr, indent, err)
+			return
+		}
+		view := db.InspectPage(ptr, data, in.pageSize, in.maxBytes)
+		fmt.Fprintf(in.out, "%spage %d: %s, %d keys, %d bytes used, %d free", indent, ptr, view.Type, view.NKeys, view.UsedBytes, view.FreeBytes)
+		if len(view.KVs) > 0 {
+			fmt.Fprintf(in.out, ", keys %s .. %s", view.KVs[0].Key, view.KVs[len(view.KVs)-1].Key)
+		}
+		fmt.Fprintln(in.out)
+		for _, e := range view.Errors {
+			fmt.Fprintf(in.out, "%s  ! %s\n", indent, e)
+		}
+		if view.TypeCode == db.BNODE_NODE {
+			for _, kid := range view.Pointers {
+				walk(kid, depth+1)
+			}
+		}
+	}
+	walk(meta.Root, 0)
+	return nil
+}
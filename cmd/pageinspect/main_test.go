@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"building-a-db/db"
+	"building-a-db/internal/dbtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A database file with n keys
+func newTestDB(t *testing.T, n int) string {
+	return dbtest.NewFile(t, n, bytes.Repeat([]byte("v"), 100))
+}
+
+// Helper: The root page of a database file and its first kid
+func rootAndKid(t *testing.T, path string) (uint64, uint64) {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	root := db.InspectMeta(data).Root
+	view := db.InspectPage(root, data[root*db.BTREE_PAGE_SIZE:], db.BTREE_PAGE_SIZE, 0)
+	return root, view.Pointers[0]
+}
+
+// Helper: Run the inspector on a file and return what it printed
+func inspect(t *testing.T, path string, fn func(in *inspector) error) (string, error) {
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	out := &bytes.Buffer{}
+	in, err := newInspector(f, 8, out)
+	assert.NoError(t, err)
+	err = fn(in)
+	return out.String(), err
+}
+
+func TestInspect(t *testing.T) {
+	t.Run("Meta page", func(t *testing.T) {
+		out, err := inspect(t, newTestDB(t, 10), (*inspector).meta)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "|building-a-db-01|")
+		assert.Contains(t, out, "Valid (bool): true")
+		assert.Contains(t, out, "Checksum (string): none")
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		path := newTestDB(t, 10)
+		root, _ := rootAndKid(t, path)
+		out, err := inspect(t, path, func(in *inspector) error { return in.page(root) })
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Type (string): leaf")
+		assert.Contains(t, out, "NKeys (uint16): 11")
+		assert.Contains(t, out, "Key (string): 6b 65 79 5f 30 30 30 39 |key_0009|")
+		assert.Contains(t, out, "|vvvvvvvv| ... 100 bytes", "Values are cut at -bytes")
+		assert.Contains(t, out, "FreeBytes (int): ")
+	})
+
+	t.Run("Pages of another size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "big.db")
+		kv := &db.KV{Path: path, PageSize: 65536}
+		assert.NoError(t, kv.Open())
+		for i := 0; i < 1000; i++ {
+			kv.Set([]byte(fmt.Sprintf("key_%04d", i)), bytes.Repeat([]byte("v"), 100))
+		}
+		assert.NoError(t, kv.Close())
+
+		out, err := inspect(t, path, (*inspector).meta)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "pages of 65536 bytes")
+		out, err = inspect(t, path, (*inspector).tree)
+		assert.NoError(t, err)
+		assert.Regexp(t, `  page \d+: leaf`, out)
+		assert.NotContains(t, out, "!")
+	})
+
+	t.Run("Tree", func(t *testing.T) {
+		out, err := inspect(t, newTestDB(t, 200), (*inspector).tree)
+		assert.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		assert.Contains(t, lines[0], "meta: root")
+		assert.Regexp(t, `^page \d+: node`, lines[1])
+		assert.Regexp(t, `^  page \d+: leaf`, lines[2])
+		assert.NotContains(t, out, "!")
+	})
+
+	// Edge cases
+	t.Run("Corrupt pages are decoded as far as possible", func(t *testing.T) {
+		path := newTestDB(t, 200)
+		_, kid := rootAndKid(t, path)
+		data, _ := os.ReadFile(path)
+		data[kid*db.BTREE_PAGE_SIZE] = 7
+		os.WriteFile(path, data, 0644)
+
+		out, err := inspect(t, path, func(in *inspector) error { return in.page(kid) })
+		assert.NoError(t, err)
+		assert.Contains(t, out, "Type (string): unknown")
+		assert.Contains(t, out, "bad node type 7")
+		assert.Contains(t, out, "|key_0000|", "KVs are still decoded")
+
+		out, _ = inspect(t, path, (*inspector).tree)
+		assert.Contains(t, out, "  ! bad node type 7")
+	})
+
+	t.Run("New database has a short meta page", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "new.db")
+		kv := &db.KV{Path: path}
+		assert.NoError(t, kv.Open())
+		kv.Close()
+
+		out, err := inspect(t, path, (*inspector).tree)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "empty tree")
+	})
+
+	t.Run("Page outside the file", func(t *testing.T) {
+		_, err := inspect(t, newTestDB(t, 10), func(in *inspector) error { return in.page(99) })
+		assert.ErrorContains(t, err, "outside the file")
+	})
+
+	t.Run("Not a database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "junk")
+		os.WriteFile(path, []byte("hello"), 0644)
+		out, err := inspect(t, path, (*inspector).meta)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "meta page of 5 bytes")
+		assert.Contains(t, out, "Valid (bool): false")
+	})
+}
@@ -0,0 +1,234 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Online backups of the committed tree
+
+/*
+*
+Backup streams a copy of the tree committed when it is called. Copy on write never
+overwrites a page reachable from a committed root and there is no free list to reuse pages,
+so the pinned pages stay intact while transactions commit during the backup, even from
+another goroutine. A free list will have to keep the pages of a running backup.
+
+Only the pages reachable from the root are copied, renumbered in breadth first order from 1,
+so the copy has no leaked pages. The stream is:
+
+	header:  BACKUP_SIG 16 bytes, page size 4 bytes
+	order:   'C', name length 1 byte, name of the comparator
+	pages:   'P' then a page, the root first
+	trailer: 'E', number of pages 8 bytes, CRC-32 of the pages 4 bytes
+
+Restore writes the pages and the meta page to a new file next to the destination, checks it
+with Check and renames it into place, so a truncated or corrupt backup never leaves a
+database behind. A backup without a 'C' record was taken before the comparator was stored
+and is in byte order.
+*/
+const BACKUP_SIG = "building-a-db-bk"
+
+const (
+	BACKUP_PAGE       = 'P'
+	BACKUP_END        = 'E'
+	BACKUP_COMPARATOR = 'C'
+)
+
+var ErrBadBackup = errors.New("bad backup")
+
+// the committed root and the number of pages in the file, for a goroutine other than the writer
+func (db *KV) pin() (root, npages uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.tree.root, db.page.flushed
+}
+
+// stream the committed tree to w, commits can go on while it runs
+func (db *KV) Backup(w io.Writer) error {
+	root, npages := db.pin()
+	bw := bufio.NewWriter(w)
+
+	var header [20]byte
+	copy(header[:16], BACKUP_SIG)
+	binary.LittleEndian.PutUint32(header[16:], uint32(db.PageSize))
+	if _, err := bw.Write(header[:]); err != nil {
+		return err
+	}
+	order := append([]byte{BACKUP_COMPARATOR, byte(len(db.Comparator.Name))}, db.Comparator.Name...)
+	if _, err := bw.Write(order); err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	count := uint64(0)
+	if root != 0 {
+		queue := []uint64{root}
+		next := uint64(2) // the new pointer of the next page queued, the root is 1
+		for len(queue) > 0 {
+			ptr := queue[0]
+			queue = queue[1:]
+
+			page := make([]byte, db.PageSize)
+			if _, err := db.fd.ReadAt(page, int64(ptr)*int64(db.PageSize)); err != nil && !errors.Is(err, io.EOF) {
+				return fmt.Errorf("read page %d: %w", ptr, err)
+			}
+			node, err := checkPage(page, db.PageSize)
+			if err != nil {
+				return fmt.Errorf("page %d: %w", ptr, err)
+			}
+			if node.btype == BNODE_NODE {
+				for i, kid := range node.ptrs {
+					if kid == 0 || kid >= npages {
+						return fmt.Errorf("page %d: pointer to page %d outside the file of %d pages", ptr, kid, npages)
+					}
+					// a valid tree reaches each page once
+					if next >= npages {
+						return fmt.Errorf("page %d: the tree reaches more pages than the file has", ptr)
+					}
+					queue = append(queue, kid)
+					BNode(page).setPtr(uint16(i), next)
+					next++
+				}
+			}
+
+			if err := bw.WriteByte(BACKUP_PAGE); err != nil {
+				return err
+			}
+			if _, err := bw.Write(page); err != nil {
+				return err
+			}
+			crc.Write(page)
+			count++
+		}
+	}
+
+	var trailer [13]byte
+	trailer[0] = BACKUP_END
+	binary.LittleEndian.PutUint64(trailer[1:], count)
+	binary.LittleEndian.PutUint32(trailer[9:], crc.Sum32())
+	if _, err := bw.Write(trailer[:]); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// create the database file path from a backup, path must not exist
+func Restore(r io.Reader, path string) (err error) {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("restore: %s already exists", path)
+	}
+	tmp := path + ".restore"
+	fd, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			fd.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	meta, err := restorePages(bufio.NewReader(r), fd)
+	if err != nil {
+		return err
+	}
+	if meta.used > 1 {
+		meta.root = 1
+	}
+	if _, err := fd.WriteAt(encodeMeta(meta), 0); err != nil {
+		return fmt.Errorf("write meta page: %w", err)
+	}
+	if err := fd.Sync(); err != nil {
+		return fmt.Errorf("fsync: %w", err)
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+
+	// check the copy before it takes the name of the database
+	cmp := LookupComparator(meta.order)
+	if cmp == nil {
+		return fmt.Errorf("restore: the backup is ordered by comparator %q which is not registered", meta.order)
+	}
+	kv := &KV{Path: tmp, Comparator: cmp}
+	if err := kv.Open(); err != nil {
+		return fmt.Errorf("%w: %v", ErrBadBackup, err)
+	}
+	report := kv.Check()
+	kv.Close()
+	if !report.OK() {
+		return fmt.Errorf("%w: %d violations, the first one %v", ErrBadBackup, len(report.Violations), report.Violations[0])
+	}
+	return os.Rename(tmp, path)
+}
+
+// write the pages of a backup from page 1 on and return the meta page without the root
+func restorePages(r *bufio.Reader, fd *os.File) (metaPage, error) {
+	var header [20]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return metaPage{}, fmt.Errorf("%w: read header: %v", ErrBadBackup, err)
+	}
+	if !bytes.Equal(header[:16], []byte(BACKUP_SIG)) {
+		return metaPage{}, fmt.Errorf("%w: bad signature, not a backup", ErrBadBackup)
+	}
+	pageSize := int(binary.LittleEndian.Uint32(header[16:]))
+	if err := checkPageSize(pageSize); err != nil {
+		return metaPage{}, fmt.Errorf("%w: %v", ErrBadBackup, err)
+	}
+	order := COMPARE_BYTES.Name
+	if tag, err := r.Peek(1); err == nil && tag[0] == BACKUP_COMPARATOR {
+		var n [2]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return metaPage{}, fmt.Errorf("%w: truncated comparator name", ErrBadBackup)
+		}
+		name := make([]byte, n[1])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return metaPage{}, fmt.Errorf("%w: truncated comparator name", ErrBadBackup)
+		}
+		if len(name) == 0 || len(name) > MAX_COMPARATOR_NAME {
+			return metaPage{}, fmt.Errorf("%w: comparator name of %d bytes", ErrBadBackup, len(name))
+		}
+		order = string(name)
+	}
+
+	crc := crc32.NewIEEE()
+	page := make([]byte, pageSize)
+	for count := uint64(0); ; count++ {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return metaPage{}, fmt.Errorf("%w: truncated after %d pages", ErrBadBackup, count)
+		}
+		switch tag {
+		case BACKUP_PAGE:
+			if _, err := io.ReadFull(r, page); err != nil {
+				return metaPage{}, fmt.Errorf("%w: truncated in page %d", ErrBadBackup, count+1)
+			}
+			crc.Write(page)
+			if _, err := fd.WriteAt(page, int64(count+1)*int64(pageSize)); err != nil {
+				return metaPage{}, fmt.Errorf("write page %d: %w", count+1, err)
+			}
+		case BACKUP_END:
+			var trailer [12]byte
+			if _, err := io.ReadFull(r, trailer[:]); err != nil {
+				return metaPage{}, fmt.Errorf("%w: truncated trailer", ErrBadBackup)
+			}
+			if n := binary.LittleEndian.Uint64(trailer[:]); n != count {
+				return metaPage{}, fmt.Errorf("%w: trailer says %d pages, read %d", ErrBadBackup, n, count)
+			}
+			if sum := binary.LittleEndian.Uint32(trailer[8:]); sum != crc.Sum32() {
+				return metaPage{}, fmt.Errorf("%w: checksum mismatch", ErrBadBackup)
+			}
+			return metaPage{used: count + 1, pageSize: pageSize, order: order}, nil
+		default:
+			return metaPage{}, fmt.Errorf("%w: bad record tag %q after %d pages", ErrBadBackup, tag, count)
+		}
+	}
+}
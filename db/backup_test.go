@@ -0,0 +1,281 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A database with n keys, every third one deleted again
+func backupDB(t *testing.T, n int) *KV {
+	db := openKV(t, filepath.Join(t.TempDir(), "src.db"))
+	tx, _ := db.Begin()
+	for i := 0; i < n; i++ {
+		tx.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(strings.Repeat("v", i%200)))
+	}
+	assert.NoError(t, tx.Commit())
+	for i := 0; i < n; i += 3 {
+		db.Del([]byte(fmt.Sprintf("key%04d", i)))
+	}
+	return db
+}
+
+// Helper: Restore a backup into a new file and open it
+func restoreKV(t *testing.T, backup []byte) (*KV, error) {
+	path := filepath.Join(t.TempDir(), "restored.db")
+	if err := Restore(bytes.NewReader(backup), path); err != nil {
+		return nil, err
+	}
+	db := openKV(t, path)
+	t.Cleanup(func() { db.Close() })
+	return db, nil
+}
+
+// Helper: A backup stream of the given pages
+func backupStream(pages ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(BACKUP_SIG)
+	binary.Write(&buf, binary.LittleEndian, uint32(BTREE_PAGE_SIZE))
+	buf.Write([]byte{BACKUP_COMPARATOR, byte(len("bytes"))})
+	buf.WriteString("bytes")
+	crc := crc32.NewIEEE()
+	for _, page := range pages {
+		buf.WriteByte(BACKUP_PAGE)
+		buf.Write(page)
+		crc.Write(page)
+	}
+	buf.WriteByte(BACKUP_END)
+	binary.Write(&buf, binary.LittleEndian, uint64(len(pages)))
+	binary.Write(&buf, binary.LittleEndian, crc.Sum32())
+	return buf.Bytes()
+}
+
+// Helper: Calls fn before each write
+type hookWriter struct {
+	w  io.Writer
+	fn func()
+}
+
+func (h *hookWriter) Write(p []byte) (int, error) {
+	h.fn()
+	return h.w.Write(p)
+}
+
+func TestBackup(t *testing.T) {
+	t.Run("Restore gives the same keys without leaked pages", func(t *testing.T) {
+		src := backupDB(t, 500)
+		defer src.Close()
+		var buf bytes.Buffer
+		assert.NoError(t, src.Backup(&buf))
+
+		dst, err := restoreKV(t, buf.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, dumpKV(src), dumpKV(dst))
+
+		report := dst.Check()
+		assert.True(t, report.OK(), report.Violations)
+		assert.Empty(t, report.Unreachable)
+		assert.Equal(t, src.Check().Pages, report.Pages)
+		assert.Less(t, dst.page.flushed, src.page.flushed)
+
+		// the copy is a normal database
+		assert.NoError(t, dst.Set([]byte("new"), []byte("key")))
+		val, _ := dst.Get([]byte("new"))
+		assert.Equal(t, []byte("key"), val)
+	})
+
+	t.Run("Commits during the backup are not in it", func(t *testing.T) {
+		src := backupDB(t, 300)
+		defer src.Close()
+		want := dumpKV(src)
+
+		var buf bytes.Buffer
+		i := 0
+		w := &hookWriter{w: &buf, fn: func() {
+			src.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("changed"))
+			src.Del([]byte(fmt.Sprintf("key%04d", i+1)))
+			i += 2
+		}}
+		assert.NoError(t, src.Backup(w))
+		assert.Greater(t, i, 0)
+
+		dst, err := restoreKV(t, buf.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, want, dumpKV(dst))
+		assert.NotEqual(t, want, dumpKV(src))
+	})
+
+	t.Run("Backup in another goroutine", func(t *testing.T) {
+		src := backupDB(t, 300)
+		defer src.Close()
+		want := dumpKV(src)
+
+		r, w := io.Pipe()
+		done := make(chan error)
+		go func() {
+			err := src.Backup(w)
+			w.CloseWithError(err)
+			done <- err
+		}()
+		// commit while the backup goroutine has pages left to write
+		var buf bytes.Buffer
+		chunk := make([]byte, 1000)
+		for i := 0; ; i++ {
+			n, err := r.Read(chunk)
+			buf.Write(chunk[:n])
+			if err != nil {
+				break
+			}
+			src.Set([]byte(fmt.Sprintf("new%04d", i)), []byte("v"))
+		}
+		assert.NoError(t, <-done)
+
+		dst, err := restoreKV(t, buf.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, want, dumpKV(dst))
+	})
+
+	// Edge cases
+	t.Run("Page size is kept", func(t *testing.T) {
+		src := openKVPageSize(t, filepath.Join(t.TempDir(), "src.db"), 16384)
+		defer src.Close()
+		for i := 0; i < 300; i++ {
+			src.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(strings.Repeat("v", i)))
+		}
+		var buf bytes.Buffer
+		assert.NoError(t, src.Backup(&buf))
+		assert.Equal(t, uint32(16384), binary.LittleEndian.Uint32(buf.Bytes()[16:]))
+
+		dst, err := restoreKV(t, buf.Bytes())
+		assert.NoError(t, err)
+		assert.Equal(t, 16384, dst.PageSize)
+		assert.Equal(t, dumpKV(src), dumpKV(dst))
+	})
+
+	t.Run("Empty database", func(t *testing.T) {
+		src := openKV(t, filepath.Join(t.TempDir(), "src.db"))
+		defer src.Close()
+		var buf bytes.Buffer
+		assert.NoError(t, src.Backup(&buf))
+		assert.Equal(t, 20+2+len("bytes")+13, buf.Len(), "Header, order and trailer only")
+
+		dst, err := restoreKV(t, buf.Bytes())
+		assert.NoError(t, err)
+		assert.Empty(t, dumpKV(dst))
+	})
+
+	t.Run("Corrupt source page", func(t *testing.T) {
+		src := backupDB(t, 300)
+		defer src.Close()
+		kid := BNode(src.pageGet(src.tree.root)).getPtr(0)
+		src.fd.WriteAt([]byte{9, 0}, int64(kid*BTREE_PAGE_SIZE))
+
+		err := src.Backup(io.Discard)
+		assert.ErrorContains(t, err, fmt.Sprintf("page %d: bad node type 9", kid))
+	})
+}
+
+func TestRestore(t *testing.T) {
+	src := backupDB(t, 100)
+	defer src.Close()
+	var buf bytes.Buffer
+	assert.NoError(t, src.Backup(&buf))
+	backup := buf.Bytes()
+	head := 20 + 2 + len("bytes") // header and comparator record
+
+	// Helper: Restore must fail and leave nothing behind
+	badRestore := func(t *testing.T, data []byte, msg string) {
+		dir := t.TempDir()
+		err := Restore(bytes.NewReader(data), filepath.Join(dir, "restored.db"))
+		assert.ErrorIs(t, err, ErrBadBackup)
+		assert.ErrorContains(t, err, msg)
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries)
+	}
+
+	t.Run("Stream format", func(t *testing.T) {
+		var pages [][]byte
+		for i := head + 1; i < len(backup)-13; i += 1 + BTREE_PAGE_SIZE {
+			pages = append(pages, backup[i:i+BTREE_PAGE_SIZE])
+		}
+		assert.Equal(t, backup, backupStream(pages...))
+	})
+
+	t.Run("Truncated backups", func(t *testing.T) {
+		for _, n := range []int{0, 10, 20, 21, 23, head + 1 + BTREE_PAGE_SIZE, len(backup) - 1} {
+			badRestore(t, backup[:n], "")
+		}
+		badRestore(t, backup[:head+1+BTREE_PAGE_SIZE], "truncated after 1 pages")
+	})
+
+	t.Run("Corrupt page", func(t *testing.T) {
+		data := bytes.Clone(backup)
+		data[head+1+100] ^= 0xff
+		badRestore(t, data, "checksum mismatch")
+	})
+
+	t.Run("Bad page count", func(t *testing.T) {
+		data := bytes.Clone(backup)
+		data[len(data)-12]++
+		badRestore(t, data, "trailer says")
+	})
+
+	t.Run("Bad page size", func(t *testing.T) {
+		data := bytes.Clone(backup)
+		binary.LittleEndian.PutUint32(data[16:], 1000)
+		badRestore(t, data, "page size must be")
+	})
+
+	t.Run("Bad comparator record", func(t *testing.T) {
+		data := bytes.Clone(backup)
+		data[21] = 0
+		badRestore(t, data, "comparator name of 0 bytes")
+		data[21] = MAX_COMPARATOR_NAME + 1
+		badRestore(t, data, "comparator name of 28 bytes")
+	})
+
+	t.Run("Backup without a comparator is in byte order", func(t *testing.T) {
+		data := append(bytes.Clone(backup[:20]), backup[head:]...)
+		dst, err := restoreKV(t, data)
+		assert.NoError(t, err)
+		assert.Equal(t, COMPARE_BYTES, dst.Comparator)
+		assert.Equal(t, dumpKV(src), dumpKV(dst))
+	})
+
+	t.Run("Unknown comparator", func(t *testing.T) {
+		data := bytes.Clone(backup)
+		copy(data[22:], "bytez")
+		dir := t.TempDir()
+		err := Restore(bytes.NewReader(data), filepath.Join(dir, "restored.db"))
+		assert.ErrorContains(t, err, `comparator "bytez" which is not registered`)
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("Not a backup", func(t *testing.T) {
+		badRestore(t, []byte(strings.Repeat("x", 100)), "not a backup")
+	})
+
+	t.Run("Valid stream of a broken tree", func(t *testing.T) {
+		// only the root, its kids are missing
+		root := backup[head+1 : head+1+BTREE_PAGE_SIZE]
+		badRestore(t, backupStream(root), "violations")
+	})
+
+	t.Run("Existing destination", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "exists.db")
+		os.WriteFile(path, []byte("data"), 0644)
+		err := Restore(bytes.NewReader(backup), path)
+		assert.ErrorContains(t, err, "already exists")
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, []byte("data"), data)
+	})
+}
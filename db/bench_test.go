@@ -0,0 +1,190 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// Benchmarks, run with: go test ./db -run '^$' -bench . -benchmem
+
+/*
+*
+The page size is fixed, so the fanout of the tree follows from the key size: about
+BTREE_PAGE_SIZE / (key + value + 14) KVs per page. Each benchmark runs for a few key sizes
+with BENCH_VAL_SIZE byte values, and reports the tree height and the KVs per leaf.
+*/
+const BENCH_VAL_SIZE = 32
+const BENCH_KEYS = 10000
+
+var benchKeySizes = []int{16, 128, 512}
+
+// Helper: The i-th key padded to size bytes, keys sort like i
+func benchKey(i, size int) []byte {
+	key := make([]byte, size)
+	binary.BigEndian.PutUint64(key, uint64(i))
+	return key
+}
+
+// Helper: A tree in memory with n keys inserted in random order
+func benchTree(b *testing.B, n, size int) *BTree {
+	tree := &newC().tree
+	val := make([]byte, BENCH_VAL_SIZE)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		if err := tree.Insert(benchKey(i, size), val); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return tree
+}
+
+// Helper: Report the height and the average KVs per leaf
+func reportShape(b *testing.B, tree *BTree) {
+	if tree.root == 0 {
+		return
+	}
+	height, leaves, kvs := 0, 0, 0
+	var walk func(ptr uint64, depth int)
+	walk = func(ptr uint64, depth int) {
+		node := BNode(tree.get(ptr))
+		if node.btype() == BNODE_LEAF {
+			height = depth
+			leaves++
+			kvs += int(node.nkeys())
+			return
+		}
+		for i := uint16(0); i < node.nkeys(); i++ {
+			walk(node.getPtr(i), depth+1)
+		}
+	}
+	walk(tree.root, 1)
+	b.ReportMetric(float64(height), "height")
+	b.ReportMetric(float64(kvs)/float64(leaves), "kvs/leaf")
+}
+
+func BenchmarkBTreeInsert(b *testing.B) {
+	for _, size := range benchKeySizes {
+		b.Run(fmt.Sprintf("key=%dB", size), func(b *testing.B) {
+			tree := &newC().tree
+			val := make([]byte, BENCH_VAL_SIZE)
+			order := rand.New(rand.NewSource(1)).Perm(b.N)
+			b.ResetTimer()
+			for _, i := range order {
+				tree.Insert(benchKey(i, size), val)
+			}
+			b.StopTimer()
+			reportShape(b, tree)
+		})
+	}
+}
+
+func BenchmarkBTreeGet(b *testing.B) {
+	for _, size := range benchKeySizes {
+		b.Run(fmt.Sprintf("key=%dB", size), func(b *testing.B) {
+			tree := benchTree(b, BENCH_KEYS, size)
+			rnd := rand.New(rand.NewSource(2))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok := tree.Get(benchKey(rnd.Intn(BENCH_KEYS), size)); !ok {
+					b.Fatal("key not found")
+				}
+			}
+			b.StopTimer()
+			reportShape(b, tree)
+		})
+	}
+}
+
+func BenchmarkBTreeDelete(b *testing.B) {
+	for _, size := range benchKeySizes {
+		b.Run(fmt.Sprintf("key=%dB", size), func(b *testing.B) {
+			tree := benchTree(b, b.N, size)
+			order := rand.New(rand.NewSource(2)).Perm(b.N)
+			b.ResetTimer()
+			for _, i := range order {
+				if ok, _ := tree.Delete(benchKey(i, size)); !ok {
+					b.Fatal("key not deleted")
+				}
+			}
+		})
+	}
+}
+
+// Seek and read the next 100 KVs
+func BenchmarkBTreeScan(b *testing.B) {
+	for _, size := range benchKeySizes {
+		b.Run(fmt.Sprintf("key=%dB", size), func(b *testing.B) {
+			tree := benchTree(b, BENCH_KEYS, size)
+			rnd := rand.New(rand.NewSource(2))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				iter := tree.Seek(benchKey(rnd.Intn(BENCH_KEYS-100), size), CMP_GE)
+				for n := 0; n < 100 && iter.Valid(); n++ {
+					iter.Deref()
+					iter.Next()
+				}
+			}
+		})
+	}
+}
+
+// every Set is a transaction with 2 fsyncs, this measures the disk more than the tree
+func BenchmarkKVSet(b *testing.B) {
+	kv := &KV{Path: filepath.Join(b.TempDir(), "bench.db")}
+	if err := kv.Open(); err != nil {
+		b.Fatal(err)
+	}
+	defer kv.Close()
+	val := make([]byte, BENCH_VAL_SIZE)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := kv.Set(benchKey(i, 16), val); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 100 Sets in one transaction
+func BenchmarkKVTXCommit(b *testing.B) {
+	kv := &KV{Path: filepath.Join(b.TempDir(), "bench.db")}
+	if err := kv.Open(); err != nil {
+		b.Fatal(err)
+	}
+	defer kv.Close()
+	val := make([]byte, BENCH_VAL_SIZE)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx, _ := kv.Begin()
+		for j := 0; j < 100; j++ {
+			tx.Set(benchKey(i*100+j, 16), val)
+		}
+		if err := tx.Commit(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkKVGet(b *testing.B) {
+	kv := &KV{Path: filepath.Join(b.TempDir(), "bench.db")}
+	if err := kv.Open(); err != nil {
+		b.Fatal(err)
+	}
+	defer kv.Close()
+	tx, _ := kv.Begin()
+	val := make([]byte, BENCH_VAL_SIZE)
+	for i := 0; i < BENCH_KEYS; i++ {
+		tx.Set(benchKey(i, 16), val)
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	rnd := rand.New(rand.NewSource(2))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := kv.Get(benchKey(rnd.Intn(BENCH_KEYS), 16)); !ok {
+			b.Fatal("key not found")
+		}
+	}
+}
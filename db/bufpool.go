@@ -0,0 +1,306 @@
+package db
+
+import (
+	"cmp"
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Buffer pool, a bounded cache of pages between the tree and the file
+
+/*
+*
+The pool has a fixed number of frames, each holds one page. Fetch returns the frame of a
+page, reading it from the file on a miss, and pins it: a pinned frame is never evicted.
+Unpin releases it and marks it dirty if the caller changed it. A dirty frame is written
+back when it is evicted and by FlushAll.
+
+When every frame holds a page, the victim is an unpinned frame picked by the policy:
+
+  - EVICT_LRU: the least recently used frame
+  - EVICT_CLOCK: a hand sweeps the frames, a frame used since the hand last passed gets
+    a second chance, its reference bit is cleared and the hand moves on
+
+KV pins a frame only while it copies the page out of it: the tree holds on to nodes (an
+iterator keeps its whole path) and never tells when it is done with them.
+Pages reachable from a committed root never change, so the only dirty frames are the new
+pages of the transaction in progress. Evicting one writes it past the end of the committed
+file, which is safe because the meta page doesn't point to it until the commit.
+
+Like KV, the pool is not safe for concurrent use.
+*/
+type EvictionPolicy int
+
+const (
+	EVICT_LRU EvictionPolicy = iota
+	EVICT_CLOCK
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EVICT_LRU:
+		return "lru"
+	case EVICT_CLOCK:
+		return "clock"
+	}
+	return fmt.Sprintf("EvictionPolicy(%d)", int(p))
+}
+
+// frames of a KV that doesn't set PoolFrames, 1MB of 4kb pages
+const DEFAULT_POOL_FRAMES = 256
+
+var ErrPoolFull = errors.New("buffer pool: every frame is pinned")
+
+type PoolStats struct {
+	Hits       int
+	Misses     int
+	Evictions  int
+	WriteBacks int // dirty frames written to the file
+	Frames     int
+	Used       int // frames holding a page
+	Dirty      int
+	Pinned     int
+}
+
+func (s PoolStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+type frame struct {
+	ptr   uint64
+	data  []byte
+	valid bool // holds a page
+	pins  int
+	dirty bool
+	ref   bool          // CLOCK: used since the hand last passed
+	elem  *list.Element // LRU: position in the recency list
+}
+
+type BufferPool struct {
+	file     File
+	pageSize int
+	policy   EvictionPolicy
+	frames   []frame
+	table    map[uint64]int // page -> frame
+	free     []int          // frames without a page
+	lru      *list.List     // frame indexes, least recently used first
+	hand     int
+	stats    PoolStats
+}
+
+func NewBufferPool(file File, pageSize, nframes int, policy EvictionPolicy) *BufferPool {
+	assertStatement(nframes > 0, "NewBufferPool: the pool needs at least one frame")
+	p := &BufferPool{
+		file:     file,
+		pageSize: pageSize,
+		policy:   policy,
+		frames:   make([]frame, nframes),
+		table:    map[uint64]int{},
+		lru:      list.New(),
+	}
+	for i := range p.frames {
+		p.frames[i].data = make([]byte, pageSize)
+		p.free = append(p.free, nframes-1-i) // frame 0 is used first
+	}
+	return p
+}
+
+// the frame of a page, pinned, read from the file on a miss
+func (p *BufferPool) Fetch(ptr uint64) ([]byte, error) {
+	if i, ok := p.table[ptr]; ok {
+		p.stats.Hits++
+		p.touch(i)
+		p.frames[i].pins++
+		return p.frames[i].data, nil
+	}
+	p.stats.Misses++
+
+	i, err := p.frameFor(ptr)
+	if err != nil {
+		return nil, err
+	}
+	f := &p.frames[i]
+	n, err := p.file.ReadAt(f.data, int64(ptr)*int64(p.pageSize))
+	if err != nil && !errors.Is(err, io.EOF) {
+		p.drop(i)
+		return nil, fmt.Errorf("read page %d: %w", ptr, err)
+	}
+	clear(f.data[n:])
+	f.pins = 1
+	return f.data, nil
+}
+
+// put a new page in a frame without reading the file, it is pinned and dirty
+func (p *BufferPool) NewPage(ptr uint64, data []byte) ([]byte, error) {
+	assertStatement(len(data) <= p.pageSize, "NewPage: the page should fit in a frame")
+	i, ok := p.table[ptr]
+	if ok {
+		p.touch(i)
+	} else {
+		var err error
+		if i, err = p.frameFor(ptr); err != nil {
+			return nil, err
+		}
+	}
+	f := &p.frames[i]
+	copy(f.data, data)
+	clear(f.data[len(data):])
+	f.pins++
+	f.dirty = true
+	return f.data, nil
+}
+
+// release a frame returned by Fetch or NewPage, dirty if the caller changed it
+func (p *BufferPool) Unpin(ptr uint64, dirty bool) {
+	i, ok := p.table[ptr]
+	assertStatement(ok && p.frames[i].pins > 0, "Unpin: the page should be pinned")
+	p.frames[i].pins--
+	p.frames[i].dirty = p.frames[i].dirty || dirty
+}
+
+// write every dirty frame to the file, in page order
+func (p *BufferPool) FlushAll() error {
+	var dirty []int
+	for i := range p.frames {
+		if p.frames[i].valid && p.frames[i].dirty {
+			dirty = append(dirty, i)
+		}
+	}
+	slices.SortFunc(dirty, func(a, b int) int {
+		return cmp.Compare(p.frames[a].ptr, p.frames[b].ptr)
+	})
+	for _, i := range dirty {
+		if err := p.writeBack(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forget the pages from ptr on, dirty or not
+func (p *BufferPool) Discard(from uint64) {
+	for i := range p.frames {
+		if p.frames[i].valid && p.frames[i].ptr >= from {
+			assertStatement(p.frames[i].pins == 0, "Discard: the page should not be pinned")
+			p.drop(i)
+		}
+	}
+}
+
+func (p *BufferPool) Stats() PoolStats {
+	stats := p.stats
+	stats.Frames = len(p.frames)
+	for _, f := range p.frames {
+		if !f.valid {
+			continue
+		}
+		stats.Used++
+		if f.dirty {
+			stats.Dirty++
+		}
+		if f.pins > 0 {
+			stats.Pinned++
+		}
+	}
+	return stats
+}
+
+// mark a frame as used for the eviction policy
+func (p *BufferPool) touch(i int) {
+	switch p.policy {
+	case EVICT_LRU:
+		p.lru.MoveToBack(p.frames[i].elem)
+	case EVICT_CLOCK:
+		p.frames[i].ref = true
+	}
+}
+
+// a frame for a page that isn't in the pool, a free one or a victim
+func (p *BufferPool) frameFor(ptr uint64) (int, error) {
+	var i int
+	if n := len(p.free); n > 0 {
+		i = p.free[n-1]
+		p.free = p.free[:n-1]
+	} else {
+		var err error
+		if i, err = p.victim(); err != nil {
+			return 0, err
+		}
+		if p.frames[i].dirty {
+			if err := p.writeBack(i); err != nil {
+				return 0, err
+			}
+		}
+		delete(p.table, p.frames[i].ptr)
+		if p.frames[i].elem != nil {
+			p.lru.Remove(p.frames[i].elem)
+		}
+		p.stats.Evictions++
+	}
+
+	p.frames[i] = frame{ptr: ptr, data: p.frames[i].data, valid: true, ref: true}
+	if p.policy == EVICT_LRU {
+		p.frames[i].elem = p.lru.PushBack(i)
+	}
+	p.table[ptr] = i
+	return i, nil
+}
+
+func (p *BufferPool) victim() (int, error) {
+	switch p.policy {
+	case EVICT_LRU:
+		for e := p.lru.Front(); e != nil; e = e.Next() {
+			if i := e.Value.(int); p.frames[i].pins == 0 {
+				return i, nil
+			}
+		}
+	case EVICT_CLOCK:
+		// the first sweep may only clear reference bits
+		for range 2 * len(p.frames) {
+			i := p.hand
+			p.hand = (p.hand + 1) % len(p.frames)
+			f := &p.frames[i]
+			if f.pins > 0 {
+				continue
+			}
+			if f.ref {
+				f.ref = false
+				continue
+			}
+			return i, nil
+		}
+	}
+	return 0, ErrPoolFull
+}
+
+func (p *BufferPool) writeBack(i int) error {
+	f := &p.frames[i]
+	if _, err := p.file.WriteAt(f.data, int64(f.ptr)*int64(p.pageSize)); err != nil {
+		return fmt.Errorf("write page %d: %w", f.ptr, err)
+	}
+	f.dirty = false
+	p.stats.WriteBacks++
+	return nil
+}
+
+// empty a frame without writing it
+func (p *BufferPool) drop(i int) {
+	f := &p.frames[i]
+	delete(p.table, f.ptr)
+	if f.elem != nil {
+		p.lru.Remove(f.elem)
+	}
+	p.frames[i] = frame{data: f.data}
+	p.free = append(p.free, i)
+}
+
+// the counters of the buffer pool of the KV
+func (db *KV) PoolStats() PoolStats {
+	return db.pool.Stats()
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A File in memory that counts the reads and writes of pages
+type memFile struct {
+	data   []byte
+	reads  int
+	writes []uint64 // pages written, in order
+	fail   error    // returned by every read and write when set
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.fail != nil {
+		return 0, f.fail
+	}
+	f.reads++
+	if off >= int64(len(f.data)) {
+		return 0, nil
+	}
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.fail != nil {
+		return 0, f.fail
+	}
+	f.writes = append(f.writes, uint64(off)/BTREE_PAGE_SIZE)
+	if end := int(off) + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	copy(f.data[off:], p)
+	return len(p), nil
+}
+
+func (f *memFile) Sync() error          { return nil }
+func (f *memFile) Size() (int64, error) { return int64(len(f.data)), nil }
+func (f *memFile) Close() error         { return nil }
+
+// Helper: A file whose page i starts with the byte i
+func newMemFile(npages int) *memFile {
+	f := &memFile{data: make([]byte, npages*BTREE_PAGE_SIZE)}
+	for i := 0; i < npages; i++ {
+		f.data[i*BTREE_PAGE_SIZE] = byte(i)
+	}
+	return f
+}
+
+// Helper: Fetch and unpin a list of pages
+func fetchAll(t *testing.T, pool *BufferPool, ptrs ...uint64) {
+	for _, ptr := range ptrs {
+		data, err := pool.Fetch(ptr)
+		assert.NoError(t, err)
+		assert.Equal(t, byte(ptr), data[0])
+		pool.Unpin(ptr, false)
+	}
+}
+
+// Helper: The pages in the pool
+func cached(pool *BufferPool, ptrs ...uint64) []bool {
+	var in []bool
+	for _, ptr := range ptrs {
+		_, ok := pool.table[ptr]
+		in = append(in, ok)
+	}
+	return in
+}
+
+func TestBufferPool(t *testing.T) {
+	t.Run("Hits and misses", func(t *testing.T) {
+		f := newMemFile(10)
+		pool := NewBufferPool(f, BTREE_PAGE_SIZE, 4, EVICT_LRU)
+		fetchAll(t, pool, 1, 2, 1, 1, 3)
+
+		stats := pool.Stats()
+		assert.Equal(t, 2, stats.Hits)
+		assert.Equal(t, 3, stats.Misses)
+		assert.Equal(t, 3, f.reads, "Only misses read the file")
+		assert.Equal(t, 3, stats.Used)
+		assert.Equal(t, 0, stats.Evictions)
+		assert.InDelta(t, 0.4, stats.HitRate(), 1e-9)
+	})
+
+	t.Run("LRU evicts the least recently used page", func(t *testing.T) {
+		pool := NewBufferPool(newMemFile(10), BTREE_PAGE_SIZE, 3, EVICT_LRU)
+		fetchAll(t, pool, 1, 2, 3, 1, 4)
+		assert.Equal(t, []bool{true, false, true, true}, cached(pool, 1, 2, 3, 4))
+		fetchAll(t, pool, 5)
+		assert.Equal(t, []bool{true, false, false, true, true}, cached(pool, 1, 2, 3, 4, 5))
+		assert.Equal(t, 2, pool.Stats().Evictions)
+	})
+
+	t.Run("CLOCK gives used pages a second chance", func(t *testing.T) {
+		pool := NewBufferPool(newMemFile(10), BTREE_PAGE_SIZE, 3, EVICT_CLOCK)
+		fetchAll(t, pool, 1, 2, 3)
+		// every reference bit is set, a full sweep clears them and the hand stops at 1
+		fetchAll(t, pool, 4)
+		assert.Equal(t, []bool{false, true, true, true}, cached(pool, 1, 2, 3, 4))
+		// 2 is used again, so the hand skips it and takes 3
+		fetchAll(t, pool, 2, 5)
+		assert.Equal(t, []bool{true, false, true, true}, cached(pool, 2, 3, 4, 5))
+	})
+
+	t.Run("Pinned pages stay", func(t *testing.T) {
+		for _, policy := range []EvictionPolicy{EVICT_LRU, EVICT_CLOCK} {
+			pool := NewBufferPool(newMemFile(10), BTREE_PAGE_SIZE, 2, policy)
+			_, err := pool.Fetch(1)
+			assert.NoError(t, err)
+			fetchAll(t, pool, 2, 3, 4)
+			assert.Equal(t, []bool{true, false, false, true}, cached(pool, 1, 2, 3, 4), policy)
+
+			_, err = pool.Fetch(5)
+			assert.NoError(t, err)
+			_, err = pool.Fetch(6)
+			assert.ErrorIs(t, err, ErrPoolFull, policy)
+			assert.Equal(t, 2, pool.Stats().Pinned)
+
+			pool.Unpin(1, false)
+			fetchAll(t, pool, 6)
+		}
+	})
+
+	t.Run("Dirty pages are written back when evicted", func(t *testing.T) {
+		f := newMemFile(10)
+		pool := NewBufferPool(f, BTREE_PAGE_SIZE, 2, EVICT_LRU)
+		data, _ := pool.Fetch(1)
+		data[1] = 'x'
+		pool.Unpin(1, true)
+		page := make([]byte, 100)
+		page[0] = 20
+		pool.NewPage(20, page)
+		pool.Unpin(20, false)
+		assert.Equal(t, 2, pool.Stats().Dirty)
+		assert.Empty(t, f.writes)
+
+		fetchAll(t, pool, 2, 3)
+		assert.Equal(t, []uint64{1, 20}, f.writes)
+		assert.Equal(t, byte('x'), f.data[BTREE_PAGE_SIZE+1])
+		assert.Equal(t, byte(20), f.data[20*BTREE_PAGE_SIZE])
+		assert.Equal(t, 2, pool.Stats().WriteBacks)
+
+		fetchAll(t, pool, 1, 20)
+		assert.Equal(t, 0, pool.Stats().Dirty)
+	})
+
+	t.Run("FlushAll writes the dirty pages in order", func(t *testing.T) {
+		f := newMemFile(10)
+		pool := NewBufferPool(f, BTREE_PAGE_SIZE, 8, EVICT_CLOCK)
+		for _, ptr := range []uint64{15, 12, 14} {
+			pool.NewPage(ptr, []byte{byte(ptr)})
+			pool.Unpin(ptr, false)
+		}
+		fetchAll(t, pool, 3)
+		assert.NoError(t, pool.FlushAll())
+		assert.Equal(t, []uint64{12, 14, 15}, f.writes)
+		assert.Equal(t, 0, pool.Stats().Dirty)
+
+		assert.NoError(t, pool.FlushAll())
+		assert.Len(t, f.writes, 3, "Nothing left to write")
+	})
+
+	// Edge cases
+	t.Run("Discard forgets pages without writing them", func(t *testing.T) {
+		f := newMemFile(10)
+		pool := NewBufferPool(f, BTREE_PAGE_SIZE, 4, EVICT_LRU)
+		fetchAll(t, pool, 1, 2)
+		pool.NewPage(10, []byte{10})
+		pool.Unpin(10, false)
+		pool.NewPage(11, []byte{11})
+		pool.Unpin(11, false)
+
+		pool.Discard(10)
+		assert.Equal(t, []bool{true, true, false, false}, cached(pool, 1, 2, 10, 11))
+		assert.NoError(t, pool.FlushAll())
+		assert.Empty(t, f.writes)
+		fetchAll(t, pool, 3, 4)
+		assert.Equal(t, 0, pool.Stats().Evictions, "Discarded frames are free")
+	})
+
+	t.Run("Page past the end of the file reads as zeros", func(t *testing.T) {
+		pool := NewBufferPool(newMemFile(2), BTREE_PAGE_SIZE, 1, EVICT_LRU)
+		fetchAll(t, pool, 1)
+		data, err := pool.Fetch(5)
+		assert.NoError(t, err)
+		assert.Equal(t, make([]byte, BTREE_PAGE_SIZE), data, "The old page is cleared")
+	})
+
+	t.Run("I/O errors", func(t *testing.T) {
+		f := newMemFile(10)
+		pool := NewBufferPool(f, BTREE_PAGE_SIZE, 1, EVICT_LRU)
+		pool.NewPage(20, []byte{20})
+		pool.Unpin(20, false)
+
+		f.fail = errors.New("disk on fire")
+		_, err := pool.Fetch(1)
+		assert.ErrorContains(t, err, "write page 20: disk on fire")
+		assert.Equal(t, []bool{true}, cached(pool, 20), "The dirty page stays")
+
+		f.fail = nil
+		fetchAll(t, pool, 1)
+		f.fail = errors.New("disk on fire")
+		_, err = pool.Fetch(2)
+		assert.ErrorContains(t, err, "read page 2: disk on fire")
+		assert.Equal(t, 0, pool.Stats().Used)
+	})
+
+	t.Run("Unpin of a page that isn't pinned", func(t *testing.T) {
+		pool := NewBufferPool(newMemFile(10), BTREE_PAGE_SIZE, 2, EVICT_LRU)
+		fetchAll(t, pool, 1)
+		assert.Panics(t, func() { pool.Unpin(1, false) })
+		assert.Panics(t, func() { pool.Unpin(2, false) })
+	})
+}
+
+func TestKVBufferPool(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EVICT_LRU, EVICT_CLOCK} {
+		t.Run(fmt.Sprintf("Tiny %v pool", policy), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			db := &KV{Path: path, PoolFrames: 4, PoolPolicy: policy}
+			assert.NoError(t, db.Open())
+			defer db.Close()
+
+			// a transaction with many more new pages than frames
+			want := map[string]string{}
+			tx, _ := db.Begin()
+			for i := 0; i < 500; i++ {
+				key, val := fmt.Sprintf("key%04d", i), fmt.Sprintf("%0100d", i)
+				assert.NoError(t, tx.Set([]byte(key), []byte(val)))
+				want[key] = val
+			}
+			assert.NoError(t, tx.Commit())
+			assert.Greater(t, db.PoolStats().WriteBacks, 0, "New pages were evicted")
+			assert.Equal(t, want, dumpKV(db))
+
+			// the pages evicted by an aborted transaction are not seen
+			tx, _ = db.Begin()
+			for i := 0; i < 500; i++ {
+				tx.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("aborted"))
+			}
+			tx.Abort()
+			assert.Equal(t, want, dumpKV(db))
+			assert.True(t, db.Check().OK())
+			assert.Equal(t, 4, db.PoolStats().Frames)
+
+			assert.NoError(t, db.Set([]byte("key0000"), []byte("new")))
+			want["key0000"] = "new"
+			db.Close()
+			reopened := openKV(t, path)
+			defer reopened.Close()
+			assert.Equal(t, want, dumpKV(reopened))
+		})
+	}
+
+	t.Run("Reads hit the pool", func(t *testing.T) {
+		db := backupDB(t, 300)
+		defer db.Close()
+		before := db.PoolStats()
+		for i := 0; i < 10; i++ {
+			db.Get([]byte("key0100"))
+		}
+		after := db.PoolStats()
+		assert.Equal(t, before.Misses, after.Misses)
+		assert.Greater(t, after.Hits, before.Hits)
+		assert.Equal(t, DEFAULT_POOL_FRAMES, after.Frames)
+	})
+}
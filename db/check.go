@@ -0,0 +1,243 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Structural checks of a tree, fsck for database files
+
+/*
+*
+Check walks every page reachable from the root and reports all the problems it finds
+instead of stopping at the first one:
+
+  - page layout: node type, number of keys, offsets and KVs inside the page
+  - key and value sizes within the limits, no values in internal nodes
+  - keys sorted in each node and inside the range given by the parent
+  - the key of a kid in its parent is the first key of the kid (separator keys)
+  - the first key of the tree is the empty dummy key
+  - all the leaves are at the same depth
+  - every page is reached once, and for KV.Check pointers stay inside the file
+
+Pages are decoded by checkPage instead of the BNode accessors, which panic on bad offsets.
+
+There is no free list and pages have no checksums yet, so neither is checked. KV.Check
+lists the pages the tree doesn't reach instead: copy on write leaks them until there is
+a free list, so they are expected and not violations.
+*/
+type Violation struct {
+	Page uint64
+	Msg  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("page %d: %s", v.Page, v.Msg)
+}
+
+type CheckReport struct {
+	Violations  []Violation
+	Height      int // levels, 0 for an empty tree
+	Pages       int // pages reachable from the root
+	Leaves      int
+	Keys        int      // KVs in the leaves without the dummy key
+	Unreachable []uint64 // pages of the file the tree doesn't reach, KV.Check only
+}
+
+func (r *CheckReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+type checker struct {
+	tree   *BTree
+	npages uint64 // pointers must be below it, 0 if unknown
+	seen   map[uint64]bool
+	report *CheckReport
+}
+
+// check the tree in memory, the pages can't be checked against a file size
+func Check(tree *BTree) *CheckReport {
+	c := &checker{tree: tree, seen: map[uint64]bool{}, report: &CheckReport{}}
+	c.run()
+	return c.report
+}
+
+// check the committed tree and find the pages of the file it doesn't reach
+func (db *KV) Check() *CheckReport {
+	c := &checker{tree: &db.tree, npages: db.page.flushed, seen: map[uint64]bool{}, report: &CheckReport{}}
+	c.run()
+	for ptr := uint64(1); ptr < db.page.flushed; ptr++ {
+		if !c.seen[ptr] {
+			c.report.Unreachable = append(c.report.Unreachable, ptr)
+		}
+	}
+	return c.report
+}
+
+func (c *checker) run() {
+	if c.tree.root == 0 {
+		return
+	}
+	if c.npages > 0 && c.tree.root >= c.npages {
+		c.fail(c.tree.root, "the root is outside the file of %d pages", c.npages)
+		return
+	}
+	// the leftmost path starts with the dummy key, so the root's first key is empty
+	c.walk(c.tree.root, 1, []byte{}, nil)
+}
+
+func (c *checker) fail(ptr uint64, format string, args ...any) {
+	c.report.Violations = append(c.report.Violations, Violation{Page: ptr, Msg: fmt.Sprintf(format, args...)})
+}
+
+// read a page, a bad pointer can make tree.get panic
+func (c *checker) read(ptr uint64) (page []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return c.tree.get(ptr), nil
+}
+
+// check the node and its kids, its keys must be in [first, end) and start with first.
+// A nil end means no upper bound.
+func (c *checker) walk(ptr uint64, depth int, first, end []byte) {
+	if c.seen[ptr] {
+		c.fail(ptr, "reached more than once")
+		return
+	}
+	c.seen[ptr] = true
+	c.report.Pages++
+
+	data, err := c.read(ptr)
+	if err != nil {
+		c.fail(ptr, "read: %v", err)
+		return
+	}
+	page, err := checkPage(data, c.tree.pageSize())
+	if err != nil {
+		c.fail(ptr, "%v", err)
+		return
+	}
+
+	if page.wide != wideOffsets(c.tree.pageSize()) {
+		c.fail(ptr, "offsets of the wrong width for pages of %d bytes", c.tree.pageSize())
+	}
+	maxKey, maxVal := MaxKeySize(c.tree.pageSize()), MaxValSize(c.tree.pageSize())
+	for i, key := range page.keys {
+		if len(key) > maxKey {
+			c.fail(ptr, "key %d has %d bytes, the limit is %d", i, len(key), maxKey)
+		}
+		if len(page.vals[i]) > maxVal {
+			c.fail(ptr, "value %d has %d bytes, the limit is %d", i, len(page.vals[i]), maxVal)
+		}
+		if page.btype == BNODE_NODE && len(page.vals[i]) > 0 {
+			c.fail(ptr, "internal node with a value for key %d", i)
+		}
+		if i > 0 && c.tree.compare(page.keys[i-1], key) >= 0 {
+			c.fail(ptr, "key %d %q is not after key %d %q", i, key, i-1, page.keys[i-1])
+		}
+	}
+	if !bytes.Equal(page.keys[0], first) {
+		c.fail(ptr, "first key %q differs from its key %q in the parent", page.keys[0], first)
+	}
+	if last := page.keys[len(page.keys)-1]; end != nil && c.tree.compare(last, end) >= 0 {
+		c.fail(ptr, "last key %q is not before the next key %q in the parent", last, end)
+	}
+
+	if page.btype == BNODE_LEAF {
+		if c.report.Height == 0 {
+			c.report.Height = depth
+		} else if depth != c.report.Height {
+			c.fail(ptr, "leaf at depth %d, other leaves are at depth %d", depth, c.report.Height)
+		}
+		c.report.Leaves++
+		c.report.Keys += len(page.keys)
+		if len(first) == 0 && len(page.keys[0]) == 0 {
+			c.report.Keys-- // the dummy key
+		}
+		return
+	}
+
+	for i, kid := range page.ptrs {
+		if kid == 0 {
+			c.fail(ptr, "pointer %d is null, page 0 is the meta page", i)
+			continue
+		}
+		if c.npages > 0 && kid >= c.npages {
+			c.fail(ptr, "pointer %d to page %d is outside the file of %d pages", i, kid, c.npages)
+			continue
+		}
+		kidEnd := end
+		if i+1 < len(page.keys) {
+			kidEnd = page.keys[i+1]
+		}
+		c.walk(kid, depth+1, page.keys[i], kidEnd)
+	}
+}
+
+type checkedPage struct {
+	btype uint16
+	wide  bool // 4 byte offsets
+	ptrs  []uint64
+	keys  [][]byte
+	vals  [][]byte
+}
+
+// decode a page of at most pageSize bytes, checking that every offset and KV is inside it
+func checkPage(data []byte, pageSize int) (*checkedPage, error) {
+	if len(data) < HEADER {
+		return nil, fmt.Errorf("page of %d bytes has no header", len(data))
+	}
+	size := min(len(data), pageSize)
+	btype := binary.LittleEndian.Uint16(data)
+	page := &checkedPage{btype: btype &^ BNODE_WIDE, wide: btype&BNODE_WIDE != 0}
+	if page.btype != BNODE_NODE && page.btype != BNODE_LEAF {
+		return nil, fmt.Errorf("bad node type %d", btype)
+	}
+	nkeys := int(binary.LittleEndian.Uint16(data[2:]))
+	if nkeys == 0 {
+		return nil, errors.New("node without keys")
+	}
+	offsetSize := 2
+	if page.wide {
+		offsetSize = 4
+	}
+	kvStart := HEADER + (8+offsetSize)*nkeys
+	if kvStart > size {
+		return nil, fmt.Errorf("%d keys don't fit in a page", nkeys)
+	}
+
+	pos := kvStart // where the next KV starts
+	for i := 0; i < nkeys; i++ {
+		page.ptrs = append(page.ptrs, binary.LittleEndian.Uint64(data[HEADER+8*i:]))
+		if pos+4 > size {
+			return nil, fmt.Errorf("KV %d starts at %d, outside the page", i, pos)
+		}
+		klen := int(binary.LittleEndian.Uint16(data[pos:]))
+		vlen := int(binary.LittleEndian.Uint16(data[pos+2:]))
+		kvEnd := pos + 4 + klen + vlen
+		if kvEnd > size {
+			return nil, fmt.Errorf("KV %d ends at %d, outside the page", i, kvEnd)
+		}
+		// offset i+1 is the end of KV i relative to the first KV
+		if offset := readOffset(data[HEADER+8*nkeys+offsetSize*i:], page.wide); kvStart+offset != kvEnd {
+			return nil, fmt.Errorf("offset %d is %d but KV %d ends at %d", i+1, offset, i, kvEnd-kvStart)
+		}
+		page.keys = append(page.keys, data[pos+4:pos+4+klen])
+		page.vals = append(page.vals, data[pos+4+klen:kvEnd])
+		pos = kvEnd
+	}
+	return page, nil
+}
+
+// an offset of 2 bytes, or of 4 for the nodes with BNODE_WIDE
+func readOffset(data []byte, wide bool) int {
+	if wide {
+		return int(binary.LittleEndian.Uint32(data))
+	}
+	return int(binary.LittleEndian.Uint16(data))
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: The i-th key, long keys give small internal nodes
+func checkKey(i int) string {
+	return fmt.Sprintf("key_%04d", i) + strings.Repeat("k", 300)
+}
+
+// Helper: A tree with n keys, 3 levels from 100 keys
+func checkTree(t *testing.T, n int) *C {
+	c := newC()
+	for i := 0; i < n; i++ {
+		assert.NoError(t, c.add(checkKey(i), strings.Repeat("v", 200)))
+	}
+	return c
+}
+
+// Helper: The messages of the violations on a page
+func violations(report *CheckReport, page uint64) []string {
+	var msgs []string
+	for _, v := range report.Violations {
+		if v.Page == page {
+			msgs = append(msgs, v.Msg)
+		}
+	}
+	return msgs
+}
+
+func TestCheck(t *testing.T) {
+	t.Run("Valid trees pass", func(t *testing.T) {
+		c := checkTree(t, 1000)
+		for i := 0; i < 1000; i += 3 {
+			c.del(checkKey(i))
+		}
+		report := Check(&c.tree)
+		assert.True(t, report.OK(), "%v", report.Violations)
+		assert.Equal(t, len(c.ref), report.Keys)
+		assert.Equal(t, len(c.pages), report.Pages)
+		assert.Equal(t, 4, report.Height)
+	})
+
+	t.Run("Keys out of order", func(t *testing.T) {
+		c := checkTree(t, 100)
+		kid := BNode(c.pages[c.tree.root]).getPtr(1)
+		leaf := BNode(c.pages[kid]).getPtr(1)
+		node := BNode(c.pages[leaf])
+		// same length keys, so swapping the bytes keeps the layout valid
+		k1, k2 := node.getKey(1), node.getKey(2)
+		tmp := string(k1)
+		copy(k1, k2)
+		copy(k2, tmp)
+
+		msgs := violations(Check(&c.tree), leaf)
+		assert.Equal(t, 1, len(msgs))
+		assert.Contains(t, msgs[0], "key 2")
+	})
+
+	t.Run("Separator key differs from the first key of the kid", func(t *testing.T) {
+		c := checkTree(t, 100)
+		root := BNode(c.pages[c.tree.root])
+		// the key of kid 1 becomes the last key of kid 0
+		kid0 := BNode(c.pages[root.getPtr(0)])
+		copy(root.getKey(1), kid0.getKey(kid0.nkeys()-1))
+
+		report := Check(&c.tree)
+		msgs := violations(report, root.getPtr(1))
+		assert.Equal(t, 1, len(msgs), "%v", report.Violations)
+		assert.Contains(t, msgs[0], "differs from its key")
+		assert.Contains(t, violations(report, root.getPtr(0))[0], "not before the next key")
+	})
+
+	t.Run("Leaves at different depths", func(t *testing.T) {
+		c := checkTree(t, 100)
+		root := BNode(c.pages[c.tree.root])
+		// a leaf replaces the internal node, same first key
+		kid := root.getPtr(0)
+		leaf := BNode(c.pages[BNode(c.pages[kid]).getPtr(0)])
+		c.pages[kid] = leaf
+
+		report := Check(&c.tree)
+		assert.False(t, report.OK())
+		found := false
+		for _, v := range report.Violations {
+			found = found || strings.Contains(v.Msg, "depth")
+		}
+		assert.True(t, found, "%v", report.Violations)
+	})
+
+	t.Run("Page reached twice", func(t *testing.T) {
+		c := checkTree(t, 100)
+		root := BNode(c.pages[c.tree.root])
+		root.setPtr(1, root.getPtr(0))
+
+		report := Check(&c.tree)
+		assert.Contains(t, violations(report, root.getPtr(0)), "reached more than once")
+	})
+
+	t.Run("Corrupt pages are reported, not panics", func(t *testing.T) {
+		c := checkTree(t, 100)
+		root := BNode(c.pages[c.tree.root])
+		kids := []uint64{root.getPtr(0), root.getPtr(1), root.getPtr(2)}
+		c.pages[kids[0]][0] = 7 // node type
+		binary.LittleEndian.PutUint16(c.pages[kids[1]][2:], 0xffff)
+		node := BNode(c.pages[kids[2]])
+		binary.LittleEndian.PutUint16(node[offsetPos(node, 1):], 3)
+
+		report := Check(&c.tree)
+		assert.Contains(t, violations(report, kids[0])[0], "bad node type 7")
+		assert.Contains(t, violations(report, kids[1])[0], "don't fit in a page")
+		assert.Contains(t, violations(report, kids[2])[0], "offset 1 is 3")
+	})
+
+	t.Run("Every violation is reported", func(t *testing.T) {
+		c := checkTree(t, 100)
+		root := BNode(c.pages[c.tree.root])
+		for i := uint16(0); i < root.nkeys(); i++ {
+			c.pages[root.getPtr(i)][0] = 9
+		}
+		assert.Equal(t, int(root.nkeys()), len(Check(&c.tree).Violations))
+	})
+
+	// Edge cases
+	t.Run("Empty tree", func(t *testing.T) {
+		report := Check(&newC().tree)
+		assert.True(t, report.OK())
+		assert.Equal(t, 0, report.Height)
+	})
+
+	t.Run("Missing dummy key", func(t *testing.T) {
+		c := newC()
+		c.add("a", "1")
+		leaf := BNode(c.pages[c.tree.root])
+		// the dummy key becomes "\x00"
+		fixed := BNode(make([]byte, BTREE_PAGE_SIZE))
+		fixed.setHeader(BNODE_LEAF, 2)
+		nodeAppendKV(fixed, 0, 0, []byte{0}, nil)
+		nodeAppendKV(fixed, 1, 0, leaf.getKey(1), leaf.getVal(1))
+		c.pages[c.tree.root] = fixed
+
+		msgs := violations(Check(&c.tree), c.tree.root)
+		assert.Equal(t, 1, len(msgs))
+		assert.Contains(t, msgs[0], "first key")
+	})
+
+	t.Run("Dangling pointer", func(t *testing.T) {
+		c := checkTree(t, 100)
+		root := BNode(c.pages[c.tree.root])
+		delete(c.pages, root.getPtr(0))
+		assert.Contains(t, violations(Check(&c.tree), root.getPtr(0))[0], "read:")
+	})
+}
+
+func TestKVCheck(t *testing.T) {
+	t.Run("Database file passes", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+		for i := 0; i < 300; i++ {
+			db.Set([]byte(fmt.Sprintf("key_%04d", i)), []byte(strings.Repeat("v", 100)))
+		}
+		report := db.Check()
+		assert.True(t, report.OK(), "%v", report.Violations)
+		assert.Equal(t, 300, report.Keys)
+		// copy on write leaves the old versions of the pages behind
+		assert.Equal(t, int(db.page.flushed)-1-report.Pages, len(report.Unreachable))
+		assert.NotEmpty(t, report.Unreachable)
+	})
+
+	t.Run("Pointer outside the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKV(t, path)
+		for i := 0; i < 300; i++ {
+			db.Set([]byte(fmt.Sprintf("key_%04d", i)), []byte(strings.Repeat("v", 100)))
+		}
+		root := db.tree.root
+		db.Close()
+
+		// point the first kid of the root past the end of the file
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		assert.NoError(t, err)
+		f.WriteAt(binary.LittleEndian.AppendUint64(nil, 1<<20), int64(root*BTREE_PAGE_SIZE+HEADER))
+		f.Close()
+
+		db = openKV(t, path)
+		defer db.Close()
+		msgs := violations(db.Check(), root)
+		assert.Equal(t, 1, len(msgs))
+		assert.Contains(t, msgs[0], "outside the file")
+	})
+
+	// Edge cases
+	t.Run("New database", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+		report := db.Check()
+		assert.True(t, report.OK())
+		assert.Empty(t, report.Unreachable)
+	})
+}
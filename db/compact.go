@@ -0,0 +1,245 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Compaction, rewriting the tree into a fresh file
+
+/*
+*
+Copy on write appends every update to the file and there is no free list, so the file
+only grows and the leaves end up scattered. Compact bulk loads the committed tree into a
+new file:
+
+  - the leaves are written first, in key order, from page 1 on, so range scans read
+    the file sequentially
+  - then each level of internal nodes, built from the first keys of the level below
+  - the root is the last page
+
+Each page is filled up to fill * the page size bytes. 1 packs the pages, which makes the
+next insert into any leaf split it, a lower fill leaves room for updates. A leaf gets at
+least 1 KV and an internal node at least 2 kids whatever the fill, so every level is
+smaller than the one below.
+
+Vacuum compacts into a file next to the database and renames it over the database, the
+rename is atomic so a crash leaves either the old or the new file. It switches the KV to
+the new file, so it must not run while a Backup is reading the old one.
+*/
+const COMPACT_FILL = 0.9
+
+var ErrBadFill = errors.New("fill must be in (0, 1]")
+
+// a KV or a pointer waiting to be written into a node
+type compactEntry struct {
+	ptr uint64
+	key []byte
+	val []byte
+}
+
+// writes the pages of the new file in order
+type compactor struct {
+	fd       *os.File
+	pageSize int
+	next     uint64 // the pointer of the next page written
+	limit    int    // bytes a node is filled up to
+}
+
+// bytes the entry takes in a node: pointer, offset and KV
+func (c *compactor) size(e compactEntry) int {
+	offset := 2
+	if wideOffsets(c.pageSize) {
+		offset = 4
+	}
+	return 8 + offset + 4 + len(e.key) + len(e.val)
+}
+
+func (c *compactor) write(btype uint16, entries []compactEntry) (uint64, error) {
+	node := newNode(c.pageSize, wideOffsets(c.pageSize))
+	node.setHeader(btype, uint16(len(entries)))
+	for i, e := range entries {
+		nodeAppendKV(node, uint16(i), e.ptr, e.key, e.val)
+	}
+	ptr := c.next
+	if _, err := c.fd.WriteAt(node, int64(ptr)*int64(c.pageSize)); err != nil {
+		return 0, fmt.Errorf("write page %d: %w", ptr, err)
+	}
+	c.next++
+	return ptr, nil
+}
+
+// packs the entries of one level into nodes as they come
+type compactLevel struct {
+	c       *compactor
+	btype   uint16
+	pending []compactEntry // the node being filled
+	size    int
+	parents []compactEntry // the first key and pointer of each node written
+}
+
+func (l *compactLevel) add(e compactEntry) error {
+	minEntries := 1
+	if l.btype == BNODE_NODE {
+		minEntries = 2
+	}
+	size := l.c.size(e)
+	full := l.size+size > l.c.limit || l.size+size > l.c.pageSize
+	if full && len(l.pending) >= minEntries {
+		if err := l.flush(); err != nil {
+			return err
+		}
+	}
+	if len(l.pending) == 0 {
+		l.size = HEADER
+	}
+	l.pending = append(l.pending, e)
+	l.size += size
+	return nil
+}
+
+func (l *compactLevel) flush() error {
+	ptr, err := l.c.write(l.btype, l.pending)
+	if err != nil {
+		return err
+	}
+	// a copy, the key may point into a whole page
+	l.parents = append(l.parents, compactEntry{ptr: ptr, key: bytes.Clone(l.pending[0].key)})
+	l.pending = l.pending[:0]
+	return nil
+}
+
+// bulk load the committed tree into fd and write its meta page
+func (db *KV) compactInto(fd *os.File, fill float64) error {
+	if fill <= 0 || fill > 1 {
+		return fmt.Errorf("%w: %v", ErrBadFill, fill)
+	}
+	c := &compactor{fd: fd, pageSize: db.PageSize, next: 1, limit: int(fill * float64(db.PageSize))}
+
+	root := uint64(0)
+	if db.tree.root != 0 {
+		// the leaves are streamed, only the levels above are kept in memory
+		level := &compactLevel{c: c, btype: BNODE_LEAF}
+		if err := level.add(compactEntry{key: []byte{}, val: []byte{}}); err != nil { // the dummy key
+			return err
+		}
+		for iter := db.Seek(nil, CMP_GE); iter.Valid(); iter.Next() {
+			key, val := iter.Deref()
+			if err := level.add(compactEntry{key: key, val: val}); err != nil {
+				return err
+			}
+		}
+		if err := level.flush(); err != nil {
+			return err
+		}
+
+		for len(level.parents) > 1 {
+			entries := level.parents
+			level = &compactLevel{c: c, btype: BNODE_NODE}
+			for _, e := range entries {
+				if err := level.add(e); err != nil {
+					return err
+				}
+			}
+			if err := level.flush(); err != nil {
+				return err
+			}
+		}
+		root = level.parents[0].ptr
+	}
+
+	if err := fd.Sync(); err != nil {
+		return fmt.Errorf("fsync pages: %w", err)
+	}
+	if _, err := fd.WriteAt(db.encodeMeta(root, c.next), 0); err != nil {
+		return fmt.Errorf("write meta page: %w", err)
+	}
+	if err := fd.Sync(); err != nil {
+		return fmt.Errorf("fsync meta page: %w", err)
+	}
+	return nil
+}
+
+// check a compacted file before it is used, it is ordered by cmp
+func checkFile(path string, cmp *Comparator) error {
+	kv := &KV{Path: path, Comparator: cmp}
+	if err := kv.Open(); err != nil {
+		return err
+	}
+	defer kv.Close()
+	if report := kv.Check(); !report.OK() {
+		return fmt.Errorf("compacted file has %d violations, the first one %v", len(report.Violations), report.Violations[0])
+	}
+	return nil
+}
+
+// write the committed tree to a new database file dst, dst must not exist
+func (db *KV) Compact(dst string, fill float64) (err error) {
+	fd, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	if err := db.compactInto(fd, fill); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	return checkFile(dst, db.Comparator)
+}
+
+// compact the database in place, there must be no transaction in progress
+func (db *KV) Vacuum(fill float64) error {
+	if db.tx != nil {
+		return ErrTxInProgress
+	}
+	if db.Path == "" {
+		return errors.New("vacuum: the database was opened without a path")
+	}
+	tmp := db.Path + ".vacuum"
+	os.Remove(tmp) // left by a crash during an earlier vacuum
+	if err := db.Compact(tmp, fill); err != nil {
+		return err
+	}
+
+	// open the new file before the rename, once it is the database nothing can fail and
+	// leave the KV on the old file that is gone from the directory
+	next := &KV{Path: db.Path, PageSize: db.PageSize, Comparator: db.Comparator, PoolFrames: db.PoolFrames, PoolPolicy: db.PoolPolicy}
+	fd, err := os.OpenFile(tmp, os.O_RDWR, 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if err := next.OpenFile(osFile{fd}); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	if err := os.Rename(tmp, db.Path); err != nil {
+		next.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	// make the rename durable
+	if dir, err := os.Open(filepath.Dir(db.Path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	// switch to the new file, the page size and the order are the same
+	old := db.fd
+	db.mu.Lock()
+	db.fd, db.pool = next.fd, next.pool
+	db.tree.root = next.tree.root
+	db.page.flushed = next.page.flushed
+	db.mu.Unlock()
+	return old.Close()
+}
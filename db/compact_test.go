@@ -0,0 +1,195 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompact(t *testing.T) {
+	t.Run("Same keys in fewer pages", func(t *testing.T) {
+		src := backupDB(t, 1000)
+		defer src.Close()
+		path := filepath.Join(t.TempDir(), "compact.db")
+		assert.NoError(t, src.Compact(path, COMPACT_FILL))
+
+		dst := openKV(t, path)
+		defer dst.Close()
+		assert.Equal(t, dumpKV(src), dumpKV(dst))
+		report := dst.Check()
+		assert.True(t, report.OK(), report.Violations)
+		assert.Empty(t, report.Unreachable)
+		assert.Less(t, dst.page.flushed, src.page.flushed)
+	})
+
+	t.Run("Leaves in key order at the start of the file", func(t *testing.T) {
+		src := backupDB(t, 1000)
+		defer src.Close()
+		path := filepath.Join(t.TempDir(), "compact.db")
+		assert.NoError(t, src.Compact(path, COMPACT_FILL))
+		dst := openKV(t, path)
+		defer dst.Close()
+
+		// depth first order visits the leaves in key order
+		var leaves []uint64
+		for _, page := range dst.tree.exportPages() {
+			if page.Type == "leaf" {
+				leaves = append(leaves, page.ID)
+			}
+		}
+		for i, ptr := range leaves {
+			assert.Equal(t, uint64(i+1), ptr)
+		}
+		assert.Equal(t, dst.page.flushed-1, dst.tree.root, "Root last")
+	})
+
+	t.Run("Fill factor", func(t *testing.T) {
+		src := backupDB(t, 1000)
+		defer src.Close()
+		var leafFill []float64
+		for _, fill := range []float64{0.5, 1} {
+			path := filepath.Join(t.TempDir(), "compact.db")
+			assert.NoError(t, src.Compact(path, fill))
+			dst := openKV(t, path)
+			stats := dst.Stats()
+			leafFill = append(leafFill, stats.Levels[stats.Height-1].Fill())
+			for _, page := range dst.tree.exportPages() {
+				assert.LessOrEqual(t, page.Bytes, int(fill*BTREE_PAGE_SIZE))
+			}
+			dst.Close()
+		}
+		assert.Greater(t, leafFill[0], 0.4)
+		assert.Greater(t, leafFill[1], 0.9)
+	})
+
+	t.Run("Updates after compaction", func(t *testing.T) {
+		src := backupDB(t, 300)
+		defer src.Close()
+		path := filepath.Join(t.TempDir(), "compact.db")
+		assert.NoError(t, src.Compact(path, 1))
+		dst := openKV(t, path)
+		defer dst.Close()
+
+		for i := 0; i < 300; i += 2 {
+			assert.NoError(t, dst.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("new")))
+		}
+		want := dumpKV(src)
+		for i := 0; i < 300; i += 2 {
+			want[fmt.Sprintf("key%04d", i)] = "new"
+		}
+		assert.Equal(t, want, dumpKV(dst))
+		assert.True(t, dst.Check().OK())
+	})
+
+	t.Run("Page size is kept", func(t *testing.T) {
+		src := openKVPageSize(t, filepath.Join(t.TempDir(), "src.db"), BTREE_MAX_PAGE_SIZE)
+		defer src.Close()
+		tx, _ := src.Begin()
+		for i := 0; i < 300; i++ {
+			tx.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(strings.Repeat("v", 100*i)))
+		}
+		assert.NoError(t, tx.Commit())
+		path := filepath.Join(t.TempDir(), "compact.db")
+		assert.NoError(t, src.Compact(path, COMPACT_FILL))
+
+		dst := openKV(t, path)
+		defer dst.Close()
+		assert.Equal(t, BTREE_MAX_PAGE_SIZE, dst.PageSize)
+		assert.Equal(t, dumpKV(src), dumpKV(dst))
+		assert.True(t, dst.Check().OK())
+		assert.Greater(t, dst.Stats().Levels[dst.Stats().Height-1].Fill(), 0.7)
+	})
+
+	// Edge cases
+	t.Run("Tiny fill still makes a tree", func(t *testing.T) {
+		src := backupDB(t, 300)
+		defer src.Close()
+		path := filepath.Join(t.TempDir(), "compact.db")
+		assert.NoError(t, src.Compact(path, 0.001))
+		dst := openKV(t, path)
+		defer dst.Close()
+		assert.Equal(t, dumpKV(src), dumpKV(dst))
+		assert.Equal(t, len(dumpKV(src))+1, dst.Stats().LeafPages, "One KV per leaf")
+	})
+
+	t.Run("Empty and emptied databases", func(t *testing.T) {
+		src := openKV(t, filepath.Join(t.TempDir(), "src.db"))
+		defer src.Close()
+		path := filepath.Join(t.TempDir(), "empty.db")
+		assert.NoError(t, src.Compact(path, COMPACT_FILL))
+
+		src.Set([]byte("k"), []byte("v"))
+		src.Del([]byte("k"))
+		path = filepath.Join(t.TempDir(), "emptied.db")
+		assert.NoError(t, src.Compact(path, COMPACT_FILL))
+		dst := openKV(t, path)
+		defer dst.Close()
+		assert.Empty(t, dumpKV(dst))
+		assert.NoError(t, dst.Set([]byte("a"), []byte("b")))
+	})
+
+	t.Run("Bad fill and existing destination", func(t *testing.T) {
+		src := backupDB(t, 10)
+		defer src.Close()
+		dir := t.TempDir()
+		assert.ErrorIs(t, src.Compact(filepath.Join(dir, "a.db"), 0), ErrBadFill)
+		assert.ErrorIs(t, src.Compact(filepath.Join(dir, "a.db"), 1.5), ErrBadFill)
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries, "Nothing left behind")
+
+		assert.ErrorContains(t, src.Compact(src.Path, 1), "file exists")
+	})
+}
+
+func TestVacuum(t *testing.T) {
+	t.Run("Shrinks the file in place", func(t *testing.T) {
+		db := backupDB(t, 1000)
+		defer db.Close()
+		want := dumpKV(db)
+		before, _ := os.Stat(db.Path)
+
+		assert.NoError(t, db.Vacuum(COMPACT_FILL))
+		after, _ := os.Stat(db.Path)
+		assert.Less(t, after.Size(), before.Size())
+		assert.Equal(t, want, dumpKV(db))
+		assert.Empty(t, db.Check().Unreachable)
+
+		// still usable, and the new file is the database
+		assert.NoError(t, db.Set([]byte("after"), []byte("vacuum")))
+		assert.NoError(t, db.Close())
+		reopened := openKV(t, db.Path)
+		defer reopened.Close()
+		want["after"] = "vacuum"
+		assert.Equal(t, want, dumpKV(reopened))
+		_, err := os.Stat(db.Path + ".vacuum")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	// Edge cases
+	t.Run("Not during a transaction", func(t *testing.T) {
+		db := backupDB(t, 10)
+		defer db.Close()
+		tx, _ := db.Begin()
+		assert.ErrorIs(t, db.Vacuum(COMPACT_FILL), ErrTxInProgress)
+		tx.Abort()
+	})
+
+	t.Run("Bad fill leaves the database alone", func(t *testing.T) {
+		db := backupDB(t, 10)
+		defer db.Close()
+		want := dumpKV(db)
+		assert.ErrorIs(t, db.Vacuum(2), ErrBadFill)
+		assert.Equal(t, want, dumpKV(db))
+	})
+
+	t.Run("Leftover from a crashed vacuum", func(t *testing.T) {
+		db := backupDB(t, 10)
+		defer db.Close()
+		os.WriteFile(db.Path+".vacuum", []byte("half written"), 0644)
+		assert.NoError(t, db.Vacuum(COMPACT_FILL))
+	})
+}
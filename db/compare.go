@@ -0,0 +1,154 @@
+package db
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"sync"
+)
+
+// Key order of the tree
+
+/*
+*
+A Comparator orders the keys of a tree. Its name is stored in the meta page when the file is
+created, and opening the file with another comparator fails: a tree walked in the wrong
+order finds the wrong keys and inserts new ones in the wrong places.
+
+Keys that the comparator finds equal are the same key, with COMPARE_NOCASE "Key" and "key"
+are one key, a Set with one replaces the other. The empty dummy key is always the first key
+of the tree whatever the comparator, Compare is never called with an empty key.
+
+The built-in comparators are registered, a KV that doesn't set Comparator uses the one named
+in the meta page. A program with its own comparator registers it before opening its files, so
+tools like dbcheck built with it can open them too.
+*/
+type Comparator struct {
+	Name    string // stored in the meta page, at most MAX_COMPARATOR_NAME bytes
+	Compare func(a, b []byte) int
+}
+
+const MAX_COMPARATOR_NAME = 27
+
+var (
+	COMPARE_BYTES   = &Comparator{Name: "bytes", Compare: bytes.Compare}
+	COMPARE_NUMERIC = &Comparator{Name: "numeric", Compare: compareNumeric}
+	COMPARE_NOCASE  = &Comparator{Name: "nocase", Compare: compareNoCase}
+	COMPARE_REVERSE = &Comparator{Name: "reverse", Compare: compareReverse}
+)
+
+var comparators = struct {
+	sync.Mutex
+	byName map[string]*Comparator
+}{byName: map[string]*Comparator{}}
+
+func init() {
+	for _, c := range []*Comparator{COMPARE_BYTES, COMPARE_NUMERIC, COMPARE_NOCASE, COMPARE_REVERSE} {
+		RegisterComparator(c)
+	}
+}
+
+func checkComparator(c *Comparator) error {
+	if c.Name == "" || len(c.Name) > MAX_COMPARATOR_NAME {
+		return fmt.Errorf("comparator name %q should have 1 to %d bytes", c.Name, MAX_COMPARATOR_NAME)
+	}
+	if c.Compare == nil {
+		return fmt.Errorf("comparator %q has no Compare function", c.Name)
+	}
+	return nil
+}
+
+// make a comparator known by its name, it panics if the name is taken
+func RegisterComparator(c *Comparator) {
+	if err := checkComparator(c); err != nil {
+		panic(err.Error())
+	}
+	comparators.Lock()
+	defer comparators.Unlock()
+	assertStatement(comparators.byName[c.Name] == nil, "RegisterComparator: the name should not be registered twice")
+	comparators.byName[c.Name] = c
+}
+
+// the registered comparator with this name, nil if there is none
+func LookupComparator(name string) *Comparator {
+	comparators.Lock()
+	defer comparators.Unlock()
+	return comparators.byName[name]
+}
+
+// the order of the keys in the tree, the empty dummy key before any other
+func (tree *BTree) compare(a, b []byte) int {
+	if len(a) == 0 || len(b) == 0 {
+		return cmp.Compare(len(a), len(b))
+	}
+	if tree.cmp == nil {
+		return bytes.Compare(a, b)
+	}
+	return tree.cmp.Compare(a, b)
+}
+
+// sign and digits without the leading zeros of a decimal integer
+func decimal(key []byte) (neg bool, digits []byte, ok bool) {
+	if len(key) > 0 && (key[0] == '-' || key[0] == '+') {
+		neg, key = key[0] == '-', key[1:]
+	}
+	if len(key) == 0 {
+		return false, nil, false
+	}
+	for _, c := range key {
+		if c < '0' || c > '9' {
+			return false, nil, false
+		}
+	}
+	digits = bytes.TrimLeft(key, "0")
+	return neg && len(digits) > 0, digits, true // -0 is 0
+}
+
+// decimal integers by value, "9" < "10" and "7" is "007", then the other keys in byte order
+func compareNumeric(a, b []byte) int {
+	nega, da, oka := decimal(a)
+	negb, db, okb := decimal(b)
+	switch {
+	case oka != okb:
+		if oka {
+			return -1 // numbers first
+		}
+		return 1
+	case !oka:
+		return bytes.Compare(a, b)
+	case nega != negb:
+		if nega {
+			return -1
+		}
+		return 1
+	}
+	r := cmp.Compare(len(da), len(db))
+	if r == 0 {
+		r = bytes.Compare(da, db)
+	}
+	if nega {
+		return -r
+	}
+	return r
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// byte order with ASCII letters folded to lower case
+func compareNoCase(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if ca, cb := lower(a[i]), lower(b[i]); ca != cb {
+			return cmp.Compare(ca, cb)
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func compareReverse(a, b []byte) int {
+	return bytes.Compare(b, a)
+}
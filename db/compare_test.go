@@ -0,0 +1,237 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A KV ordered by cmp
+func openKVOrder(t *testing.T, path string, cmp *Comparator) *KV {
+	db := &KV{Path: path, Comparator: cmp}
+	assert.NoError(t, db.Open())
+	return db
+}
+
+// Helper: The keys of the KV in the order of the tree
+func keysInOrder(db *KV) []string {
+	var keys []string
+	for iter := db.Seek(nil, CMP_GE); iter.Valid(); iter.Next() {
+		key, _ := iter.Deref()
+		keys = append(keys, string(key))
+	}
+	return keys
+}
+
+func TestComparators(t *testing.T) {
+	t.Run("Built-in orders", func(t *testing.T) {
+		tests := []struct {
+			cmp  *Comparator
+			keys []string // in order
+		}{
+			{COMPARE_BYTES, []string{"10", "9", "B", "a", "b"}},
+			{COMPARE_NUMERIC, []string{"-20", "-3", "0", "9", "10", "100", "+101", "1a", "a"}},
+			{COMPARE_NOCASE, []string{"a", "B", "c", "cA", "Cb", "~"}},
+			{COMPARE_REVERSE, []string{"b", "ab", "a", "B", "A"}},
+		}
+		for _, test := range tests {
+			for i := 0; i < len(test.keys); i++ {
+				for j := 0; j < len(test.keys); j++ {
+					a, b := []byte(test.keys[i]), []byte(test.keys[j])
+					want := 0
+					if i < j {
+						want = -1
+					} else if i > j {
+						want = 1
+					}
+					assert.Equal(t, want, test.cmp.Compare(a, b), "%s: %q %q", test.cmp.Name, a, b)
+				}
+			}
+		}
+	})
+
+	t.Run("Equal keys", func(t *testing.T) {
+		assert.Equal(t, 0, compareNumeric([]byte("007"), []byte("7")))
+		assert.Equal(t, 0, compareNumeric([]byte("-0"), []byte("0")))
+		assert.Equal(t, 0, compareNumeric([]byte("+5"), []byte("5")))
+		assert.Equal(t, 0, compareNoCase([]byte("Key"), []byte("kEY")))
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		for _, cmp := range []*Comparator{COMPARE_BYTES, COMPARE_NUMERIC, COMPARE_NOCASE, COMPARE_REVERSE} {
+			assert.Same(t, cmp, LookupComparator(cmp.Name))
+		}
+		assert.Nil(t, LookupComparator("nope"))
+
+		mine := LookupComparator("test-length") // registered by an earlier run with -count
+		if mine == nil {
+			mine = &Comparator{Name: "test-length", Compare: func(a, b []byte) int { return len(a) - len(b) }}
+			RegisterComparator(mine)
+		}
+		assert.Same(t, mine, LookupComparator("test-length"))
+		assert.Panics(t, func() { RegisterComparator(mine) }, "Name taken")
+		assert.Panics(t, func() { RegisterComparator(&Comparator{Name: "test-nil"}) })
+		assert.Panics(t, func() { RegisterComparator(&Comparator{Compare: bytes.Compare}) })
+		long := &Comparator{Name: string(bytes.Repeat([]byte("x"), MAX_COMPARATOR_NAME+1)), Compare: bytes.Compare}
+		assert.Panics(t, func() { RegisterComparator(long) })
+	})
+
+	// Edge cases
+	t.Run("The dummy key comes first", func(t *testing.T) {
+		tree := &BTree{cmp: COMPARE_REVERSE}
+		assert.Equal(t, -1, tree.compare([]byte{}, []byte("a")))
+		assert.Equal(t, 1, tree.compare([]byte("a"), nil))
+		assert.Equal(t, 0, tree.compare(nil, []byte{}))
+		assert.Equal(t, -1, (&BTree{}).compare([]byte("a"), []byte("b")), "Byte order without a comparator")
+	})
+}
+
+func TestTreeOrder(t *testing.T) {
+	t.Run("Multi level trees in each order", func(t *testing.T) {
+		for _, cmp := range []*Comparator{COMPARE_NUMERIC, COMPARE_NOCASE, COMPARE_REVERSE} {
+			db := openKVOrder(t, filepath.Join(t.TempDir(), "test.db"), cmp)
+			tx, _ := db.Begin()
+			var want []string
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("%d", i)
+				if cmp == COMPARE_NOCASE {
+					key = fmt.Sprintf("Key%04d", i)
+				}
+				assert.NoError(t, tx.Set([]byte(key), []byte("v")))
+				want = append(want, key)
+			}
+			assert.NoError(t, tx.Commit())
+			if cmp == COMPARE_REVERSE {
+				// reverse byte order, not reverse numeric order
+				slices.Sort(want)
+				slices.Reverse(want)
+			}
+			assert.Equal(t, want, keysInOrder(db), cmp.Name)
+			report := db.Check()
+			assert.True(t, report.OK(), report.Violations)
+			assert.Equal(t, uint16(BNODE_NODE), BNode(db.tree.get(db.tree.root)).btype(), "Tree should have more than 1 level")
+
+			// delete half of the keys, the tree merges in the same order
+			for i := 0; i < len(want); i += 2 {
+				ok, err := db.Del([]byte(want[i]))
+				assert.True(t, ok)
+				assert.NoError(t, err)
+			}
+			var rest []string
+			for i := 1; i < len(want); i += 2 {
+				rest = append(rest, want[i])
+			}
+			assert.Equal(t, rest, keysInOrder(db), cmp.Name)
+			assert.True(t, db.Check().OK())
+			db.Close()
+		}
+	})
+
+	t.Run("Seek in the order of the tree", func(t *testing.T) {
+		db := openKVOrder(t, filepath.Join(t.TempDir(), "test.db"), COMPARE_NUMERIC)
+		defer db.Close()
+		for _, key := range []string{"1", "5", "10", "50", "100"} {
+			db.Set([]byte(key), []byte(key))
+		}
+		key, _ := db.Seek([]byte("9"), CMP_GE).Deref()
+		assert.Equal(t, "10", string(key))
+		key, _ = db.Seek([]byte("9"), CMP_LE).Deref()
+		assert.Equal(t, "5", string(key))
+		key, _ = db.Seek([]byte("050"), CMP_GT).Deref()
+		assert.Equal(t, "100", string(key))
+		key, _ = db.SeekLast().Deref()
+		assert.Equal(t, "100", string(key))
+		assert.Equal(t, -1, db.Compare([]byte("9"), []byte("10")))
+	})
+
+	t.Run("Equal keys are the same key", func(t *testing.T) {
+		db := openKVOrder(t, filepath.Join(t.TempDir(), "test.db"), COMPARE_NOCASE)
+		defer db.Close()
+		db.Set([]byte("Key"), []byte("1"))
+		db.Set([]byte("KEY"), []byte("2"))
+		val, ok := db.Get([]byte("key"))
+		assert.True(t, ok)
+		assert.Equal(t, "2", string(val))
+		assert.Len(t, keysInOrder(db), 1)
+
+		ok, err := db.Del([]byte("kEy"))
+		assert.True(t, ok)
+		assert.NoError(t, err)
+		assert.Empty(t, keysInOrder(db))
+	})
+
+	t.Run("The order is recorded in the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKVOrder(t, path, COMPARE_REVERSE)
+		db.Set([]byte("a"), []byte("1"))
+		db.Set([]byte("b"), []byte("2"))
+		db.Close()
+
+		db = openKV(t, path)
+		assert.Same(t, COMPARE_REVERSE, db.Comparator, "The comparator of the file")
+		assert.Equal(t, []string{"b", "a"}, keysInOrder(db))
+		assert.Equal(t, "reverse", InspectMeta(db.encodeMeta(db.tree.root, db.page.flushed)).Comparator)
+		db.Close()
+
+		db = &KV{Path: path, Comparator: COMPARE_BYTES}
+		assert.ErrorContains(t, db.Open(), `the file is ordered by comparator "reverse", not "bytes"`)
+	})
+
+	t.Run("Compact and backup keep the order", func(t *testing.T) {
+		src := openKVOrder(t, filepath.Join(t.TempDir(), "src.db"), COMPARE_NUMERIC)
+		defer src.Close()
+		for i := 0; i < 500; i++ {
+			src.Set([]byte(fmt.Sprintf("%d", i)), []byte("v"))
+		}
+		path := filepath.Join(t.TempDir(), "compact.db")
+		assert.NoError(t, src.Compact(path, COMPACT_FILL))
+		dst := openKV(t, path)
+		assert.Same(t, COMPARE_NUMERIC, dst.Comparator)
+		assert.Equal(t, keysInOrder(src), keysInOrder(dst))
+		dst.Close()
+
+		var buf bytes.Buffer
+		assert.NoError(t, src.Backup(&buf))
+		restored, err := restoreKV(t, buf.Bytes())
+		assert.NoError(t, err)
+		assert.Same(t, COMPARE_NUMERIC, restored.Comparator)
+		assert.Equal(t, keysInOrder(src), keysInOrder(restored))
+	})
+
+	// Edge cases
+	t.Run("Unregistered order", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKVOrder(t, path, &Comparator{Name: "test-unregistered", Compare: bytes.Compare})
+		db.Close()
+		db = &KV{Path: path}
+		assert.ErrorContains(t, db.Open(), `the file is ordered by comparator "test-unregistered" which is not registered`)
+
+		dir := t.TempDir()
+		db = &KV{Path: filepath.Join(dir, "new.db"), Comparator: &Comparator{Name: "x"}}
+		assert.ErrorContains(t, db.Open(), "has no Compare function")
+		entries, _ := os.ReadDir(dir)
+		assert.Empty(t, entries, "The file is not created")
+	})
+
+	t.Run("Files without an order are in byte order", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		assert.NoError(t, os.WriteFile(path, encodeMeta(metaPage{root: 0, used: 1, pageSize: BTREE_PAGE_SIZE})[:36], 0644))
+		db := openKV(t, path)
+		defer db.Close()
+		assert.Same(t, COMPARE_BYTES, db.Comparator)
+	})
+
+	t.Run("SeekLast of an empty tree", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+		assert.False(t, db.SeekLast().Valid())
+		db.Set([]byte("a"), []byte("1"))
+		db.Del([]byte("a"))
+		assert.False(t, db.SeekLast().Valid(), "Only the dummy key")
+	})
+}
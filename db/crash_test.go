@@ -0,0 +1,361 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Crash recovery tests: KV runs on a fake file that crashes, then the database is opened
+// again from what a disk could have kept and must hold a prefix of the committed operations
+
+/*
+*
+A crash keeps everything synced and any subset of the writes made since the last Sync,
+a disk is free to reorder them. A kept write can also be torn: each CRASH_SECTOR of it is
+kept or not on its own, sectors are the unit a disk writes atomically.
+*/
+const CRASH_SECTOR = 512
+
+var errCrashed = errors.New("crashed")
+
+type fileWrite struct {
+	off  int64
+	data []byte
+}
+
+// Helper: A file in memory that records writes and fsyncs and crashes on a given call
+type crashFile struct {
+	synced  []byte      // what survives a crash
+	data    []byte      // what reads see: synced + pending
+	pending []fileWrite // writes since the last Sync
+	calls   int         // WriteAt and Sync calls so far
+	crashAt int         // the call that crashes, -1 for never
+	crashed bool
+	writes  int
+	syncs   int
+}
+
+func newCrashFile(image []byte, crashAt int) *crashFile {
+	return &crashFile{synced: append([]byte(nil), image...), data: append([]byte(nil), image...), crashAt: crashAt}
+}
+
+// is this call the crash? After the crash every call fails, the process is dead
+func (f *crashFile) crash() bool {
+	if f.calls == f.crashAt {
+		f.crashed = true
+	}
+	f.calls++
+	return f.crashed
+}
+
+func (f *crashFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func writeAt(dst []byte, p []byte, off int64) []byte {
+	if end := off + int64(len(p)); end > int64(len(dst)) {
+		dst = append(dst, make([]byte, end-int64(len(dst)))...)
+	}
+	copy(dst[off:], p)
+	return dst
+}
+
+func (f *crashFile) WriteAt(p []byte, off int64) (int, error) {
+	if f.crashed {
+		return 0, errCrashed
+	}
+	// the crash happens while the write is in flight, it might reach the disk
+	f.pending = append(f.pending, fileWrite{off: off, data: append([]byte(nil), p...)})
+	f.data = writeAt(f.data, p, off)
+	f.writes++
+	if f.crash() {
+		return 0, errCrashed
+	}
+	return len(p), nil
+}
+
+func (f *crashFile) Sync() error {
+	if f.crashed || f.crash() {
+		return errCrashed
+	}
+	f.synced = append(f.synced[:0], f.data...)
+	f.pending = nil
+	f.syncs++
+	return nil
+}
+
+func (f *crashFile) Size() (int64, error) {
+	return int64(len(f.data)), nil
+}
+
+func (f *crashFile) Close() error {
+	return nil
+}
+
+// the disk after a crash: nil rnd drops every pending write, otherwise each one is kept
+// with a 50% chance and torn sector by sector if tear is set
+func (f *crashFile) reboot(rnd *rand.Rand, tear bool) []byte {
+	image := append([]byte(nil), f.synced...)
+	if rnd == nil {
+		return image
+	}
+	for _, w := range f.pending {
+		if rnd.Intn(2) == 0 {
+			continue
+		}
+		if !tear {
+			image = writeAt(image, w.data, w.off)
+			continue
+		}
+		for i := 0; i < len(w.data); i += CRASH_SECTOR {
+			if rnd.Intn(2) == 0 {
+				image = writeAt(image, w.data[i:min(i+CRASH_SECTOR, len(w.data))], w.off+int64(i))
+			}
+		}
+	}
+	return image
+}
+
+// Helper: Apply ops to a KV until one fails, returns how many succeeded
+func applyKV(db *KV, ops []diffOp) int {
+	for i, op := range ops {
+		var err error
+		if op.del {
+			_, err = db.Del([]byte(op.key))
+		} else {
+			err = db.Set([]byte(op.key), []byte(op.val))
+		}
+		if err != nil {
+			return i
+		}
+	}
+	return len(ops)
+}
+
+// Helper: All the pairs of a KV
+func dumpKV(db *KV) map[string]string {
+	got := map[string]string{}
+	for iter := db.Seek(nil, CMP_GE); iter.Valid(); iter.Next() {
+		key, val := iter.Deref()
+		got[string(key)] = string(val)
+	}
+	return got
+}
+
+// the expected content after each prefix of ops
+func prefixStates(start map[string]string, ops []diffOp) []map[string]string {
+	states := []map[string]string{start}
+	for _, op := range ops {
+		next := map[string]string{}
+		for k, v := range states[len(states)-1] {
+			next[k] = v
+		}
+		if op.del {
+			delete(next, op.key)
+		} else {
+			next[op.key] = op.val
+		}
+		states = append(states, next)
+	}
+	return states
+}
+
+/*
+*
+Crash on the given call, reboot and open the database again. It must hold the ops that were
+committed, and maybe the one in flight. The reopened database must still take updates.
+*/
+func runCrash(image []byte, ops []diffOp, crashAt int, rnd *rand.Rand, tear bool) error {
+	f := newCrashFile(image, crashAt)
+	db := &KV{Path: "crash.db"}
+	// Open writes the meta page of a new database, it can crash too
+	states := prefixStates(map[string]string{}, ops)
+	committed := 0
+	switch err := db.OpenFile(f); {
+	case err == nil:
+		states = prefixStates(dumpKV(db), ops)
+		committed = applyKV(db, ops)
+	case !errors.Is(err, errCrashed):
+		return fmt.Errorf("open before the crash: %w", err)
+	}
+
+	db = &KV{Path: "crash.db"}
+	if err := db.OpenFile(newCrashFile(f.reboot(rnd, tear), -1)); err != nil {
+		return fmt.Errorf("open after the crash with %d ops committed: %w", committed, err)
+	}
+	defer db.Close()
+
+	if report := db.Check(); !report.OK() {
+		return fmt.Errorf("%d ops committed but the tree is broken: %v", committed, report.Violations)
+	}
+	got := dumpKV(db)
+	if !mapsEqual(got, states[committed]) && (committed == len(ops) || !mapsEqual(got, states[committed+1])) {
+		return fmt.Errorf("%d ops committed but the database has %d keys and matches no prefix", committed, len(got))
+	}
+
+	if err := db.Set([]byte("after-crash"), []byte("v")); err != nil {
+		return fmt.Errorf("set after the crash: %w", err)
+	}
+	if val, ok := db.Get([]byte("after-crash")); !ok || string(val) != "v" {
+		return errors.New("set after the crash is lost")
+	}
+	return nil
+}
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper: Run ops on every crash point with each kind of crash
+func checkCrashPoints(t *testing.T, image []byte, ops []diffOp) {
+	f := newCrashFile(image, -1)
+	db := &KV{Path: "crash.db"}
+	assert.NoError(t, db.OpenFile(f))
+	assert.Equal(t, len(ops), applyKV(db, ops))
+	calls := f.calls
+
+	rnd := rand.New(rand.NewSource(1))
+	for crashAt := 0; crashAt < calls; crashAt++ {
+		if err := runCrash(image, ops, crashAt, nil, false); err != nil {
+			t.Fatalf("crash on call %d, unsynced writes lost: %v", crashAt, err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := runCrash(image, ops, crashAt, rnd, false); err != nil {
+				t.Fatalf("crash on call %d, some unsynced writes kept: %v", crashAt, err)
+			}
+			if err := runCrash(image, ops, crashAt, rnd, true); err != nil {
+				t.Fatalf("crash on call %d, torn writes: %v", crashAt, err)
+			}
+		}
+	}
+}
+
+func TestCrashRecovery(t *testing.T) {
+	t.Run("Crash at every write and fsync", func(t *testing.T) {
+		ops := randomOps(rand.New(rand.NewSource(1)), 60)
+		checkCrashPoints(t, nil, ops)
+	})
+
+	t.Run("Crash on a database with data", func(t *testing.T) {
+		// build the database without crashes, then crash while it is updated
+		f := newCrashFile(nil, -1)
+		db := &KV{Path: "crash.db"}
+		assert.NoError(t, db.OpenFile(f))
+		rnd := rand.New(rand.NewSource(2))
+		assert.Equal(t, 100, applyKV(db, randomOps(rnd, 100)))
+
+		checkCrashPoints(t, f.synced, randomOps(rnd, 30))
+	})
+
+	// Edge cases
+	t.Run("Crash before the first commit", func(t *testing.T) {
+		ops := []diffOp{{key: "a", val: "1"}}
+		// Open writes and syncs the meta page, then the commit writes a leaf, syncs, writes the meta page and syncs
+		for crashAt := 0; crashAt < 6; crashAt++ {
+			assert.NoError(t, runCrash(nil, ops, crashAt, nil, false), "crash on call %d", crashAt)
+			assert.NoError(t, runCrash(nil, ops, crashAt, rand.New(rand.NewSource(int64(crashAt))), true), "crash on call %d", crashAt)
+		}
+	})
+
+	t.Run("Failed commits do not change the database", func(t *testing.T) {
+		f := newCrashFile(nil, -1)
+		db := &KV{Path: "crash.db"}
+		assert.NoError(t, db.OpenFile(f))
+		db.Set([]byte("a"), []byte("1"))
+		f.crashAt = f.calls
+
+		assert.ErrorIs(t, db.Set([]byte("b"), []byte("2")), errCrashed)
+		_, ok := db.Get([]byte("b"))
+		assert.False(t, ok)
+		val, _ := db.Get([]byte("a"))
+		assert.Equal(t, []byte("1"), val)
+	})
+
+	t.Run("A failed write during an eviction aborts the transaction", func(t *testing.T) {
+		f := newCrashFile(nil, -1)
+		db := &KV{Path: "crash.db", PoolFrames: 4}
+		assert.NoError(t, db.OpenFile(f))
+		db.Set([]byte("a"), []byte("1"))
+		f.crashAt = f.calls // the first page written back
+
+		tx, err := db.Begin()
+		assert.NoError(t, err)
+		n := 0
+		for ; err == nil && n < 1000; n++ {
+			err = tx.Set([]byte(fmt.Sprintf("key%04d", n)), make([]byte, 200))
+		}
+		assert.ErrorIs(t, err, errCrashed)
+		assert.Less(t, n, 1000, "The pool evicts dirty pages long before")
+		assert.ErrorIs(t, tx.Commit(), ErrTxDone, "Already aborted")
+
+		tx, err = db.Begin()
+		assert.NoError(t, err, "The next transaction can begin")
+		tx.Abort()
+		val, _ := db.Get([]byte("a"))
+		assert.Equal(t, []byte("1"), val)
+	})
+}
+
+func TestCrashFile(t *testing.T) {
+	f := newCrashFile(nil, -1)
+	f.WriteAt([]byte("synced"), 0)
+	f.Sync()
+	f.WriteAt([]byte("pending"), 10)
+
+	buf := make([]byte, 17)
+	n, _ := f.ReadAt(buf, 0)
+	assert.Equal(t, 17, n)
+	assert.Equal(t, []byte("pending"), buf[10:], "Reads see pending writes")
+	assert.Equal(t, 2, f.writes)
+	assert.Equal(t, 1, f.syncs)
+
+	assert.Equal(t, []byte("synced"), f.reboot(nil, false), "Pending writes are dropped")
+
+	t.Run("Torn writes keep whole sectors", func(t *testing.T) {
+		f := newCrashFile(make([]byte, 4*CRASH_SECTOR), -1)
+		page := make([]byte, 4*CRASH_SECTOR)
+		for i := range page {
+			page[i] = 1
+		}
+		f.WriteAt(page, 0)
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 20; i++ {
+			image := f.reboot(rnd, true)
+			for s := 0; s < 4; s++ {
+				sector := image[s*CRASH_SECTOR : (s+1)*CRASH_SECTOR]
+				for _, b := range sector {
+					assert.Equal(t, sector[0], b, "Sector %d is torn", s)
+				}
+			}
+		}
+	})
+
+	t.Run("Calls fail after the crash", func(t *testing.T) {
+		f := newCrashFile(nil, 1)
+		_, err := f.WriteAt([]byte("a"), 0)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, f.Sync(), errCrashed)
+		_, err = f.WriteAt([]byte("b"), 0)
+		assert.ErrorIs(t, err, errCrashed)
+	})
+}
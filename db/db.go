@@ -1,7 +1,6 @@
 package db
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -28,17 +27,56 @@ vlen: 2byte
 key: .....
 value.......
 
-Node size will be 4kb, which is typical os page size
+Node size is the page size of the tree, 4kb by default which is typical os page size.
+A database can use any power of 2 from 4kb to 64kb, it is stored in the meta page. The
+key and value limits grow with the page size so the biggest KV always fits in a node.
+
+A node being split can be 2 pages, its offsets don't fit in 2 bytes when the pages are over
+32kb. The nodes of those trees have the BNODE_WIDE flag in their type and 4 byte offsets.
 */
 const HEADER = 4
 
+// the default page size and its limits
 const BTREE_PAGE_SIZE = 4096
 const BTREE_MAX_KEY_SIZE = 1000
 const BTREE_MAX_VAL_SIZE = 3000
 
+const (
+	BTREE_MIN_PAGE_SIZE = 4096
+	BTREE_MAX_PAGE_SIZE = 65536
+)
+
+var ErrBadPageSize = fmt.Errorf("page size must be a power of 2 from %d to %d", BTREE_MIN_PAGE_SIZE, BTREE_MAX_PAGE_SIZE)
+
 func init() {
-	node1max := HEADER + 8 + 2 + 4 + BTREE_MAX_KEY_SIZE + BTREE_MAX_VAL_SIZE
-	assertStatement(node1max <= BTREE_PAGE_SIZE, "max size of node should be less than equal to BTREE_PAGE_SIZE")
+	for size := BTREE_MIN_PAGE_SIZE; size <= BTREE_MAX_PAGE_SIZE; size *= 2 {
+		node1max := HEADER + 8 + 4 + 4 + MaxKeySize(size) + MaxValSize(size)
+		assertStatement(node1max <= size, "max size of node should be less than equal to the page size")
+		assertStatement(MaxValSize(size) <= 0xffff, "klen and vlen are 2 bytes")
+		assertStatement(wideOffsets(size) || 2*size-HEADER-10 <= 0xffff, "2 byte offsets should fit a node being split")
+	}
+}
+
+func checkPageSize(size int) error {
+	if size < BTREE_MIN_PAGE_SIZE || size > BTREE_MAX_PAGE_SIZE || size&(size-1) != 0 {
+		return fmt.Errorf("%w: %d", ErrBadPageSize, size)
+	}
+	return nil
+}
+
+// the biggest key of a tree with pages of pageSize bytes
+func MaxKeySize(pageSize int) int {
+	return pageSize / BTREE_PAGE_SIZE * BTREE_MAX_KEY_SIZE
+}
+
+// the biggest value of a tree with pages of pageSize bytes
+func MaxValSize(pageSize int) int {
+	return pageSize / BTREE_PAGE_SIZE * BTREE_MAX_VAL_SIZE
+}
+
+// do the nodes of a tree with pages of pageSize bytes have 4 byte offsets
+func wideOffsets(pageSize int) bool {
+	return pageSize > 32768
 }
 
 // If we use use bnode as byte we will skip serialisation desrialisation cost
@@ -46,12 +84,26 @@ type BNode []byte
 
 type BTree struct {
 	root uint64
+	page int         // page size in bytes, BTREE_PAGE_SIZE when 0
+	cmp  *Comparator // key order, byte order when nil
 
 	get func(uint64) []byte
 	new func([]byte) uint64
 	del func(uint64)
 }
 
+func (tree *BTree) pageSize() int {
+	if tree.page == 0 {
+		return BTREE_PAGE_SIZE
+	}
+	return tree.page
+}
+
+// an empty node of n pages in the layout of the tree
+func (tree *BTree) newNode(npages int) BNode {
+	return newNode(npages*tree.pageSize(), wideOffsets(tree.pageSize()))
+}
+
 // Little endian and Big Endian is way how we are storing data
 // Little endian => Least significant byte
 // Big Endian > Most significant byte
@@ -62,6 +114,8 @@ type BTree struct {
 const (
 	BNODE_NODE = 1 // internal nodes
 	BNODE_LEAF = 2 // leaf ndoes
+
+	BNODE_WIDE = 0x100 // flag in the type, the offsets are 4 bytes
 )
 
 func assertStatement(assertCond bool, description string) {
@@ -71,7 +125,33 @@ func assertStatement(assertCond bool, description string) {
 }
 
 func (node BNode) btype() uint16 {
-	return binary.LittleEndian.Uint16(node[0:2])
+	return binary.LittleEndian.Uint16(node[0:2]) &^ BNODE_WIDE
+}
+
+func (node BNode) wide() bool {
+	return binary.LittleEndian.Uint16(node[0:2])&BNODE_WIDE != 0
+}
+
+// an empty node of size bytes, setHeader keeps the offset width
+func newNode(size int, wide bool) BNode {
+	node := BNode(make([]byte, size))
+	if wide {
+		binary.LittleEndian.PutUint16(node[0:2], BNODE_WIDE)
+	}
+	return node
+}
+
+// an empty node of size bytes with the offset width of old
+func nodeLike(old BNode, size int) BNode {
+	return newNode(size, old.wide())
+}
+
+// bytes per offset
+func (node BNode) offsetSize() uint32 {
+	if node.wide() {
+		return 4
+	}
+	return 2
 }
 
 func (node BNode) nkeys() uint16 {
@@ -79,6 +159,9 @@ func (node BNode) nkeys() uint16 {
 }
 
 func (node BNode) setHeader(btype uint16, nkeys uint16) {
+	if node.wide() {
+		btype |= BNODE_WIDE
+	}
 	binary.LittleEndian.PutUint16(node[0:2], btype)
 	binary.LittleEndian.PutUint16(node[2:4], nkeys)
 }
@@ -108,30 +191,39 @@ func (node BNode) setPtr(idx uint16, val uint64) {
 // is just 0, so we use the end offset instead, which is the start offset of the next KV
 // TODO:The above is from the book and I don't totally agree, we might change later
 
-func offsetPos(node BNode, idx uint16) uint16 {
+// Positions are uint32, a node being split can be 2 pages of 64kb
+
+func offsetPos(node BNode, idx uint16) uint32 {
 	assertStatement(1 <= idx && idx <= node.nkeys(), "offsetPos:  Index should be less than number of keys in  node")
-	return HEADER + 8*node.nkeys() + 2*(idx-1)
+	return HEADER + 8*uint32(node.nkeys()) + node.offsetSize()*uint32(idx-1)
 }
 
-func (node BNode) getOffset(idx uint16) uint16 {
+func (node BNode) getOffset(idx uint16) uint32 {
 	if idx == 0 {
 		return 0
 	}
-	return binary.LittleEndian.Uint16((node[offsetPos(node, idx):]))
+	if node.wide() {
+		return binary.LittleEndian.Uint32(node[offsetPos(node, idx):])
+	}
+	return uint32(binary.LittleEndian.Uint16((node[offsetPos(node, idx):])))
 }
 
-func (node BNode) setOffset(idx uint16, offset uint16) {
+func (node BNode) setOffset(idx uint16, offset uint32) {
 	// find offset position
 	// the at offset position: store the offset
-	// TODO: unsure how that storage action will work
-	binary.LittleEndian.PutUint16(node[offsetPos(node, idx):], offset)
+	if node.wide() {
+		binary.LittleEndian.PutUint32(node[offsetPos(node, idx):], offset)
+		return
+	}
+	assertStatement(offset <= 0xffff, "setOffset: offset should fit in 2 bytes")
+	binary.LittleEndian.PutUint16(node[offsetPos(node, idx):], uint16(offset))
 }
 
 // KVPOS
 
-func (node BNode) kvPos(idx uint16) uint16 {
+func (node BNode) kvPos(idx uint16) uint32 {
 	assertStatement(idx <= node.nkeys(), "kvPos: Index should be less than number of keys in node")
-	return HEADER + 8*node.nkeys() + 2*node.nkeys() + node.getOffset(idx) // getOffset returns relaltive to start of. kv paior
+	return HEADER + (8+node.offsetSize())*uint32(node.nkeys()) + node.getOffset(idx) // getOffset returns relaltive to start of. kv paior
 }
 
 func (node BNode) getKey(idx uint16) []byte {
@@ -151,14 +243,15 @@ func (node BNode) getVal(idx uint16) []byte {
 	klen := binary.LittleEndian.Uint16(node[pos:])
 	vlen := binary.LittleEndian.Uint16(node[pos+2:])
 
-	return node[pos+4+klen:][:vlen]
+	return node[pos+4+uint32(klen):][:vlen]
 }
 
-func nodeLookupLE(node BNode, key []byte) uint16 {
+// the last key <= key in the order of compare
+func nodeLookupLE(node BNode, key []byte, compare func(a, b []byte) int) uint16 {
 	nkeys := node.nkeys()
 	var i uint16
 	for i = 0; i < nkeys; i++ {
-		cmp := bytes.Compare(node.getKey(i), key)
+		cmp := compare(node.getKey(i), key)
 		if cmp == 0 {
 			return i
 		}
@@ -169,7 +262,7 @@ func nodeLookupLE(node BNode, key []byte) uint16 {
 	return i - 1
 }
 
-func (node BNode) nbytes() uint16 {
+func (node BNode) nbytes() uint32 {
 	return node.kvPos(node.nkeys()) // this works because this will give offsetr for n+1th node and which iss kind of same as current size
 }
 
@@ -190,10 +283,10 @@ func nodeAppendKV(new BNode, idx uint16, ptr uint64, key []byte, val []byte) {
 	binary.LittleEndian.PutUint16(new[pos+2:], uint16(len(val)))
 
 	copy(new[pos+4:], key)
-	copy(new[pos+4+uint16(len(key)):], val)
+	copy(new[pos+4+uint32(len(key)):], val)
 
 	// set Offset is being used to set offset of the next key
-	new.setOffset(idx+1, new.getOffset(idx)+4+uint16(len(key)+len(val)))
+	new.setOffset(idx+1, new.getOffset(idx)+4+uint32(len(key)+len(val)))
 }
 
 // copy multiple kvs
@@ -215,25 +308,25 @@ func leafUpdate(new, old BNode, idx uint16, key, val []byte) {
 }
 
 // split the old ndoe into two nodes -> left right
-func nodeSplit2(left, right, old BNode) {
+func nodeSplit2(left, right, old BNode, pageSize int) {
 	// code omitted
 	assertStatement(old.nkeys() >= 2, "Original node should at least have two keys")
 
 	nleft := old.nkeys() / 2
-	left_bytes := func() uint16 {
-		return 4 + 8*nleft + 2*nleft + old.getOffset(nleft)
+	left_bytes := func() uint32 {
+		return 4 + (8+old.offsetSize())*uint32(nleft) + old.getOffset(nleft)
 	}
-	for left_bytes() > BTREE_PAGE_SIZE {
+	for left_bytes() > uint32(pageSize) {
 		nleft--
 	}
 
 	assertStatement(nleft >= 1, "nleft should at least be 1")
 
-	right_bytes := func() uint16 {
+	right_bytes := func() uint32 {
 		return old.nbytes() - left_bytes() + 4
 	}
 
-	for right_bytes() > BTREE_PAGE_SIZE {
+	for right_bytes() > uint32(pageSize) {
 		nleft++
 	}
 
@@ -244,30 +337,31 @@ func nodeSplit2(left, right, old BNode) {
 	right.setHeader(old.btype(), nright)
 	nodeAppendRange(left, old, 0, 0, nleft)
 	nodeAppendRange(right, old, 0, nleft, nright)
-	assertStatement(right_bytes() <= BTREE_PAGE_SIZE, "rightbytes will always fit in Max node size")
+	assertStatement(right_bytes() <= uint32(pageSize), "rightbytes will always fit in Max node size")
 }
 
-func nodeSplit3(old BNode) (uint16, [3]BNode) {
-	if old.nbytes() <= BTREE_PAGE_SIZE {
-		old = old[:BTREE_PAGE_SIZE]
+// split a node into nodes of at most pageSize bytes
+func nodeSplit3(old BNode, pageSize int) (uint16, [3]BNode) {
+	if old.nbytes() <= uint32(pageSize) {
+		old = old[:pageSize]
 		return 1, [3]BNode{old} // no split
 	}
 
-	left := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
-	right := BNode(make([]byte, BTREE_PAGE_SIZE))
-	nodeSplit2(left, right, old)
+	left := nodeLike(old, 2*pageSize)
+	right := nodeLike(old, pageSize)
+	nodeSplit2(left, right, old, pageSize)
 
-	if left.nbytes() <= BTREE_PAGE_SIZE { // TODO: this indicates when we do split2, right is always less than PAGE SIZE and whatever remaining is put into left
-		left := left[:BTREE_PAGE_SIZE]
+	if left.nbytes() <= uint32(pageSize) { // TODO: this indicates when we do split2, right is always less than PAGE SIZE and whatever remaining is put into left
+		left := left[:pageSize]
 		return 2, [3]BNode{left, right}
 	}
 
-	leftleft := BNode(make([]byte, BTREE_PAGE_SIZE))
-	middle := BNode(make([]byte, BTREE_PAGE_SIZE))
+	leftleft := nodeLike(old, pageSize)
+	middle := nodeLike(old, pageSize)
 
-	nodeSplit2(leftleft, middle, left)
+	nodeSplit2(leftleft, middle, left, pageSize)
 
-	assertStatement(leftleft.nbytes() <= BTREE_PAGE_SIZE, "nodeSplit3: leftleft size should be less than BTREE_PAGE_SIZE") // TODO: What happens if leftleft is not less than BTREE_PAGE_SIZE
+	assertStatement(leftleft.nbytes() <= uint32(pageSize), "nodeSplit3: leftleft size should be less than the page size") // TODO: What happens if leftleft is not less than the page size
 	return 3, [3]BNode{leftleft, middle, right}
 }
 
@@ -280,17 +374,17 @@ func treeInsert(tree *BTree, node BNode, key []byte, val []byte) BNode {
 	// the result node
 	// it's allowed to be bigger than 1 page and will be split if so
 
-	new := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
+	new := tree.newNode(2)
 
 	// where to insert key
-	idx := nodeLookupLE(node, key)
+	idx := nodeLookupLE(node, key, tree.compare)
 
 	// act depending on node type
 
 	switch node.btype() {
 	case BNODE_LEAF:
-		// leaf, node.getKey(idx) <= idx
-		if bytes.Equal(key, node.getKey(idx)) {
+		// leaf, node.getKey(idx) <= idx, an equal key is replaced by the new one
+		if tree.compare(key, node.getKey(idx)) == 0 {
 			leafUpdate(new, node, idx, key, val)
 		} else {
 			// insert it after the position
@@ -301,7 +395,7 @@ func treeInsert(tree *BTree, node BNode, key []byte, val []byte) BNode {
 		// recursive insertion to kid node
 		knode := treeInsert(tree, tree.get(kptr), key, val)
 		// split the result
-		nsplit, split := nodeSplit3(knode)
+		nsplit, split := nodeSplit3(knode, tree.pageSize())
 		// deallocate the kid node
 		tree.del(kptr)
 		//update the kid links
@@ -336,7 +430,7 @@ func nodeReplaceKidN(
 // delete a key and returns whenther the key was there
 
 func (tree *BTree) Delete(key []byte) (bool, error) {
-	if err := checkLimit(key, nil); err != nil {
+	if err := tree.checkLimit(key, nil); err != nil {
 		return false, err // the only way for an update to fail
 	}
 
@@ -354,31 +448,31 @@ func (tree *BTree) Delete(key []byte) (bool, error) {
 		// remove a level
 		tree.root = updated.getPtr(0)
 	} else {
-		tree.root = tree.new(updated)
+		tree.setRoot(updated)
 	}
 	return true, nil
 }
 
-func checkLimit(k, v []byte) error {
+func (tree *BTree) checkLimit(k, v []byte) error {
 	if len(k) == 0 {
 		return errors.New("empty key") // used as a dummy key
 	}
-	if len(k) > BTREE_MAX_KEY_SIZE {
+	if len(k) > MaxKeySize(tree.pageSize()) {
 		return errors.New("key too long")
 	}
-	if len(v) > BTREE_MAX_VAL_SIZE {
+	if len(v) > MaxValSize(tree.pageSize()) {
 		return errors.New("value too long")
 	}
 	return nil
 }
 
 func (tree *BTree) Insert(key []byte, val []byte) error {
-	if err := checkLimit(key, val); err != nil {
+	if err := tree.checkLimit(key, val); err != nil {
 		return err
 	}
 
 	if tree.root == 0 {
-		root := BNode(make([]byte, BTREE_PAGE_SIZE))
+		root := tree.newNode(1)
 		root.setHeader(BNODE_LEAF, 2)
 		nodeAppendKV(root, 0, 0, nil, nil)
 		nodeAppendKV(root, 1, 0, key, val)
@@ -387,10 +481,16 @@ func (tree *BTree) Insert(key []byte, val []byte) error {
 	}
 
 	node := treeInsert(tree, tree.get(tree.root), key, val)
+	tree.del(tree.root)
+	tree.setRoot(node)
+	return nil
+}
 
-	nsplit, split := nodeSplit3(node)
+// store the new root node, if it doesn't fit in a page it is split and a level is added
+func (tree *BTree) setRoot(node BNode) {
+	nsplit, split := nodeSplit3(node, tree.pageSize())
 	if nsplit > 1 {
-		root := BNode(make([]byte, BTREE_PAGE_SIZE))
+		root := tree.newNode(1)
 		root.setHeader(BNODE_NODE, nsplit)
 		for i, knode := range split[:nsplit] {
 			ptr, key := tree.new(knode), knode.getKey(0)
@@ -400,7 +500,6 @@ func (tree *BTree) Insert(key []byte, val []byte) error {
 	} else {
 		tree.root = tree.new(split[0])
 	}
-	return nil
 }
 
 // Merginfg  nodes
@@ -439,17 +538,18 @@ func nodeReplace2Kid(new, old BNode, idx uint16, ptr uint64, key []byte) {
 
 // should the updated kid me merged with siblig?
 
-// Basically if a node has data < quarter of BTREE page size we want to merge sibling nodes
+// Basically if a node has data < quarter of the page size we want to merge sibling nodes
 // return 0 for no merge, -1 for merge with leftand 1 to merge with right
 func shouldMerge(tree *BTree, node BNode, idx uint16, updated BNode) (int, BNode) {
-	if updated.nbytes() > BTREE_PAGE_SIZE/4 { // NO split
+	pageSize := uint32(tree.pageSize())
+	if updated.nbytes() > pageSize/4 { // NO split
 		return 0, BNode{}
 	}
 
 	if idx > 0 {
 		sibling := BNode(tree.get(node.getPtr(idx - 1)))
 		merged := sibling.nbytes() + updated.nbytes() - HEADER
-		if merged <= BTREE_PAGE_SIZE {
+		if merged <= pageSize {
 			return -1, sibling // left
 		}
 	}
@@ -457,7 +557,7 @@ func shouldMerge(tree *BTree, node BNode, idx uint16, updated BNode) (int, BNode
 	if idx+1 < node.nkeys() {
 		sibling := BNode(tree.get(node.getPtr(idx + 1)))
 		merged := sibling.nbytes() + updated.nbytes() - HEADER
-		if merged <= BTREE_PAGE_SIZE {
+		if merged <= pageSize {
 			return 1, sibling
 		}
 	}
@@ -467,16 +567,17 @@ func shouldMerge(tree *BTree, node BNode, idx uint16, updated BNode) (int, BNode
 
 // delete a key from the tree
 func treeDelete(tree *BTree, node BNode, key []byte) BNode {
-	new := BNode(make([]byte, BTREE_PAGE_SIZE))
+	new := tree.newNode(1)
 
-	idx := nodeLookupLE(node, key)
+	idx := nodeLookupLE(node, key, tree.compare)
 
 	switch node.btype() {
 	case BNODE_LEAF:
 		// leaf, node.getKey(idx) <= idx
-		if bytes.Equal(key, node.getKey(idx)) {
-			leafDelete(new, node, idx)
+		if tree.compare(key, node.getKey(idx)) != 0 {
+			return BNode{} // not found
 		}
+		leafDelete(new, node, idx)
 	case BNODE_NODE:
 		new = nodeDelete(tree, node, idx, key)
 	default:
@@ -497,17 +598,18 @@ func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
 
 	tree.del(kptr)
 
-	new := BNode(make([]byte, BTREE_PAGE_SIZE))
+	// the result can be bigger than a page, see the no merge case, the caller splits it
+	new := tree.newNode(2)
 	mergeDir, sibling := shouldMerge(tree, node, idx, updated)
 
 	switch {
 	case mergeDir < 0: // left
-		merged := BNode(make([]byte, BTREE_PAGE_SIZE))
+		merged := tree.newNode(1)
 		nodeMerge(merged, sibling, updated)
 		tree.del(node.getPtr(idx - 1))
 		nodeReplace2Kid(new, node, idx-1, tree.new(merged), merged.getKey(0))
 	case mergeDir > 0: // right
-		merged := BNode(make([]byte, BTREE_PAGE_SIZE))
+		merged := tree.newNode(1)
 		nodeMerge(merged, updated, sibling)
 		tree.del(node.getPtr(idx + 1))
 		nodeReplace2Kid(new, node, idx, tree.new(merged), merged.getKey(0))
@@ -515,7 +617,34 @@ func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
 		assertStatement(node.nkeys() == 1 && idx == 0, "TODO") // 1 empty child but no sibling
 		new.setHeader(BNODE_NODE, 0)                           // the parent becomes empty too
 	case mergeDir == 0 && updated.nkeys() > 0: // no merge
-		nodeReplaceKidN(tree, new, node, idx, updated)
+		// when the first key of the kid was deleted, the key in this node becomes the next
+		// one which can be longer, so the kid (and this node) can grow past a page
+		nsplit, split := nodeSplit3(updated, tree.pageSize())
+		nodeReplaceKidN(tree, new, node, idx, split[:nsplit]...)
 	}
 	return new
 }
+
+// look up a key starting from the given node
+func nodeGetKey(tree *BTree, node BNode, key []byte) ([]byte, bool) {
+	idx := nodeLookupLE(node, key, tree.compare)
+	switch node.btype() {
+	case BNODE_LEAF:
+		if tree.compare(key, node.getKey(idx)) == 0 {
+			return node.getVal(idx), true
+		}
+		return nil, false
+	case BNODE_NODE:
+		return nodeGetKey(tree, tree.get(node.getPtr(idx)), key)
+	default:
+		panic("bad node!")
+	}
+}
+
+// get the value of a key, the second return value is false if the key does not exist
+func (tree *BTree) Get(key []byte) ([]byte, bool) {
+	if tree.root == 0 || len(key) == 0 {
+		return nil, false // the empty key is the dummy key, not a user key
+	}
+	return nodeGetKey(tree, tree.get(tree.root), key)
+}
@@ -0,0 +1,425 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"building-a-db/temp"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Differential tests: db.BTree, temp.BTree and a map run the same operations
+
+/*
+*
+temp has its own copy of the page format and the tree algorithms, so after every operation
+both trees should hold the same keys and values AND the same page structure: same node
+types, same keys in every node, same page sizes. Only the page pointers differ.
+
+Pages are read with decodePage below, which does not use the BNode methods, so a bug in
+the node accessors can't hide itself.
+
+When a sequence fails it is shrunk to a minimal one that still fails, and printed as Go
+code that can be pasted into a test.
+*/
+
+type diffOp struct {
+	del bool
+	key string
+	val string
+}
+
+func (op diffOp) String() string {
+	if op.del {
+		return fmt.Sprintf("{del: true, key: %q}", op.key)
+	}
+	return fmt.Sprintf("{key: %q, val: %q}", op.key, op.val)
+}
+
+type diffHarness struct {
+	db        *C
+	temp      *temp.BTree
+	tempPages map[uint64][]byte
+}
+
+func newDiffHarness() *diffHarness {
+	h := &diffHarness{db: newC(), tempPages: map[uint64][]byte{}}
+	next := uint64(1)
+	h.temp = temp.NewBTree(
+		func(ptr uint64) []byte { return h.tempPages[ptr] },
+		func(node []byte) uint64 {
+			ptr := next
+			next++
+			h.tempPages[ptr] = node
+			return ptr
+		},
+		func(ptr uint64) { delete(h.tempPages, ptr) },
+	)
+	return h
+}
+
+// run one op on the three implementations and compare what they return
+func (h *diffHarness) apply(op diffOp) error {
+	key, val := []byte(op.key), []byte(op.val)
+	if op.del {
+		_, inRef := h.db.ref[op.key]
+		dbDeleted, dbErr := h.db.del(op.key)
+		tempDeleted, tempErr := h.temp.Delete(key)
+		if (dbErr == nil) != (tempErr == nil) {
+			return fmt.Errorf("delete errors differ: db=%v temp=%v", dbErr, tempErr)
+		}
+		if dbErr == nil && (dbDeleted != inRef || tempDeleted != inRef) {
+			return fmt.Errorf("delete returned db=%v temp=%v, map had the key: %v", dbDeleted, tempDeleted, inRef)
+		}
+		return nil
+	}
+	dbErr := h.db.add(op.key, op.val)
+	tempErr := h.temp.Insert(key, val)
+	if (dbErr == nil) != (tempErr == nil) {
+		return fmt.Errorf("insert errors differ: db=%v temp=%v", dbErr, tempErr)
+	}
+	return nil
+}
+
+// compare the contents with the map and the page structure of the 2 trees
+func (h *diffHarness) check() error {
+	for k, v := range h.db.ref {
+		dbVal, dbOK := h.db.tree.Get([]byte(k))
+		tempVal, tempOK := h.temp.Get([]byte(k))
+		if !dbOK || !tempOK || string(dbVal) != v || string(tempVal) != v {
+			return fmt.Errorf("get %q: map=%q db=(%q, %v) temp=(%q, %v)", k, v, dbVal, dbOK, tempVal, tempOK)
+		}
+	}
+
+	dbRoot, tempRoot := h.db.tree.root, h.temp.Root()
+	if (dbRoot == 0) != (tempRoot == 0) {
+		return fmt.Errorf("one tree is empty: db root=%d temp root=%d", dbRoot, tempRoot)
+	}
+	if dbRoot == 0 {
+		return nil
+	}
+	var keys []string
+	err := compareSubtrees(
+		func(ptr uint64) []byte { return h.db.pages[ptr] },
+		func(ptr uint64) []byte { return h.tempPages[ptr] },
+		dbRoot, tempRoot, "root", nil, &keys,
+	)
+	if err != nil {
+		return err
+	}
+
+	// the leaves hold exactly the map keys, in order, plus the dummy key
+	var expected []string
+	for k := range h.db.ref {
+		expected = append(expected, k)
+	}
+	sort.Strings(expected)
+	if len(keys) == 0 || keys[0] != "" {
+		return fmt.Errorf("the first leaf key should be the empty dummy key")
+	}
+	if strings.Join(keys[1:], "\x00") != strings.Join(expected, "\x00") {
+		return fmt.Errorf("leaf keys %q, map keys %q", keys[1:], expected)
+	}
+	return nil
+}
+
+// a page decoded without the BNode accessors
+type decodedPage struct {
+	btype  uint16
+	ptrs   []uint64
+	keys   [][]byte
+	vals   [][]byte
+	nbytes int
+}
+
+func decodePage(page []byte) (decodedPage, error) {
+	var p decodedPage
+	if len(page) < HEADER {
+		return p, fmt.Errorf("page is %d bytes", len(page))
+	}
+	p.btype = binary.LittleEndian.Uint16(page[0:])
+	nkeys := int(binary.LittleEndian.Uint16(page[2:]))
+	if p.btype != BNODE_NODE && p.btype != BNODE_LEAF {
+		return p, fmt.Errorf("bad node type %d", p.btype)
+	}
+
+	ptrsEnd := HEADER + 8*nkeys
+	kvStart := ptrsEnd + 2*nkeys
+	if kvStart > len(page) {
+		return p, fmt.Errorf("%d keys don't fit in the page", nkeys)
+	}
+	for i := 0; i < nkeys; i++ {
+		p.ptrs = append(p.ptrs, binary.LittleEndian.Uint64(page[HEADER+8*i:]))
+	}
+
+	// offsets are relative to the first KV, the first one is implicitly 0
+	offset := 0
+	for i := 0; i < nkeys; i++ {
+		if i > 0 {
+			next := int(binary.LittleEndian.Uint16(page[ptrsEnd+2*(i-1):]))
+			if next < offset {
+				return p, fmt.Errorf("offset %d goes backwards", i)
+			}
+			offset = next
+		}
+		pos := kvStart + offset
+		if pos+4 > len(page) {
+			return p, fmt.Errorf("kv %d is outside the page", i)
+		}
+		klen := int(binary.LittleEndian.Uint16(page[pos:]))
+		vlen := int(binary.LittleEndian.Uint16(page[pos+2:]))
+		if pos+4+klen+vlen > len(page) {
+			return p, fmt.Errorf("kv %d is outside the page", i)
+		}
+		p.keys = append(p.keys, page[pos+4:pos+4+klen])
+		p.vals = append(p.vals, page[pos+4+klen:pos+4+klen+vlen])
+	}
+	if nkeys > 0 {
+		p.nbytes = kvStart + int(binary.LittleEndian.Uint16(page[ptrsEnd+2*(nkeys-1):]))
+	} else {
+		p.nbytes = kvStart
+	}
+	if p.nbytes > BTREE_PAGE_SIZE {
+		return p, fmt.Errorf("node uses %d bytes", p.nbytes)
+	}
+	return p, nil
+}
+
+// walk both trees side by side. low is the key the parent has for this node, leaf keys are
+// appended to keys.
+func compareSubtrees(dbGet, tempGet func(uint64) []byte, dbPtr, tempPtr uint64, path string, low []byte, keys *[]string) error {
+	dbPage, tempPage := dbGet(dbPtr), tempGet(tempPtr)
+	if dbPage == nil || tempPage == nil {
+		return fmt.Errorf("%s: dangling pointer db=%d temp=%d", path, dbPtr, tempPtr)
+	}
+	a, err := decodePage(dbPage)
+	if err != nil {
+		return fmt.Errorf("%s: db: %w", path, err)
+	}
+	b, err := decodePage(tempPage)
+	if err != nil {
+		return fmt.Errorf("%s: temp: %w", path, err)
+	}
+
+	if a.btype != b.btype || len(a.keys) != len(b.keys) || a.nbytes != b.nbytes {
+		return fmt.Errorf("%s: db has type %d, %d keys, %d bytes, temp has type %d, %d keys, %d bytes",
+			path, a.btype, len(a.keys), a.nbytes, b.btype, len(b.keys), b.nbytes)
+	}
+	if len(a.keys) == 0 {
+		return nil
+	}
+	if low != nil && !bytes.Equal(a.keys[0], low) {
+		return fmt.Errorf("%s: first key %q doesn't match the parent key %q", path, a.keys[0], low)
+	}
+	for i := range a.keys {
+		if !bytes.Equal(a.keys[i], b.keys[i]) {
+			return fmt.Errorf("%s: key %d is %q in db and %q in temp", path, i, a.keys[i], b.keys[i])
+		}
+		if i > 0 && bytes.Compare(a.keys[i-1], a.keys[i]) >= 0 {
+			return fmt.Errorf("%s: keys %d and %d are not sorted", path, i-1, i)
+		}
+	}
+
+	if a.btype == BNODE_LEAF {
+		for i := range a.keys {
+			if !bytes.Equal(a.vals[i], b.vals[i]) {
+				return fmt.Errorf("%s: value of %q differs", path, a.keys[i])
+			}
+			*keys = append(*keys, string(a.keys[i]))
+		}
+		return nil
+	}
+	for i := range a.keys {
+		kidPath := fmt.Sprintf("%s/%d", path, i)
+		if err := compareSubtrees(dbGet, tempGet, a.ptrs[i], b.ptrs[i], kidPath, a.keys[i], keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run ops on a fresh harness, returns the index of the failing op.
+// Panics from the trees are failures too.
+func runDiff(ops []diffOp) (step int, err error) {
+	h := newDiffHarness()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	for step = range ops {
+		if err = h.apply(ops[step]); err != nil {
+			return step, err
+		}
+		if err = h.check(); err != nil {
+			return step, err
+		}
+	}
+	return len(ops), nil
+}
+
+// remove chunks of ops while the sequence still fails, then try shorter values
+func shrinkOps(ops []diffOp, fails func([]diffOp) bool) []diffOp {
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for i := 0; i < len(ops); {
+			end := min(i+chunk, len(ops))
+			candidate := append(append([]diffOp(nil), ops[:i]...), ops[end:]...)
+			if fails(candidate) {
+				ops = candidate
+			} else {
+				i += chunk
+			}
+		}
+	}
+	for i := range ops {
+		for len(ops[i].val) > 1 {
+			candidate := append([]diffOp(nil), ops...)
+			candidate[i].val = ops[i].val[:len(ops[i].val)/2]
+			if !fails(candidate) {
+				break
+			}
+			ops = candidate
+		}
+	}
+	return ops
+}
+
+// keys come from a small space so ops hit existing keys, some keys are long so internal
+// nodes split too
+const DIFF_KEYS = 150
+
+func diffKey(k int) string {
+	k %= DIFF_KEYS
+	return fmt.Sprintf("%03d", k) + strings.Repeat("k", (k%5)*120)
+}
+
+func randomOps(rnd *rand.Rand, n int) []diffOp {
+	var ops []diffOp
+	for i := 0; i < n; i++ {
+		key := diffKey(rnd.Intn(DIFF_KEYS))
+		if rnd.Intn(3) == 0 {
+			ops = append(ops, diffOp{del: true, key: key})
+		} else {
+			ops = append(ops, diffOp{key: key, val: strings.Repeat("v", rnd.Intn(800))})
+		}
+	}
+	return ops
+}
+
+func TestDifferential(t *testing.T) {
+	t.Run("Random sequences", func(t *testing.T) {
+		for seed := int64(0); seed < 30; seed++ {
+			ops := randomOps(rand.New(rand.NewSource(seed)), 300)
+			step, err := runDiff(ops)
+			if err == nil {
+				continue
+			}
+			minimal := shrinkOps(ops[:step+1], func(ops []diffOp) bool {
+				_, err := runDiff(ops)
+				return err != nil
+			})
+			_, minErr := runDiff(minimal)
+			var lines []string
+			for _, op := range minimal {
+				lines = append(lines, "\t"+op.String()+",")
+			}
+			t.Fatalf("seed %d failed at op %d: %v\nminimal reproducer (%v):\n[]diffOp{\n%s\n}",
+				seed, step, err, minErr, strings.Join(lines, "\n"))
+		}
+	})
+
+	t.Run("Trees get deep", func(t *testing.T) {
+		h := newDiffHarness()
+		for _, op := range randomOps(rand.New(rand.NewSource(1)), 300) {
+			assert.NoError(t, h.apply(op))
+		}
+		depth := 0
+		for ptr := h.db.tree.root; ; depth++ {
+			page, err := decodePage(h.db.pages[ptr])
+			assert.NoError(t, err)
+			if page.btype == BNODE_LEAF {
+				break
+			}
+			ptr = page.ptrs[0]
+		}
+		assert.GreaterOrEqual(t, depth, 2, "The random ops split internal nodes")
+	})
+
+	// The harness found this one with seed 1: deleting the first key of a kid makes the
+	// next key the key in the parent, when it is longer the parent grows and overflowed its
+	// page. nodeDelete now splits its result like treeInsert does.
+	t.Run("Deleted first keys make parents grow", func(t *testing.T) {
+		step, err := runDiff(randomOps(rand.New(rand.NewSource(1)), 300))
+		assert.NoError(t, err, "op %d", step)
+	})
+
+	t.Run("Delete everything", func(t *testing.T) {
+		ops := randomOps(rand.New(rand.NewSource(2)), 200)
+		for k := 0; k < DIFF_KEYS; k++ {
+			ops = append(ops, diffOp{del: true, key: diffKey(k)})
+		}
+		_, err := runDiff(ops)
+		assert.NoError(t, err)
+	})
+}
+
+func TestShrinkOps(t *testing.T) {
+	// fails when "b" is deleted after "a" was set with a value of 3+ bytes
+	fails := func(ops []diffOp) bool {
+		set := false
+		for _, op := range ops {
+			if !op.del && op.key == "a" && len(op.val) >= 3 {
+				set = true
+			}
+			if op.del && op.key == "b" && set {
+				return true
+			}
+		}
+		return false
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	ops := randomOps(rnd, 50)
+	ops = append(ops, diffOp{key: "a", val: strings.Repeat("x", 100)})
+	ops = append(ops, randomOps(rnd, 50)...)
+	ops = append(ops, diffOp{del: true, key: "b"})
+	ops = append(ops, randomOps(rnd, 50)...)
+
+	minimal := shrinkOps(ops, fails)
+	assert.Equal(t, []diffOp{{key: "a", val: strings.Repeat("x", 3)}, {del: true, key: "b"}}, minimal)
+}
+
+func TestDecodePage(t *testing.T) {
+	node := BNode(make([]byte, BTREE_PAGE_SIZE))
+	node.setHeader(BNODE_LEAF, 2)
+	nodeAppendKV(node, 0, 0, nil, nil)
+	nodeAppendKV(node, 1, 0, []byte("key"), []byte("value"))
+
+	p, err := decodePage(node)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(BNODE_LEAF), p.btype)
+	assert.Equal(t, [][]byte{{}, []byte("key")}, p.keys)
+	assert.Equal(t, []byte("value"), p.vals[1])
+	assert.Equal(t, int(node.nbytes()), p.nbytes)
+
+	// Edge cases
+	t.Run("Bad pages", func(t *testing.T) {
+		_, err := decodePage(node[:2])
+		assert.Error(t, err)
+
+		bad := BNode(append([]byte(nil), node...))
+		bad.setHeader(7, 2)
+		_, err = decodePage(bad)
+		assert.Error(t, err, "Bad type")
+
+		bad.setHeader(BNODE_LEAF, 2000)
+		_, err = decodePage(bad)
+		assert.Error(t, err, "Too many keys")
+	})
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Export the tree structure for Graphviz or other visualizers
+
+/*
+*
+ExportDOT writes a Graphviz digraph, one record per page with its ID, type, size and keys,
+and an edge from each key of an internal node to its kid:
+
+	echo ".export dot tree.dot" | go run ./cmd/dbshell data.db && dot -Tsvg tree.dot > tree.svg
+
+ExportJSON writes the same pages as JSON:
+
+	{"root": 12, "page_size": 4096, "pages": [
+	  {"id": 12, "type": "node", "bytes": 64, "keys": ["", "k5"], "kids": [3, 7]},
+	  {"id": 3, "type": "leaf", "bytes": 120, "keys": ["", "k1"], "val_sizes": [0, 5]}, ...]}
+
+Pages are in depth first order. Keys that are not valid UTF-8 are written as {"hex": "ff00"}
+so they can't be mistaken for a key that looks like hex. DOT labels show the keys as Go
+strings, "k5" or "\xff\x00", cut to DOT_KEY_LEN characters.
+The leaves have no sibling pointers, their order is the order of the kids.
+*/
+type exportPage struct {
+	ID       uint64      `json:"id"`
+	Type     string      `json:"type"`
+	Bytes    int         `json:"bytes"`
+	Keys     []exportKey `json:"keys"`
+	Kids     []uint64    `json:"kids,omitempty"`
+	ValSizes []int       `json:"val_sizes,omitempty"`
+}
+
+type exportTree struct {
+	Root     uint64       `json:"root"`
+	PageSize int          `json:"page_size"`
+	Pages    []exportPage `json:"pages"`
+}
+
+// keys longer than this many characters are cut in DOT labels
+const DOT_KEY_LEN = 16
+
+// a JSON string, or {"hex": ...} when the key is not valid UTF-8
+type exportKey []byte
+
+type hexKey struct {
+	Hex string `json:"hex"`
+}
+
+func (k exportKey) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(k) {
+		return json.Marshal(string(k))
+	}
+	return json.Marshal(hexKey{Hex: hex.EncodeToString(k)})
+}
+
+func (k *exportKey) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var h hexKey
+		if err := json.Unmarshal(data, &h); err != nil {
+			return err
+		}
+		b, err := hex.DecodeString(h.Hex)
+		*k = b
+		return err
+	}
+	var str string
+	err := json.Unmarshal(data, &str)
+	*k = exportKey(str)
+	return err
+}
+
+// the pages reachable from the root, depth first
+func (tree *BTree) exportPages() []exportPage {
+	pages := []exportPage{}
+	var walk func(ptr uint64)
+	walk = func(ptr uint64) {
+		node := BNode(tree.get(ptr))
+		page := exportPage{ID: ptr, Bytes: int(node.nbytes()), Keys: []exportKey{}}
+		for i := uint16(0); i < node.nkeys(); i++ {
+			page.Keys = append(page.Keys, bytes.Clone(node.getKey(i)))
+		}
+		switch node.btype() {
+		case BNODE_LEAF:
+			page.Type = "leaf"
+			for i := uint16(0); i < node.nkeys(); i++ {
+				page.ValSizes = append(page.ValSizes, len(node.getVal(i)))
+			}
+			pages = append(pages, page)
+		case BNODE_NODE:
+			page.Type = "node"
+			for i := uint16(0); i < node.nkeys(); i++ {
+				page.Kids = append(page.Kids, node.getPtr(i))
+			}
+			pages = append(pages, page)
+			for _, kid := range page.Kids {
+				walk(kid)
+			}
+		}
+	}
+	if tree.root != 0 {
+		walk(tree.root)
+	}
+	return pages
+}
+
+func (tree *BTree) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(exportTree{Root: tree.root, PageSize: tree.pageSize(), Pages: tree.exportPages()})
+}
+
+// a key as a Go string cut to DOT_KEY_LEN characters, on a rune boundary when it is UTF-8
+func dotKey(key []byte) string {
+	if utf8.Valid(key) {
+		if runes := []rune(string(key)); len(runes) > DOT_KEY_LEN {
+			return strconv.Quote(string(runes[:DOT_KEY_LEN])) + "..."
+		}
+	} else if len(key) > DOT_KEY_LEN {
+		return strconv.Quote(string(key[:DOT_KEY_LEN])) + "..."
+	}
+	return strconv.Quote(string(key))
+}
+
+// escape a string for a DOT record label
+func dotLabel(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+func (tree *BTree) ExportDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph btree {\n\tnode [shape=record, fontname=monospace];\n")
+	for _, page := range tree.exportPages() {
+		fields := []string{fmt.Sprintf("%d %s %dB", page.ID, page.Type, page.Bytes)}
+		for i, key := range page.Keys {
+			label := dotKey(key)
+			if len(key) == 0 && i == 0 {
+				label = "(dummy)"
+			}
+			fields = append(fields, fmt.Sprintf("<k%d> %s", i, dotLabel(label)))
+		}
+		fmt.Fprintf(&b, "\tp%d [label=\"%s\"];\n", page.ID, strings.Join(fields, "|"))
+		for i, kid := range page.Kids {
+			fmt.Fprintf(&b, "\tp%d:k%d -> p%d;\n", page.ID, i, kid)
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// export the committed tree
+func (db *KV) ExportJSON(w io.Writer) error {
+	return db.tree.ExportJSON(w)
+}
+
+func (db *KV) ExportDOT(w io.Writer) error {
+	return db.tree.ExportDOT(w)
+}
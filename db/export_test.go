@@ -0,0 +1,122 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	t.Run("JSON has every page", func(t *testing.T) {
+		c := checkTree(t, 100)
+		var buf bytes.Buffer
+		assert.NoError(t, c.tree.ExportJSON(&buf))
+
+		var got exportTree
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, c.tree.root, got.Root)
+		assert.Equal(t, BTREE_PAGE_SIZE, got.PageSize)
+		assert.Equal(t, len(c.pages), len(got.Pages))
+		assert.Equal(t, c.tree.root, got.Pages[0].ID, "Root first")
+
+		// depth first order lists the leaf keys in order
+		var keys []string
+		for _, page := range got.Pages {
+			node := BNode(c.pages[page.ID])
+			assert.Equal(t, int(node.nbytes()), page.Bytes)
+			assert.Equal(t, int(node.nkeys()), len(page.Keys))
+			if page.Type == "leaf" {
+				for _, key := range page.Keys {
+					keys = append(keys, string(key))
+				}
+				assert.Equal(t, len(page.Keys), len(page.ValSizes))
+				assert.Empty(t, page.Kids)
+			} else {
+				assert.Equal(t, len(page.Keys), len(page.Kids))
+			}
+		}
+		assert.Equal(t, "", keys[0], "Dummy key")
+		for i := 0; i < 100; i++ {
+			assert.Equal(t, checkKey(i), keys[i+1])
+		}
+	})
+
+	t.Run("DOT has a record per page and an edge per kid", func(t *testing.T) {
+		c := checkTree(t, 100)
+		var buf bytes.Buffer
+		assert.NoError(t, c.tree.ExportDOT(&buf))
+		dot := buf.String()
+
+		assert.True(t, strings.HasPrefix(dot, "digraph btree {"))
+		assert.Equal(t, len(c.pages), strings.Count(dot, "[label="))
+		assert.Equal(t, len(c.pages)-1, strings.Count(dot, "->"))
+		root := BNode(c.pages[c.tree.root])
+		assert.Contains(t, dot, fmt.Sprintf("p%d:k1 -> p%d;", c.tree.root, root.getPtr(1)))
+		assert.Contains(t, dot, "<k0> (dummy)")
+		assert.Contains(t, dot, `\"key_0001kkkkkkkk\"...`, "Long keys are cut")
+	})
+
+	t.Run("Database file", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+		db.Set([]byte("a"), []byte("12345"))
+
+		var buf bytes.Buffer
+		assert.NoError(t, db.ExportJSON(&buf))
+		var got exportTree
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, []exportPage{{ID: got.Root, Type: "leaf", Bytes: 4 + 2*14 + 1 + 5, Keys: []exportKey{exportKey(""), exportKey("a")}, ValSizes: []int{0, 5}}}, got.Pages)
+	})
+
+	// Edge cases
+	t.Run("Empty tree", func(t *testing.T) {
+		var buf bytes.Buffer
+		newC().tree.ExportJSON(&buf)
+		assert.Contains(t, buf.String(), `"pages": []`)
+		buf.Reset()
+		newC().tree.ExportDOT(&buf)
+		assert.NotContains(t, buf.String(), "label=\"")
+	})
+
+	t.Run("Keys are escaped", func(t *testing.T) {
+		c := newC()
+		c.add("{a|b}", "1")
+		c.add("\xff\x00", "2")
+		var buf bytes.Buffer
+		c.tree.ExportDOT(&buf)
+		assert.Contains(t, buf.String(), `\"\{a\|b\}\"`)
+		assert.Contains(t, buf.String(), `\"\\xff\\x00\"`, "Binary keys as Go strings")
+	})
+
+	t.Run("Long keys are cut on a rune boundary", func(t *testing.T) {
+		c := newC()
+		c.add(strings.Repeat("é", 20), "1")
+		c.add(strings.Repeat("\xff", 20), "2")
+		var buf bytes.Buffer
+		c.tree.ExportDOT(&buf)
+		dot := buf.String()
+		assert.True(t, utf8.ValidString(dot))
+		assert.Contains(t, dot, `\"`+strings.Repeat("é", DOT_KEY_LEN)+`\"...`)
+		assert.Contains(t, dot, `\"`+strings.Repeat(`\\xff`, DOT_KEY_LEN)+`\"...`)
+	})
+
+	t.Run("Binary keys can't be mistaken for other keys", func(t *testing.T) {
+		c := newC()
+		c.add("0xff00", "1")
+		c.add("\xff\x00", "2")
+		var buf bytes.Buffer
+		assert.NoError(t, c.tree.ExportJSON(&buf))
+		assert.Contains(t, buf.String(), `"0xff00"`)
+		assert.Regexp(t, `\{\s*"hex": "ff00"\s*\}`, buf.String())
+
+		var got exportTree
+		assert.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+		assert.Equal(t, []exportKey{exportKey(""), exportKey("0xff00"), exportKey("\xff\x00")}, got.Pages[0].Keys)
+	})
+}
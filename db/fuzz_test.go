@@ -0,0 +1,175 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// Fuzz targets, run one with: go test ./db -fuzz FuzzBTreeOps
+//
+// Inputs that crashed go to testdata/fuzz/<target>/ and are replayed by every plain go test.
+
+// two KVs written with nodeAppendKV read back the same
+func FuzzNodeKV(f *testing.F) {
+	f.Add([]byte("k1"), []byte("v1"), []byte("k2"), []byte(""), uint64(1))
+	f.Add([]byte(""), []byte(""), []byte{0xff}, []byte{0}, uint64(0))
+	f.Add(bytes.Repeat([]byte("k"), BTREE_MAX_KEY_SIZE), []byte("v"), []byte("k"), bytes.Repeat([]byte("v"), 10), ^uint64(0))
+
+	f.Fuzz(func(t *testing.T, k1, v1, k2, v2 []byte, ptr uint64) {
+		size := HEADER + 2*(8+2+4) + len(k1) + len(v1) + len(k2) + len(v2)
+		if size > BTREE_PAGE_SIZE {
+			t.Skip("doesn't fit in a page")
+		}
+		node := BNode(make([]byte, BTREE_PAGE_SIZE))
+		node.setHeader(BNODE_LEAF, 2)
+		nodeAppendKV(node, 0, ptr, k1, v1)
+		nodeAppendKV(node, 1, ptr+1, k2, v2)
+
+		if node.btype() != BNODE_LEAF || node.nkeys() != 2 {
+			t.Fatalf("header is type %d with %d keys", node.btype(), node.nkeys())
+		}
+		if !bytes.Equal(node.getKey(0), k1) || !bytes.Equal(node.getVal(0), v1) {
+			t.Fatalf("kv 0 is %q=%q, want %q=%q", node.getKey(0), node.getVal(0), k1, v1)
+		}
+		if !bytes.Equal(node.getKey(1), k2) || !bytes.Equal(node.getVal(1), v2) {
+			t.Fatalf("kv 1 is %q=%q, want %q=%q", node.getKey(1), node.getVal(1), k2, v2)
+		}
+		if node.getPtr(0) != ptr || node.getPtr(1) != ptr+1 {
+			t.Fatalf("pointers are %d %d, want %d %d", node.getPtr(0), node.getPtr(1), ptr, ptr+1)
+		}
+		if int(node.nbytes()) != size {
+			t.Fatalf("nbytes is %d, want %d", node.nbytes(), size)
+		}
+	})
+}
+
+// decode KV sizes from the fuzz input, 4 bytes per KV: | klen | vlen |, both capped at the
+// limits and 2 bytes little endian. Keys are at least 2 bytes to hold their index. Sizes are added while the node fits in 2 pages.
+func fuzzNode(data []byte) (BNode, [][]byte) {
+	node := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
+	var keys [][]byte
+	var kvs [][2]int
+	size := HEADER
+	for i := 0; i+4 <= len(data); i += 4 {
+		klen := 2 + int(binary.LittleEndian.Uint16(data[i:]))%(BTREE_MAX_KEY_SIZE-1)
+		vlen := int(binary.LittleEndian.Uint16(data[i+2:])) % (BTREE_MAX_VAL_SIZE + 1)
+		if size+8+2+4+klen+vlen > 2*BTREE_PAGE_SIZE {
+			break
+		}
+		size += 8 + 2 + 4 + klen + vlen
+		kvs = append(kvs, [2]int{klen, vlen})
+	}
+
+	node.setHeader(BNODE_LEAF, uint16(len(kvs)))
+	for i, kv := range kvs {
+		// sorted keys: a 2 byte index then padding
+		key := binary.BigEndian.AppendUint16(nil, uint16(i))
+		key = append(key, bytes.Repeat([]byte{'k'}, kv[0]-2)...)
+		nodeAppendKV(node, uint16(i), 0, key, bytes.Repeat([]byte{'v'}, kv[1]))
+		keys = append(keys, key)
+	}
+	return node, keys
+}
+
+// nodeSplit3 returns 1 to 3 nodes that fit in a page and keep every key in order
+func FuzzNodeSplit3(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x10, 0x00, 0x10, 0x00})
+	// 3 big KVs, the middle one has to be in its own node
+	f.Add([]byte{0xe7, 0x03, 0xb8, 0x0b, 0xe7, 0x03, 0xb8, 0x0b, 0xe7, 0x03, 0xb8, 0x0b})
+	f.Add(bytes.Repeat([]byte{0x01, 0x00, 0x00, 0x01}, 40))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		node, keys := fuzzNode(data)
+		nsplit, split := nodeSplit3(node, BTREE_PAGE_SIZE)
+		if nsplit < 1 || nsplit > 3 {
+			t.Fatalf("split into %d nodes", nsplit)
+		}
+		if node.nbytes() <= BTREE_PAGE_SIZE && nsplit != 1 {
+			t.Fatalf("a node of %d bytes was split", node.nbytes())
+		}
+
+		var got [][]byte
+		for _, part := range split[:nsplit] {
+			if part.nbytes() > BTREE_PAGE_SIZE {
+				t.Fatalf("part of %d bytes", part.nbytes())
+			}
+			if len(part) != BTREE_PAGE_SIZE {
+				t.Fatalf("part buffer is %d bytes", len(part))
+			}
+			if nsplit > 1 && part.nkeys() == 0 {
+				t.Fatalf("empty part")
+			}
+			for i := uint16(0); i < part.nkeys(); i++ {
+				got = append(got, part.getKey(i))
+			}
+		}
+		if len(got) != len(keys) {
+			t.Fatalf("%d keys after the split, want %d", len(got), len(keys))
+		}
+		for i := range keys {
+			if !bytes.Equal(got[i], keys[i]) {
+				t.Fatalf("key %d changed", i)
+			}
+		}
+	})
+}
+
+// 4 bytes per op: | flags | key | vlen |. flags&1 deletes, the key is diffKey(key) and vlen
+// is 2 bytes big endian capped at the value limit.
+func decodeFuzzOps(data []byte) []diffOp {
+	var ops []diffOp
+	for i := 0; i+4 <= len(data); i += 4 {
+		key := diffKey(int(data[i+1]))
+		if data[i]&1 == 1 {
+			ops = append(ops, diffOp{del: true, key: key})
+			continue
+		}
+		vlen := int(binary.BigEndian.Uint16(data[i+2:])) % (BTREE_MAX_VAL_SIZE + 1)
+		ops = append(ops, diffOp{key: key, val: strings.Repeat("v", vlen)})
+	}
+	return ops
+}
+
+func encodeFuzzOps(ops []diffOp) []byte {
+	var data []byte
+	for _, op := range ops {
+		k := 0
+		for k < DIFF_KEYS && diffKey(k) != op.key {
+			k++
+		}
+		if op.del {
+			data = append(data, 1, byte(k), 0, 0)
+		} else {
+			data = binary.BigEndian.AppendUint16(append(data, 0, byte(k)), uint16(len(op.val)))
+		}
+	}
+	return data
+}
+
+// random Insert/Delete sequences, checked by the differential harness: against a map and
+// page by page against temp.BTree
+func FuzzBTreeOps(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(encodeFuzzOps([]diffOp{{key: diffKey(1), val: "v"}, {del: true, key: diffKey(1)}}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ops := decodeFuzzOps(data)
+		if step, err := runDiff(ops); err != nil {
+			t.Fatalf("op %d (%v): %v", step, ops[min(step, len(ops)-1)], err)
+		}
+	})
+}
+
+func TestFuzzOpsEncoding(t *testing.T) {
+	ops := []diffOp{
+		{key: diffKey(3), val: "vvv"},
+		{del: true, key: diffKey(149)},
+		{key: diffKey(0), val: ""},
+	}
+	if got := decodeFuzzOps(encodeFuzzOps(ops)); len(got) != 3 || got[0] != ops[0] || got[1] != ops[1] || got[2] != ops[2] {
+		t.Fatalf("roundtrip gave %v", got)
+	}
+}
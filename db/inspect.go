@@ -0,0 +1,177 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Decoded views of raw pages for inspection tools
+
+/*
+*
+The views decode as much of a page as they can and list what is wrong in Errors instead of
+failing, so they also work on corrupt pages. Bytes are shown as hex then ASCII:
+
+	6b 65 79 31 |key1|
+
+Pages have no checksums in this format, so Checksum always says so.
+*/
+const CHECKSUM_STATUS = "none, the page format has no checksums"
+
+type MetaView struct {
+	Signature  string
+	Valid      bool
+	Root       uint64
+	PagesUsed  uint64
+	PageSize   int
+	Comparator string // empty if the name doesn't fit
+	Checksum   string
+	Errors     []string
+}
+
+type PageView struct {
+	ID        uint64
+	TypeCode  uint16
+	Type      string // node, leaf or unknown
+	Wide      bool   // 4 byte offsets
+	NKeys     uint16
+	Pointers  []uint64
+	Offsets   []uint32 // end of each KV, relative to the first KV
+	KVs       []KVView
+	UsedBytes int // header through the last decoded KV
+	FreeBytes int
+	Checksum  string
+	Errors    []string
+}
+
+type KVView struct {
+	Index  int
+	Pos    int // in the page
+	KeyLen int
+	ValLen int
+	Key    string
+	Val    string
+}
+
+// hex and ASCII of the first max bytes of b, max <= 0 means all of them
+func HexASCII(b []byte, max int) string {
+	if len(b) == 0 {
+		return "(empty)"
+	}
+	shown := b
+	if max > 0 && len(b) > max {
+		shown = b[:max]
+	}
+	ascii := make([]byte, len(shown))
+	for i, c := range shown {
+		ascii[i] = '.'
+		if c >= 0x20 && c < 0x7f {
+			ascii[i] = c
+		}
+	}
+	s := fmt.Sprintf("% x |%s|", shown, ascii)
+	if len(shown) < len(b) {
+		s += fmt.Sprintf(" ... %d bytes", len(b))
+	}
+	return s
+}
+
+func InspectMeta(data []byte) MetaView {
+	view := MetaView{Checksum: CHECKSUM_STATUS}
+	if len(data) < 32 {
+		view.Errors = append(view.Errors, fmt.Sprintf("meta page of %d bytes, expected at least 32", len(data)))
+		return view
+	}
+	view.Signature = HexASCII(data[:16], 0)
+	view.Root = binary.LittleEndian.Uint64(data[16:])
+	view.PagesUsed = binary.LittleEndian.Uint64(data[24:])
+	if !bytes.Equal(data[:16], []byte(DB_SIG)) {
+		view.Errors = append(view.Errors, fmt.Sprintf("bad signature, expected %q", DB_SIG))
+	}
+	view.PageSize = metaPageSize(data)
+	view.Comparator = metaOrder(data)
+	if _, err := decodeMeta(data); err != nil && len(view.Errors) == 0 {
+		view.Errors = append(view.Errors, err.Error())
+	}
+	view.Valid = len(view.Errors) == 0
+	return view
+}
+
+// decode page id of a file with pages of pageSize bytes, keys and values are shown up to maxBytes
+func InspectPage(id uint64, data []byte, pageSize, maxBytes int) PageView {
+	view := PageView{ID: id, Checksum: CHECKSUM_STATUS}
+	fail := func(format string, args ...any) {
+		view.Errors = append(view.Errors, fmt.Sprintf(format, args...))
+	}
+	size := min(len(data), pageSize)
+	if size < HEADER {
+		fail("page of %d bytes has no header", len(data))
+		return view
+	}
+
+	view.TypeCode = binary.LittleEndian.Uint16(data) &^ BNODE_WIDE
+	view.Wide = binary.LittleEndian.Uint16(data)&BNODE_WIDE != 0
+	view.NKeys = binary.LittleEndian.Uint16(data[2:])
+	switch view.TypeCode {
+	case BNODE_NODE:
+		view.Type = "node"
+	case BNODE_LEAF:
+		view.Type = "leaf"
+	default:
+		view.Type = "unknown"
+		fail("bad node type %d", view.TypeCode)
+	}
+
+	if view.Wide != wideOffsets(pageSize) {
+		fail("offsets of the wrong width for pages of %d bytes", pageSize)
+	}
+
+	// only the keys whose pointer and offset fit in the page
+	offsetSize := 2
+	if view.Wide {
+		offsetSize = 4
+	}
+	nkeys := int(view.NKeys)
+	if HEADER+(8+offsetSize)*nkeys > size {
+		fail("%d keys don't fit in a page", nkeys)
+		nkeys = (size - HEADER) / (8 + offsetSize)
+	}
+	kvStart := HEADER + (8+offsetSize)*nkeys
+	for i := 0; i < nkeys; i++ {
+		view.Pointers = append(view.Pointers, binary.LittleEndian.Uint64(data[HEADER+8*i:]))
+	}
+	for i := 0; i < nkeys; i++ {
+		view.Offsets = append(view.Offsets, uint32(readOffset(data[HEADER+8*nkeys+offsetSize*i:], view.Wide)))
+	}
+
+	pos := kvStart
+	for i := 0; i < nkeys; i++ {
+		if pos+4 > size {
+			fail("KV %d starts at %d, outside the page", i, pos)
+			break
+		}
+		klen := int(binary.LittleEndian.Uint16(data[pos:]))
+		vlen := int(binary.LittleEndian.Uint16(data[pos+2:]))
+		kvEnd := pos + 4 + klen + vlen
+		if kvEnd > size {
+			fail("KV %d ends at %d, outside the page", i, kvEnd)
+			break
+		}
+		if kvStart+int(view.Offsets[i]) != kvEnd {
+			fail("offset %d is %d but KV %d ends at %d", i+1, view.Offsets[i], i, kvEnd-kvStart)
+		}
+		view.KVs = append(view.KVs, KVView{
+			Index:  i,
+			Pos:    pos,
+			KeyLen: klen,
+			ValLen: vlen,
+			Key:    HexASCII(data[pos+4:pos+4+klen], maxBytes),
+			Val:    HexASCII(data[pos+4+klen:kvEnd], maxBytes),
+		})
+		pos = kvEnd
+	}
+	view.UsedBytes = pos
+	view.FreeBytes = pageSize - pos
+	return view
+}
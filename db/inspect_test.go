@@ -0,0 +1,169 @@
+package db
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A leaf page with the dummy key and the given keys, values are "v" + key
+func inspectLeaf(keys ...string) BNode {
+	node := BNode(make([]byte, BTREE_PAGE_SIZE))
+	node.setHeader(BNODE_LEAF, uint16(len(keys)+1))
+	nodeAppendKV(node, 0, 0, nil, nil)
+	for i, key := range keys {
+		nodeAppendKV(node, uint16(i+1), 0, []byte(key), []byte("v"+key))
+	}
+	return node
+}
+
+func TestHexASCII(t *testing.T) {
+	assert.Equal(t, "6b 65 79 31 |key1|", HexASCII([]byte("key1"), 0))
+	assert.Equal(t, "00 41 ff |.A.|", HexASCII([]byte{0, 'A', 0xff}, 0))
+	assert.Equal(t, "61 62 |ab| ... 5 bytes", HexASCII([]byte("abcde"), 2))
+	assert.Equal(t, "(empty)", HexASCII(nil, 4))
+}
+
+func TestInspectMeta(t *testing.T) {
+	t.Run("Valid meta page", func(t *testing.T) {
+		view := InspectMeta(encodeMeta(metaPage{root: 7, used: 9, pageSize: BTREE_PAGE_SIZE}))
+		assert.True(t, view.Valid)
+		assert.Equal(t, uint64(7), view.Root)
+		assert.Equal(t, uint64(9), view.PagesUsed)
+		assert.Equal(t, BTREE_PAGE_SIZE, view.PageSize)
+		assert.Contains(t, view.Signature, "|building-a-db-01|")
+		assert.Equal(t, CHECKSUM_STATUS, view.Checksum)
+		assert.Empty(t, view.Errors)
+	})
+
+	// Edge cases
+	t.Run("Bad signature is still decoded", func(t *testing.T) {
+		data := encodeMeta(metaPage{root: 7, used: 9, pageSize: BTREE_PAGE_SIZE})
+		copy(data, "not a database!!")
+		view := InspectMeta(data)
+		assert.False(t, view.Valid)
+		assert.Equal(t, uint64(7), view.Root)
+		assert.Contains(t, view.Errors[0], "bad signature")
+	})
+
+	t.Run("Root outside the used pages", func(t *testing.T) {
+		view := InspectMeta(encodeMeta(metaPage{root: 9, used: 9, pageSize: BTREE_PAGE_SIZE}))
+		assert.False(t, view.Valid)
+		assert.Len(t, view.Errors, 1)
+	})
+
+	t.Run("Meta page without a page size", func(t *testing.T) {
+		view := InspectMeta(encodeMeta(metaPage{root: 7, used: 9, pageSize: 16384})[:32])
+		assert.True(t, view.Valid)
+		assert.Equal(t, BTREE_PAGE_SIZE, view.PageSize)
+	})
+
+	t.Run("Bad page size", func(t *testing.T) {
+		view := InspectMeta(encodeMeta(metaPage{root: 7, used: 9, pageSize: 1000}))
+		assert.False(t, view.Valid)
+		assert.Equal(t, 1000, view.PageSize)
+		assert.Contains(t, view.Errors[0], "page size must be")
+	})
+
+	t.Run("Short meta page", func(t *testing.T) {
+		view := InspectMeta([]byte("hello"))
+		assert.False(t, view.Valid)
+		assert.Equal(t, []string{"meta page of 5 bytes, expected at least 32"}, view.Errors)
+	})
+}
+
+func TestInspectPage(t *testing.T) {
+	t.Run("Leaf", func(t *testing.T) {
+		node := inspectLeaf("a", "bb")
+		view := InspectPage(3, node, BTREE_PAGE_SIZE, 0)
+		assert.Equal(t, uint64(3), view.ID)
+		assert.Equal(t, "leaf", view.Type)
+		assert.Equal(t, uint16(3), view.NKeys)
+		assert.Equal(t, []uint64{0, 0, 0}, view.Pointers)
+		assert.Equal(t, []uint32{4, 11, 20}, view.Offsets)
+		assert.Len(t, view.KVs, 3)
+		assert.Equal(t, "(empty)", view.KVs[0].Key)
+		assert.Equal(t, "62 62 |bb|", view.KVs[2].Key)
+		assert.Equal(t, "76 62 62 |vbb|", view.KVs[2].Val)
+		assert.Equal(t, HEADER+10*3, view.KVs[0].Pos)
+		assert.Equal(t, int(node.nbytes()), view.UsedBytes)
+		assert.Equal(t, BTREE_PAGE_SIZE-int(node.nbytes()), view.FreeBytes)
+		assert.Empty(t, view.Errors)
+	})
+
+	t.Run("Internal node", func(t *testing.T) {
+		node := BNode(make([]byte, BTREE_PAGE_SIZE))
+		node.setHeader(BNODE_NODE, 2)
+		nodeAppendKV(node, 0, 5, nil, nil)
+		nodeAppendKV(node, 1, 6, []byte("m"), nil)
+		view := InspectPage(1, node, BTREE_PAGE_SIZE, 0)
+		assert.Equal(t, "node", view.Type)
+		assert.Equal(t, []uint64{5, 6}, view.Pointers)
+		assert.Empty(t, view.Errors)
+	})
+
+	t.Run("Keys are cut at maxBytes", func(t *testing.T) {
+		view := InspectPage(1, inspectLeaf(strings.Repeat("k", 100)), BTREE_PAGE_SIZE, 4)
+		assert.Equal(t, "6b 6b 6b 6b |kkkk| ... 100 bytes", view.KVs[1].Key)
+		assert.Equal(t, 100, view.KVs[1].KeyLen)
+	})
+
+	t.Run("Wide offsets", func(t *testing.T) {
+		node := newNode(BTREE_MAX_PAGE_SIZE, true)
+		node.setHeader(BNODE_LEAF, 2)
+		nodeAppendKV(node, 0, 0, nil, nil)
+		nodeAppendKV(node, 1, 0, []byte("a"), []byte("va"))
+		view := InspectPage(1, node, BTREE_MAX_PAGE_SIZE, 0)
+		assert.Equal(t, "leaf", view.Type)
+		assert.True(t, view.Wide)
+		assert.Equal(t, []uint32{4, 11}, view.Offsets)
+		assert.Equal(t, HEADER+12*2, view.KVs[0].Pos)
+		assert.Equal(t, BTREE_MAX_PAGE_SIZE-int(node.nbytes()), view.FreeBytes)
+		assert.Empty(t, view.Errors)
+
+		view = InspectPage(1, node[:BTREE_PAGE_SIZE], BTREE_PAGE_SIZE, 0)
+		assert.Equal(t, []string{"offsets of the wrong width for pages of 4096 bytes"}, view.Errors)
+	})
+
+	// Edge cases
+	t.Run("Bad node type", func(t *testing.T) {
+		node := inspectLeaf("a")
+		binary.LittleEndian.PutUint16(node, 9)
+		view := InspectPage(1, node, BTREE_PAGE_SIZE, 0)
+		assert.Equal(t, "unknown", view.Type)
+		assert.Equal(t, []string{"bad node type 9"}, view.Errors)
+		assert.Len(t, view.KVs, 2, "KVs are still decoded")
+	})
+
+	t.Run("Too many keys", func(t *testing.T) {
+		node := inspectLeaf("a")
+		binary.LittleEndian.PutUint16(node[2:], 1000)
+		view := InspectPage(1, node, BTREE_PAGE_SIZE, 0)
+		assert.Contains(t, view.Errors[0], "1000 keys don't fit in a page")
+		assert.Len(t, view.Pointers, (BTREE_PAGE_SIZE-HEADER)/10)
+	})
+
+	t.Run("KV outside the page", func(t *testing.T) {
+		node := inspectLeaf("a", "b")
+		pos := HEADER + 10*3 + 4 + 7 // the KV of "b"
+		binary.LittleEndian.PutUint16(node[pos:], 5000)
+		view := InspectPage(1, node, BTREE_PAGE_SIZE, 0)
+		assert.Len(t, view.KVs, 2)
+		assert.Contains(t, view.Errors[0], "KV 2 ends at")
+	})
+
+	t.Run("Wrong offset", func(t *testing.T) {
+		node := inspectLeaf("a")
+		binary.LittleEndian.PutUint16(node[HEADER+8*2:], 99)
+		view := InspectPage(1, node, BTREE_PAGE_SIZE, 0)
+		assert.Equal(t, []string{"offset 1 is 99 but KV 0 ends at 4"}, view.Errors)
+	})
+
+	t.Run("Short page", func(t *testing.T) {
+		view := InspectPage(1, []byte{1, 0}, BTREE_PAGE_SIZE, 0)
+		assert.Equal(t, []string{"page of 2 bytes has no header"}, view.Errors)
+		assert.Empty(t, view.KVs)
+	})
+}
@@ -0,0 +1,190 @@
+package db
+
+// Range queries
+
+/*
+*
+The iterator remembers the path from the root to the current leaf, for each
+level we keep the node and the index of the kid (or KV) we are at.
+Moving to the next key bumps the index in the leaf, when the leaf is exhausted
+we go up a level, move to the next kid and come back down to its first key.
+
+The first key of the first leaf is the dummy key inserted with the root,
+it is never returned by the iterator.
+*/
+type BIter struct {
+	tree *BTree
+	path []BNode  // from root to leaf
+	pos  []uint16 // indexes into the nodes of the path
+}
+
+// comparison operators for Seek
+const (
+	CMP_GE = +3 // >=
+	CMP_GT = +2 // >
+	CMP_LT = -2 // <
+	CMP_LE = -3 // <=
+)
+
+// is the iterator on the dummy key?
+func (iter *BIter) atDummy() bool {
+	for _, p := range iter.pos {
+		if p != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// does the iterator point to a KV?
+func (iter *BIter) Valid() bool {
+	if len(iter.path) == 0 {
+		return false
+	}
+	last := len(iter.path) - 1
+	return iter.pos[last] < iter.path[last].nkeys() && !iter.atDummy()
+}
+
+// get the current KV pair
+func (iter *BIter) Deref() ([]byte, []byte) {
+	assertStatement(iter.Valid(), "Deref: iterator should point to a KV")
+	last := len(iter.path) - 1
+	node, idx := iter.path[last], iter.pos[last]
+	return node.getKey(idx), node.getVal(idx)
+}
+
+// move to the next key in a node at the given level,
+// returns false when there is nothing after the current key
+func iterNext(iter *BIter, level int) bool {
+	if iter.pos[level]+1 < iter.path[level].nkeys() {
+		iter.pos[level]++ // move within this node
+	} else if level == 0 || !iterNext(iter, level-1) {
+		return false // rightmost key of the tree
+	}
+
+	if level+1 < len(iter.pos) {
+		// we moved to a new kid, start from its first key
+		node := iter.path[level]
+		kid := BNode(iter.tree.get(node.getPtr(iter.pos[level])))
+		iter.path[level+1] = kid
+		iter.pos[level+1] = 0
+	}
+	return true
+}
+
+// move to the previous key in a node at the given level,
+// returns false when there is nothing before the current key
+func iterPrev(iter *BIter, level int) bool {
+	if iter.pos[level] > 0 {
+		iter.pos[level]-- // move within this node
+	} else if level == 0 || !iterPrev(iter, level-1) {
+		return false // leftmost key of the tree
+	}
+
+	if level+1 < len(iter.pos) {
+		// we moved to a new kid, start from its last key
+		node := iter.path[level]
+		kid := BNode(iter.tree.get(node.getPtr(iter.pos[level])))
+		iter.path[level+1] = kid
+		iter.pos[level+1] = kid.nkeys() - 1
+	}
+	return true
+}
+
+func (iter *BIter) Next() {
+	if !iter.Valid() {
+		return
+	}
+	last := len(iter.path) - 1
+	if !iterNext(iter, last) {
+		iter.pos[last] = iter.path[last].nkeys() // past the end
+	}
+}
+
+func (iter *BIter) Prev() {
+	if !iter.Valid() {
+		return
+	}
+	iterPrev(iter, len(iter.path)-1) // stops at the dummy key which is not valid
+}
+
+// find the closest position that is less than or equal to the input key
+func (tree *BTree) SeekLE(key []byte) *BIter {
+	iter := &BIter{tree: tree}
+	for ptr := tree.root; ptr != 0; {
+		node := BNode(tree.get(ptr))
+		idx := nodeLookupLE(node, key, tree.compare)
+		iter.path = append(iter.path, node)
+		iter.pos = append(iter.pos, idx)
+		if node.btype() == BNODE_NODE {
+			ptr = node.getPtr(idx)
+		} else {
+			ptr = 0
+		}
+	}
+	return iter
+}
+
+// the last key of the tree, not valid if the tree is empty
+func (tree *BTree) SeekLast() *BIter {
+	iter := &BIter{tree: tree}
+	for ptr := tree.root; ptr != 0; {
+		node := BNode(tree.get(ptr))
+		idx := node.nkeys() - 1
+		iter.path = append(iter.path, node)
+		iter.pos = append(iter.pos, idx)
+		if node.btype() == BNODE_NODE {
+			ptr = node.getPtr(idx)
+		} else {
+			ptr = 0
+		}
+	}
+	return iter
+}
+
+// key cmp ref in the order of the tree
+func (tree *BTree) cmpOK(key []byte, cmp int, ref []byte) bool {
+	r := tree.compare(key, ref)
+	switch cmp {
+	case CMP_GE:
+		return r >= 0
+	case CMP_GT:
+		return r > 0
+	case CMP_LT:
+		return r < 0
+	case CMP_LE:
+		return r <= 0
+	default:
+		panic("bad cmp!")
+	}
+}
+
+// find the closest position to the key with respect to the cmp relation
+func (tree *BTree) Seek(key []byte, cmp int) *BIter {
+	iter := tree.SeekLE(key)
+	if len(iter.path) == 0 || cmp == CMP_LE {
+		return iter
+	}
+
+	if cmp > 0 {
+		// SeekLE lands on the dummy key when every key is bigger
+		if iter.atDummy() {
+			last := len(iter.path) - 1
+			if !iterNext(iter, last) {
+				iter.pos[last] = iter.path[last].nkeys()
+			}
+			return iter
+		}
+		if cur, _ := iter.Deref(); !tree.cmpOK(cur, cmp, key) {
+			iter.Next() // off by one
+		}
+		return iter
+	}
+
+	if iter.Valid() {
+		if cur, _ := iter.Deref(); !tree.cmpOK(cur, cmp, key) {
+			iter.Prev() // off by one
+		}
+	}
+	return iter
+}
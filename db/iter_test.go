@@ -0,0 +1,127 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Collect keys by walking the iterator forwards
+func collectForward(iter *BIter) []string {
+	var keys []string
+	for ; iter.Valid(); iter.Next() {
+		key, _ := iter.Deref()
+		keys = append(keys, string(key))
+	}
+	return keys
+}
+
+// Helper: Collect keys by walking the iterator backwards
+func collectBackward(iter *BIter) []string {
+	var keys []string
+	for ; iter.Valid(); iter.Prev() {
+		key, _ := iter.Deref()
+		keys = append(keys, string(key))
+	}
+	return keys
+}
+
+func TestBIter(t *testing.T) {
+	t.Run("Iterate over a multi level tree", func(t *testing.T) {
+		c := newC()
+		var expected []string
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key_%04d", i)
+			c.add(key, fmt.Sprintf("value_%d", i))
+			expected = append(expected, key)
+		}
+		assert.Equal(t, uint16(BNODE_NODE), BNode(c.tree.get(c.tree.root)).btype(), "Tree should have more than 1 level")
+
+		keys := collectForward(c.tree.Seek([]byte("key_0000"), CMP_GE))
+		assert.Equal(t, expected, keys)
+
+		keys = collectBackward(c.tree.Seek([]byte("key_9999"), CMP_LE))
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		assert.Equal(t, expected, keys)
+	})
+
+	t.Run("Deref returns the value", func(t *testing.T) {
+		c := newC()
+		c.add("a", "1")
+		c.add("b", "2")
+
+		iter := c.tree.Seek([]byte("b"), CMP_GE)
+		assert.True(t, iter.Valid())
+		key, val := iter.Deref()
+		assert.Equal(t, []byte("b"), key)
+		assert.Equal(t, []byte("2"), val)
+	})
+
+	t.Run("Seek comparison operators", func(t *testing.T) {
+		c := newC()
+		for i := 0; i < 300; i += 2 {
+			c.add(fmt.Sprintf("k%03d", i), "v")
+		}
+
+		cases := []struct {
+			key      string
+			cmp      int
+			expected string
+			valid    bool
+		}{
+			{"k010", CMP_GE, "k010", true},
+			{"k011", CMP_GE, "k012", true},
+			{"k010", CMP_GT, "k012", true},
+			{"k011", CMP_GT, "k012", true},
+			{"k010", CMP_LE, "k010", true},
+			{"k011", CMP_LE, "k010", true},
+			{"k010", CMP_LT, "k008", true},
+			{"k011", CMP_LT, "k010", true},
+			{"a", CMP_GE, "k000", true},
+			{"a", CMP_GT, "k000", true},
+			{"a", CMP_LE, "", false},
+			{"k000", CMP_LT, "", false},
+			{"z", CMP_LE, "k298", true},
+			{"k298", CMP_GT, "", false},
+			{"z", CMP_GE, "", false},
+		}
+		for _, tc := range cases {
+			iter := c.tree.Seek([]byte(tc.key), tc.cmp)
+			assert.Equal(t, tc.valid, iter.Valid(), "Seek(%q, %d) validity", tc.key, tc.cmp)
+			if tc.valid && iter.Valid() {
+				key, _ := iter.Deref()
+				assert.Equal(t, tc.expected, string(key), "Seek(%q, %d)", tc.key, tc.cmp)
+			}
+		}
+	})
+
+	// Edge cases
+	t.Run("Empty tree", func(t *testing.T) {
+		c := newC()
+		assert.False(t, c.tree.Seek([]byte("a"), CMP_GE).Valid())
+		assert.False(t, c.tree.Seek([]byte("a"), CMP_LE).Valid())
+	})
+
+	t.Run("Dummy key is never returned", func(t *testing.T) {
+		c := newC()
+		c.add("only", "value")
+		c.del("only")
+
+		assert.False(t, c.tree.Seek([]byte("a"), CMP_GE).Valid())
+		assert.False(t, c.tree.Seek([]byte("z"), CMP_LE).Valid())
+	})
+
+	t.Run("Next past the end stays invalid", func(t *testing.T) {
+		c := newC()
+		c.add("a", "1")
+
+		iter := c.tree.Seek([]byte("a"), CMP_GE)
+		iter.Next()
+		assert.False(t, iter.Valid())
+		iter.Next()
+		assert.False(t, iter.Valid())
+	})
+}
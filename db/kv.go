@@ -0,0 +1,397 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Persisting the B+tree to a file
+
+/*
+*
+File layout: the file is an array of pages, a pointer is the page number.
+
+Page 0 is the meta page, it tells us where the tree is:
+
+sig: 16 bytes
+root pointer: 8 bytes
+pages used: 8 bytes
+page size: 4 bytes
+comparator name: 1 byte length, then the name
+
+The page size and the comparator are picked when the file is created. Files made before
+they were stored have a shorter meta page, a missing or zero page size means
+BTREE_PAGE_SIZE and a missing name means COMPARE_BYTES.
+
+Updates never overwrite a page that is reachable from the meta page (copy on write),
+new pages are appended to the end of the file. An update is made durable in 2 steps:
+
+ 1. write the new pages and fsync
+ 2. write the meta page pointing to the new root and fsync
+
+If we crash before step 2 the old meta page still points to the old tree which is untouched,
+so we either see the whole update or nothing of it. A new file gets the meta page of an
+empty tree when it is opened, so there is always an old meta page to fall back to.
+The meta page is 64 bytes, it is written atomically by the disk.
+
+crash_test.go checks this with a fake File that loses or tears the writes that were not synced.
+
+TODO: There is no free list yet, deleted pages are leaked until we have one. Vacuum
+reclaims them by rewriting the file.
+*/
+const DB_SIG = "building-a-db-01"
+
+const META_SIZE = 64
+
+type KV struct {
+	Path       string
+	PageSize   int            // of a new file, BTREE_PAGE_SIZE when 0. Open sets it to the page size of the file
+	Comparator *Comparator    // key order of a new file, COMPARE_BYTES when nil. Open sets it to the order of the file
+	PoolFrames int            // pages cached in memory, DEFAULT_POOL_FRAMES when 0
+	PoolPolicy EvictionPolicy // LRU when not set
+
+	fd   File
+	pool *BufferPool
+	tree BTree
+	page struct {
+		flushed uint64 // number of pages in the file, including the meta page
+		nappend uint64 // pages allocated by the current transaction, in the pool or past flushed
+	}
+	tx *KVTX // the transaction in progress if any
+
+	// guards tree.root and page.flushed when a commit changes them, so Backup can
+	// pin them from another goroutine. KV is otherwise used by a single goroutine.
+	mu sync.Mutex
+}
+
+var ErrTxInProgress = errors.New("a transaction is already in progress")
+
+// the file operations used by KV, an *os.File or a fake one to inject faults in tests
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	Sync() error
+	Size() (int64, error)
+	Close() error
+}
+
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) Size() (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// open or create the database file
+func (db *KV) Open() error {
+	if db.PageSize != 0 {
+		if err := checkPageSize(db.PageSize); err != nil {
+			return err // before the file is created
+		}
+	}
+	if db.Comparator != nil {
+		if err := checkComparator(db.Comparator); err != nil {
+			return err
+		}
+	}
+	fd, err := os.OpenFile(db.Path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	return db.OpenFile(osFile{fd})
+}
+
+// use an already open file, it is closed on error and by Close
+func (db *KV) OpenFile(fd File) error {
+	db.fd = fd
+
+	db.tree.get = db.pageGet
+	db.tree.new = db.pageNew
+	db.tree.del = db.pageDel
+
+	if err := db.loadMeta(); err != nil {
+		db.fd.Close()
+		return err
+	}
+	frames := db.PoolFrames
+	if frames <= 0 {
+		frames = DEFAULT_POOL_FRAMES
+	}
+	db.pool = NewBufferPool(fd, db.PageSize, frames, db.PoolPolicy)
+	return nil
+}
+
+func (db *KV) Close() error {
+	if db.tx != nil {
+		db.tx.Abort()
+	}
+	return db.fd.Close()
+}
+
+// read the meta page, an empty file becomes an empty database
+func (db *KV) loadMeta() error {
+	size, err := db.fd.Size()
+	if err != nil {
+		return fmt.Errorf("stat: %w", err)
+	}
+	if size == 0 {
+		if db.PageSize == 0 {
+			db.PageSize = BTREE_PAGE_SIZE
+		}
+		if err := checkPageSize(db.PageSize); err != nil {
+			return err
+		}
+		if db.Comparator == nil {
+			db.Comparator = COMPARE_BYTES
+		}
+		if err := checkComparator(db.Comparator); err != nil {
+			return err
+		}
+		// a new database gets the meta page of an empty tree right away, so a crash
+		// during the first commit still leaves a valid meta page behind
+		db.page.flushed = 1
+		db.tree.root = 0
+		db.tree.page = db.PageSize
+		db.tree.cmp = db.Comparator
+		if _, err := db.fd.WriteAt(db.encodeMeta(0, 1), 0); err != nil {
+			return fmt.Errorf("write meta page: %w", err)
+		}
+		if err := db.fd.Sync(); err != nil {
+			return fmt.Errorf("fsync meta page: %w", err)
+		}
+		return nil
+	}
+
+	data := make([]byte, META_SIZE)
+	n, err := db.fd.ReadAt(data, 0)
+	if err != nil && !(errors.Is(err, io.EOF) && n >= 32) {
+		return fmt.Errorf("read meta page: %w", err)
+	}
+	meta, err := decodeMeta(data[:n])
+	if err != nil {
+		return err
+	}
+	if db.PageSize != 0 && db.PageSize != meta.pageSize {
+		return fmt.Errorf("the file has pages of %d bytes, not %d", meta.pageSize, db.PageSize)
+	}
+	cmp := db.Comparator
+	if cmp == nil {
+		if cmp = LookupComparator(meta.order); cmp == nil {
+			return fmt.Errorf("the file is ordered by comparator %q which is not registered", meta.order)
+		}
+	}
+	if cmp.Name != meta.order {
+		return fmt.Errorf("the file is ordered by comparator %q, not %q", meta.order, cmp.Name)
+	}
+	// the meta page of a new database is only META_SIZE bytes
+	if meta.used > 1 && meta.used*uint64(meta.pageSize) > uint64(size) {
+		return fmt.Errorf("bad meta page: %d pages used but the file has %d bytes", meta.used, size)
+	}
+	db.tree.root = meta.root
+	db.tree.page = meta.pageSize
+	db.tree.cmp = cmp
+	db.page.flushed = meta.used
+	db.PageSize = meta.pageSize
+	db.Comparator = cmp
+	return nil
+}
+
+// the content of the meta page
+type metaPage struct {
+	root     uint64
+	used     uint64 // pages in the file, including the meta page
+	pageSize int
+	order    string // name of the comparator
+}
+
+func encodeMeta(meta metaPage) []byte {
+	assertStatement(len(meta.order) <= MAX_COMPARATOR_NAME, "encodeMeta: comparator name too long")
+	var data [META_SIZE]byte
+	copy(data[:16], []byte(DB_SIG))
+	binary.LittleEndian.PutUint64(data[16:], meta.root)
+	binary.LittleEndian.PutUint64(data[24:], meta.used)
+	binary.LittleEndian.PutUint32(data[32:], uint32(meta.pageSize))
+	data[36] = byte(len(meta.order))
+	copy(data[37:], meta.order)
+	return data[:]
+}
+
+// the meta page of the committed tree of the KV
+func (db *KV) encodeMeta(root, used uint64) []byte {
+	return encodeMeta(metaPage{root: root, used: used, pageSize: db.PageSize, order: db.Comparator.Name})
+}
+
+// decode a meta page of at least 32 bytes, older ones have no page size or comparator
+func decodeMeta(data []byte) (metaPage, error) {
+	if len(data) < 32 || !bytes.Equal(data[:16], []byte(DB_SIG)) {
+		return metaPage{}, errors.New("bad signature, not a database file")
+	}
+	meta := metaPage{
+		root:     binary.LittleEndian.Uint64(data[16:]),
+		used:     binary.LittleEndian.Uint64(data[24:]),
+		pageSize: metaPageSize(data),
+		order:    metaOrder(data),
+	}
+	if meta.used < 1 || meta.root >= meta.used {
+		return metaPage{}, fmt.Errorf("bad meta page: root=%d used=%d", meta.root, meta.used)
+	}
+	if err := checkPageSize(meta.pageSize); err != nil {
+		return metaPage{}, fmt.Errorf("bad meta page: %w", err)
+	}
+	if meta.order == "" {
+		return metaPage{}, errors.New("bad meta page: comparator name too long")
+	}
+	return meta, nil
+}
+
+// the page size in a meta page, not checked
+func metaPageSize(data []byte) int {
+	if len(data) < 36 || binary.LittleEndian.Uint32(data[32:]) == 0 {
+		return BTREE_PAGE_SIZE // written before the page size was stored
+	}
+	return int(binary.LittleEndian.Uint32(data[32:]))
+}
+
+// the comparator name in a meta page, empty if it doesn't fit
+func metaOrder(data []byte) string {
+	if len(data) < 37 || data[36] == 0 {
+		return COMPARE_BYTES.Name // written before the comparator was stored
+	}
+	n := int(data[36])
+	if n > MAX_COMPARATOR_NAME || 37+n > len(data) {
+		return ""
+	}
+	return string(data[37 : 37+n])
+}
+
+// Page management callbacks for the BTree
+
+// dereference a pointer through the buffer pool, the tree gets a copy it can keep
+func (db *KV) pageGet(ptr uint64) []byte {
+	assertStatement(ptr < db.page.flushed+db.page.nappend, "pageGet: pointer past the last page")
+	data, err := db.pool.Fetch(ptr)
+	if err != nil {
+		return db.poolFailed(fmt.Errorf("fetch page %d: %w", ptr, err))
+	}
+	page := bytes.Clone(data)
+	db.pool.Unpin(ptr, false)
+	return page
+}
+
+// allocate a new page, it is a dirty frame until the commit or until it is evicted
+func (db *KV) pageNew(node []byte) uint64 {
+	assertStatement(len(node) <= db.PageSize, "pageNew: node should fit in a page")
+	ptr := db.page.flushed + db.page.nappend
+	db.page.nappend++
+	if _, err := db.pool.NewPage(ptr, node); err != nil {
+		db.poolFailed(fmt.Errorf("new page %d: %w", ptr, err))
+		return ptr
+	}
+	db.pool.Unpin(ptr, true)
+	return ptr
+}
+
+/*
+*
+The tree callbacks can't return errors, and the pool fails when it evicts a dirty page and
+the write fails. The first error fails the transaction in progress: the tree goes on with
+an empty leaf in place of the page, and Set, Del and Commit abort the transaction and return
+the error, so what was built is thrown away. Outside of a transaction there are no dirty
+pages, only a read of the committed tree can fail and there is nothing to return it to.
+*/
+func (db *KV) poolFailed(err error) []byte {
+	if db.tx == nil {
+		panic(err.Error())
+	}
+	if db.tx.err == nil {
+		db.tx.err = err
+	}
+	leaf := db.tree.newNode(1)
+	leaf.setHeader(BNODE_LEAF, 1)
+	nodeAppendKV(leaf, 0, 0, nil, nil) // the dummy key
+	return leaf
+}
+
+// deallocate a page, no-op until we have a free list
+func (db *KV) pageDel(uint64) {}
+
+// persist the pages of the current transaction and then the meta page
+func (db *KV) flush(root uint64) error {
+	// the pages evicted during the transaction are already written
+	if err := db.pool.FlushAll(); err != nil {
+		return err
+	}
+	if err := db.fd.Sync(); err != nil {
+		return fmt.Errorf("fsync pages: %w", err)
+	}
+
+	used := db.page.flushed + db.page.nappend
+	if _, err := db.fd.WriteAt(db.encodeMeta(root, used), 0); err != nil {
+		return fmt.Errorf("write meta page: %w", err)
+	}
+	if err := db.fd.Sync(); err != nil {
+		return fmt.Errorf("fsync meta page: %w", err)
+	}
+
+	db.mu.Lock()
+	db.page.flushed = used
+	db.mu.Unlock()
+	db.page.nappend = 0
+	return nil
+}
+
+// KV interface, each update is its own transaction
+
+func (db *KV) Get(key []byte) ([]byte, bool) {
+	return db.tree.Get(key)
+}
+
+// find the closest position to the key in the committed tree
+func (db *KV) Seek(key []byte, cmp int) *BIter {
+	return db.tree.Seek(key, cmp)
+}
+
+// the last key of the committed tree
+func (db *KV) SeekLast() *BIter {
+	return db.tree.SeekLast()
+}
+
+// compare two keys in the order of the database
+func (db *KV) Compare(a, b []byte) int {
+	return db.tree.compare(a, b)
+}
+
+func (db *KV) Set(key []byte, val []byte) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := tx.Set(key, val); err != nil {
+		tx.Abort()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *KV) Del(key []byte) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	deleted, err := tx.Del(key)
+	if err != nil || !deleted {
+		tx.Abort()
+		return false, err
+	}
+	return true, tx.Commit()
+}
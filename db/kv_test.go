@@ -0,0 +1,281 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Open a database file inside a temp directory
+func openKV(t *testing.T, path string) *KV {
+	db := &KV{Path: path}
+	err := db.Open()
+	assert.NoError(t, err)
+	return db
+}
+
+func TestKV(t *testing.T) {
+	t.Run("Set, get and delete", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+
+		assert.NoError(t, db.Set([]byte("k1"), []byte("v1")))
+		assert.NoError(t, db.Set([]byte("k2"), []byte("v2")))
+
+		val, ok := db.Get([]byte("k1"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("v1"), val)
+
+		deleted, err := db.Del([]byte("k1"))
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+
+		_, ok = db.Get([]byte("k1"))
+		assert.False(t, ok)
+
+		deleted, err = db.Del([]byte("missing"))
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("Data survives reopening", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKV(t, path)
+		for i := 0; i < 1000; i++ {
+			assert.NoError(t, db.Set([]byte(fmt.Sprintf("key_%04d", i)), []byte(fmt.Sprintf("value_%d", i))))
+		}
+		for i := 0; i < 1000; i += 2 {
+			db.Del([]byte(fmt.Sprintf("key_%04d", i)))
+		}
+		assert.NoError(t, db.Close())
+
+		db = openKV(t, path)
+		defer db.Close()
+		for i := 0; i < 1000; i++ {
+			val, ok := db.Get([]byte(fmt.Sprintf("key_%04d", i)))
+			if i%2 == 0 {
+				assert.False(t, ok, "key_%04d should be deleted", i)
+			} else {
+				assert.True(t, ok, "key_%04d should exist", i)
+				assert.Equal(t, fmt.Sprintf("value_%d", i), string(val))
+			}
+		}
+	})
+
+	t.Run("Seek over committed data", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+		for _, k := range []string{"c", "a", "b"} {
+			db.Set([]byte(k), []byte(k))
+		}
+		assert.Equal(t, []string{"b", "c"}, collectForward(db.Seek([]byte("b"), CMP_GE)))
+	})
+
+	// Edge cases
+	t.Run("Invalid key is rejected", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+		assert.Error(t, db.Set([]byte(""), []byte("v")))
+		assert.Error(t, db.Set(make([]byte, BTREE_MAX_KEY_SIZE+1), []byte("v")))
+	})
+
+	t.Run("Reject file that is not a database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "junk.db")
+		assert.NoError(t, os.WriteFile(path, make([]byte, BTREE_PAGE_SIZE), 0644))
+		db := &KV{Path: path}
+		assert.Error(t, db.Open())
+	})
+
+	t.Run("Reject truncated file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKV(t, path)
+		for i := 0; i < 100; i++ {
+			db.Set([]byte(fmt.Sprintf("key_%03d", i)), make([]byte, 100))
+		}
+		db.Close()
+		assert.NoError(t, os.Truncate(path, BTREE_PAGE_SIZE))
+
+		db = &KV{Path: path}
+		assert.Error(t, db.Open())
+	})
+}
+
+func TestKVTX(t *testing.T) {
+	t.Run("Commit makes updates visible", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKV(t, path)
+
+		tx, err := db.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Set([]byte("a"), []byte("1")))
+		assert.NoError(t, tx.Set([]byte("b"), []byte("2")))
+
+		// Uncommitted updates are only visible inside the transaction
+		_, ok := db.Get([]byte("a"))
+		assert.False(t, ok)
+		val, ok := tx.Get([]byte("a"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("1"), val)
+
+		assert.NoError(t, tx.Commit())
+		val, ok = db.Get([]byte("b"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("2"), val)
+		db.Close()
+
+		db = openKV(t, path)
+		defer db.Close()
+		_, ok = db.Get([]byte("a"))
+		assert.True(t, ok, "Committed data should survive reopening")
+	})
+
+	t.Run("Abort throws away updates", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKV(t, path)
+		db.Set([]byte("a"), []byte("1"))
+
+		tx, _ := db.Begin()
+		tx.Set([]byte("a"), []byte("changed"))
+		tx.Set([]byte("b"), []byte("2"))
+		tx.Del([]byte("a"))
+		tx.Abort()
+
+		val, ok := db.Get([]byte("a"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("1"), val)
+		_, ok = db.Get([]byte("b"))
+		assert.False(t, ok)
+
+		// The database is still usable after an abort
+		assert.NoError(t, db.Set([]byte("c"), []byte("3")))
+		db.Close()
+
+		db = openKV(t, path)
+		defer db.Close()
+		assert.Equal(t, []string{"a", "c"}, collectForward(db.Seek([]byte("a"), CMP_GE)))
+	})
+
+	// Edge cases
+	t.Run("Only one transaction at a time", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+
+		tx, err := db.Begin()
+		assert.NoError(t, err)
+		_, err = db.Begin()
+		assert.ErrorIs(t, err, ErrTxInProgress)
+		assert.ErrorIs(t, db.Set([]byte("a"), []byte("1")), ErrTxInProgress)
+
+		tx.Abort()
+		_, err = db.Begin()
+		assert.NoError(t, err)
+	})
+
+	t.Run("Finished transaction cannot be used", func(t *testing.T) {
+		db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+		defer db.Close()
+
+		tx, _ := db.Begin()
+		assert.NoError(t, tx.Commit())
+		assert.ErrorIs(t, tx.Set([]byte("a"), []byte("1")), ErrTxDone)
+		assert.ErrorIs(t, tx.Commit(), ErrTxDone)
+	})
+}
+
+// Helper: Open a database file with pages of size bytes
+func openKVPageSize(t *testing.T, path string, size int) *KV {
+	db := &KV{Path: path, PageSize: size}
+	assert.NoError(t, db.Open())
+	return db
+}
+
+func TestKVPageSize(t *testing.T) {
+	t.Run("Every page size", func(t *testing.T) {
+		for size := BTREE_MIN_PAGE_SIZE; size <= BTREE_MAX_PAGE_SIZE; size *= 2 {
+			path := filepath.Join(t.TempDir(), "test.db")
+			db := openKVPageSize(t, path, size)
+			want := map[string]string{}
+			tx, _ := db.Begin()
+			for i := 0; i < 300; i++ {
+				key := fmt.Sprintf("key%04d", i)
+				val := strings.Repeat("v", i*size/1000)
+				if i%50 == 0 {
+					key += strings.Repeat("k", MaxKeySize(size)-len(key))
+					val = strings.Repeat("v", MaxValSize(size))
+				}
+				assert.NoError(t, tx.Set([]byte(key), []byte(val)))
+				want[key] = val
+			}
+			assert.NoError(t, tx.Commit())
+			for key := range want {
+				if key[len(key)-1]%2 == 0 {
+					db.Del([]byte(key))
+					delete(want, key)
+				}
+			}
+			report := db.Check()
+			assert.True(t, report.OK(), "%d: %v", size, report.Violations)
+			assert.Greater(t, report.Height, 1, size)
+			db.Close()
+
+			fi, _ := os.Stat(path)
+			assert.Zero(t, fi.Size()%int64(size), size)
+			reopened := openKV(t, path)
+			assert.Equal(t, size, reopened.PageSize)
+			assert.Equal(t, size, reopened.Stats().PageSize)
+			assert.Equal(t, want, dumpKV(reopened))
+			reopened.Close()
+		}
+	})
+
+	t.Run("Limits grow with the page size", func(t *testing.T) {
+		db := openKVPageSize(t, filepath.Join(t.TempDir(), "test.db"), 16384)
+		defer db.Close()
+		assert.NoError(t, db.Set(make([]byte, 4000), make([]byte, 12000)))
+		assert.Error(t, db.Set(make([]byte, 4001), nil))
+		assert.Error(t, db.Set([]byte("k"), make([]byte, 12001)))
+	})
+
+	// Edge cases
+	t.Run("Bad page size", func(t *testing.T) {
+		for _, size := range []int{-1, 2048, 5000, 131072} {
+			path := filepath.Join(t.TempDir(), "test.db")
+			db := &KV{Path: path, PageSize: size}
+			assert.ErrorIs(t, db.Open(), ErrBadPageSize, size)
+			_, err := os.Stat(path)
+			assert.True(t, os.IsNotExist(err), "The file is not created")
+		}
+	})
+
+	t.Run("An existing file keeps its page size", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db := openKVPageSize(t, path, 8192)
+		db.Close()
+		db = &KV{Path: path, PageSize: 4096}
+		assert.ErrorContains(t, db.Open(), "the file has pages of 8192 bytes, not 4096")
+	})
+
+	t.Run("File without a page size in the meta page", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		assert.NoError(t, os.WriteFile(path, encodeMeta(metaPage{root: 0, used: 1, pageSize: 0})[:32], 0644))
+		db := openKV(t, path)
+		assert.Equal(t, BTREE_PAGE_SIZE, db.PageSize)
+		assert.NoError(t, db.Set([]byte("k"), []byte("v")))
+		db.Close()
+
+		db = openKV(t, path)
+		defer db.Close()
+		assert.Equal(t, map[string]string{"k": "v"}, dumpKV(db))
+	})
+
+	t.Run("Bad page size in the meta page", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		assert.NoError(t, os.WriteFile(path, encodeMeta(metaPage{root: 0, used: 1, pageSize: 1000}), 0644))
+		db := &KV{Path: path}
+		assert.ErrorIs(t, db.Open(), ErrBadPageSize)
+	})
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Statistics of how the tree sits in its pages
+
+/*
+*
+Stats walks every page reachable from the root and returns:
+
+  - the height and the number of internal and leaf pages
+  - per level from the root: pages, keys, bytes used and the average fill of a page
+  - the number of keys and the histograms of key and value sizes, leaf KVs without the dummy key
+  - for KV.Stats, the pages of the file the tree doesn't reach
+
+There is no free list yet, so FreePages are the pages copy on write left behind, a free list
+would reuse them. Stats trusts the pages, run Check first on a file that may be corrupt.
+*/
+type TreeStats struct {
+	PageSize      int
+	Height        int // levels, 0 for an empty tree
+	InternalPages int
+	LeafPages     int
+	Levels        []LevelStats // Levels[0] is the root
+	Keys          int
+	KeySizes      SizeHistogram
+	ValSizes      SizeHistogram
+	FilePages     int // pages in the file including the meta page, KV.Stats only
+	FreePages     int // pages of the file the tree doesn't reach, KV.Stats only
+}
+
+type LevelStats struct {
+	Pages    int
+	Keys     int // KVs in the pages of the level, including the dummy key
+	Bytes    int // bytes used by the pages of the level
+	Capacity int // bytes of the pages of the level
+}
+
+// average fraction of a page in use at this level
+func (l LevelStats) Fill() float64 {
+	if l.Capacity == 0 {
+		return 0
+	}
+	return float64(l.Bytes) / float64(l.Capacity)
+}
+
+// average fraction of a page in use over the whole tree
+func (s *TreeStats) Fill() float64 {
+	var all LevelStats
+	for _, l := range s.Levels {
+		all.Bytes += l.Bytes
+		all.Capacity += l.Capacity
+	}
+	return all.Fill()
+}
+
+/*
+*
+SizeHistogram counts sizes in power of 2 buckets:
+
+	Counts[0]  size 0
+	Counts[1]  size 1
+	Counts[2]  sizes 2-3
+	Counts[i]  sizes 2^(i-1) .. 2^i-1
+*/
+type SizeHistogram struct {
+	Counts []int
+	Total  int // sum of the sizes
+	Max    int
+}
+
+func (h *SizeHistogram) add(size int) {
+	i := bits.Len(uint(size))
+	for len(h.Counts) <= i {
+		h.Counts = append(h.Counts, 0)
+	}
+	h.Counts[i]++
+	h.Total += size
+	h.Max = max(h.Max, size)
+}
+
+// number of sizes counted
+func (h *SizeHistogram) N() int {
+	n := 0
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+func (h *SizeHistogram) Mean() float64 {
+	if h.N() == 0 {
+		return 0
+	}
+	return float64(h.Total) / float64(h.N())
+}
+
+// the smallest and largest size of bucket i
+func BucketRange(i int) (int, int) {
+	if i == 0 {
+		return 0, 0
+	}
+	return 1 << (i - 1), 1<<i - 1
+}
+
+// non empty buckets as "2-3: 5, 4-7: 1"
+func (h SizeHistogram) String() string {
+	var parts []string
+	for i, c := range h.Counts {
+		if c == 0 {
+			continue
+		}
+		lo, hi := BucketRange(i)
+		if lo == hi {
+			parts = append(parts, fmt.Sprintf("%d: %d", lo, c))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d: %d", lo, hi, c))
+		}
+	}
+	if len(parts) == 0 {
+		return "(empty)"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (tree *BTree) Stats() *TreeStats {
+	stats := &TreeStats{PageSize: tree.pageSize()}
+	seen := map[uint64]bool{}
+	var walk func(ptr uint64, depth int)
+	walk = func(ptr uint64, depth int) {
+		if seen[ptr] {
+			return
+		}
+		seen[ptr] = true
+		node := BNode(tree.get(ptr))
+		if len(stats.Levels) <= depth {
+			stats.Levels = append(stats.Levels, LevelStats{})
+		}
+		level := &stats.Levels[depth]
+		level.Pages++
+		level.Keys += int(node.nkeys())
+		level.Bytes += int(node.nbytes())
+		level.Capacity += stats.PageSize
+
+		switch node.btype() {
+		case BNODE_NODE:
+			stats.InternalPages++
+			for i := uint16(0); i < node.nkeys(); i++ {
+				walk(node.getPtr(i), depth+1)
+			}
+		case BNODE_LEAF:
+			start := uint16(0)
+			if stats.LeafPages == 0 && node.nkeys() > 0 && len(node.getKey(0)) == 0 {
+				start = 1 // the dummy key of the leftmost leaf
+			}
+			stats.LeafPages++
+			for i := start; i < node.nkeys(); i++ {
+				stats.Keys++
+				stats.KeySizes.add(len(node.getKey(i)))
+				stats.ValSizes.add(len(node.getVal(i)))
+			}
+		}
+	}
+	if tree.root != 0 {
+		walk(tree.root, 0)
+	}
+	stats.Height = len(stats.Levels)
+	return stats
+}
+
+// stats of the committed tree and of the pages of the file it doesn't reach
+func (db *KV) Stats() *TreeStats {
+	stats := db.tree.Stats()
+	stats.FilePages = int(db.page.flushed)
+	if db.page.flushed > 0 {
+		stats.FreePages = int(db.page.flushed) - 1 - stats.InternalPages - stats.LeafPages
+	}
+	return stats
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreeStats(t *testing.T) {
+	t.Run("Matches the pages of the tree", func(t *testing.T) {
+		c := checkTree(t, 100)
+		stats := c.tree.Stats()
+		report := Check(&c.tree)
+
+		assert.Equal(t, report.Height, stats.Height)
+		assert.Equal(t, 3, stats.Height, "Long keys give 3 levels")
+		assert.Equal(t, len(c.pages), stats.InternalPages+stats.LeafPages)
+		assert.Equal(t, report.Leaves, stats.LeafPages)
+		assert.Equal(t, 100, stats.Keys)
+		assert.Equal(t, 1, stats.Levels[0].Pages, "One root")
+		assert.Equal(t, stats.LeafPages, stats.Levels[stats.Height-1].Pages)
+
+		used := 0
+		for _, page := range c.pages {
+			used += int(BNode(page).nbytes())
+		}
+		total := 0
+		for _, level := range stats.Levels {
+			total += level.Bytes
+			assert.Greater(t, level.Fill(), 0.0)
+			assert.LessOrEqual(t, level.Fill(), 1.0)
+		}
+		assert.Equal(t, used, total)
+		assert.InDelta(t, float64(used)/float64(len(c.pages)*BTREE_PAGE_SIZE), stats.Fill(), 1e-9)
+	})
+
+	t.Run("Key and value size histograms", func(t *testing.T) {
+		c := newC()
+		c.add("a", "")
+		c.add("bb", "123")
+		c.add("cccc", strings.Repeat("v", 1000))
+		stats := c.tree.Stats()
+
+		assert.Equal(t, 3, stats.Keys, "No dummy key")
+		assert.Equal(t, []int{0, 1, 1, 1}, stats.KeySizes.Counts)
+		assert.Equal(t, 7, stats.KeySizes.Total)
+		assert.Equal(t, 4, stats.KeySizes.Max)
+		assert.Equal(t, "1: 1, 2-3: 1, 4-7: 1", stats.KeySizes.String())
+		assert.Equal(t, "0: 1, 2-3: 1, 512-1023: 1", stats.ValSizes.String())
+		assert.InDelta(t, 1003.0/3, stats.ValSizes.Mean(), 1e-9)
+		assert.Equal(t, 3, stats.ValSizes.N())
+	})
+
+	// Edge cases
+	t.Run("Empty tree", func(t *testing.T) {
+		stats := newC().tree.Stats()
+		assert.Equal(t, 0, stats.Height)
+		assert.Equal(t, 0, stats.Keys)
+		assert.Equal(t, 0.0, stats.Fill())
+		assert.Equal(t, "(empty)", stats.KeySizes.String())
+		assert.Equal(t, 0.0, stats.KeySizes.Mean())
+	})
+
+	t.Run("Only the dummy key", func(t *testing.T) {
+		c := newC()
+		c.add("k", "v")
+		c.del("k")
+		stats := c.tree.Stats()
+		assert.Equal(t, 1, stats.Height)
+		assert.Equal(t, 0, stats.Keys)
+		assert.Equal(t, 1, stats.Levels[0].Keys)
+	})
+
+	t.Run("Bucket ranges", func(t *testing.T) {
+		for i, want := range map[int][2]int{0: {0, 0}, 1: {1, 1}, 2: {2, 3}, 3: {4, 7}, 11: {1024, 2047}} {
+			lo, hi := BucketRange(i)
+			assert.Equal(t, want, [2]int{lo, hi})
+		}
+	})
+}
+
+func TestKVStats(t *testing.T) {
+	db := openKV(t, filepath.Join(t.TempDir(), "test.db"))
+	defer db.Close()
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, db.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value")))
+	}
+
+	stats := db.Stats()
+	report := db.Check()
+	assert.Equal(t, 50, stats.Keys)
+	assert.Equal(t, int(db.page.flushed), stats.FilePages)
+	assert.Equal(t, len(report.Unreachable), stats.FreePages, "Pages left by copy on write")
+	assert.Greater(t, stats.FreePages, 0)
+}
@@ -0,0 +1,115 @@
+package db
+
+import "errors"
+
+// Transactions
+
+/*
+*
+A transaction works on its own copy of the BTree struct. Thanks to copy on write
+the committed tree is never modified, so readers of the KV keep seeing the last
+committed root while the transaction is running.
+
+Commit writes the new pages and switches the root, Abort just forgets the new pages.
+Only one transaction can run at a time.
+*/
+type KVTX struct {
+	db   *KV
+	tree BTree
+	done bool
+	err  error // the first buffer pool error, the transaction can only be aborted
+}
+
+var ErrTxDone = errors.New("transaction has already been committed or aborted")
+
+// start a transaction
+func (db *KV) Begin() (*KVTX, error) {
+	if db.tx != nil {
+		return nil, ErrTxInProgress
+	}
+	tx := &KVTX{db: db, tree: db.tree}
+	db.tx = tx
+	return tx, nil
+}
+
+func (tx *KVTX) Get(key []byte) ([]byte, bool) {
+	return tx.tree.Get(key)
+}
+
+// find the closest position to the key, including the updates of this transaction
+func (tx *KVTX) Seek(key []byte, cmp int) *BIter {
+	return tx.tree.Seek(key, cmp)
+}
+
+// compare two keys in the order of the database
+func (tx *KVTX) Compare(a, b []byte) int {
+	return tx.tree.compare(a, b)
+}
+
+func (tx *KVTX) Set(key []byte, val []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return tx.check(tx.tree.Insert(key, val))
+}
+
+// the error Set would return for the sizes of the key and value, without writing
+func (tx *KVTX) CheckLimit(key []byte, val []byte) error {
+	return tx.tree.checkLimit(key, val)
+}
+
+func (tx *KVTX) Del(key []byte) (bool, error) {
+	if tx.done {
+		return false, ErrTxDone
+	}
+	deleted, err := tx.tree.Delete(key)
+	if err = tx.check(err); err != nil {
+		return false, err
+	}
+	return deleted, nil
+}
+
+// abort the transaction if the buffer pool failed during the update, see KV.poolFailed
+func (tx *KVTX) check(err error) error {
+	if tx.err != nil {
+		err = tx.err
+		tx.Abort()
+	}
+	return err
+}
+
+// make the updates durable, on failure the transaction is aborted
+func (tx *KVTX) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if err := tx.check(nil); err != nil {
+		return err
+	}
+	db := tx.db
+	if tx.tree.root == db.tree.root {
+		tx.Abort() // nothing to write
+		return nil
+	}
+	if err := db.flush(tx.tree.root); err != nil {
+		tx.Abort()
+		return err
+	}
+	db.mu.Lock()
+	db.tree.root = tx.tree.root
+	db.mu.Unlock()
+	tx.done = true
+	db.tx = nil
+	return nil
+}
+
+// throw away the updates
+func (tx *KVTX) Abort() {
+	if tx.done {
+		return
+	}
+	tx.db.pool.Discard(tx.db.page.flushed)
+	tx.db.page.nappend = 0
+	tx.done = true
+	tx.db.tx = nil
+}
@@ -0,0 +1,343 @@
+package docstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"building-a-db/db"
+)
+
+// JSON document collections stored in db.KV
+
+/*
+*
+Everything lives in the same KV, the kind of record and the collection name are key prefixes:
+
+	doc \0 <collection> \0 <id>                           the JSON document
+	seq \0 <collection>                                   the next id
+	idxdef \0 <collection> \0 <path>                      an index on path
+	idx \0 <collection> \0 <path> \0 <value> <id>         an index entry, the value is empty
+
+ids are 8 byte big endian so documents are stored in insertion order, and <value> uses the
+order preserving encoding from index.go so a range condition is a range scan of index entries.
+
+Every update runs in one db transaction, so a document and its index entries are always in sync.
+The transaction reads the index definitions again, so an index created through another
+Collection of the same name is kept up to date too.
+The prefixes and the index encoding only work in byte order, so the KV must use COMPARE_BYTES.
+*/
+type Collection struct {
+	kv      *db.KV
+	name    string
+	indexes []string // indexed paths
+}
+
+// the field holding the id in stored documents
+const ID_FIELD = "_id"
+
+var ErrBadName = errors.New("collection names and paths can't be empty or contain \\0")
+
+func checkName(name string) error {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return ErrBadName
+	}
+	return nil
+}
+
+func key(parts ...string) []byte {
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+func (c *Collection) docKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(key("doc", c.name, ""), id)
+}
+
+func (c *Collection) indexPrefix(path string) []byte {
+	return key("idx", c.name, path, "")
+}
+
+// open a collection, it exists as soon as something is inserted
+func Open(kv *db.KV, name string) (*Collection, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if kv.Comparator.Name != db.COMPARE_BYTES.Name {
+		return nil, fmt.Errorf("docstore: the KV is ordered by %q, collections need %q", kv.Comparator.Name, db.COMPARE_BYTES.Name)
+	}
+	c := &Collection{kv: kv, name: name}
+	c.loadIndexes(kv)
+	return c, nil
+}
+
+// the committed tree of a db.KV or a transaction
+type seeker interface {
+	Seek(key []byte, cmp int) *db.BIter
+}
+
+// read the index definitions
+func (c *Collection) loadIndexes(r seeker) {
+	c.indexes = nil
+	prefix := key("idxdef", c.name, "")
+	for iter := r.Seek(prefix, db.CMP_GE); iter.Valid(); iter.Next() {
+		k, _ := iter.Deref()
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		c.indexes = append(c.indexes, string(k[len(prefix):]))
+	}
+}
+
+// the names of the collections of kv that have documents or indexes, sorted
+func Collections(kv *db.KV) ([]string, error) {
+	if kv.Comparator.Name != db.COMPARE_BYTES.Name {
+		return nil, fmt.Errorf("docstore: the KV is ordered by %q, collections need %q", kv.Comparator.Name, db.COMPARE_BYTES.Name)
+	}
+	seen := map[string]bool{}
+	for _, kind := range []string{"seq", "idxdef"} {
+		prefix := key(kind, "")
+		for iter := kv.Seek(prefix, db.CMP_GE); iter.Valid(); iter.Next() {
+			k, _ := iter.Deref()
+			if !bytes.HasPrefix(k, prefix) {
+				break
+			}
+			name, _, _ := strings.Cut(string(k[len(prefix):]), "\x00")
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c *Collection) Indexes() []string {
+	c.loadIndexes(c.kv)
+	return append([]string(nil), c.indexes...)
+}
+
+// run fn in a transaction with the index definitions of the transaction, commit if it succeeds
+func (c *Collection) update(fn func(tx *db.KVTX) error) error {
+	tx, err := c.kv.Begin()
+	if err != nil {
+		return err
+	}
+	c.loadIndexes(tx)
+	if err := fn(tx); err != nil {
+		tx.Abort()
+		return err
+	}
+	return tx.Commit()
+}
+
+// add or remove the index entries of a document
+func (c *Collection) updateIndexes(tx *db.KVTX, id uint64, doc map[string]any, add bool) error {
+	for _, path := range c.indexes {
+		value, exists := lookup(doc, path)
+		if !exists {
+			continue
+		}
+		k, ok := encodeValue(c.indexPrefix(path), value)
+		if !ok {
+			continue // objects and arrays are not indexed
+		}
+		k = binary.BigEndian.AppendUint64(k, id)
+		if add {
+			if err := tx.Set(k, nil); err != nil {
+				return fmt.Errorf("index %s: %w", path, err)
+			}
+		} else if _, err := tx.Del(k); err != nil {
+			return fmt.Errorf("index %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// store a document and return its id, the id is also added to the document as "_id"
+func (c *Collection) Insert(doc map[string]any) (uint64, error) {
+	normalized, err := normalize(doc)
+	if err != nil {
+		return 0, fmt.Errorf("document is not valid JSON: %w", err)
+	}
+	stored, ok := normalized.(map[string]any)
+	if !ok {
+		return 0, errors.New("document should be a JSON object")
+	}
+
+	var id uint64
+	err = c.update(func(tx *db.KVTX) error {
+		seqKey := key("seq", c.name)
+		id = 1
+		if val, ok := tx.Get(seqKey); ok {
+			id = binary.BigEndian.Uint64(val)
+		}
+		if err := tx.Set(seqKey, binary.BigEndian.AppendUint64(nil, id+1)); err != nil {
+			return err
+		}
+
+		stored[ID_FIELD] = float64(id)
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		if err := tx.Set(c.docKey(id), data); err != nil {
+			return fmt.Errorf("document too big: %w", err)
+		}
+		return c.updateIndexes(tx, id, stored, true)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (c *Collection) Get(id uint64) (map[string]any, bool, error) {
+	data, ok := c.kv.Get(c.docKey(id))
+	if !ok {
+		return nil, false, nil
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, false, fmt.Errorf("document %d is corrupted: %w", id, err)
+	}
+	return doc, true, nil
+}
+
+func (c *Collection) Delete(id uint64) (bool, error) {
+	doc, ok, err := c.Get(id)
+	if err != nil || !ok {
+		return false, err
+	}
+	err = c.update(func(tx *db.KVTX) error {
+		if _, err := tx.Del(c.docKey(id)); err != nil {
+			return err
+		}
+		return c.updateIndexes(tx, id, doc, false)
+	})
+	return err == nil, err
+}
+
+// index a path and add the existing documents to it
+func (c *Collection) CreateIndex(path string) error {
+	if err := checkName(path); err != nil {
+		return err
+	}
+	c.loadIndexes(c.kv)
+	for _, p := range c.indexes {
+		if p == path {
+			return nil
+		}
+	}
+
+	err := c.update(func(tx *db.KVTX) error {
+		if err := tx.Set(key("idxdef", c.name, path), nil); err != nil {
+			return err
+		}
+		// only the new index is filled
+		backfill := &Collection{kv: c.kv, name: c.name, indexes: []string{path}}
+		return c.scanDocs(func(id uint64, doc map[string]any) error {
+			return backfill.updateIndexes(tx, id, doc, true)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	c.indexes = append(c.indexes, path)
+	return nil
+}
+
+// call fn for every document in id order
+func (c *Collection) scanDocs(fn func(id uint64, doc map[string]any) error) error {
+	prefix := key("doc", c.name, "")
+	for iter := c.kv.Seek(prefix, db.CMP_GE); iter.Valid(); iter.Next() {
+		k, data := iter.Deref()
+		if !bytes.HasPrefix(k, prefix) {
+			break
+		}
+		var doc map[string]any
+		id := binary.BigEndian.Uint64(k[len(prefix):])
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("document %d is corrupted: %w", id, err)
+		}
+		if err := fn(id, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pick the indexed path with a condition that can narrow down the scan
+func (c *Collection) plan(filter compiledFilter) (path string, start, end []byte, ok bool) {
+	for _, p := range c.indexes {
+		conds, filtered := filter[p]
+		if !filtered {
+			continue
+		}
+		if start, end, ok := indexRange(conds); ok {
+			return p, start, end, true
+		}
+	}
+	return "", nil, nil, false
+}
+
+// ids of the index entries in [start, end) for a path
+func (c *Collection) scanIndex(path string, start, end []byte) []uint64 {
+	prefix := c.indexPrefix(path)
+	from := append(bytes.Clone(prefix), start...)
+	to := prefixEnd(prefix)
+	if end != nil {
+		to = append(bytes.Clone(prefix), end...)
+	}
+
+	var ids []uint64
+	for iter := c.kv.Seek(from, db.CMP_GE); iter.Valid(); iter.Next() {
+		k, _ := iter.Deref()
+		if bytes.Compare(k, to) >= 0 {
+			break
+		}
+		ids = append(ids, binary.BigEndian.Uint64(k[len(k)-8:]))
+	}
+	return ids
+}
+
+// return the documents matching the filter, see filter.go for the syntax.
+// Documents come back in id order.
+func (c *Collection) Find(filter map[string]any) ([]map[string]any, error) {
+	compiled, err := compileFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := []map[string]any{}
+	c.loadIndexes(c.kv)
+	path, start, end, useIndex := c.plan(compiled)
+	if !useIndex {
+		err := c.scanDocs(func(id uint64, doc map[string]any) error {
+			if compiled.match(doc) {
+				docs = append(docs, doc)
+			}
+			return nil
+		})
+		return docs, err
+	}
+
+	ids := c.scanIndex(path, start, end)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		doc, ok, err := c.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		// the index only narrows down the candidates, the other conditions still apply
+		if ok && compiled.match(doc) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
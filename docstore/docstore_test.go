@@ -0,0 +1,276 @@
+package docstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"building-a-db/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Open a collection in a fresh database
+func openCollection(t *testing.T, name string) (*Collection, *db.KV) {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	t.Cleanup(func() { kv.Close() })
+	c, err := Open(kv, name)
+	assert.NoError(t, err)
+	return c, kv
+}
+
+// Helper: Insert people with nested user.age
+func insertPeople(t *testing.T, c *Collection) {
+	people := []map[string]any{
+		{"name": "alice", "user": map[string]any{"age": 25}},
+		{"name": "bob", "user": map[string]any{"age": 35}},
+		{"name": "carol", "user": map[string]any{"age": 45}},
+		{"name": "dave", "user": map[string]any{"age": "unknown"}},
+		{"name": "erin"},
+		{"name": "frank", "user": map[string]any{"age": 35}},
+	}
+	for _, p := range people {
+		_, err := c.Insert(p)
+		assert.NoError(t, err)
+	}
+}
+
+func names(docs []map[string]any) []string {
+	var out []string
+	for _, d := range docs {
+		out = append(out, d["name"].(string))
+	}
+	return out
+}
+
+func TestCollection(t *testing.T) {
+	t.Run("Insert assigns increasing ids", func(t *testing.T) {
+		c, _ := openCollection(t, "people")
+
+		id1, err := c.Insert(map[string]any{"name": "alice"})
+		assert.NoError(t, err)
+		id2, err := c.Insert(map[string]any{"name": "bob"})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), id1)
+		assert.Equal(t, uint64(2), id2)
+
+		doc, ok, err := c.Get(id2)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "bob", doc["name"])
+		assert.Equal(t, float64(2), doc[ID_FIELD])
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		c, _ := openCollection(t, "people")
+		id, _ := c.Insert(map[string]any{"name": "alice"})
+
+		deleted, err := c.Delete(id)
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		_, ok, _ := c.Get(id)
+		assert.False(t, ok)
+
+		deleted, err = c.Delete(id)
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("Collections are separate", func(t *testing.T) {
+		a, kv := openCollection(t, "a")
+		b, err := Open(kv, "ab")
+		assert.NoError(t, err)
+
+		a.Insert(map[string]any{"name": "in a"})
+		b.Insert(map[string]any{"name": "in ab"})
+
+		docs, _ := a.Find(nil)
+		assert.Equal(t, []string{"in a"}, names(docs))
+		docs, _ = b.Find(nil)
+		assert.Equal(t, []string{"in ab"}, names(docs))
+	})
+
+	t.Run("List the collections", func(t *testing.T) {
+		a, kv := openCollection(t, "people")
+		list, err := Collections(kv)
+		assert.NoError(t, err)
+		assert.Empty(t, list, "Nothing inserted yet")
+
+		a.Insert(map[string]any{"name": "x"})
+		b, _ := Open(kv, "cars")
+		b.CreateIndex("brand")
+		list, err = Collections(kv)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"cars", "people"}, list)
+	})
+
+	// Edge cases
+	t.Run("Bad names", func(t *testing.T) {
+		_, kv := openCollection(t, "people")
+
+		_, err := Open(kv, "")
+		assert.ErrorIs(t, err, ErrBadName)
+		_, err = Open(kv, "a\x00b")
+		assert.ErrorIs(t, err, ErrBadName)
+	})
+
+	t.Run("Only in byte order", func(t *testing.T) {
+		kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db"), Comparator: db.COMPARE_NUMERIC}
+		assert.NoError(t, kv.Open())
+		defer kv.Close()
+		_, err := Open(kv, "people")
+		assert.ErrorContains(t, err, `ordered by "numeric"`)
+	})
+
+	t.Run("Document too big", func(t *testing.T) {
+		c, _ := openCollection(t, "people")
+
+		_, err := c.Insert(map[string]any{"blob": string(make([]byte, db.BTREE_MAX_VAL_SIZE))})
+		assert.Error(t, err)
+		docs, _ := c.Find(nil)
+		assert.Empty(t, docs, "Failed insert leaves nothing behind")
+	})
+}
+
+func TestFind(t *testing.T) {
+	// Run the same queries with and without an index, the results should not change
+	for _, indexed := range []bool{false, true} {
+		c, _ := openCollection(t, "people")
+		insertPeople(t, c)
+		if indexed {
+			assert.NoError(t, c.CreateIndex("user.age"))
+		}
+
+		cases := []struct {
+			filter   map[string]any
+			expected []string
+		}{
+			{nil, []string{"alice", "bob", "carol", "dave", "erin", "frank"}},
+			{map[string]any{"name": "bob"}, []string{"bob"}},
+			{map[string]any{"user.age": 35}, []string{"bob", "frank"}},
+			{map[string]any{"user.age": map[string]any{"$gt": 30}}, []string{"bob", "carol", "frank"}},
+			{map[string]any{"user.age": map[string]any{"$gte": 35, "$lt": 45}}, []string{"bob", "frank"}},
+			{map[string]any{"user.age": map[string]any{"$lte": 35}}, []string{"alice", "bob", "frank"}},
+			{map[string]any{"user.age": map[string]any{"$gt": 25, "$lte": 25}}, nil},
+			{map[string]any{"user.age": map[string]any{"$ne": 35}}, []string{"alice", "carol", "dave", "erin"}},
+			{map[string]any{"user.age": "unknown"}, []string{"dave"}},
+			{map[string]any{"user.age": map[string]any{"$gt": 30}, "name": "frank"}, []string{"frank"}},
+			{map[string]any{"user.age": map[string]any{"$gt": "a"}}, []string{"dave"}},
+			{map[string]any{"missing.path": 1}, nil},
+		}
+		for _, tc := range cases {
+			docs, err := c.Find(tc.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, names(docs), "indexed=%v filter=%v", indexed, tc.filter)
+		}
+	}
+
+	t.Run("Bad filters", func(t *testing.T) {
+		c, _ := openCollection(t, "people")
+
+		_, err := c.Find(map[string]any{"a": map[string]any{"$regex": "x"}})
+		assert.Error(t, err)
+		_, err = c.Find(map[string]any{"a": map[string]any{"$gt": []int{1}}})
+		assert.Error(t, err)
+		_, err = c.Find(map[string]any{"a": []int{1}})
+		assert.Error(t, err)
+	})
+}
+
+func TestIndexes(t *testing.T) {
+	t.Run("Index is kept in sync", func(t *testing.T) {
+		c, kv := openCollection(t, "people")
+		assert.NoError(t, c.CreateIndex("user.age"))
+		insertPeople(t, c)
+
+		docs, _ := c.Find(map[string]any{"user.age": 35})
+		assert.Equal(t, []string{"bob", "frank"}, names(docs))
+
+		c.Delete(2) // bob
+		docs, _ = c.Find(map[string]any{"user.age": 35})
+		assert.Equal(t, []string{"frank"}, names(docs))
+
+		// 3 numeric ages and 1 string age are left after the delete
+		prefix := c.indexPrefix("user.age")
+		n := 0
+		for iter := kv.Seek(prefix, db.CMP_GE); iter.Valid(); iter.Next() {
+			k, _ := iter.Deref()
+			if !bytes.HasPrefix(k, prefix) {
+				break
+			}
+			n++
+		}
+		assert.Equal(t, 4, n)
+	})
+
+	t.Run("Index definitions survive reopening", func(t *testing.T) {
+		c, kv := openCollection(t, "people")
+		assert.NoError(t, c.CreateIndex("user.age"))
+		assert.NoError(t, c.CreateIndex("name"))
+		assert.NoError(t, c.CreateIndex("name"), "Creating an index twice is a no-op")
+
+		reopened, err := Open(kv, "people")
+		assert.NoError(t, err)
+		indexes := reopened.Indexes()
+		sort.Strings(indexes)
+		assert.Equal(t, []string{"name", "user.age"}, indexes)
+	})
+
+	t.Run("An index created through another handle is kept in sync", func(t *testing.T) {
+		c, kv := openCollection(t, "people")
+		other, err := Open(kv, "people")
+		assert.NoError(t, err)
+		assert.NoError(t, other.CreateIndex("name"))
+
+		insertPeople(t, c)
+		c.Delete(2) // bob
+		docs, _ := other.Find(map[string]any{"name": "alice"})
+		assert.Equal(t, []string{"alice"}, names(docs))
+		docs, _ = other.Find(map[string]any{"name": "bob"})
+		assert.Empty(t, docs)
+		assert.Equal(t, []string{"name"}, c.Indexes())
+		assert.NoError(t, c.CreateIndex("name"), "Already created by the other handle")
+	})
+
+	t.Run("Query planner uses the index", func(t *testing.T) {
+		c, _ := openCollection(t, "people")
+		insertPeople(t, c)
+
+		filter, _ := compileFilter(map[string]any{"user.age": map[string]any{"$gt": 30}})
+		_, _, _, ok := c.plan(filter)
+		assert.False(t, ok, "No index yet")
+
+		c.CreateIndex("user.age")
+		path, _, _, ok := c.plan(filter)
+		assert.True(t, ok)
+		assert.Equal(t, "user.age", path)
+
+		filter, _ = compileFilter(map[string]any{"user.age": map[string]any{"$ne": 30}})
+		_, _, _, ok = c.plan(filter)
+		assert.False(t, ok, "$ne can't use the index")
+	})
+}
+
+func TestEncodeValue(t *testing.T) {
+	// Sorted by the expected order
+	values := []any{
+		nil,
+		false, true,
+		-1e300, -2.5, -1.0, 0.0, 1e-300, 1.0, 2.5, 1e300,
+		"", "\x00", "\x01", "a", "a\x00", "ab", "b",
+	}
+	var encoded [][]byte
+	for _, v := range values {
+		enc, ok := encodeValue(nil, v)
+		assert.True(t, ok)
+		encoded = append(encoded, enc)
+	}
+	for i := 1; i < len(encoded); i++ {
+		assert.True(t, bytes.Compare(encoded[i-1], encoded[i]) < 0, "%v should sort before %v", values[i-1], values[i])
+	}
+
+	_, ok := encodeValue(nil, map[string]any{})
+	assert.False(t, ok, "Objects are not indexed")
+}
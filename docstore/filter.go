@@ -0,0 +1,185 @@
+package docstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Query filters
+
+/*
+*
+A filter maps a dotted path to a condition, all the conditions have to hold:
+
+	{"name": "bob"}                           equality
+	{"user.age": {"$gt": 30, "$lte": 60}}     operators on a nested field
+
+Operators: $eq $ne $gt $gte $lt $lte
+Comparisons only match values of the same type (number, string, bool, null),
+like {"age": {"$gt": 30}} does not match {"age": "40"}.
+*/
+
+// a single condition on a path
+type cond struct {
+	op    string
+	value any
+}
+
+type compiledFilter map[string][]cond
+
+var operators = map[string]bool{"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true}
+
+// turn Go values (int, structs...) into what encoding/json gives us back for a document
+func normalize(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func compileFilter(filter map[string]any) (compiledFilter, error) {
+	normalized, err := normalize(filter)
+	if err != nil {
+		return nil, fmt.Errorf("bad filter: %w", err)
+	}
+
+	compiled := compiledFilter{}
+	fields, _ := normalized.(map[string]any) // a nil filter matches everything
+	for path, raw := range fields {
+		if path == "" {
+			return nil, fmt.Errorf("bad filter: empty path")
+		}
+		ops, isOps := raw.(map[string]any)
+		if !isOps || len(ops) == 0 || !strings.HasPrefix(firstKey(ops), "$") {
+			if !isScalar(raw) {
+				return nil, fmt.Errorf("bad filter: %s should be a number, string, bool or null, objects and arrays can't be compared", path)
+			}
+			compiled[path] = []cond{{"$eq", raw}}
+			continue
+		}
+		for op, value := range ops {
+			if !operators[op] {
+				return nil, fmt.Errorf("bad filter: unknown operator %s", op)
+			}
+			if !isScalar(value) {
+				return nil, fmt.Errorf("bad filter: %s %s should compare to a number, string, bool or null", path, op)
+			}
+			compiled[path] = append(compiled[path], cond{op, value})
+		}
+	}
+	return compiled, nil
+}
+
+func firstKey(m map[string]any) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
+
+func isScalar(v any) bool {
+	switch v.(type) {
+	case nil, bool, float64, string:
+		return true
+	default:
+		return false
+	}
+}
+
+// find the value at a dotted path like "user.age"
+func lookup(doc map[string]any, path string) (any, bool) {
+	var cur any = doc
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		cur, ok = obj[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// compare 2 scalars of the same type, ok is false when they can't be compared
+func compare(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	case bool:
+		if b, same := b.(bool); same {
+			switch {
+			case a == b:
+				return 0, true
+			case !a:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case float64:
+		if b, same := b.(float64); same {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	case string:
+		if b, same := b.(string); same {
+			return strings.Compare(a, b), true
+		}
+	}
+	return 0, false
+}
+
+func (c cond) match(value any, exists bool) bool {
+	if !exists {
+		return c.op == "$ne"
+	}
+	r, ok := compare(value, c.value)
+	if c.op == "$ne" {
+		return !ok || r != 0
+	}
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "$eq":
+		return r == 0
+	case "$gt":
+		return r > 0
+	case "$gte":
+		return r >= 0
+	case "$lt":
+		return r < 0
+	case "$lte":
+		return r <= 0
+	default:
+		panic("bad operator!")
+	}
+}
+
+func (f compiledFilter) match(doc map[string]any) bool {
+	for path, conds := range f {
+		value, exists := lookup(doc, path)
+		for _, c := range conds {
+			if !c.match(value, exists) {
+				return false
+			}
+		}
+	}
+	return true
+}
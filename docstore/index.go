@@ -0,0 +1,131 @@
+package docstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Order preserving encoding of index values
+
+/*
+*
+Index entries are KV keys, so bytes.Compare on the encoded values has to give the same
+order as comparing the values. Each value starts with a type tag so that types don't mix:
+
+	null:   0x01
+	bool:   0x02 then 0x00 or 0x01
+	number: 0x03 then 8 bytes, the float64 bits with the sign bit flipped for positive
+	        numbers and all bits flipped for negative ones, big endian
+	string: 0x04 then the bytes with 0x00 escaped as 0x01 0x01 and 0x01 as 0x01 0x02,
+	        terminated by 0x00 so that "a" sorts before "ab"
+*/
+const (
+	TAG_NULL   = 0x01
+	TAG_BOOL   = 0x02
+	TAG_NUMBER = 0x03
+	TAG_STRING = 0x04
+)
+
+// returns false for values that are not indexed (objects and arrays)
+func encodeValue(out []byte, v any) ([]byte, bool) {
+	switch v := v.(type) {
+	case nil:
+		return append(out, TAG_NULL), true
+	case bool:
+		if v {
+			return append(out, TAG_BOOL, 1), true
+		}
+		return append(out, TAG_BOOL, 0), true
+	case float64:
+		bits := math.Float64bits(v)
+		if v < 0 || (v == 0 && math.Signbit(v)) {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		if v == 0 {
+			bits = 1 << 63 // -0 and +0 are the same number
+		}
+		return binary.BigEndian.AppendUint64(append(out, TAG_NUMBER), bits), true
+	case string:
+		out = append(out, TAG_STRING)
+		for _, c := range []byte(v) {
+			switch c {
+			case 0x00:
+				out = append(out, 0x01, 0x01)
+			case 0x01:
+				out = append(out, 0x01, 0x02)
+			default:
+				out = append(out, c)
+			}
+		}
+		return append(out, 0x00), true
+	default:
+		return out, false
+	}
+}
+
+// the smallest key that is bigger than every key starting with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil // no upper bound
+}
+
+// the [start, end) range of index keys (after the index prefix) that can match the conditions,
+// ok is false when the conditions can't use the index and we need a full scan
+func indexRange(conds []cond) (start []byte, end []byte, ok bool) {
+	var tag byte
+	for _, c := range conds {
+		if c.op == "$ne" {
+			return nil, nil, false
+		}
+		enc, _ := encodeValue(nil, c.value)
+		if tag != 0 && enc[0] != tag {
+			return nil, nil, false // conditions on different types never all match
+		}
+		tag = enc[0]
+
+		// the id follows the value, so keys for the value v all start with enc(v)
+		switch c.op {
+		case "$eq":
+			start, end = maxBytes(start, enc), minBytes(end, prefixEnd(enc))
+		case "$gt":
+			start = maxBytes(start, prefixEnd(enc))
+		case "$gte":
+			start = maxBytes(start, enc)
+		case "$lt":
+			end = minBytes(end, enc)
+		case "$lte":
+			end = minBytes(end, prefixEnd(enc))
+		}
+	}
+	if tag == 0 {
+		return nil, nil, false
+	}
+	// stay within the type
+	start = maxBytes(start, []byte{tag})
+	end = minBytes(end, []byte{tag + 1})
+	return start, end, true
+}
+
+func maxBytes(a, b []byte) []byte {
+	if a == nil || bytes.Compare(b, a) > 0 {
+		return b
+	}
+	return a
+}
+
+// nil means no upper bound
+func minBytes(a, b []byte) []byte {
+	if a == nil || (b != nil && bytes.Compare(b, a) < 0) {
+		return b
+	}
+	return a
+}
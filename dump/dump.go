@@ -1,24 +1,32 @@
-package main
+package dump
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"strings"
 )
 
+// DumpStruct prints the exported fields of v, nested structs, slices and maps are indented
 func DumpStruct(v interface{}) {
-	dumpStruct(reflect.ValueOf(v), 0)
+	FdumpStruct(os.Stdout, v)
 }
 
-func dumpStruct(val reflect.Value, indent int) {
+// FdumpStruct is DumpStruct writing to w
+func FdumpStruct(w io.Writer, v interface{}) {
+	dumpStruct(w, reflect.ValueOf(v), 0)
+}
+
+func dumpStruct(w io.Writer, val reflect.Value, indent int) {
 	if !val.IsValid() {
-		fmt.Println(strings.Repeat("  ", indent) + "<invalid value>")
+		fmt.Fprintln(w, strings.Repeat("  ", indent)+"<invalid value>")
 		return
 	}
 
 	if val.Kind() == reflect.Pointer || val.Kind() == reflect.Interface {
 		if val.IsNil() {
-			fmt.Println(strings.Repeat("  ", indent) + "<nil>")
+			fmt.Fprintln(w, strings.Repeat("  ", indent)+"<nil>")
 			return
 		}
 		val = val.Elem()
@@ -35,21 +43,21 @@ func dumpStruct(val reflect.Value, indent int) {
 			}
 			prefix := fmt.Sprintf("%s%s (%s): ", strings.Repeat("  ", indent), fieldType.Name, fieldVal.Type())
 			if isSimpleKind(fieldVal.Kind()) {
-				fmt.Printf("%s%v\n", prefix, fieldVal.Interface())
+				fmt.Fprintf(w, "%s%v\n", prefix, fieldVal.Interface())
 			} else {
-				fmt.Println(prefix)
-				dumpStruct(fieldVal, indent+1)
+				fmt.Fprintln(w, prefix)
+				dumpStruct(w, fieldVal, indent+1)
 			}
 		}
 	case reflect.Slice, reflect.Array:
 		for i := 0; i < val.Len(); i++ {
-			fmt.Printf("%s[%d]: ", strings.Repeat("  ", indent), i)
+			fmt.Fprintf(w, "%s[%d]: ", strings.Repeat("  ", indent), i)
 			elem := val.Index(i)
 			if isSimpleKind(elem.Kind()) {
-				fmt.Printf("%v\n", elem.Interface())
+				fmt.Fprintf(w, "%v\n", elem.Interface())
 			} else {
-				fmt.Println()
-				dumpStruct(elem, indent+1)
+				fmt.Fprintln(w)
+				dumpStruct(w, elem, indent+1)
 			}
 		}
 	case reflect.Map:
@@ -57,16 +65,16 @@ func dumpStruct(val reflect.Value, indent int) {
 		for iter.Next() {
 			key := iter.Key()
 			elem := iter.Value()
-			fmt.Printf("%s%v: ", strings.Repeat("  ", indent), key.Interface())
+			fmt.Fprintf(w, "%s%v: ", strings.Repeat("  ", indent), key.Interface())
 			if isSimpleKind(elem.Kind()) {
-				fmt.Printf("%v\n", elem.Interface())
+				fmt.Fprintf(w, "%v\n", elem.Interface())
 			} else {
-				fmt.Println()
-				dumpStruct(elem, indent+1)
+				fmt.Fprintln(w)
+				dumpStruct(w, elem, indent+1)
 			}
 		}
 	default:
-		fmt.Printf("%s%v\n", strings.Repeat("  ", indent), val.Interface())
+		fmt.Fprintf(w, "%s%v\n", strings.Repeat("  ", indent), val.Interface())
 	}
 }
 
@@ -0,0 +1,302 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"building-a-db/db"
+)
+
+// HTTP/JSON API in front of db.KV
+
+/*
+*
+	GET    /kv/{key}                                  {"key": ..., "value": ...} or 404
+	PUT    /kv/{key}                                  the request body is the value
+	DELETE /kv/{key}                                  {"deleted": true|false}
+	GET    /scan?start=&end=&limit=&reverse=          keys in [start, end), backwards if reverse=true
+	POST   /batch                                     run several operations in one transaction
+
+Keys in the URL are the raw bytes of the (unescaped) path, keys and values in JSON bodies are
+base64 encoded so that binary data round trips.
+
+db.KV only allows a single transaction at a time, so requests are serialised with a mutex.
+*/
+
+const DEFAULT_SCAN_LIMIT = 100
+const MAX_SCAN_LIMIT = 1000
+
+// requests bigger than this are rejected, a batch of max size values fits comfortably
+const MAX_BODY_SIZE = 4 << 20
+
+type KVPair struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type ScanResult struct {
+	Items []KVPair `json:"items"`
+	// there are more keys in the range after the last item, scan again from there
+	More bool `json:"more"`
+}
+
+type BatchOp struct {
+	Op    string `json:"op"` // get, set or del
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type BatchRequest struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+type BatchOpResult struct {
+	Found   *bool  `json:"found,omitempty"`   // get
+	Value   []byte `json:"value,omitempty"`   // get
+	Deleted *bool  `json:"deleted,omitempty"` // del
+}
+
+type BatchResponse struct {
+	Results []BatchOpResult `json:"results"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// index of the batch operation that failed
+	Op *int `json:"op,omitempty"`
+}
+
+type handler struct {
+	db  *db.KV
+	mu  sync.Mutex // protects db
+	mux *http.ServeMux
+}
+
+func NewHandler(kv *db.KV) http.Handler {
+	h := &handler{db: kv, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /kv/{key...}", h.get)
+	h.mux.HandleFunc("PUT /kv/{key...}", h.put)
+	h.mux.HandleFunc("DELETE /kv/{key...}", h.delete)
+	h.mux.HandleFunc("GET /scan", h.scan)
+	h.mux.HandleFunc("POST /batch", h.batch)
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// run fn holding the lock, the response is written after it so a slow client doesn't hold it
+func (h *handler) locked(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fn()
+}
+
+// run fn in a transaction holding the lock, commit if it succeeds. A panic aborts the
+// transaction before it goes on, else the KV would refuse every later Begin
+func (h *handler) update(fn func(tx *db.KVTX) error) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Abort()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.Abort()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	key := []byte(r.PathValue("key"))
+
+	var val []byte
+	var ok bool
+	h.locked(func() { val, ok = h.db.Get(key) })
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("key not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, KVPair{Key: key, Value: val})
+}
+
+func (h *handler) put(w http.ResponseWriter, r *http.Request) {
+	key := []byte(r.PathValue("key"))
+	val, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_BODY_SIZE))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	err = h.update(func(tx *db.KVTX) error { return tx.Set(key, val) })
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) delete(w http.ResponseWriter, r *http.Request) {
+	key := []byte(r.PathValue("key"))
+
+	var deleted bool
+	err := h.update(func(tx *db.KVTX) (err error) {
+		deleted, err = tx.Del(key)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"deleted": deleted})
+}
+
+// parse the scan parameters, end is nil when there is no upper bound
+func scanParams(r *http.Request) (start, end []byte, limit int, reverse bool, err error) {
+	q := r.URL.Query()
+	start = []byte(q.Get("start"))
+	if q.Has("end") {
+		end = []byte(q.Get("end"))
+	}
+
+	limit = DEFAULT_SCAN_LIMIT
+	if s := q.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > MAX_SCAN_LIMIT {
+			return nil, nil, 0, false, fmt.Errorf("limit should be between 1 and %d", MAX_SCAN_LIMIT)
+		}
+	}
+
+	if s := q.Get("reverse"); s != "" {
+		reverse, err = strconv.ParseBool(s)
+		if err != nil {
+			return nil, nil, 0, false, errors.New("reverse should be true or false")
+		}
+	}
+	return start, end, limit, reverse, nil
+}
+
+func (h *handler) scan(w http.ResponseWriter, r *http.Request) {
+	start, end, limit, reverse, err := scanParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// walk [start, end) forwards from start, or backwards from just before end
+	var iter *db.BIter
+	if !reverse {
+		iter = h.db.Seek(start, db.CMP_GE)
+	} else if end != nil {
+		iter = h.db.Seek(end, db.CMP_LT)
+	} else {
+		iter = h.db.SeekLast()
+	}
+
+	result := ScanResult{Items: []KVPair{}}
+	for ; iter.Valid(); advance(iter, reverse) {
+		key, val := iter.Deref()
+		if !reverse && end != nil && h.db.Compare(key, end) >= 0 {
+			break
+		}
+		if reverse && h.db.Compare(key, start) < 0 {
+			break
+		}
+		if len(result.Items) == limit {
+			result.More = true
+			break
+		}
+		result.Items = append(result.Items, KVPair{Key: bytes.Clone(key), Value: bytes.Clone(val)})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func advance(iter *db.BIter, reverse bool) {
+	if reverse {
+		iter.Prev()
+	} else {
+		iter.Next()
+	}
+}
+
+// run the operations in one transaction, any failure aborts the whole batch
+func (h *handler) batch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_BODY_SIZE))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad batch request: %w", err))
+		return
+	}
+
+	resp := BatchResponse{Results: make([]BatchOpResult, len(req.Ops))}
+	failed := -1
+	err := h.update(func(tx *db.KVTX) error {
+		for i, op := range req.Ops {
+			if err := runOp(tx, op, &resp.Results[i]); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return nil
+	})
+	if failed >= 0 {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error(), Op: &failed})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func runOp(tx *db.KVTX, op BatchOp, result *BatchOpResult) error {
+	switch op.Op {
+	case "get":
+		val, ok := tx.Get(op.Key)
+		result.Found = &ok
+		if ok {
+			result.Value = bytes.Clone(val)
+		}
+	case "set":
+		return tx.Set(op.Key, op.Value)
+	case "del":
+		deleted, err := tx.Del(op.Key)
+		if err != nil {
+			return err
+		}
+		result.Deleted = &deleted
+	default:
+		return fmt.Errorf("unknown op %q, expected get, set or del", op.Op)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"building-a-db/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Start a test server backed by a fresh database
+func startServer(t *testing.T) (*httptest.Server, *db.KV) {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	srv := httptest.NewServer(NewHandler(kv))
+	t.Cleanup(func() {
+		srv.Close()
+		kv.Close()
+	})
+	return srv, kv
+}
+
+// Helper: Send a request and decode the JSON response into out (if not nil)
+func call(t *testing.T, method, url, body string, out any) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	if out != nil {
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(out))
+	} else {
+		io.Copy(io.Discard, res.Body)
+	}
+	return res.StatusCode
+}
+
+func keys(result ScanResult) []string {
+	var out []string
+	for _, item := range result.Items {
+		out = append(out, string(item.Key))
+	}
+	return out
+}
+
+func TestKVEndpoints(t *testing.T) {
+	t.Run("Put, get, delete", func(t *testing.T) {
+		srv, _ := startServer(t)
+
+		assert.Equal(t, http.StatusNoContent, call(t, "PUT", srv.URL+"/kv/hello", "world", nil))
+
+		var pair KVPair
+		assert.Equal(t, http.StatusOK, call(t, "GET", srv.URL+"/kv/hello", "", &pair))
+		assert.Equal(t, []byte("hello"), pair.Key)
+		assert.Equal(t, []byte("world"), pair.Value)
+
+		var del map[string]bool
+		assert.Equal(t, http.StatusOK, call(t, "DELETE", srv.URL+"/kv/hello", "", &del))
+		assert.True(t, del["deleted"])
+
+		assert.Equal(t, http.StatusNotFound, call(t, "GET", srv.URL+"/kv/hello", "", nil))
+		call(t, "DELETE", srv.URL+"/kv/hello", "", &del)
+		assert.False(t, del["deleted"])
+	})
+
+	t.Run("Binary values are base64 in JSON", func(t *testing.T) {
+		srv, _ := startServer(t)
+		call(t, "PUT", srv.URL+"/kv/bin", "\x00\xff\x01", nil)
+
+		var raw map[string]string
+		call(t, "GET", srv.URL+"/kv/bin", "", &raw)
+		assert.Equal(t, "AP8B", raw["value"])
+	})
+
+	t.Run("Keys with slashes and escapes", func(t *testing.T) {
+		srv, kv := startServer(t)
+
+		assert.Equal(t, http.StatusNoContent, call(t, "PUT", srv.URL+"/kv/users/1%20a", "x", nil))
+		_, ok := kv.Get([]byte("users/1 a"))
+		assert.True(t, ok)
+	})
+
+	// Edge cases
+	t.Run("Invalid keys", func(t *testing.T) {
+		srv, _ := startServer(t)
+
+		assert.Equal(t, http.StatusBadRequest, call(t, "PUT", srv.URL+"/kv/", "x", nil))
+		longKey := strings.Repeat("k", db.BTREE_MAX_KEY_SIZE+1)
+		assert.Equal(t, http.StatusBadRequest, call(t, "PUT", srv.URL+"/kv/"+longKey, "x", nil))
+		assert.Equal(t, http.StatusNotFound, call(t, "GET", srv.URL+"/kv/", "", nil))
+	})
+
+	t.Run("Wrong method", func(t *testing.T) {
+		srv, _ := startServer(t)
+		assert.Equal(t, http.StatusMethodNotAllowed, call(t, "POST", srv.URL+"/kv/a", "", nil))
+	})
+}
+
+func TestScanEndpoint(t *testing.T) {
+	srv, kv := startServer(t)
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, kv.Set([]byte(fmt.Sprintf("k%02d", i)), []byte("v")))
+	}
+
+	t.Run("Range", func(t *testing.T) {
+		var result ScanResult
+		assert.Equal(t, http.StatusOK, call(t, "GET", srv.URL+"/scan?start=k05&end=k08", "", &result))
+		assert.Equal(t, []string{"k05", "k06", "k07"}, keys(result))
+		assert.False(t, result.More)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		var result ScanResult
+		call(t, "GET", srv.URL+"/scan?start=k05&limit=2", "", &result)
+		assert.Equal(t, []string{"k05", "k06"}, keys(result))
+		assert.True(t, result.More)
+	})
+
+	t.Run("Reverse", func(t *testing.T) {
+		var result ScanResult
+		call(t, "GET", srv.URL+"/scan?start=k05&end=k08&reverse=true", "", &result)
+		assert.Equal(t, []string{"k07", "k06", "k05"}, keys(result))
+
+		call(t, "GET", srv.URL+"/scan?reverse=true&limit=2", "", &result)
+		assert.Equal(t, []string{"k19", "k18"}, keys(result))
+		assert.True(t, result.More)
+	})
+
+	t.Run("Whole database", func(t *testing.T) {
+		var result ScanResult
+		call(t, "GET", srv.URL+"/scan", "", &result)
+		assert.Len(t, result.Items, 20)
+	})
+
+	// Edge cases
+	t.Run("Empty range", func(t *testing.T) {
+		var result ScanResult
+		call(t, "GET", srv.URL+"/scan?start=x", "", &result)
+		assert.NotNil(t, result.Items)
+		assert.Empty(t, result.Items)
+	})
+
+	t.Run("Bad parameters", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call(t, "GET", srv.URL+"/scan?limit=0", "", nil))
+		assert.Equal(t, http.StatusBadRequest, call(t, "GET", srv.URL+"/scan?limit=abc", "", nil))
+		assert.Equal(t, http.StatusBadRequest, call(t, "GET", srv.URL+"/scan?reverse=maybe", "", nil))
+	})
+}
+
+func TestBatchEndpoint(t *testing.T) {
+	t.Run("Operations see each other", func(t *testing.T) {
+		srv, kv := startServer(t)
+		kv.Set([]byte("old"), []byte("1"))
+
+		// keys and values are base64: a=YQ== b=Yg== old=b2xk 1=MQ== 2=Mg==
+		body := `{"ops": [
+			{"op": "set", "key": "YQ==", "value": "MQ=="},
+			{"op": "get", "key": "YQ=="},
+			{"op": "del", "key": "b2xk"},
+			{"op": "get", "key": "Yg=="}
+		]}`
+		var resp BatchResponse
+		assert.Equal(t, http.StatusOK, call(t, "POST", srv.URL+"/batch", body, &resp))
+		assert.Len(t, resp.Results, 4)
+		assert.True(t, *resp.Results[1].Found)
+		assert.Equal(t, []byte("1"), resp.Results[1].Value)
+		assert.True(t, *resp.Results[2].Deleted)
+		assert.False(t, *resp.Results[3].Found)
+
+		_, ok := kv.Get([]byte("a"))
+		assert.True(t, ok)
+		_, ok = kv.Get([]byte("old"))
+		assert.False(t, ok)
+	})
+
+	// Edge cases
+	t.Run("Failure aborts the whole batch", func(t *testing.T) {
+		srv, kv := startServer(t)
+
+		body := `{"ops": [
+			{"op": "set", "key": "YQ==", "value": "MQ=="},
+			{"op": "set", "key": "", "value": "MQ=="}
+		]}`
+		var resp errorResponse
+		assert.Equal(t, http.StatusBadRequest, call(t, "POST", srv.URL+"/batch", body, &resp))
+		assert.Equal(t, "empty key", resp.Error)
+		assert.Equal(t, 1, *resp.Op)
+
+		_, ok := kv.Get([]byte("a"))
+		assert.False(t, ok, "First set should be rolled back")
+		assert.NoError(t, kv.Set([]byte("b"), []byte("2")), "Database is usable after the abort")
+	})
+
+	t.Run("A panic aborts the transaction", func(t *testing.T) {
+		_, kv := startServer(t)
+		h := NewHandler(kv).(*handler)
+
+		assert.Panics(t, func() {
+			h.update(func(tx *db.KVTX) error {
+				tx.Set([]byte("a"), []byte("1"))
+				panic("boom")
+			})
+		})
+		_, ok := kv.Get([]byte("a"))
+		assert.False(t, ok)
+		assert.NoError(t, h.update(func(tx *db.KVTX) error { return tx.Set([]byte("b"), []byte("2")) }), "The next transaction can begin")
+	})
+
+	t.Run("Bad requests", func(t *testing.T) {
+		srv, _ := startServer(t)
+
+		assert.Equal(t, http.StatusBadRequest, call(t, "POST", srv.URL+"/batch", `not json`, nil))
+		assert.Equal(t, http.StatusBadRequest, call(t, "POST", srv.URL+"/batch", `{"ops": [{"op": "frob", "key": "YQ=="}]}`, nil))
+		assert.Equal(t, http.StatusBadRequest, call(t, "POST", srv.URL+"/batch", `{"ops": [], "extra": 1}`, nil))
+		assert.Equal(t, http.StatusBadRequest, call(t, "POST", srv.URL+"/batch", `{"ops": [{"op": "get", "key": "!!"}]}`, nil))
+	})
+}
@@ -0,0 +1,29 @@
+package dbtest
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"building-a-db/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fixtures for the tests of the tools in cmd/ that work on database files
+
+// a closed database file with the keys key_0000, key_0001... up to n, all with the value val,
+// written in one transaction
+func NewFile(t *testing.T, n int, val []byte) string {
+	path := filepath.Join(t.TempDir(), "test.db")
+	kv := &db.KV{Path: path}
+	assert.NoError(t, kv.Open())
+	tx, err := kv.Begin()
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		assert.NoError(t, tx.Set([]byte(fmt.Sprintf("key_%04d", i)), val))
+	}
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, kv.Close())
+	return path
+}
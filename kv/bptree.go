@@ -0,0 +1,99 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"building-a-db/bptree"
+)
+
+// Adapter for bptree: int keys, string values, no deletes and a fixed capacity
+
+/*
+*
+bptree keys are ints, so Engine keys must be 8 bytes: the int in big endian with the sign
+bit flipped, which makes bytes.Compare agree with the int order. EncodeIntKey builds them.
+
+bptree.Insert adds duplicates, so Set updates the key when it is already there.
+The tree has 2 levels of at most bptree.MAX_SIZE nodes, Set fails once it is full.
+*/
+var ErrBadIntKey = errors.New("bptree keys are 8 byte encoded ints, see EncodeIntKey")
+
+func EncodeIntKey(k int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(k)^(1<<63))
+}
+
+func decodeIntKey(key []byte) (int, error) {
+	if len(key) != 8 {
+		return 0, ErrBadIntKey
+	}
+	return int(binary.BigEndian.Uint64(key) ^ (1 << 63)), nil
+}
+
+type bptreeEngine struct {
+	tree *bptree.BpTreeRootNode
+}
+
+func NewBpTree() Engine {
+	return &bptreeEngine{tree: bptree.NewBpTree()}
+}
+
+func (e *bptreeEngine) Get(key []byte) ([]byte, bool, error) {
+	k, err := decodeIntKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	// bptree returns an error for missing keys
+	val, err := e.tree.Get(k)
+	if err != nil {
+		return nil, false, nil
+	}
+	return []byte(val), true, nil
+}
+
+func (e *bptreeEngine) Set(key, val []byte) error {
+	k, err := decodeIntKey(key)
+	if err != nil {
+		return err
+	}
+	if _, err := e.tree.Get(k); err == nil {
+		return e.tree.Update(k, string(val))
+	}
+	return e.tree.Insert(k, string(val))
+}
+
+func (e *bptreeEngine) Delete(key []byte) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// leaves are visited through the internal nodes, they are in key order
+func (e *bptreeEngine) Scan(start, end []byte, fn func(key, val []byte) bool) error {
+	for _, inode := range e.tree.Children {
+		for _, leaf := range inode.Children {
+			key := EncodeIntKey(leaf.Key)
+			if bytes.Compare(key, start) < 0 {
+				continue
+			}
+			if end != nil && bytes.Compare(key, end) >= 0 {
+				return nil
+			}
+			if !fn(key, []byte(leaf.Value)) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (e *bptreeEngine) Close() error {
+	return nil
+}
+
+func (e *bptreeEngine) Stats() (Stats, error) {
+	n, err := countKeys(e)
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{Engine: "bptree", Keys: n, Extra: map[string]int64{"internal_nodes": int64(len(e.tree.Children))}}, nil
+}
@@ -0,0 +1,179 @@
+package kv
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+
+	"building-a-db/bitcask"
+	"building-a-db/db"
+	"building-a-db/lsm"
+	"building-a-db/temp"
+)
+
+// Adapters for the byte key engines
+
+// db.KV: the B+tree in a file, every update is its own transaction
+type dbEngine struct {
+	kv *db.KV
+}
+
+// the engines compare keys with bytes.Compare, so the file must be in byte order
+func OpenDB(path string) (Engine, error) {
+	kv := &db.KV{Path: path, Comparator: db.COMPARE_BYTES}
+	if err := kv.Open(); err != nil {
+		return nil, err
+	}
+	return &dbEngine{kv: kv}, nil
+}
+
+func (e *dbEngine) Get(key []byte) ([]byte, bool, error) {
+	val, ok := e.kv.Get(key)
+	return bytes.Clone(val), ok, nil
+}
+
+func (e *dbEngine) Set(key, val []byte) error {
+	return e.kv.Set(key, val)
+}
+
+func (e *dbEngine) Delete(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, nil // the dummy key
+	}
+	return e.kv.Del(key)
+}
+
+func (e *dbEngine) Scan(start, end []byte, fn func(key, val []byte) bool) error {
+	for iter := e.kv.Seek(start, db.CMP_GE); iter.Valid(); iter.Next() {
+		key, val := iter.Deref()
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		if !fn(key, val) {
+			break
+		}
+	}
+	return nil
+}
+
+func (e *dbEngine) Close() error {
+	return e.kv.Close()
+}
+
+func (e *dbEngine) Stats() (Stats, error) {
+	n, err := countKeys(e)
+	if err != nil {
+		return Stats{}, err
+	}
+	info, err := os.Stat(e.kv.Path)
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{Engine: "db", Keys: n, Extra: map[string]int64{"file_bytes": info.Size()}}, nil
+}
+
+// lsm.DB already has the Engine methods except Stats
+type lsmEngine struct {
+	*lsm.DB
+}
+
+func OpenLSM(dir string) (Engine, error) {
+	d, err := lsm.Open(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return lsmEngine{d}, nil
+}
+
+func (e lsmEngine) Stats() (Stats, error) {
+	n, err := countKeys(e)
+	if err != nil {
+		return Stats{}, err
+	}
+	s := e.DB.Stats()
+	extra := map[string]int64{"memtable_bytes": int64(s.MemtableBytes)}
+	for level, tables := range s.TablesPerLevel {
+		extra["l"+strconv.Itoa(level)+"_tables"] = int64(tables)
+		extra["l"+strconv.Itoa(level)+"_bytes"] = int64(s.BytesPerLevel[level])
+	}
+	return Stats{Engine: "lsm", Keys: n, Extra: extra}, nil
+}
+
+// bitcask.DB already has the Engine methods except Stats
+type bitcaskEngine struct {
+	*bitcask.DB
+}
+
+func OpenBitcask(dir string) (Engine, error) {
+	d, err := bitcask.Open(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bitcaskEngine{d}, nil
+}
+
+func (e bitcaskEngine) Stats() (Stats, error) {
+	s, err := e.DB.Stats()
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{Engine: "bitcask", Keys: s.Keys, Extra: map[string]int64{"files": int64(s.Files), "file_bytes": s.Bytes}}, nil
+}
+
+// temp.BTree: the B+tree with pages kept in a map
+type tempEngine struct {
+	tree  *temp.BTree
+	pages map[uint64][]byte
+	next  uint64
+}
+
+func NewTemp() Engine {
+	e := &tempEngine{pages: map[uint64][]byte{}, next: 1}
+	e.tree = temp.NewBTree(
+		func(ptr uint64) []byte { return e.pages[ptr] },
+		func(node []byte) uint64 {
+			ptr := e.next
+			e.next++
+			e.pages[ptr] = node
+			return ptr
+		},
+		func(ptr uint64) { delete(e.pages, ptr) },
+	)
+	return e
+}
+
+func (e *tempEngine) Get(key []byte) ([]byte, bool, error) {
+	if len(key) == 0 {
+		return nil, false, nil // the dummy key
+	}
+	val, ok := e.tree.Get(key)
+	return bytes.Clone(val), ok, nil
+}
+
+func (e *tempEngine) Set(key, val []byte) error {
+	return e.tree.Insert(key, val)
+}
+
+func (e *tempEngine) Delete(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, nil
+	}
+	return e.tree.Delete(key)
+}
+
+func (e *tempEngine) Scan(start, end []byte, fn func(key, val []byte) bool) error {
+	e.tree.Scan(start, end, fn)
+	return nil
+}
+
+func (e *tempEngine) Close() error {
+	return nil
+}
+
+func (e *tempEngine) Stats() (Stats, error) {
+	n, err := countKeys(e)
+	if err != nil {
+		return Stats{}, err
+	}
+	return Stats{Engine: "temp", Keys: n, Extra: map[string]int64{"pages": int64(len(e.pages))}}, nil
+}
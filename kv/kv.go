@@ -0,0 +1,70 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// One interface for every storage engine in the repo
+
+/*
+*
+The engines started with different method sets: bptree has int keys and string values,
+temp and db return bools where lsm and bitcask return errors. The adapters in this package
+put them all behind Engine so tools and benchmarks can swap them by name.
+
+Keys are compared with bytes.Compare. Scan calls fn for the keys in [start, end) in order
+until it returns false, a nil end means no upper bound. The key and val passed to fn are
+only valid during the call.
+
+Engines with a capacity limit (bptree) return an error from Set when full, and a failed Set
+leaves the engine unchanged. Engines without deletes return errors.ErrUnsupported.
+*/
+type Engine interface {
+	Get(key []byte) ([]byte, bool, error)
+	Set(key, val []byte) error
+	Delete(key []byte) (bool, error)
+	Scan(start, end []byte, fn func(key, val []byte) bool) error
+	Close() error
+	Stats() (Stats, error)
+}
+
+type Stats struct {
+	Engine string
+	Keys   int              // live keys
+	Extra  map[string]int64 // engine specific numbers
+}
+
+// engine names accepted by Open
+var ENGINES = []string{"db", "lsm", "bitcask", "temp", "bptree"}
+
+var ErrUnknownEngine = errors.New("unknown engine")
+
+// open an engine by name. path is a file for db and a directory for lsm and bitcask,
+// temp and bptree live in memory and ignore it.
+func Open(engine, path string) (Engine, error) {
+	switch engine {
+	case "db":
+		return OpenDB(path)
+	case "lsm":
+		return OpenLSM(path)
+	case "bitcask":
+		return OpenBitcask(path)
+	case "temp":
+		return NewTemp(), nil
+	case "bptree":
+		return NewBpTree(), nil
+	default:
+		return nil, fmt.Errorf("%w %q, expected one of %v", ErrUnknownEngine, engine, ENGINES)
+	}
+}
+
+// count the keys with a full scan, for engines that don't keep a count
+func countKeys(e Engine) (int, error) {
+	n := 0
+	err := e.Scan(nil, nil, func(key, val []byte) bool {
+		n++
+		return true
+	})
+	return n, err
+}
@@ -0,0 +1,99 @@
+package kv_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"building-a-db/bptree"
+	"building-a-db/kv"
+	"building-a-db/kv/kvtest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEngines(t *testing.T) {
+	// Helper: Reopen an engine stored at path
+	reopen := func(name string, path *string) func(t *testing.T, e kv.Engine) kv.Engine {
+		return func(t *testing.T, e kv.Engine) kv.Engine {
+			assert.NoError(t, e.Close())
+			e, err := kv.Open(name, *path)
+			assert.NoError(t, err)
+			return e
+		}
+	}
+
+	for _, name := range []string{"db", "lsm", "bitcask"} {
+		var path string
+		t.Run(name, func(t *testing.T) {
+			kvtest.Run(t, kvtest.Config{
+				Open: func(t *testing.T) kv.Engine {
+					path = filepath.Join(t.TempDir(), "data")
+					e, err := kv.Open(name, path)
+					assert.NoError(t, err)
+					return e
+				},
+				Reopen: reopen(name, &path),
+			})
+		})
+	}
+
+	t.Run("temp", func(t *testing.T) {
+		kvtest.Run(t, kvtest.Config{
+			Open: func(t *testing.T) kv.Engine { return kv.NewTemp() },
+		})
+	})
+
+	t.Run("bptree", func(t *testing.T) {
+		kvtest.Run(t, kvtest.Config{
+			Open:     func(t *testing.T) kv.Engine { return kv.NewBpTree() },
+			Key:      kv.EncodeIntKey,
+			MaxKeys:  bptree.MAX_SIZE * bptree.MAX_SIZE,
+			NoDelete: true,
+		})
+	})
+}
+
+func TestOpen(t *testing.T) {
+	_, err := kv.Open("nope", t.TempDir())
+	assert.ErrorIs(t, err, kv.ErrUnknownEngine)
+}
+
+func TestBpTreeAdapter(t *testing.T) {
+	e := kv.NewBpTree()
+
+	t.Run("Int keys keep their order", func(t *testing.T) {
+		for _, k := range []int{5, -3, 0, 100, -100} {
+			assert.NoError(t, e.Set(kv.EncodeIntKey(k), []byte("v")))
+		}
+		var keys [][]byte
+		e.Scan(nil, nil, func(key, val []byte) bool {
+			keys = append(keys, key)
+			return true
+		})
+		expected := [][]byte{}
+		for _, k := range []int{-100, -3, 0, 5, 100} {
+			expected = append(expected, kv.EncodeIntKey(k))
+		}
+		assert.Equal(t, expected, keys)
+	})
+
+	// Edge cases
+	t.Run("Keys that are not ints", func(t *testing.T) {
+		assert.ErrorIs(t, e.Set([]byte("abc"), nil), kv.ErrBadIntKey)
+		_, _, err := e.Get([]byte("abc"))
+		assert.ErrorIs(t, err, kv.ErrBadIntKey)
+	})
+
+	t.Run("Full tree", func(t *testing.T) {
+		full := kv.NewBpTree()
+		var err error
+		for i := 0; err == nil; i++ {
+			err = full.Set(kv.EncodeIntKey(i), []byte("v"))
+		}
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, kv.ErrBadIntKey))
+		stats, _ := full.Stats()
+		assert.Equal(t, bptree.MAX_SIZE*bptree.MAX_SIZE, stats.Keys)
+	})
+}
@@ -0,0 +1,262 @@
+package kvtest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"building-a-db/kv"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Conformance tests that every kv.Engine must pass
+
+type Config struct {
+	Open     func(t *testing.T) kv.Engine              // a new empty engine
+	Reopen   func(t *testing.T, e kv.Engine) kv.Engine // close and open the same data again, nil if not persistent
+	Key      func(i int) []byte                        // the i-th key, increasing with i. nil means "key000001"...
+	MaxKeys  int                                       // capacity, 0 for no limit
+	NoDelete bool                                      // Delete returns errors.ErrUnsupported
+}
+
+func (cfg Config) key(i int) []byte {
+	if cfg.Key != nil {
+		return cfg.Key(i)
+	}
+	return []byte(fmt.Sprintf("key%06d", i))
+}
+
+// how many keys the bulk tests use
+func (cfg Config) numKeys() int {
+	if cfg.MaxKeys > 0 {
+		return cfg.MaxKeys
+	}
+	return 500
+}
+
+// the order in which the bulk tests insert keys: shuffled, or ascending for engines with a
+// capacity since their fill depends on the order
+func (cfg Config) insertOrder(rnd *rand.Rand) []int {
+	n := cfg.numKeys()
+	if cfg.MaxKeys > 0 {
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		return order
+	}
+	return rnd.Perm(n)
+}
+
+// Helper: Collect the pairs of a scan
+func scan(t *testing.T, e kv.Engine, start, end []byte) (keys []string, vals []string) {
+	err := e.Scan(start, end, func(key, val []byte) bool {
+		keys = append(keys, string(key))
+		vals = append(vals, string(val))
+		return true
+	})
+	assert.NoError(t, err)
+	return keys, vals
+}
+
+func Run(t *testing.T, cfg Config) {
+	open := func(t *testing.T) kv.Engine {
+		e := cfg.Open(t)
+		t.Cleanup(func() { e.Close() })
+		return e
+	}
+
+	t.Run("Set and Get", func(t *testing.T) {
+		e := open(t)
+		assert.NoError(t, e.Set(cfg.key(1), []byte("one")))
+		val, ok, err := e.Get(cfg.key(1))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("one"), val)
+
+		_, ok, err = e.Get(cfg.key(2))
+		assert.NoError(t, err)
+		assert.False(t, ok, "Missing key")
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		e := open(t)
+		e.Set(cfg.key(1), []byte("old"))
+		assert.NoError(t, e.Set(cfg.key(1), []byte("new")))
+		val, _, _ := e.Get(cfg.key(1))
+		assert.Equal(t, []byte("new"), val)
+
+		keys, _ := scan(t, e, nil, nil)
+		assert.Equal(t, 1, len(keys), "No duplicates")
+	})
+
+	t.Run("Returned values are copies", func(t *testing.T) {
+		e := open(t)
+		e.Set(cfg.key(1), []byte("value"))
+		val, _, _ := e.Get(cfg.key(1))
+		val[0] = 'X'
+		again, _, _ := e.Get(cfg.key(1))
+		assert.Equal(t, []byte("value"), again)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		e := open(t)
+		e.Set(cfg.key(1), []byte("one"))
+		deleted, err := e.Delete(cfg.key(1))
+		if cfg.NoDelete {
+			assert.ErrorIs(t, err, errors.ErrUnsupported)
+			assert.False(t, deleted)
+			return
+		}
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		_, ok, _ := e.Get(cfg.key(1))
+		assert.False(t, ok)
+
+		deleted, err = e.Delete(cfg.key(1))
+		assert.NoError(t, err)
+		assert.False(t, deleted, "Already deleted")
+		deleted, err = e.Delete(cfg.key(2))
+		assert.NoError(t, err)
+		assert.False(t, deleted, "Never existed")
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		e := open(t)
+		rnd := rand.New(rand.NewSource(1))
+		n := cfg.numKeys()
+		for _, i := range cfg.insertOrder(rnd) {
+			assert.NoError(t, e.Set(cfg.key(i), []byte(fmt.Sprint(i))))
+		}
+
+		keys, vals := scan(t, e, nil, nil)
+		assert.Equal(t, n, len(keys))
+		assert.True(t, sort.StringsAreSorted(keys), "Keys are in order")
+		for i := range keys {
+			assert.Equal(t, string(cfg.key(i)), keys[i])
+			assert.Equal(t, fmt.Sprint(i), vals[i])
+		}
+
+		// [start, end) with bounds on existing keys
+		keys, _ = scan(t, e, cfg.key(2), cfg.key(5))
+		assert.Equal(t, []string{string(cfg.key(2)), string(cfg.key(3)), string(cfg.key(4))}, keys)
+
+		// end before start and empty ranges
+		keys, _ = scan(t, e, cfg.key(5), cfg.key(2))
+		assert.Empty(t, keys)
+		keys, _ = scan(t, e, cfg.key(n), nil)
+		assert.Empty(t, keys)
+
+		// fn stops the scan
+		count := 0
+		e.Scan(nil, nil, func(key, val []byte) bool {
+			count++
+			return count < 3
+		})
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("Stats counts keys", func(t *testing.T) {
+		e := open(t)
+		for i := 0; i < 5; i++ {
+			e.Set(cfg.key(i), []byte("v"))
+		}
+		e.Set(cfg.key(0), []byte("again"))
+		stats, err := e.Stats()
+		assert.NoError(t, err)
+		assert.Equal(t, 5, stats.Keys)
+		assert.NotEmpty(t, stats.Engine)
+	})
+
+	t.Run("Matches a map", func(t *testing.T) {
+		e := open(t)
+		expected := map[string]string{}
+		rnd := rand.New(rand.NewSource(2))
+		space := cfg.numKeys()
+
+		for i := 0; i < 2000; i++ {
+			key := cfg.key(rnd.Intn(space))
+			switch op := rnd.Intn(4); {
+			case op == 0 && !cfg.NoDelete:
+				_, exists := expected[string(key)]
+				deleted, err := e.Delete(key)
+				assert.NoError(t, err)
+				assert.Equal(t, exists, deleted)
+				delete(expected, string(key))
+			case op == 1:
+				val, ok, err := e.Get(key)
+				assert.NoError(t, err)
+				exp, exists := expected[string(key)]
+				assert.Equal(t, exists, ok)
+				if exists {
+					assert.Equal(t, exp, string(val))
+				}
+			default:
+				val := fmt.Sprint(i)
+				if err := e.Set(key, []byte(val)); err != nil {
+					// only a full engine may refuse a write, and it must not change
+					assert.Greater(t, cfg.MaxKeys, 0, "Set failed: %v", err)
+					_, exists := expected[string(key)]
+					assert.False(t, exists, "Updates never fail")
+					_, ok, _ := e.Get(key)
+					assert.False(t, ok, "Failed Set left the key behind")
+					continue
+				}
+				expected[string(key)] = val
+			}
+		}
+
+		keys, vals := scan(t, e, nil, nil)
+		got := map[string]string{}
+		for i := range keys {
+			got[keys[i]] = vals[i]
+		}
+		assert.Equal(t, expected, got)
+	})
+
+	if cfg.Reopen != nil {
+		t.Run("Reopen keeps the data", func(t *testing.T) {
+			e := cfg.Open(t)
+			for i := 0; i < 50; i++ {
+				e.Set(cfg.key(i), []byte(fmt.Sprint(i)))
+			}
+			if !cfg.NoDelete {
+				e.Delete(cfg.key(10))
+			}
+			e = cfg.Reopen(t, e)
+			defer e.Close()
+
+			keys, _ := scan(t, e, nil, nil)
+			if cfg.NoDelete {
+				assert.Equal(t, 50, len(keys))
+			} else {
+				assert.Equal(t, 49, len(keys))
+				_, ok, _ := e.Get(cfg.key(10))
+				assert.False(t, ok)
+			}
+			val, ok, _ := e.Get(cfg.key(20))
+			assert.True(t, ok)
+			assert.Equal(t, []byte("20"), val)
+		})
+	}
+
+	// Edge cases
+	t.Run("Empty key", func(t *testing.T) {
+		e := open(t)
+		if cfg.Key != nil {
+			// engines with their own key format reject it like any bad key
+			assert.Error(t, e.Set(nil, []byte("v")))
+			return
+		}
+		e.Set(cfg.key(1), []byte("v"))
+		assert.Error(t, e.Set(nil, []byte("v")), "Empty keys are rejected")
+		_, ok, err := e.Get(nil)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		keys, _ := scan(t, e, nil, nil)
+		assert.Equal(t, 1, len(keys), "No dummy entries in scans")
+	})
+}
@@ -0,0 +1,76 @@
+package lsm
+
+import (
+	"hash/fnv"
+)
+
+// Bloom filter: can tell that a key is definitely not in an SSTable without reading it
+
+/*
+*
+A bit array of m bits, adding a key sets k bits, a lookup checks them all.
+The k bit positions come from 2 hashes: h1 + i*h2 (double hashing).
+With 10 bits per key and 7 hashes the false positive rate is about 1%.
+
+Encoding:
+
+	| k  | bits |
+	| 1B | ...  |
+*/
+const BLOOM_BITS_PER_KEY = 10
+const BLOOM_HASHES = 7
+
+type bloom struct {
+	k    uint8
+	bits []byte
+}
+
+func bloomHash(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1 // h2 is odd so the positions don't repeat early
+}
+
+// build a filter from the hashes of all the keys
+func newBloom(hashes [][2]uint32) bloom {
+	nbits := len(hashes) * BLOOM_BITS_PER_KEY
+	if nbits < 64 {
+		nbits = 64
+	}
+	b := bloom{k: BLOOM_HASHES, bits: make([]byte, (nbits+7)/8)}
+	m := uint32(len(b.bits) * 8)
+	for _, h := range hashes {
+		for i := uint32(0); i < uint32(b.k); i++ {
+			pos := (h[0] + i*h[1]) % m
+			b.bits[pos/8] |= 1 << (pos % 8)
+		}
+	}
+	return b
+}
+
+func (b bloom) mayContain(key []byte) bool {
+	if len(b.bits) == 0 {
+		return true
+	}
+	h1, h2 := bloomHash(key)
+	m := uint32(len(b.bits) * 8)
+	for i := uint32(0); i < uint32(b.k); i++ {
+		pos := (h1 + i*h2) % m
+		if b.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bloom) encode() []byte {
+	return append([]byte{b.k}, b.bits...)
+}
+
+func decodeBloom(data []byte) bloom {
+	if len(data) == 0 {
+		return bloom{}
+	}
+	return bloom{k: data[0], bits: data[1:]}
+}
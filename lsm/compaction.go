@@ -0,0 +1,153 @@
+package lsm
+
+import (
+	"bytes"
+	"os"
+	"sort"
+)
+
+// Leveled compaction
+
+/*
+*
+Level 0 is compacted when it has L0Tables tables: all of them are merged with the
+overlapping tables of level 1. Level i >= 1 is compacted when it is bigger than
+BaseLevelSize * LevelMultiplier^(i-1): one table is merged with the overlapping tables of
+level i+1. The table is picked round robin by key so every part of the level gets its turn.
+
+The new tables replace the inputs in the manifest before the inputs are deleted, so a crash
+leaves either the old or the new tables live, and the other ones are deleted on open.
+*/
+
+func (db *DB) maxLevelSize(level int) uint64 {
+	size := db.opts.BaseLevelSize
+	for i := 1; i < level; i++ {
+		size *= uint64(db.opts.LevelMultiplier)
+	}
+	return size
+}
+
+func levelSize(tables []*table) uint64 {
+	var size uint64
+	for _, t := range tables {
+		size += uint64(t.size)
+	}
+	return size
+}
+
+// the key range of tables
+func keyRange(tables []*table) (smallest, largest []byte) {
+	for _, t := range tables {
+		if smallest == nil || bytes.Compare(t.smallest(), smallest) < 0 {
+			smallest = t.smallest()
+		}
+		if largest == nil || bytes.Compare(t.largest(), largest) > 0 {
+			largest = t.largest()
+		}
+	}
+	return smallest, largest
+}
+
+func overlapping(tables []*table, smallest, largest []byte) []*table {
+	var out []*table
+	for _, t := range tables {
+		if t.overlaps(smallest, largest) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// pick the level to compact and the tables from it, ok is false if nothing needs compacting
+func (db *DB) pickCompaction() (level int, inputs []*table, ok bool) {
+	if len(db.levels[0]) >= db.opts.L0Tables {
+		return 0, db.levels[0], true
+	}
+	// the last level has nowhere to go
+	for level := 1; level < len(db.levels)-1; level++ {
+		tables := db.levels[level]
+		if levelSize(tables) <= db.maxLevelSize(level) {
+			continue
+		}
+		pick := tables[0]
+		for _, t := range tables {
+			if bytes.Compare(t.smallest(), db.compactPtr[level]) > 0 {
+				pick = t
+				break
+			}
+		}
+		db.compactPtr[level] = pick.largest()
+		return level, []*table{pick}, true
+	}
+	return 0, nil, false
+}
+
+// compact until every level is within its limits
+func (db *DB) compact() error {
+	for {
+		level, inputs, ok := db.pickCompaction()
+		if !ok {
+			return nil
+		}
+		if err := db.compactLevel(level, inputs); err != nil {
+			return err
+		}
+	}
+}
+
+// merge inputs from level with the overlapping tables of the next level
+func (db *DB) compactLevel(level int, inputs []*table) error {
+	smallest, largest := keyRange(inputs)
+	next := overlapping(db.levels[level+1], smallest, largest)
+
+	// newest first: level 0 tables from newest to oldest, then the next level
+	var sources []iterator
+	for i := len(inputs) - 1; i >= 0; i-- {
+		sources = append(sources, inputs[i].seek(nil))
+	}
+	if len(next) > 0 {
+		sources = append(sources, newLevelIter(next, nil))
+	}
+
+	// tombstones are only needed while there is older data below to hide
+	bottom := true
+	for _, tables := range db.levels[level+2:] {
+		if len(tables) > 0 {
+			bottom = false
+		}
+	}
+	outputs, err := db.writeTables(newMergeIter(sources), db.opts.TableSize, bottom)
+	if err != nil {
+		return err
+	}
+
+	obsolete := map[*table]bool{}
+	for _, t := range append(append([]*table(nil), inputs...), next...) {
+		obsolete[t] = true
+	}
+	db.levels[level] = without(db.levels[level], obsolete)
+	merged := append(without(db.levels[level+1], obsolete), outputs...)
+	sort.Slice(merged, func(i, j int) bool {
+		return bytes.Compare(merged[i].smallest(), merged[j].smallest()) < 0
+	})
+	db.levels[level+1] = merged
+	if err := db.saveManifest(); err != nil {
+		return err
+	}
+
+	for t := range obsolete {
+		t.close()
+		os.Remove(t.path)
+	}
+	return nil
+}
+
+func without(tables []*table, remove map[*table]bool) []*table {
+	out := []*table{}
+	for _, t := range tables {
+		if !remove[t] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
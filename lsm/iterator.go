@@ -0,0 +1,117 @@
+package lsm
+
+import (
+	"bytes"
+)
+
+// Merging iterator over the memtable and the SSTables
+
+/*
+*
+The same key can be in several sources, the newest one wins. Sources are ordered from the
+newest (the memtable) to the oldest (the last level), so for the smallest current key the
+first source that has it provides the entry, and every source at that key moves forward.
+
+Tombstones are returned as entries, Scan skips them and compaction keeps them unless
+nothing older can be hidden by them.
+*/
+type iterator interface {
+	Valid() bool
+	Key() []byte
+	Value() []byte
+	Deleted() bool
+	Next()
+	Err() error
+}
+
+type mergeIter struct {
+	sources []iterator // newest first
+	cur     int        // the source of the current entry, -1 at the end
+}
+
+func newMergeIter(sources []iterator) *mergeIter {
+	it := &mergeIter{sources: sources}
+	it.pick()
+	return it
+}
+
+// find the smallest key, the first source with it is the newest
+func (it *mergeIter) pick() {
+	it.cur = -1
+	for i, src := range it.sources {
+		if !src.Valid() {
+			continue
+		}
+		if it.cur < 0 || bytes.Compare(src.Key(), it.sources[it.cur].Key()) < 0 {
+			it.cur = i
+		}
+	}
+}
+
+func (it *mergeIter) Valid() bool   { return it.cur >= 0 }
+func (it *mergeIter) Key() []byte   { return it.sources[it.cur].Key() }
+func (it *mergeIter) Value() []byte { return it.sources[it.cur].Value() }
+func (it *mergeIter) Deleted() bool { return it.sources[it.cur].Deleted() }
+
+func (it *mergeIter) Next() {
+	key := bytes.Clone(it.Key())
+	for _, src := range it.sources {
+		if src.Valid() && bytes.Equal(src.Key(), key) {
+			src.Next() // older versions of the key are hidden
+		}
+	}
+	it.pick()
+}
+
+func (it *mergeIter) Err() error {
+	for _, src := range it.sources {
+		if err := src.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// concatenate the tables of a level, they are sorted and don't overlap
+type levelIter struct {
+	tables []*table
+	i      int
+	iter   *tableIter
+}
+
+func newLevelIter(tables []*table, key []byte) *levelIter {
+	it := &levelIter{tables: tables}
+	// the first table that can have keys >= key
+	for it.i < len(tables) && bytes.Compare(tables[it.i].largest(), key) < 0 {
+		it.i++
+	}
+	if it.i < len(tables) {
+		it.iter = tables[it.i].seek(key)
+		it.skipEmpty()
+	}
+	return it
+}
+
+func (it *levelIter) skipEmpty() {
+	for !it.iter.Valid() && it.iter.Err() == nil && it.i < len(it.tables)-1 {
+		it.i++
+		it.iter = it.tables[it.i].seek(nil)
+	}
+}
+
+func (it *levelIter) Valid() bool   { return it.iter != nil && it.iter.Valid() }
+func (it *levelIter) Key() []byte   { return it.iter.Key() }
+func (it *levelIter) Value() []byte { return it.iter.Value() }
+func (it *levelIter) Deleted() bool { return it.iter.Deleted() }
+
+func (it *levelIter) Next() {
+	it.iter.Next()
+	it.skipEmpty()
+}
+
+func (it *levelIter) Err() error {
+	if it.iter == nil {
+		return nil
+	}
+	return it.iter.Err()
+}
@@ -0,0 +1,418 @@
+package lsm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A log-structured merge tree, the write optimized alternative to the B+tree in db
+
+/*
+*
+Writes go to the WAL and the memtable. When the memtable is big enough it is written out as
+an SSTable in level 0, and the WAL starts over.
+
+Level 0 tables can overlap each other, every other level is a sorted run of non-overlapping
+tables. When a level gets too big some of its tables are merged into the next level
+(leveled compaction), each level is LevelMultiplier times bigger than the previous one.
+
+A read checks the memtable, then the level 0 tables from newest to oldest, then at most one
+table per level. Bloom filters skip most tables that don't have the key.
+
+Flushes and compactions run in the writer, there are no background threads.
+
+	dir/
+	  MANIFEST      live tables per level
+	  wal.log       writes since the last flush
+	  000001.sst    SSTables
+*/
+type Options struct {
+	MemtableSize    int    // flush the memtable after this many bytes
+	BlockSize       int    // SSTable data block size
+	TableSize       uint64 // split compaction outputs at this size
+	L0Tables        int    // compact level 0 when it has this many tables
+	BaseLevelSize   uint64 // max size of level 1
+	LevelMultiplier int    // each level is this many times bigger than the previous one
+	Levels          int
+	NoSync          bool // don't fsync the WAL on every write
+}
+
+var DefaultOptions = Options{
+	MemtableSize:    4 << 20,
+	BlockSize:       4096,
+	TableSize:       2 << 20,
+	L0Tables:        4,
+	BaseLevelSize:   10 << 20,
+	LevelMultiplier: 10,
+	Levels:          7,
+}
+
+const WAL_NAME = "wal.log"
+
+var ErrEmptyKey = errors.New("lsm: empty key")
+var ErrClosed = errors.New("lsm: database is closed")
+
+type DB struct {
+	dir        string
+	opts       Options
+	mu         sync.Mutex
+	wal        *wal
+	mem        *memtable
+	levels     [][]*table
+	nextFile   uint64
+	compactPtr [][]byte // where the next compaction of each level starts, round robin
+	closed     bool
+}
+
+// open or create a database in dir, nil opts means DefaultOptions
+func Open(dir string, opts *Options) (*DB, error) {
+	if opts == nil {
+		opts = &DefaultOptions
+	}
+	if opts.Levels < 2 {
+		return nil, errors.New("lsm: need at least 2 levels")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db := &DB{
+		dir:        dir,
+		opts:       *opts,
+		mem:        newMemtable(),
+		levels:     make([][]*table, opts.Levels),
+		compactPtr: make([][]byte, opts.Levels),
+	}
+
+	m, err := loadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Levels) > opts.Levels {
+		return nil, errors.New("lsm: the database has more levels than Options.Levels")
+	}
+	db.nextFile = m.NextFile
+	live := map[uint64]bool{}
+	for level, nums := range m.Levels {
+		for _, num := range nums {
+			t, err := openTable(tableName(dir, num), num)
+			if err != nil {
+				db.closeTables()
+				return nil, err
+			}
+			db.levels[level] = append(db.levels[level], t)
+			live[num] = true
+		}
+	}
+	if err := removeOrphans(dir, live); err != nil {
+		db.closeTables()
+		return nil, err
+	}
+
+	if db.wal, err = openWAL(filepath.Join(dir, WAL_NAME), !opts.NoSync); err != nil {
+		db.closeTables()
+		return nil, err
+	}
+	if err := db.wal.replay(db.mem); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// delete table files left by an interrupted flush or compaction
+func removeOrphans(dir string, live map[uint64]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".sst")
+		if !ok {
+			continue
+		}
+		num, err := strconv.ParseUint(name, 10, 64)
+		if err != nil || live[num] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) closeTables() {
+	for _, tables := range db.levels {
+		for _, t := range tables {
+			t.close()
+		}
+	}
+}
+
+// the memtable is not flushed, the WAL has its content
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	db.closeTables()
+	return db.wal.close()
+}
+
+func (db *DB) Get(key []byte) ([]byte, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, false, ErrClosed
+	}
+	if len(key) == 0 {
+		return nil, false, nil
+	}
+	val, deleted, found, err := db.get(key)
+	if err != nil || !found || deleted {
+		return nil, false, err
+	}
+	return bytes.Clone(val), true, nil
+}
+
+// found is true for tombstones too
+func (db *DB) get(key []byte) (val []byte, deleted bool, found bool, err error) {
+	if val, deleted, found := db.mem.get(key); found {
+		return val, deleted, true, nil
+	}
+	// level 0 tables overlap, the newest one wins
+	l0 := db.levels[0]
+	for i := len(l0) - 1; i >= 0; i-- {
+		if !l0[i].overlaps(key, key) {
+			continue
+		}
+		if val, deleted, found, err = l0[i].get(key); err != nil || found {
+			return
+		}
+	}
+	// at most one table per level can have the key
+	for _, tables := range db.levels[1:] {
+		i := sort.Search(len(tables), func(i int) bool {
+			return bytes.Compare(tables[i].largest(), key) >= 0
+		})
+		if i == len(tables) || bytes.Compare(tables[i].smallest(), key) > 0 {
+			continue
+		}
+		if val, deleted, found, err = tables[i].get(key); err != nil || found {
+			return
+		}
+	}
+	return nil, false, false, nil
+}
+
+func (db *DB) Set(key, val []byte) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.wal.append(key, val, false); err != nil {
+		return err
+	}
+	db.mem.put(key, val)
+	return db.maybeFlush()
+}
+
+// returns false if the key doesn't exist
+func (db *DB) Delete(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return false, ErrClosed
+	}
+	_, deleted, found, err := db.get(key)
+	if err != nil || !found || deleted {
+		return false, err
+	}
+	if err := db.wal.append(key, nil, true); err != nil {
+		return false, err
+	}
+	db.mem.delete(key)
+	return true, db.maybeFlush()
+}
+
+// call fn for the keys in [start, end) in order until it returns false, nil end means no
+// upper bound. The database is locked during the scan, fn must not call back into it, and
+// key and val must not be kept after fn returns.
+func (db *DB) Scan(start, end []byte, fn func(key, val []byte) bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	iter := db.newIter(start)
+	for ; iter.Valid(); iter.Next() {
+		if end != nil && bytes.Compare(iter.Key(), end) >= 0 {
+			break
+		}
+		if iter.Deleted() {
+			continue
+		}
+		if !fn(iter.Key(), iter.Value()) {
+			break
+		}
+	}
+	return iter.Err()
+}
+
+// merge every source, newest first
+func (db *DB) newIter(start []byte) *mergeIter {
+	sources := []iterator{db.mem.seek(start)}
+	l0 := db.levels[0]
+	for i := len(l0) - 1; i >= 0; i-- {
+		sources = append(sources, l0[i].seek(start))
+	}
+	for _, tables := range db.levels[1:] {
+		if len(tables) > 0 {
+			sources = append(sources, newLevelIter(tables, start))
+		}
+	}
+	return newMergeIter(sources)
+}
+
+func (db *DB) maybeFlush() error {
+	if db.mem.size < db.opts.MemtableSize {
+		return nil
+	}
+	if err := db.flush(); err != nil {
+		return err
+	}
+	return db.compact()
+}
+
+// write the memtable to a level 0 table and empty the WAL
+func (db *DB) flush() error {
+	if db.mem.count == 0 {
+		return nil
+	}
+	tables, err := db.writeTables(db.mem.seek(nil), 0, false)
+	if err != nil {
+		return err
+	}
+	db.levels[0] = append(db.levels[0], tables...)
+	if err := db.saveManifest(); err != nil {
+		return err
+	}
+	// the data is in the table now, replaying the WAL again after a crash here is harmless
+	if err := db.wal.reset(); err != nil {
+		return err
+	}
+	db.mem = newMemtable()
+	return nil
+}
+
+func (db *DB) saveManifest() error {
+	m := manifest{NextFile: db.nextFile, Levels: make([][]uint64, len(db.levels))}
+	for level, tables := range db.levels {
+		m.Levels[level] = []uint64{}
+		for _, t := range tables {
+			m.Levels[level] = append(m.Levels[level], t.num)
+		}
+	}
+	return saveManifest(db.dir, m)
+}
+
+// write the entries of iter to new tables, a new table is started every splitSize bytes
+// (0 means never). Tombstones are dropped when nothing older can be hidden by them.
+func (db *DB) writeTables(iter iterator, splitSize uint64, dropTombstones bool) ([]*table, error) {
+	var tables []*table
+	var tw *tableWriter
+	var num uint64
+
+	finish := func() error {
+		w := tw
+		tw = nil
+		if err := w.finish(); err != nil {
+			w.abort()
+			return err
+		}
+		t, err := openTable(tableName(db.dir, num), num)
+		if err != nil {
+			os.Remove(tableName(db.dir, num))
+			return err
+		}
+		tables = append(tables, t)
+		return nil
+	}
+	cleanup := func() {
+		if tw != nil {
+			tw.abort()
+		}
+		for _, t := range tables {
+			t.close()
+			os.Remove(t.path)
+		}
+	}
+
+	for ; iter.Valid(); iter.Next() {
+		if dropTombstones && iter.Deleted() {
+			continue
+		}
+		if tw == nil {
+			num = db.nextFile
+			db.nextFile++
+			var err error
+			if tw, err = newTableWriter(tableName(db.dir, num), db.opts.BlockSize); err != nil {
+				cleanup()
+				return nil, err
+			}
+		}
+		if err := tw.add(iter.Key(), iter.Value(), iter.Deleted()); err != nil {
+			cleanup()
+			return nil, err
+		}
+		if splitSize > 0 && tw.estimatedSize() >= splitSize {
+			if err := finish(); err != nil {
+				cleanup()
+				return nil, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		cleanup()
+		return nil, err
+	}
+	if tw != nil {
+		if err := finish(); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	return tables, nil
+}
+
+type Stats struct {
+	MemtableBytes  int
+	TablesPerLevel []int
+	BytesPerLevel  []uint64
+}
+
+func (db *DB) Stats() Stats {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s := Stats{MemtableBytes: db.mem.size}
+	for _, tables := range db.levels {
+		s.TablesPerLevel = append(s.TablesPerLevel, len(tables))
+		s.BytesPerLevel = append(s.BytesPerLevel, levelSize(tables))
+	}
+	return s
+}
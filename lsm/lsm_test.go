@@ -0,0 +1,364 @@
+package lsm
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Small sizes so that a few hundred keys go through flushes and compactions
+var smallOptions = Options{
+	MemtableSize:    1024,
+	BlockSize:       128,
+	TableSize:       2048,
+	L0Tables:        2,
+	BaseLevelSize:   4096,
+	LevelMultiplier: 2,
+	Levels:          4,
+	NoSync:          true,
+}
+
+// Helper: Open a database in a fresh directory
+func openDB(t *testing.T, opts *Options) (*DB, string) {
+	dir := t.TempDir()
+	db, err := Open(dir, opts)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db, dir
+}
+
+// Helper: Collect every key-value pair in [start, end)
+func scanAll(t *testing.T, db *DB, start, end []byte) map[string]string {
+	out := map[string]string{}
+	var prev string
+	err := db.Scan(start, end, func(key, val []byte) bool {
+		assert.True(t, prev < string(key), "Keys are in order")
+		prev = string(key)
+		out[string(key)] = string(val)
+		return true
+	})
+	assert.NoError(t, err)
+	return out
+}
+
+func TestDB(t *testing.T) {
+	t.Run("Set Get Delete", func(t *testing.T) {
+		db, _ := openDB(t, nil)
+
+		assert.NoError(t, db.Set([]byte("k1"), []byte("v1")))
+		val, ok, err := db.Get([]byte("k1"))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte("v1"), val)
+
+		deleted, err := db.Delete([]byte("k1"))
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		_, ok, _ = db.Get([]byte("k1"))
+		assert.False(t, ok)
+
+		deleted, err = db.Delete([]byte("k1"))
+		assert.NoError(t, err)
+		assert.False(t, deleted, "Already deleted")
+	})
+
+	t.Run("Reopen replays the WAL", func(t *testing.T) {
+		db, dir := openDB(t, nil)
+		db.Set([]byte("a"), []byte("1"))
+		db.Set([]byte("b"), []byte("2"))
+		db.Delete([]byte("a"))
+		assert.NoError(t, db.Close())
+
+		db, err := Open(dir, nil)
+		assert.NoError(t, err)
+		defer db.Close()
+		assert.Equal(t, map[string]string{"b": "2"}, scanAll(t, db, nil, nil))
+	})
+
+	t.Run("Flushes and compactions match a map", func(t *testing.T) {
+		db, dir := openDB(t, &smallOptions)
+		expected := map[string]string{}
+		rnd := rand.New(rand.NewSource(1))
+
+		for i := 0; i < 3000; i++ {
+			key := fmt.Sprintf("key%04d", rnd.Intn(500))
+			if rnd.Intn(4) == 0 {
+				_, exists := expected[key]
+				deleted, err := db.Delete([]byte(key))
+				assert.NoError(t, err)
+				assert.Equal(t, exists, deleted)
+				delete(expected, key)
+			} else {
+				val := fmt.Sprintf("val%d", i)
+				assert.NoError(t, db.Set([]byte(key), []byte(val)))
+				expected[key] = val
+			}
+		}
+
+		tables := 0
+		for level := 1; level < len(db.levels); level++ {
+			tables += len(db.levels[level])
+		}
+		assert.Greater(t, tables, 0, "Data reached the lower levels")
+		assert.Less(t, len(db.levels[0]), smallOptions.L0Tables)
+
+		check := func(db *DB) {
+			assert.Equal(t, expected, scanAll(t, db, nil, nil))
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("key%04d", i)
+				val, ok, err := db.Get([]byte(key))
+				assert.NoError(t, err)
+				exp, exists := expected[key]
+				assert.Equal(t, exists, ok, key)
+				if exists {
+					assert.Equal(t, exp, string(val))
+				}
+			}
+		}
+		check(db)
+
+		assert.NoError(t, db.Close())
+		reopened, err := Open(dir, &smallOptions)
+		assert.NoError(t, err)
+		defer reopened.Close()
+		check(reopened)
+	})
+
+	t.Run("Levels don't overlap", func(t *testing.T) {
+		db, _ := openDB(t, &smallOptions)
+		for i := 0; i < 2000; i++ {
+			db.Set([]byte(fmt.Sprintf("key%05d", (i*7919)%2000)), []byte("value"))
+		}
+		for level := 1; level < len(db.levels); level++ {
+			tables := db.levels[level]
+			for i := 1; i < len(tables); i++ {
+				assert.Less(t, string(tables[i-1].largest()), string(tables[i].smallest()), "level %d", level)
+			}
+		}
+	})
+
+	t.Run("Scan ranges", func(t *testing.T) {
+		db, _ := openDB(t, &smallOptions)
+		for i := 0; i < 300; i++ {
+			db.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("%d", i)))
+		}
+		// some keys are in the memtable, some in the tables
+		db.Delete([]byte("key150"))
+
+		got := scanAll(t, db, []byte("key148"), []byte("key153"))
+		assert.Equal(t, map[string]string{"key148": "148", "key149": "149", "key151": "151", "key152": "152"}, got)
+
+		var keys []string
+		db.Scan([]byte("key290"), nil, func(key, val []byte) bool {
+			keys = append(keys, string(key))
+			return len(keys) < 3
+		})
+		assert.Equal(t, []string{"key290", "key291", "key292"}, keys, "Stops when fn returns false")
+	})
+
+	// Edge cases
+	t.Run("Empty keys", func(t *testing.T) {
+		db, _ := openDB(t, nil)
+		assert.ErrorIs(t, db.Set(nil, []byte("v")), ErrEmptyKey)
+		_, ok, err := db.Get(nil)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Closed database", func(t *testing.T) {
+		db, _ := openDB(t, nil)
+		assert.NoError(t, db.Close())
+		assert.ErrorIs(t, db.Set([]byte("k"), nil), ErrClosed)
+		_, _, err := db.Get([]byte("k"))
+		assert.ErrorIs(t, err, ErrClosed)
+	})
+
+	t.Run("Torn WAL tail is dropped", func(t *testing.T) {
+		db, dir := openDB(t, nil)
+		db.Set([]byte("a"), []byte("1"))
+		db.Set([]byte("b"), []byte("2"))
+		db.Close()
+
+		// cut the last record in half
+		path := filepath.Join(dir, WAL_NAME)
+		info, _ := os.Stat(path)
+		assert.NoError(t, os.Truncate(path, info.Size()-2))
+
+		db, err := Open(dir, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"a": "1"}, scanAll(t, db, nil, nil))
+
+		// new writes go after the good records
+		db.Set([]byte("c"), []byte("3"))
+		db.Close()
+		db, err = Open(dir, nil)
+		assert.NoError(t, err)
+		defer db.Close()
+		assert.Equal(t, map[string]string{"a": "1", "c": "3"}, scanAll(t, db, nil, nil))
+	})
+
+	t.Run("WAL record length past the end of the file", func(t *testing.T) {
+		db, dir := openDB(t, nil)
+		db.Set([]byte("a"), []byte("1"))
+		db.Close()
+
+		// a torn header whose len asks for 4GB
+		path := filepath.Join(dir, WAL_NAME)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		assert.NoError(t, err)
+		f.Write([]byte{0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0})
+		f.Close()
+
+		db, err = Open(dir, nil)
+		assert.NoError(t, err)
+		defer db.Close()
+		assert.Equal(t, map[string]string{"a": "1"}, scanAll(t, db, nil, nil))
+		info, _ := os.Stat(path)
+		assert.Less(t, info.Size(), int64(20), "The torn record is cut off")
+	})
+
+	t.Run("Orphan tables are removed", func(t *testing.T) {
+		_, dir := openDB(t, nil)
+		orphan := tableName(dir, 99)
+		assert.NoError(t, os.WriteFile(orphan, []byte("garbage"), 0644))
+
+		db, err := Open(dir, nil)
+		assert.NoError(t, err)
+		defer db.Close()
+		_, err = os.Stat(orphan)
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestSSTable(t *testing.T) {
+	// Helper: Write sorted entries to a table
+	write := func(t *testing.T, keys []string, deleted map[string]bool) *table {
+		path := filepath.Join(t.TempDir(), "000001.sst")
+		tw, err := newTableWriter(path, 64)
+		assert.NoError(t, err)
+		for _, k := range keys {
+			assert.NoError(t, tw.add([]byte(k), []byte("v"+k), deleted[k]))
+		}
+		assert.NoError(t, tw.finish())
+		tbl, err := openTable(path, 1)
+		assert.NoError(t, err)
+		t.Cleanup(func() { tbl.close() })
+		return tbl
+	}
+
+	var keys []string
+	for i := 0; i < 200; i += 2 {
+		keys = append(keys, fmt.Sprintf("k%03d", i))
+	}
+	tbl := write(t, keys, map[string]bool{"k010": true})
+
+	t.Run("Multiple blocks", func(t *testing.T) {
+		assert.Greater(t, len(tbl.blocks), 1)
+		assert.Equal(t, []byte("k000"), tbl.smallest())
+		assert.Equal(t, []byte("k198"), tbl.largest())
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		for _, k := range keys {
+			val, deleted, found, err := tbl.get([]byte(k))
+			assert.NoError(t, err)
+			assert.True(t, found, k)
+			if k == "k010" {
+				assert.True(t, deleted)
+			} else {
+				assert.Equal(t, "v"+k, string(val))
+			}
+		}
+		for _, k := range []string{"a", "k001", "k199", "z"} {
+			_, _, found, err := tbl.get([]byte(k))
+			assert.NoError(t, err)
+			assert.False(t, found, k)
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		var got []string
+		for it := tbl.seek([]byte("k191")); it.Valid(); it.Next() {
+			got = append(got, string(it.Key()))
+		}
+		assert.Equal(t, []string{"k192", "k194", "k196", "k198"}, got)
+
+		it := tbl.seek([]byte("z"))
+		assert.False(t, it.Valid())
+		assert.NoError(t, it.Err())
+	})
+
+	t.Run("Corruption is detected", func(t *testing.T) {
+		data, _ := os.ReadFile(tbl.path)
+		data[10] ^= 0xff // inside the first data block
+		path := filepath.Join(t.TempDir(), "000002.sst")
+		os.WriteFile(path, data, 0644)
+
+		bad, err := openTable(path, 2)
+		assert.NoError(t, err, "The index is intact")
+		defer bad.close()
+		_, _, _, err = bad.get([]byte("k000"))
+		assert.ErrorIs(t, err, ErrCorrupted)
+
+		os.WriteFile(path, data[:len(data)-1], 0644)
+		_, err = openTable(path, 2)
+		assert.ErrorIs(t, err, ErrCorrupted, "Footer is gone")
+	})
+}
+
+func TestMemtable(t *testing.T) {
+	m := newMemtable()
+	rnd := rand.New(rand.NewSource(1))
+	expected := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("%d", rnd.Intn(300))
+		m.put([]byte(k), []byte(k))
+		expected[k] = true
+	}
+	m.delete([]byte("tombstone"))
+
+	var sorted []string
+	for k := range expected {
+		sorted = append(sorted, k)
+	}
+	sorted = append(sorted, "tombstone")
+	sort.Strings(sorted)
+
+	var got []string
+	for it := m.seek(nil); it.Valid(); it.Next() {
+		got = append(got, string(it.Key()))
+	}
+	assert.Equal(t, sorted, got)
+	assert.Equal(t, len(sorted), m.count, "Overwrites don't add nodes")
+
+	_, deleted, found := m.get([]byte("tombstone"))
+	assert.True(t, found)
+	assert.True(t, deleted)
+	_, _, found = m.get([]byte("missing"))
+	assert.False(t, found)
+}
+
+func TestBloom(t *testing.T) {
+	var hashes [][2]uint32
+	for i := 0; i < 1000; i++ {
+		h1, h2 := bloomHash([]byte(fmt.Sprintf("in%d", i)))
+		hashes = append(hashes, [2]uint32{h1, h2})
+	}
+	b := decodeBloom(newBloom(hashes).encode())
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, b.mayContain([]byte(fmt.Sprintf("in%d", i))), "No false negatives")
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if b.mayContain([]byte(fmt.Sprintf("out%d", i))) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 300, "About 1%% false positives")
+}
@@ -0,0 +1,80 @@
+package lsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Manifest: which SSTables are live and at which level
+
+/*
+*
+The manifest is a small JSON file that is replaced as a whole: write MANIFEST.tmp, fsync,
+rename over MANIFEST, fsync the directory. A table file that isn't in the manifest is garbage
+from an interrupted flush or compaction and is deleted on open.
+*/
+type manifest struct {
+	NextFile uint64     `json:"next_file"`
+	Levels   [][]uint64 `json:"levels"` // table numbers, L0 from oldest to newest, L1+ by key
+}
+
+const MANIFEST_NAME = "MANIFEST"
+
+func tableName(dir string, num uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d.sst", num))
+}
+
+func loadManifest(dir string) (manifest, error) {
+	m := manifest{NextFile: 1}
+	data, err := os.ReadFile(filepath.Join(dir, MANIFEST_NAME))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", MANIFEST_NAME, ErrCorrupted)
+	}
+	return m, nil
+}
+
+func saveManifest(dir string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, MANIFEST_NAME+".tmp")
+	fd, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, MANIFEST_NAME)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// make the rename durable
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return fd.Sync()
+}
@@ -0,0 +1,121 @@
+package lsm
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+// Memtable: a skiplist holding the latest writes in key order
+
+/*
+*
+Each node has a tower of forward pointers, level 0 links every node in order and each level
+above skips over roughly half of the nodes of the level below. A lookup starts at the top
+level of the head and moves right while the next key is smaller, then goes down a level.
+
+Deletes are stored as tombstones, they must hide older values of the key in the SSTables.
+*/
+const MAX_HEIGHT = 12
+
+type skipNode struct {
+	key     []byte
+	value   []byte
+	deleted bool // tombstone
+	next    []*skipNode
+}
+
+type memtable struct {
+	head   *skipNode
+	height int
+	rnd    *rand.Rand
+	size   int // approximate memory used by keys and values
+	count  int
+}
+
+func newMemtable() *memtable {
+	return &memtable{
+		head:   &skipNode{next: make([]*skipNode, MAX_HEIGHT)},
+		height: 1,
+		rnd:    rand.New(rand.NewSource(1)),
+	}
+}
+
+// each level is used with probability 1/2 of the level below
+func (m *memtable) randomHeight() int {
+	h := 1
+	for h < MAX_HEIGHT && m.rnd.Intn(2) == 0 {
+		h++
+	}
+	return h
+}
+
+// find the first node >= key, prev[i] is the last node < key at level i
+func (m *memtable) findGE(key []byte, prev []*skipNode) *skipNode {
+	node := m.head
+	for level := m.height - 1; level >= 0; level-- {
+		for node.next[level] != nil && bytes.Compare(node.next[level].key, key) < 0 {
+			node = node.next[level]
+		}
+		if prev != nil {
+			prev[level] = node
+		}
+	}
+	return node.next[0]
+}
+
+func (m *memtable) set(key, value []byte, deleted bool) {
+	prev := make([]*skipNode, MAX_HEIGHT)
+	node := m.findGE(key, prev)
+	if node != nil && bytes.Equal(node.key, key) {
+		m.size += len(value) - len(node.value)
+		node.value, node.deleted = value, deleted
+		return
+	}
+
+	h := m.randomHeight()
+	if h > m.height {
+		for level := m.height; level < h; level++ {
+			prev[level] = m.head
+		}
+		m.height = h
+	}
+	node = &skipNode{key: key, value: value, deleted: deleted, next: make([]*skipNode, h)}
+	for level := 0; level < h; level++ {
+		node.next[level] = prev[level].next[level]
+		prev[level].next[level] = node
+	}
+	m.size += len(key) + len(value)
+	m.count++
+}
+
+func (m *memtable) put(key, value []byte) {
+	m.set(bytes.Clone(key), bytes.Clone(value), false)
+}
+
+func (m *memtable) delete(key []byte) {
+	m.set(bytes.Clone(key), nil, true)
+}
+
+// found is true for tombstones too, they stop the lookup from going to the SSTables
+func (m *memtable) get(key []byte) (value []byte, deleted bool, found bool) {
+	node := m.findGE(key, nil)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil, false, false
+	}
+	return node.value, node.deleted, true
+}
+
+type memIter struct {
+	node *skipNode
+}
+
+func (m *memtable) seek(key []byte) *memIter {
+	return &memIter{node: m.findGE(key, nil)}
+}
+
+func (it *memIter) Valid() bool   { return it.node != nil }
+func (it *memIter) Key() []byte   { return it.node.key }
+func (it *memIter) Value() []byte { return it.node.value }
+func (it *memIter) Deleted() bool { return it.node.deleted }
+func (it *memIter) Next()         { it.node = it.node.next[0] }
+func (it *memIter) Err() error    { return nil }
@@ -0,0 +1,381 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+)
+
+// SSTable: an immutable file of sorted entries
+
+/*
+*
+File layout:
+
+	| data block | data block | ... | index block | bloom block | footer |
+
+Data blocks hold sorted entries in the WAL entry format (flag, klen, vlen, key, val) and are
+cut at about BlockSize bytes. Each block, including the index and the bloom filter, is
+followed by the crc32 of its content.
+
+The index has one entry per data block so a lookup reads a single block:
+
+	| offset | size    | klen    | first key | klen    | last key |
+	|  8B    | uvarint | uvarint |   ...     | uvarint |   ...    |
+
+The footer is fixed size and points to the index and the bloom filter:
+
+	| index offset | index size | bloom offset | bloom size | magic |
+	|      8B      |     8B     |      8B      |     8B     |  8B   |
+
+The index and the bloom filter stay in memory while the table is open.
+*/
+const SST_MAGIC = 0x4c534d5353543031 // "LSMSST01"
+const SST_FOOTER_SIZE = 40
+
+var ErrCorrupted = errors.New("lsm: corrupted file")
+
+type blockHandle struct {
+	offset uint64
+	size   uint64 // without the crc
+	first  []byte
+	last   []byte
+}
+
+type table struct {
+	num    uint64
+	path   string
+	fd     *os.File
+	size   int64
+	blocks []blockHandle
+	filter bloom
+}
+
+func (t *table) smallest() []byte { return t.blocks[0].first }
+func (t *table) largest() []byte  { return t.blocks[len(t.blocks)-1].last }
+
+// does the table have keys in [start, end]
+func (t *table) overlaps(start, end []byte) bool {
+	return bytes.Compare(t.largest(), start) >= 0 && bytes.Compare(t.smallest(), end) <= 0
+}
+
+type tableWriter struct {
+	fd     *os.File
+	w      *bufio.Writer
+	offset uint64
+	block  []byte
+	first  []byte
+	last   []byte
+	blocks []blockHandle
+	hashes [][2]uint32
+	size   int // block size
+}
+
+func newTableWriter(path string, blockSize int) (*tableWriter, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tableWriter{fd: fd, w: bufio.NewWriter(fd), size: blockSize}, nil
+}
+
+// keys must be added in increasing order
+func (tw *tableWriter) add(key, val []byte, deleted bool) error {
+	if len(tw.block) == 0 {
+		tw.first = bytes.Clone(key)
+	}
+	tw.block = appendEntry(tw.block, key, val, deleted)
+	tw.last = bytes.Clone(key)
+	h1, h2 := bloomHash(key)
+	tw.hashes = append(tw.hashes, [2]uint32{h1, h2})
+	if len(tw.block) >= tw.size {
+		return tw.flushBlock()
+	}
+	return nil
+}
+
+// write data and its crc, returns where it starts
+func (tw *tableWriter) writeChecked(data []byte) (uint64, error) {
+	offset := tw.offset
+	if _, err := tw.w.Write(data); err != nil {
+		return 0, err
+	}
+	if _, err := tw.w.Write(binary.LittleEndian.AppendUint32(nil, crc32.ChecksumIEEE(data))); err != nil {
+		return 0, err
+	}
+	tw.offset += uint64(len(data)) + 4
+	return offset, nil
+}
+
+func (tw *tableWriter) flushBlock() error {
+	if len(tw.block) == 0 {
+		return nil
+	}
+	offset, err := tw.writeChecked(tw.block)
+	if err != nil {
+		return err
+	}
+	tw.blocks = append(tw.blocks, blockHandle{offset: offset, size: uint64(len(tw.block)), first: tw.first, last: tw.last})
+	tw.block = tw.block[:0]
+	return nil
+}
+
+// bytes written so far
+func (tw *tableWriter) estimatedSize() uint64 {
+	return tw.offset + uint64(len(tw.block))
+}
+
+func (tw *tableWriter) empty() bool {
+	return len(tw.blocks) == 0 && len(tw.block) == 0
+}
+
+// write the index, the bloom filter and the footer, then fsync
+func (tw *tableWriter) finish() error {
+	if err := tw.flushBlock(); err != nil {
+		return err
+	}
+
+	var index []byte
+	for _, b := range tw.blocks {
+		index = binary.BigEndian.AppendUint64(index, b.offset)
+		index = binary.AppendUvarint(index, b.size)
+		index = binary.AppendUvarint(index, uint64(len(b.first)))
+		index = append(index, b.first...)
+		index = binary.AppendUvarint(index, uint64(len(b.last)))
+		index = append(index, b.last...)
+	}
+	indexOffset, err := tw.writeChecked(index)
+	if err != nil {
+		return err
+	}
+	filter := newBloom(tw.hashes).encode()
+	bloomOffset, err := tw.writeChecked(filter)
+	if err != nil {
+		return err
+	}
+
+	footer := make([]byte, SST_FOOTER_SIZE)
+	binary.BigEndian.PutUint64(footer[0:], indexOffset)
+	binary.BigEndian.PutUint64(footer[8:], uint64(len(index)))
+	binary.BigEndian.PutUint64(footer[16:], bloomOffset)
+	binary.BigEndian.PutUint64(footer[24:], uint64(len(filter)))
+	binary.BigEndian.PutUint64(footer[32:], SST_MAGIC)
+	if _, err := tw.w.Write(footer); err != nil {
+		return err
+	}
+	if err := tw.w.Flush(); err != nil {
+		return err
+	}
+	if err := tw.fd.Sync(); err != nil {
+		return err
+	}
+	return tw.fd.Close()
+}
+
+// give up on a table that is being written
+func (tw *tableWriter) abort() {
+	tw.fd.Close()
+	os.Remove(tw.fd.Name())
+}
+
+func openTable(path string, num uint64) (*table, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := loadTable(fd, num)
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	t.path = path
+	return t, nil
+}
+
+func loadTable(fd *os.File, num uint64) (*table, error) {
+	info, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t := &table{num: num, fd: fd, size: info.Size()}
+	if t.size < SST_FOOTER_SIZE {
+		return nil, ErrCorrupted
+	}
+	footer := make([]byte, SST_FOOTER_SIZE)
+	if _, err := fd.ReadAt(footer, t.size-SST_FOOTER_SIZE); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint64(footer[32:]) != SST_MAGIC {
+		return nil, ErrCorrupted
+	}
+
+	index, err := t.readBlock(binary.BigEndian.Uint64(footer[0:]), binary.BigEndian.Uint64(footer[8:]))
+	if err != nil {
+		return nil, err
+	}
+	filter, err := t.readBlock(binary.BigEndian.Uint64(footer[16:]), binary.BigEndian.Uint64(footer[24:]))
+	if err != nil {
+		return nil, err
+	}
+	t.filter = decodeBloom(filter)
+
+	for len(index) > 0 {
+		var b blockHandle
+		var ok bool
+		if len(index) < 8 {
+			return nil, ErrCorrupted
+		}
+		b.offset = binary.BigEndian.Uint64(index)
+		index = index[8:]
+		if b.size, index, ok = readUvarint(index); !ok {
+			return nil, ErrCorrupted
+		}
+		if b.first, index, ok = readBytes(index); !ok {
+			return nil, ErrCorrupted
+		}
+		if b.last, index, ok = readBytes(index); !ok {
+			return nil, ErrCorrupted
+		}
+		t.blocks = append(t.blocks, b)
+	}
+	if len(t.blocks) == 0 {
+		return nil, ErrCorrupted // empty tables are never written
+	}
+	return t, nil
+}
+
+func readUvarint(data []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, false
+	}
+	return v, data[n:], true
+}
+
+func readBytes(data []byte) ([]byte, []byte, bool) {
+	n, data, ok := readUvarint(data)
+	if !ok || uint64(len(data)) < n {
+		return nil, nil, false
+	}
+	return data[:n], data[n:], true
+}
+
+// read a block and check its crc
+func (t *table) readBlock(offset, size uint64) ([]byte, error) {
+	if offset+size+4 > uint64(t.size) {
+		return nil, ErrCorrupted
+	}
+	data := make([]byte, size+4)
+	if _, err := t.fd.ReadAt(data, int64(offset)); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data[:size]) != binary.LittleEndian.Uint32(data[size:]) {
+		return nil, ErrCorrupted
+	}
+	return data[:size], nil
+}
+
+// the first block whose last key is >= key
+func (t *table) findBlock(key []byte) int {
+	return sort.Search(len(t.blocks), func(i int) bool {
+		return bytes.Compare(t.blocks[i].last, key) >= 0
+	})
+}
+
+// found is true for tombstones too
+func (t *table) get(key []byte) (val []byte, deleted bool, found bool, err error) {
+	if !t.filter.mayContain(key) {
+		return nil, false, false, nil
+	}
+	i := t.findBlock(key)
+	if i == len(t.blocks) || bytes.Compare(t.blocks[i].first, key) > 0 {
+		return nil, false, false, nil
+	}
+	data, err := t.readBlock(t.blocks[i].offset, t.blocks[i].size)
+	if err != nil {
+		return nil, false, false, err
+	}
+	for len(data) > 0 {
+		k, v, del, n := decodeEntry(data)
+		if n == 0 {
+			return nil, false, false, ErrCorrupted
+		}
+		switch bytes.Compare(k, key) {
+		case 0:
+			return v, del, true, nil
+		case 1:
+			return nil, false, false, nil
+		}
+		data = data[n:]
+	}
+	return nil, false, false, nil
+}
+
+func (t *table) close() error {
+	return t.fd.Close()
+}
+
+// iterate the entries of a table one block at a time
+type tableIter struct {
+	t     *table
+	block int
+	data  []byte // rest of the current block
+	key   []byte
+	val   []byte
+	del   bool
+	valid bool
+	err   error
+}
+
+// position at the first key >= key
+func (t *table) seek(key []byte) *tableIter {
+	it := &tableIter{t: t, block: t.findBlock(key)}
+	if it.block < len(t.blocks) {
+		it.loadBlock()
+	}
+	it.Next()
+	for it.valid && bytes.Compare(it.key, key) < 0 {
+		it.Next()
+	}
+	return it
+}
+
+func (it *tableIter) loadBlock() {
+	b := it.t.blocks[it.block]
+	it.data, it.err = it.t.readBlock(b.offset, b.size)
+}
+
+func (it *tableIter) Next() {
+	it.valid = false
+	if it.err != nil {
+		return
+	}
+	for len(it.data) == 0 {
+		if it.block >= len(it.t.blocks)-1 {
+			it.block = len(it.t.blocks)
+			return
+		}
+		it.block++
+		if it.loadBlock(); it.err != nil {
+			return
+		}
+	}
+	k, v, del, n := decodeEntry(it.data)
+	if n == 0 {
+		it.err = ErrCorrupted
+		return
+	}
+	it.key, it.val, it.del, it.valid = k, v, del, true
+	it.data = it.data[n:]
+}
+
+func (it *tableIter) Valid() bool   { return it.valid }
+func (it *tableIter) Key() []byte   { return it.key }
+func (it *tableIter) Value() []byte { return it.val }
+func (it *tableIter) Deleted() bool { return it.del }
+func (it *tableIter) Err() error    { return it.err }
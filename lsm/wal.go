@@ -0,0 +1,164 @@
+package lsm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Write-ahead log: every write is appended here before it goes into the memtable
+
+/*
+*
+Record format:
+
+	| crc32 | len | flag | klen    | vlen    | key | val |
+	|  4B   | 4B  |  1B  | uvarint | uvarint | ... | ... |
+
+crc32 covers everything after len. The log only holds the writes of the current memtable,
+it is truncated after the memtable is flushed to an SSTable.
+
+Replaying stops at the first torn or corrupted record, that is the write we crashed in the
+middle of, and it was never acknowledged. A len past the end of the file is torn too, it is
+checked before the payload is allocated so a garbage len can't ask for gigabytes.
+*/
+const (
+	FLAG_PUT    = 0
+	FLAG_DELETE = 1
+)
+
+type wal struct {
+	fd   *os.File
+	sync bool
+	buf  []byte
+}
+
+func openWAL(path string, sync bool) (*wal, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &wal{fd: fd, sync: sync}, nil
+}
+
+func appendEntry(out []byte, key, val []byte, deleted bool) []byte {
+	flag := byte(FLAG_PUT)
+	if deleted {
+		flag = FLAG_DELETE
+	}
+	out = append(out, flag)
+	out = binary.AppendUvarint(out, uint64(len(key)))
+	out = binary.AppendUvarint(out, uint64(len(val)))
+	out = append(out, key...)
+	return append(out, val...)
+}
+
+// decode one entry, returns the number of bytes used or 0 if data is too short
+func decodeEntry(data []byte) (key, val []byte, deleted bool, n int) {
+	if len(data) < 1 {
+		return nil, nil, false, 0
+	}
+	deleted = data[0] == FLAG_DELETE
+	pos := 1
+	klen, k := binary.Uvarint(data[pos:])
+	if k <= 0 {
+		return nil, nil, false, 0
+	}
+	pos += k
+	vlen, k := binary.Uvarint(data[pos:])
+	if k <= 0 {
+		return nil, nil, false, 0
+	}
+	pos += k
+	if uint64(len(data)-pos) < klen || uint64(len(data)-pos)-klen < vlen {
+		return nil, nil, false, 0
+	}
+	key = data[pos : pos+int(klen)]
+	pos += int(klen)
+	val = data[pos : pos+int(vlen)]
+	pos += int(vlen)
+	return key, val, deleted, pos
+}
+
+func (w *wal) append(key, val []byte, deleted bool) error {
+	payload := appendEntry(w.buf[:0], key, val, deleted)
+	record := make([]byte, 8, 8+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(payload)))
+	record = append(record, payload...)
+	w.buf = payload
+
+	if _, err := w.fd.Write(record); err != nil {
+		return err
+	}
+	if w.sync {
+		return w.fd.Sync()
+	}
+	return nil
+}
+
+// read the log into the memtable and cut off the torn tail
+func (w *wal) replay(mem *memtable) error {
+	info, err := w.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := w.fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(w.fd)
+	var good int64
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+		if size > info.Size()-good-8 {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return err
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[0:4]) {
+			break
+		}
+		key, val, deleted, n := decodeEntry(payload)
+		if n != len(payload) {
+			break
+		}
+		mem.set(key, val, deleted)
+		good += int64(8 + len(payload))
+	}
+
+	if err := w.fd.Truncate(good); err != nil {
+		return err
+	}
+	_, err = w.fd.Seek(good, io.SeekStart)
+	return err
+}
+
+// drop everything, called after the memtable is safely in an SSTable
+func (w *wal) reset() error {
+	if err := w.fd.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.fd.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.fd.Sync()
+}
+
+func (w *wal) close() error {
+	return w.fd.Close()
+}
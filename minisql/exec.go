@@ -0,0 +1,641 @@
+package minisql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"building-a-db/db"
+	"building-a-db/query"
+)
+
+// Executing statements with the query operators
+
+/*
+*
+A minimal SQL over the key-value store. A table is the keys under the prefix of its name
+and a 0 byte, the rest of the key is the primary key. Every table has the same two columns,
+key and value, and exists as soon as it has a row, there is no CREATE TABLE. The prefixes
+only work when the KV uses COMPARE_BYTES, callers check it with CheckOrder:
+
+	INSERT INTO t [(key, value)] VALUES (k, v) [, (k, v)]...
+	DELETE FROM t [WHERE conds]
+	SELECT * | items FROM t [alias] [[INNER | LEFT] JOIN t2 [alias] ON col = col]
+	    [WHERE conds] [GROUP BY col] [HAVING conds] [LIMIT n]
+
+An item is a column or COUNT(*), COUNT, SUM, AVG, MIN or MAX of one, conds are comparisons
+of a column (or an item in HAVING) with a constant joined by AND. Constants are 'strings'
+or numbers, or placeholders: ? or $n. Two values compare as numbers when both are decimal
+numbers and as bytes otherwise.
+
+A join on the key of the right table is an index join with point gets, a join on its value
+is a hash join, query.PlanJoin picks it. GROUP BY and aggregates go through
+query.Aggregate. Statements run in a transaction, BEGIN, COMMIT and ROLLBACK are parsed for
+the callers that manage them.
+*/
+type Tx interface {
+	query.Reader
+	Set(key, val []byte) error
+	Del(key []byte) (bool, error)
+}
+
+type Type int
+
+const (
+	TYPE_BYTES Type = iota // []byte
+	TYPE_INT               // int64, COUNT
+	TYPE_FLOAT             // float64, the other aggregates
+)
+
+type Result struct {
+	Columns      []string
+	Types        []Type
+	Rows         [][]any // nil is NULL
+	RowsAffected int64   // rows inserted or deleted
+}
+
+var (
+	ErrNotStatement = errors.New("BEGIN, COMMIT and ROLLBACK are handled by the caller")
+	ErrOrder        = errors.New("tables need a KV ordered by bytes")
+)
+
+// stops a scan at the LIMIT
+var errLimit = errors.New("limit reached")
+
+// a table is a key prefix, its rows are only next to each other in byte order
+func CheckOrder(kv *db.KV) error {
+	if kv.Comparator.Name != db.COMPARE_BYTES.Name {
+		return fmt.Errorf("%w, it is ordered by %q", ErrOrder, kv.Comparator.Name)
+	}
+	return nil
+}
+
+// parse and run a statement in its own transaction
+func Exec(kv *db.KV, sql string, args ...any) (*Result, error) {
+	if err := CheckOrder(kv); err != nil {
+		return nil, err
+	}
+	stmt, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := kv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	res, err := stmt.Run(tx, args)
+	if err != nil {
+		tx.Abort()
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+// run the statement in tx with an argument per placeholder: a string, []byte, an integer
+// or a float. The caller commits
+func (s *Stmt) Run(tx Tx, args []any) (*Result, error) {
+	if len(args) != s.params {
+		return nil, fmt.Errorf("%d arguments for %d placeholders", len(args), s.params)
+	}
+	vals := make([][]byte, len(args))
+	for i, arg := range args {
+		var err error
+		if vals[i], err = argBytes(arg); err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+	}
+	b := &binder{args: vals}
+
+	switch s.kind {
+	case STMT_INSERT:
+		return s.insert(tx, b)
+	case STMT_DELETE:
+		return s.delete(tx, b)
+	case STMT_SELECT:
+		return s.query(tx, b)
+	}
+	return nil, ErrNotStatement
+}
+
+func argBytes(arg any) ([]byte, error) {
+	switch v := arg.(type) {
+	case string:
+		return []byte(v), nil
+	case []byte:
+		return v, nil
+	case int:
+		return strconv.AppendInt(nil, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(nil, v, 10), nil
+	case float64:
+		return strconv.AppendFloat(nil, v, 'f', -1, 64), nil
+	case nil:
+		return nil, errors.New("NULL can't be stored")
+	}
+	return nil, fmt.Errorf("unsupported type %T", arg)
+}
+
+type binder struct {
+	args [][]byte
+}
+
+func (b *binder) value(l literal) []byte {
+	if l.param >= 0 {
+		return b.args[l.param]
+	}
+	return []byte(l.val)
+}
+
+// the key prefix of a table
+func tablePrefix(name string) []byte {
+	return append([]byte(name), 0)
+}
+
+func (s *Stmt) insert(tx Tx, b *binder) (*Result, error) {
+	prefix := tablePrefix(s.table.name)
+	for _, row := range s.values {
+		key := append(bytes.Clone(prefix), b.value(row[0])...)
+		if err := tx.Set(key, b.value(row[1])); err != nil {
+			return nil, err
+		}
+	}
+	return &Result{RowsAffected: int64(len(s.values))}, nil
+}
+
+func (s *Stmt) delete(tx Tx, b *binder) (*Result, error) {
+	p, err := s.plan(tx, b)
+	if err != nil {
+		return nil, err
+	}
+	// collect the keys first, the scan can't run over a tree that changes
+	var keys [][]byte
+	err = p.each(func(t *tuple) error {
+		keys = append(keys, t.rows[0].Key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if _, err := tx.Del(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Result{RowsAffected: int64(len(keys))}, nil
+}
+
+// Planning a SELECT
+
+// a row of the FROM table and, with a join, its match
+type tuple struct {
+	rows    [2]query.Row
+	present [2]bool
+}
+
+// a column: the table (0 is FROM, 1 is JOIN) and the field
+type column struct {
+	side  int
+	value bool // the value, else the primary key
+}
+
+type plan struct {
+	stmt   *Stmt
+	tables [2]*query.Table
+	names  [2]string // aliases
+	where  []func(t *tuple) bool
+	source func() (func() (*tuple, bool, error), error)
+}
+
+func (c column) get(p *plan, t *tuple) []byte {
+	if !t.present[c.side] {
+		return nil
+	}
+	row := t.rows[c.side]
+	if c.value {
+		return row.Val
+	}
+	return p.tables[c.side].PrimaryKey(row)
+}
+
+func (p *plan) resolve(ref colRef) (column, error) {
+	var c column
+	switch ref.name {
+	case "key":
+	case "value":
+		c.value = true
+	default:
+		return c, fmt.Errorf("no column %q, a table has key and value", ref.String())
+	}
+	switch {
+	case ref.table == p.names[0]:
+	case p.tables[1] != nil && ref.table == p.names[1]:
+		c.side = 1
+	case ref.table == "" && p.tables[1] != nil:
+		return c, fmt.Errorf("column %q is ambiguous, qualify it with a table", ref.name)
+	case ref.table != "":
+		return c, fmt.Errorf("no table %q", ref.table)
+	}
+	return c, nil
+}
+
+func (s *Stmt) plan(tx Tx, b *binder) (*plan, error) {
+	store := query.KV(tx)
+	p := &plan{stmt: s}
+	p.tables[0] = &query.Table{Store: store, Prefix: tablePrefix(s.table.name)}
+	p.names[0] = s.table.alias
+	if s.join != nil {
+		if s.join.table.alias == s.table.alias {
+			return nil, fmt.Errorf("table %q is used twice, give it an alias", s.table.alias)
+		}
+		p.tables[1] = &query.Table{
+			Store:   store,
+			Prefix:  tablePrefix(s.join.table.name),
+			Columns: map[string]query.KeyFunc{"value": query.Val},
+		}
+		p.names[1] = s.join.table.alias
+	}
+
+	for _, c := range s.where {
+		col, err := p.resolve(c.left.col)
+		if err != nil {
+			return nil, err
+		}
+		want, op := b.value(c.right), c.op
+		p.where = append(p.where, func(t *tuple) bool {
+			v := col.get(p, t)
+			return v != nil && compareOp(compare(v, want), op)
+		})
+	}
+
+	if s.join == nil {
+		p.source = func() (func() (*tuple, bool, error), error) {
+			rows := p.tables[0].Scan()
+			return func() (*tuple, bool, error) {
+				row, ok, err := rows.Next()
+				return &tuple{rows: [2]query.Row{row}, present: [2]bool{true}}, ok, err
+			}, nil
+		}
+		return p, nil
+	}
+
+	left, err := p.resolve(s.join.left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.resolve(s.join.right)
+	if err != nil {
+		return nil, err
+	}
+	if left.side == right.side {
+		return nil, errors.New("JOIN ... ON compares a column of each table")
+	}
+	if left.side == 1 {
+		left, right = right, left
+	}
+	joinColumn := query.PRIMARY_KEY
+	if right.value {
+		joinColumn = "value"
+	}
+	join := &query.Join{Kind: s.join.kind, LeftKey: func(row query.Row) []byte {
+		return left.get(p, &tuple{rows: [2]query.Row{row}, present: [2]bool{true}})
+	}}
+	p.source = func() (func() (*tuple, bool, error), error) {
+		joined, _, err := query.PlanJoin(p.tables[0].Scan(), p.tables[1], joinColumn, join)
+		if err != nil {
+			return nil, err
+		}
+		return func() (*tuple, bool, error) {
+			row, ok, err := joined.Next()
+			t := &tuple{rows: [2]query.Row{row.Left, row.Right}, present: [2]bool{true, row.Matched}}
+			return t, ok, err
+		}, nil
+	}
+	return p, nil
+}
+
+// call fn for every tuple that passes WHERE
+func (p *plan) each(fn func(t *tuple) error) error {
+	next, err := p.source()
+	if err != nil {
+		return err
+	}
+	for {
+		t, ok, err := next()
+		if err != nil || !ok {
+			return err
+		}
+		keep := true
+		for _, w := range p.where {
+			keep = keep && w(t)
+		}
+		if !keep {
+			continue
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+}
+
+// compare as numbers when both are numbers
+func compare(a, b []byte) int {
+	x, errA := strconv.ParseFloat(string(a), 64)
+	y, errB := strconv.ParseFloat(string(b), 64)
+	if errA != nil || errB != nil {
+		return bytes.Compare(a, b)
+	}
+	return compareFloat(x, y)
+}
+
+func compareFloat(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func compareOp(c int, op string) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// Running a SELECT
+
+// the names and types of the columns of a SELECT, known before it runs, nil for the other
+// statements
+func (s *Stmt) Columns() ([]string, []Type) {
+	if s.kind != STMT_SELECT {
+		return nil, nil
+	}
+	if s.items == nil {
+		names := []string{"key", "value"}
+		if s.join != nil {
+			names = append(names, "key", "value")
+		}
+		return names, make([]Type, len(names))
+	}
+	var names []string
+	var types []Type
+	for _, it := range s.items {
+		switch {
+		case it.agg == "":
+			names, types = append(names, it.col.name), append(types, TYPE_BYTES)
+		case it.agg == "count":
+			names, types = append(names, it.agg), append(types, TYPE_INT)
+		default:
+			names, types = append(names, it.agg), append(types, TYPE_FLOAT)
+		}
+	}
+	return names, types
+}
+
+func (s *Stmt) query(tx Tx, b *binder) (*Result, error) {
+	p, err := s.plan(tx, b)
+	if err != nil {
+		return nil, err
+	}
+	grouped := s.groupBy != nil || len(s.having) > 0
+	for _, it := range s.items {
+		grouped = grouped || it.agg != ""
+	}
+	if grouped {
+		return p.aggregate(b)
+	}
+
+	res := &Result{}
+	res.Columns, res.Types = s.Columns()
+	var cols []column
+	if s.items == nil {
+		for side := range p.tables {
+			if p.tables[side] != nil {
+				cols = append(cols, column{side: side}, column{side: side, value: true})
+			}
+		}
+	}
+	for _, it := range s.items {
+		col, err := p.resolve(it.col)
+		if err != nil {
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+
+	err = p.each(func(t *tuple) error {
+		if s.limit >= 0 && len(res.Rows) == s.limit {
+			return errLimit
+		}
+		row := make([]any, len(cols))
+		for i, col := range cols {
+			if v := col.get(p, t); v != nil {
+				row[i] = v
+			}
+		}
+		res.Rows = append(res.Rows, row)
+		return nil
+	})
+	if err != nil && err != errLimit {
+		return nil, err
+	}
+	return res, nil
+}
+
+/*
+*
+The operators of package query aggregate rows, so each tuple becomes a row whose value
+holds the 4 columns of the tuple: for each a byte that is 0 for NULL, then the length as a
+uvarint and the bytes.
+*/
+func encodeTuple(p *plan, t *tuple) []byte {
+	var out []byte
+	for _, col := range []column{{0, false}, {0, true}, {1, false}, {1, true}} {
+		v := col.get(p, t)
+		if v == nil {
+			out = append(out, 0)
+			continue
+		}
+		out = append(out, 1)
+		out = binary.AppendUvarint(out, uint64(len(v)))
+		out = append(out, v...)
+	}
+	return out
+}
+
+// the column of a row made by encodeTuple
+func tupleColumn(col column) query.KeyFunc {
+	skip := col.side * 2
+	if col.value {
+		skip++
+	}
+	return func(row query.Row) []byte {
+		buf := row.Val
+		for i := 0; ; i++ {
+			null := buf[0] == 0
+			buf = buf[1:]
+			var n uint64
+			if !null {
+				var size int
+				n, size = binary.Uvarint(buf)
+				buf = buf[size:]
+			}
+			if i == skip {
+				if null {
+					return nil
+				}
+				return buf[:n]
+			}
+			buf = buf[n:]
+		}
+	}
+}
+
+// not NULL counts as 1, for COUNT(col)
+func notNull(col query.KeyFunc) query.Expr {
+	return func(row query.Row) (float64, bool, error) {
+		return 1, col(row) != nil, nil
+	}
+}
+
+func (p *plan) aggregate(b *binder) (*Result, error) {
+	s := p.stmt
+	if s.items == nil {
+		return nil, errors.New("SELECT * can't be grouped")
+	}
+	a := &query.Aggregation{}
+	var group column
+	if s.groupBy != nil {
+		var err error
+		if group, err = p.resolve(*s.groupBy); err != nil {
+			return nil, err
+		}
+		a.GroupBy = tupleColumn(group)
+	}
+
+	// an output column is the group key (-1) or an aggregate of a.Aggs
+	addItem := func(it item) (int, error) {
+		if it.agg == "" {
+			col, err := p.resolve(it.col)
+			if err != nil {
+				return 0, err
+			}
+			if s.groupBy == nil || col != group {
+				return 0, fmt.Errorf("column %q must be in GROUP BY or an aggregate", it.col.String())
+			}
+			return -1, nil
+		}
+		agg := query.Count()
+		if !it.star {
+			col, err := p.resolve(it.col)
+			if err != nil {
+				return 0, err
+			}
+			expr := query.Number(tupleColumn(col))
+			switch aggFuncs[it.agg] {
+			case query.AGG_COUNT:
+				agg = query.CountOf(notNull(tupleColumn(col)))
+			case query.AGG_SUM:
+				agg = query.Sum(expr)
+			case query.AGG_AVG:
+				agg = query.Avg(expr)
+			case query.AGG_MIN:
+				agg = query.Min(expr)
+			case query.AGG_MAX:
+				agg = query.Max(expr)
+			}
+		}
+		a.Aggs = append(a.Aggs, agg)
+		return len(a.Aggs) - 1, nil
+	}
+
+	res := &Result{}
+	res.Columns, res.Types = s.Columns()
+	var outputs []int
+	for _, it := range s.items {
+		i, err := addItem(it)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, i)
+	}
+
+	var having []func(g query.Group) bool
+	for _, c := range s.having {
+		i, err := addItem(c.left)
+		if err != nil {
+			return nil, err
+		}
+		want, op := b.value(c.right), c.op
+		if i < 0 {
+			having = append(having, func(g query.Group) bool {
+				return compareOp(compare(g.Key, want), op)
+			})
+			continue
+		}
+		num, err := strconv.ParseFloat(string(want), 64)
+		if err != nil {
+			return nil, fmt.Errorf("HAVING compares an aggregate with %q, not a number", want)
+		}
+		having = append(having, func(g query.Group) bool {
+			v := g.Values[i]
+			return !v.Null && compareOp(compareFloat(v.Num, num), op)
+		})
+	}
+	if len(having) > 0 {
+		a.Having = func(g query.Group) bool {
+			for _, h := range having {
+				if !h(g) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	var rows []query.Row
+	err := p.each(func(t *tuple) error {
+		rows = append(rows, query.Row{Val: encodeTuple(p, t)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	groups := query.Aggregate(query.FromSlice(rows), a)
+	for s.limit < 0 || len(res.Rows) < s.limit {
+		g, ok, err := groups.Next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		row := make([]any, len(outputs))
+		for j, i := range outputs {
+			switch {
+			case i < 0:
+				row[j] = g.Key
+			case g.Values[i].Null:
+			case res.Types[j] == TYPE_INT:
+				row[j] = int64(g.Values[i].Num)
+			default:
+				row[j] = g.Values[i].Num
+			}
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	return res, nil
+}
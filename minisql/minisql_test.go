@@ -0,0 +1,221 @@
+package minisql
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"building-a-db/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Open a database in a fresh directory
+func openKV(t *testing.T) *db.KV {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	t.Cleanup(func() { kv.Close() })
+	return kv
+}
+
+// Helper: Users and their orders, the value of an order is the user key
+func shopKV(t *testing.T) *db.KV {
+	kv := openKV(t)
+	_, err := Exec(kv, "INSERT INTO users VALUES ('u1', 'alice'), ('u2', 'bob'), ('u3', 'carol')")
+	assert.NoError(t, err)
+	_, err = Exec(kv, "INSERT INTO orders (key, value) VALUES ('o1', 'u1'), ('o2', 'u1'), ('o3', 'u2'), ('o4', 'u9')")
+	assert.NoError(t, err)
+	_, err = Exec(kv, "INSERT INTO amounts VALUES ('o1', 10), ('o2', 5), ('o3', 7), ('o4', 1)")
+	assert.NoError(t, err)
+	return kv
+}
+
+// Helper: The rows of a query, []byte turned into strings
+func rows(t *testing.T, kv *db.KV, sql string, args ...any) [][]any {
+	res, err := Exec(kv, sql, args...)
+	if !assert.NoError(t, err, sql) {
+		return nil
+	}
+	out := [][]any{}
+	for _, row := range res.Rows {
+		r := make([]any, len(row))
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			r[i] = v
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func TestParse(t *testing.T) {
+	t.Run("Statements and placeholders", func(t *testing.T) {
+		stmt, err := Parse("select key from t where value = ? and key > ?;")
+		assert.NoError(t, err)
+		assert.Equal(t, STMT_SELECT, stmt.Kind())
+		assert.Equal(t, 2, stmt.NumInput())
+
+		names, types := stmt.Columns()
+		assert.Equal(t, []string{"key"}, names)
+		assert.Equal(t, []Type{TYPE_BYTES}, types)
+
+		stmt, err = Parse("SELECT value, COUNT(*), AVG(key) FROM t GROUP BY value")
+		assert.NoError(t, err)
+		names, types = stmt.Columns()
+		assert.Equal(t, []string{"value", "count", "avg"}, names)
+		assert.Equal(t, []Type{TYPE_BYTES, TYPE_INT, TYPE_FLOAT}, types)
+
+		stmt, err = Parse("INSERT INTO t VALUES ($2, $1)")
+		assert.NoError(t, err)
+		assert.Equal(t, STMT_INSERT, stmt.Kind())
+		assert.Equal(t, 2, stmt.NumInput())
+
+		for sql, kind := range map[string]StmtKind{"BEGIN": STMT_BEGIN, "start transaction": STMT_BEGIN, "COMMIT;": STMT_COMMIT, "rollback": STMT_ROLLBACK} {
+			stmt, err := Parse(sql)
+			assert.NoError(t, err)
+			assert.Equal(t, kind, stmt.Kind())
+		}
+	})
+
+	// Edge cases
+	t.Run("Syntax errors", func(t *testing.T) {
+		for _, sql := range []string{
+			"",
+			"UPDATE t SET value = 1",
+			"SELECT FROM t",
+			"SELECT key FROM t WHERE",
+			"SELECT key FROM t WHERE key LIKE 'a'",
+			"SELECT key FROM t LIMIT -1",
+			"INSERT INTO t (a, b) VALUES ('k', 'v')",
+			"INSERT INTO t VALUES ('unterminated)",
+			"SELECT key FROM t WHERE key = ? AND value = $1",
+			"SELECT key FROM t; SELECT key FROM t",
+		} {
+			_, err := Parse(sql)
+			assert.True(t, errors.Is(err, ErrSyntax), "%q: %v", sql, err)
+		}
+	})
+}
+
+func TestSelect(t *testing.T) {
+	t.Run("Rows of a table in key order", func(t *testing.T) {
+		kv := shopKV(t)
+		assert.Equal(t, [][]any{{"u1", "alice"}, {"u2", "bob"}, {"u3", "carol"}}, rows(t, kv, "SELECT * FROM users"))
+		assert.Equal(t, [][]any{{"bob"}}, rows(t, kv, "SELECT value FROM users WHERE key = ?", "u2"))
+		assert.Equal(t, [][]any{{"u2"}, {"u3"}}, rows(t, kv, "SELECT key FROM users WHERE key >= 'u2' AND value != 'x'"))
+		assert.Equal(t, [][]any{{"u1"}}, rows(t, kv, "SELECT key FROM users LIMIT 1"))
+	})
+
+	t.Run("Numbers compare as numbers", func(t *testing.T) {
+		kv := shopKV(t)
+		assert.Equal(t, [][]any{{"o1"}, {"o3"}}, rows(t, kv, "SELECT key FROM amounts WHERE value > 6"))
+	})
+
+	t.Run("Join on the primary key", func(t *testing.T) {
+		kv := shopKV(t)
+		got := rows(t, kv, "SELECT o.key, u.value FROM orders o JOIN users u ON o.value = u.key")
+		assert.Equal(t, [][]any{{"o1", "alice"}, {"o2", "alice"}, {"o3", "bob"}}, got)
+
+		got = rows(t, kv, "SELECT o.key, u.value FROM orders AS o LEFT JOIN users AS u ON u.key = o.value")
+		assert.Equal(t, [][]any{{"o1", "alice"}, {"o2", "alice"}, {"o3", "bob"}, {"o4", nil}}, got)
+	})
+
+	t.Run("Join on the value", func(t *testing.T) {
+		kv := shopKV(t)
+		got := rows(t, kv, "SELECT users.value, orders.key FROM users JOIN orders ON users.key = orders.value")
+		assert.Equal(t, [][]any{{"alice", "o1"}, {"alice", "o2"}, {"bob", "o3"}}, got)
+	})
+
+	t.Run("Aggregates", func(t *testing.T) {
+		kv := shopKV(t)
+		assert.Equal(t, [][]any{{int64(4), 23.0, 1.0, 10.0, 5.75}},
+			rows(t, kv, "SELECT COUNT(*), SUM(value), MIN(value), MAX(value), AVG(value) FROM amounts"))
+		assert.Equal(t, [][]any{{int64(0), nil}}, rows(t, kv, "SELECT count(*), sum(value) FROM nothing"))
+	})
+
+	t.Run("Group by over a join", func(t *testing.T) {
+		kv := shopKV(t)
+		sql := "SELECT o.value, COUNT(*), SUM(a.value) FROM orders o JOIN amounts a ON o.key = a.key GROUP BY o.value"
+		assert.Equal(t, [][]any{{"u1", int64(2), 15.0}, {"u2", int64(1), 7.0}, {"u9", int64(1), 1.0}}, rows(t, kv, sql))
+
+		assert.Equal(t, [][]any{{"u1", int64(2)}}, rows(t, kv, "SELECT o.value, COUNT(*) FROM orders o "+
+			"JOIN amounts a ON o.key = a.key GROUP BY o.value HAVING SUM(a.value) > ? AND o.value != 'u3'", 10))
+	})
+
+	t.Run("COUNT of a column skips NULLs", func(t *testing.T) {
+		kv := shopKV(t)
+		got := rows(t, kv, "SELECT COUNT(*), COUNT(u.key) FROM orders o LEFT JOIN users u ON o.value = u.key")
+		assert.Equal(t, [][]any{{int64(4), int64(3)}}, got)
+	})
+
+	t.Run("Reads see the writes of the transaction", func(t *testing.T) {
+		kv := shopKV(t)
+		tx, err := kv.Begin()
+		assert.NoError(t, err)
+		defer tx.Abort()
+		insert, _ := Parse("INSERT INTO users VALUES (?, ?)")
+		_, err = insert.Run(tx, []any{"u4", []byte("dave")})
+		assert.NoError(t, err)
+		count, _ := Parse("SELECT COUNT(*) FROM users")
+		res, err := count.Run(tx, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, [][]any{{int64(4)}}, res.Rows)
+	})
+
+	// Edge cases
+	t.Run("Errors", func(t *testing.T) {
+		kv := shopKV(t)
+		for _, sql := range []string{
+			"SELECT name FROM users",
+			"SELECT key FROM orders JOIN users ON orders.value = users.key",
+			"SELECT x.key FROM users",
+			"SELECT * FROM users JOIN users ON users.key = users.key",
+			"SELECT o.key FROM orders o JOIN users u ON o.key = o.value",
+			"SELECT key, COUNT(*) FROM users",
+			"SELECT * FROM users GROUP BY key",
+			"SELECT SUM(value) FROM users",
+			"SELECT COUNT(*) FROM amounts HAVING SUM(value) > 'x'",
+			"BEGIN",
+		} {
+			_, err := Exec(kv, sql)
+			assert.Error(t, err, sql)
+		}
+		_, err := Exec(kv, "SELECT key FROM users WHERE key = ?")
+		assert.Error(t, err)
+	})
+
+	t.Run("Only in byte order", func(t *testing.T) {
+		kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db"), Comparator: db.COMPARE_NOCASE}
+		assert.NoError(t, kv.Open())
+		defer kv.Close()
+		_, err := Exec(kv, "SELECT * FROM users")
+		assert.ErrorIs(t, err, ErrOrder)
+		assert.ErrorContains(t, err, `"nocase"`)
+	})
+}
+
+func TestDelete(t *testing.T) {
+	t.Run("Delete matching rows", func(t *testing.T) {
+		kv := shopKV(t)
+		res, err := Exec(kv, "DELETE FROM amounts WHERE value < 6")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, res.RowsAffected)
+		assert.Equal(t, [][]any{{"o1"}, {"o3"}}, rows(t, kv, "SELECT key FROM amounts"))
+
+		res, err = Exec(kv, "DELETE FROM amounts")
+		assert.NoError(t, err)
+		assert.EqualValues(t, 2, res.RowsAffected)
+		assert.Equal(t, [][]any{}, rows(t, kv, "SELECT key FROM amounts"))
+		assert.Len(t, rows(t, kv, "SELECT key FROM users"), 3)
+	})
+
+	// Edge cases
+	t.Run("A bad argument writes nothing", func(t *testing.T) {
+		kv := shopKV(t)
+		_, err := Exec(kv, "INSERT INTO users VALUES ('u4', 'dave'), ('u5', ?)", struct{}{})
+		assert.Error(t, err)
+		assert.Len(t, rows(t, kv, "SELECT key FROM users"), 3)
+	})
+}
@@ -0,0 +1,553 @@
+package minisql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"building-a-db/query"
+)
+
+// Lexer and parser
+
+var ErrSyntax = errors.New("syntax error")
+
+type tokenKind int
+
+const (
+	TOK_EOF    tokenKind = iota
+	TOK_IDENT            // identifiers and keywords
+	TOK_STRING           // 'quoted', '' is a quote
+	TOK_NUMBER
+	TOK_PARAM  // ? or $n
+	TOK_SYMBOL // , ( ) * . ; = != <> < <= > >=
+)
+
+type token struct {
+	kind tokenKind
+	text string // the unquoted string, the lowercased identifier
+	pos  int
+}
+
+func lex(sql string) ([]token, error) {
+	var toks []token
+	for i := 0; ; {
+		for i < len(sql) && strings.IndexByte(" \t\r\n", sql[i]) >= 0 {
+			i++
+		}
+		if i == len(sql) {
+			return append(toks, token{kind: TOK_EOF, pos: i}), nil
+		}
+		start := i
+		c := sql[i]
+		switch {
+		case isIdentByte(c, true):
+			for i < len(sql) && isIdentByte(sql[i], false) {
+				i++
+			}
+			toks = append(toks, token{TOK_IDENT, strings.ToLower(sql[start:i]), start})
+		case isDigit(c) || (c == '-' && i+1 < len(sql) && isDigit(sql[i+1])):
+			i++
+			for i < len(sql) && (isDigit(sql[i]) || sql[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{TOK_NUMBER, sql[start:i], start})
+		case c == '\'':
+			var b strings.Builder
+			for i++; ; i++ {
+				if i == len(sql) {
+					return nil, fmt.Errorf("%w: unterminated string at %d", ErrSyntax, start)
+				}
+				if sql[i] == '\'' {
+					if i+1 < len(sql) && sql[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+				b.WriteByte(sql[i])
+			}
+			i++
+			toks = append(toks, token{TOK_STRING, b.String(), start})
+		case c == '?':
+			i++
+			toks = append(toks, token{TOK_PARAM, "?", start})
+		case c == '$':
+			for i++; i < len(sql) && isDigit(sql[i]); i++ {
+			}
+			if i == start+1 {
+				return nil, fmt.Errorf("%w: $ without a number at %d", ErrSyntax, start)
+			}
+			toks = append(toks, token{TOK_PARAM, sql[start:i], start})
+		case strings.HasPrefix(sql[i:], "!=") || strings.HasPrefix(sql[i:], "<>") ||
+			strings.HasPrefix(sql[i:], "<=") || strings.HasPrefix(sql[i:], ">="):
+			i += 2
+			toks = append(toks, token{TOK_SYMBOL, sql[start:i], start})
+		case strings.IndexByte(",()*.;=<>", c) >= 0:
+			i++
+			toks = append(toks, token{TOK_SYMBOL, sql[start:i], start})
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at %d", ErrSyntax, c, start)
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && isDigit(c))
+}
+
+// words that end a table name, so they can't be an alias
+var reserved = map[string]bool{
+	"as": true, "on": true, "join": true, "inner": true, "left": true, "outer": true,
+	"where": true, "group": true, "having": true, "limit": true,
+}
+
+var aggFuncs = map[string]query.AggFunc{
+	"count": query.AGG_COUNT, "sum": query.AGG_SUM, "avg": query.AGG_AVG,
+	"min": query.AGG_MIN, "max": query.AGG_MAX,
+}
+
+// a column of a table, table is the name or alias it was qualified with
+type colRef struct {
+	table string
+	name  string
+}
+
+func (c colRef) String() string {
+	if c.table == "" {
+		return c.name
+	}
+	return c.table + "." + c.name
+}
+
+// a constant or a placeholder, param is the index of its argument or -1
+type literal struct {
+	val   string
+	param int
+}
+
+// a column or an aggregate of the select list, star for COUNT(*)
+type item struct {
+	agg  string
+	star bool
+	col  colRef
+}
+
+type tableRef struct {
+	name  string
+	alias string // the name when there is no alias
+}
+
+type cond struct {
+	left  item
+	op    string
+	right literal
+}
+
+var comparisons = map[string]bool{"=": true, "!=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+
+type joinClause struct {
+	kind        query.JoinKind
+	table       tableRef
+	left, right colRef
+}
+
+type StmtKind int
+
+const (
+	STMT_SELECT StmtKind = iota
+	STMT_INSERT
+	STMT_DELETE
+	STMT_BEGIN
+	STMT_COMMIT
+	STMT_ROLLBACK
+)
+
+// a parsed statement, Run executes it
+type Stmt struct {
+	kind   StmtKind
+	params int
+
+	items   []item // nil for SELECT *
+	table   tableRef
+	join    *joinClause
+	where   []cond
+	groupBy *colRef
+	having  []cond
+	limit   int          // -1 without LIMIT
+	values  [][2]literal // INSERT rows
+}
+
+func (s *Stmt) Kind() StmtKind {
+	return s.kind
+}
+
+// the number of placeholders
+func (s *Stmt) NumInput() int {
+	return s.params
+}
+
+type parser struct {
+	toks   []token
+	pos    int
+	params int
+	dollar bool // placeholders are $n, they can't be mixed with ?
+}
+
+// parse a single statement, an optional ; can end it
+func Parse(sql string) (*Stmt, error) {
+	toks, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	stmt, err := p.stmt()
+	if err != nil {
+		return nil, err
+	}
+	p.symbol(";")
+	if p.peek().kind != TOK_EOF {
+		return nil, p.errorf("unexpected %q after the statement", p.peek().text)
+	}
+	stmt.params = p.params
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.toks[p.pos]
+	if tok.kind != TOK_EOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w at %d: %s", ErrSyntax, p.peek().pos, fmt.Sprintf(format, args...))
+}
+
+// consume the keyword if it is next
+func (p *parser) keyword(word string) bool {
+	if tok := p.peek(); tok.kind == TOK_IDENT && tok.text == word {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) symbol(sym string) bool {
+	if tok := p.peek(); tok.kind == TOK_SYMBOL && tok.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(word string) error {
+	if !p.keyword(word) {
+		return p.errorf("expected %s", strings.ToUpper(word))
+	}
+	return nil
+}
+
+func (p *parser) expectSymbol(sym string) error {
+	if !p.symbol(sym) {
+		return p.errorf("expected %q", sym)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	tok := p.peek()
+	if tok.kind != TOK_IDENT {
+		return "", p.errorf("expected a name")
+	}
+	p.pos++
+	return tok.text, nil
+}
+
+func (p *parser) stmt() (*Stmt, error) {
+	switch {
+	case p.keyword("select"):
+		return p.selectStmt()
+	case p.keyword("insert"):
+		return p.insertStmt()
+	case p.keyword("delete"):
+		return p.deleteStmt()
+	case p.keyword("begin"), p.keyword("start"):
+		p.keyword("transaction")
+		return &Stmt{kind: STMT_BEGIN}, nil
+	case p.keyword("commit"), p.keyword("end"):
+		return &Stmt{kind: STMT_COMMIT}, nil
+	case p.keyword("rollback"), p.keyword("abort"):
+		return &Stmt{kind: STMT_ROLLBACK}, nil
+	}
+	return nil, p.errorf("expected SELECT, INSERT, DELETE, BEGIN, COMMIT or ROLLBACK")
+}
+
+// SELECT items FROM t [[INNER|LEFT [OUTER]] JOIN t2 ON col = col] [WHERE conds]
+// [GROUP BY col] [HAVING conds] [LIMIT n]
+func (p *parser) selectStmt() (*Stmt, error) {
+	s := &Stmt{kind: STMT_SELECT, limit: -1}
+	if !p.symbol("*") {
+		for {
+			it, err := p.item()
+			if err != nil {
+				return nil, err
+			}
+			s.items = append(s.items, it)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+	var err error
+	if err = p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	if s.table, err = p.tableRef(); err != nil {
+		return nil, err
+	}
+
+	kind, join := query.JOIN_INNER, false
+	switch {
+	case p.keyword("join"):
+		join = true
+	case p.keyword("inner"):
+		join = true
+		err = p.expectKeyword("join")
+	case p.keyword("left"):
+		kind, join = query.JOIN_LEFT, true
+		p.keyword("outer")
+		err = p.expectKeyword("join")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if join {
+		j := &joinClause{kind: kind}
+		if j.table, err = p.tableRef(); err != nil {
+			return nil, err
+		}
+		if err = p.expectKeyword("on"); err != nil {
+			return nil, err
+		}
+		if j.left, err = p.colRef(); err != nil {
+			return nil, err
+		}
+		if err = p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		if j.right, err = p.colRef(); err != nil {
+			return nil, err
+		}
+		s.join = j
+	}
+
+	if p.keyword("where") {
+		if s.where, err = p.conds(false); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("group") {
+		if err = p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		col, err := p.colRef()
+		if err != nil {
+			return nil, err
+		}
+		s.groupBy = &col
+	}
+	if p.keyword("having") {
+		if s.having, err = p.conds(true); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("limit") {
+		tok := p.next()
+		n, err := strconv.Atoi(tok.text)
+		if tok.kind != TOK_NUMBER || err != nil || n < 0 {
+			return nil, fmt.Errorf("%w at %d: LIMIT needs a count", ErrSyntax, tok.pos)
+		}
+		s.limit = n
+	}
+	return s, nil
+}
+
+// INSERT INTO t [(key, value)] VALUES (k, v) [, (k, v)]...
+func (p *parser) insertStmt() (*Stmt, error) {
+	s := &Stmt{kind: STMT_INSERT}
+	var err error
+	if err = p.expectKeyword("into"); err != nil {
+		return nil, err
+	}
+	if s.table.name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.symbol("(") {
+		if !p.keyword("key") || !p.symbol(",") || !p.keyword("value") || !p.symbol(")") {
+			return nil, p.errorf("the columns of a table are (key, value)")
+		}
+	}
+	if err = p.expectKeyword("values"); err != nil {
+		return nil, err
+	}
+	for {
+		var row [2]literal
+		if err = p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		if row[0], err = p.literal(); err != nil {
+			return nil, err
+		}
+		if err = p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+		if row[1], err = p.literal(); err != nil {
+			return nil, err
+		}
+		if err = p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		s.values = append(s.values, row)
+		if !p.symbol(",") {
+			return s, nil
+		}
+	}
+}
+
+// DELETE FROM t [WHERE conds]
+func (p *parser) deleteStmt() (*Stmt, error) {
+	s := &Stmt{kind: STMT_DELETE}
+	var err error
+	if err = p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	if s.table.name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	s.table.alias = s.table.name
+	if p.keyword("where") {
+		if s.where, err = p.conds(false); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) tableRef() (tableRef, error) {
+	name, err := p.ident()
+	if err != nil {
+		return tableRef{}, err
+	}
+	t := tableRef{name: name, alias: name}
+	if p.keyword("as") {
+		if t.alias, err = p.ident(); err != nil {
+			return tableRef{}, err
+		}
+	} else if tok := p.peek(); tok.kind == TOK_IDENT && !reserved[tok.text] {
+		t.alias = p.next().text
+	}
+	return t, nil
+}
+
+func (p *parser) colRef() (colRef, error) {
+	name, err := p.ident()
+	if err != nil {
+		return colRef{}, err
+	}
+	if !p.symbol(".") {
+		return colRef{name: name}, nil
+	}
+	col, err := p.ident()
+	if err != nil {
+		return colRef{}, err
+	}
+	return colRef{table: name, name: col}, nil
+}
+
+func (p *parser) item() (item, error) {
+	tok := p.peek()
+	if _, ok := aggFuncs[tok.text]; ok && tok.kind == TOK_IDENT && p.toks[p.pos+1].text == "(" {
+		// an identifier is never the last token, EOF comes after it
+		p.pos += 2
+		it := item{agg: tok.text}
+		if tok.text == "count" && p.symbol("*") {
+			it.star = true
+		} else {
+			col, err := p.colRef()
+			if err != nil {
+				return item{}, err
+			}
+			it.col = col
+		}
+		return it, p.expectSymbol(")")
+	}
+	col, err := p.colRef()
+	return item{col: col}, err
+}
+
+// conditions joined by AND, a column or with aggregates an item compared to a literal
+func (p *parser) conds(aggs bool) ([]cond, error) {
+	var out []cond
+	for {
+		var c cond
+		var err error
+		if aggs {
+			c.left, err = p.item()
+		} else {
+			c.left.col, err = p.colRef()
+		}
+		if err != nil {
+			return nil, err
+		}
+		tok := p.next()
+		if tok.kind != TOK_SYMBOL || !comparisons[tok.text] {
+			return nil, fmt.Errorf("%w at %d: expected a comparison", ErrSyntax, tok.pos)
+		}
+		c.op = tok.text
+		if c.right, err = p.literal(); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+		if !p.keyword("and") {
+			return out, nil
+		}
+	}
+}
+
+func (p *parser) literal() (literal, error) {
+	tok := p.next()
+	switch tok.kind {
+	case TOK_STRING, TOK_NUMBER:
+		return literal{val: tok.text, param: -1}, nil
+	case TOK_PARAM:
+		if tok.text == "?" {
+			if p.dollar {
+				return literal{}, fmt.Errorf("%w at %d: ? mixed with $n", ErrSyntax, tok.pos)
+			}
+			p.params++
+			return literal{param: p.params - 1}, nil
+		}
+		if p.params > 0 && !p.dollar {
+			return literal{}, fmt.Errorf("%w at %d: $n mixed with ?", ErrSyntax, tok.pos)
+		}
+		p.dollar = true
+		n, err := strconv.Atoi(tok.text[1:])
+		if err != nil || n < 1 {
+			return literal{}, fmt.Errorf("%w at %d: bad placeholder %s", ErrSyntax, tok.pos, tok.text)
+		}
+		p.params = max(p.params, n)
+		return literal{param: n - 1}, nil
+	}
+	return literal{}, fmt.Errorf("%w at %d: expected a string, a number or a placeholder", ErrSyntax, tok.pos)
+}
@@ -0,0 +1,161 @@
+package pgwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Postgres v3 messages
+
+/*
+*
+A message is a type byte, a big endian int32 length that counts itself but not the type, and
+the body. The startup message comes first and has no type byte. Strings in a body end with a
+0 byte.
+*/
+const (
+	PROTOCOL_V3    = 196608 // 3.0
+	SSL_REQUEST    = 80877103
+	GSSENC_REQUEST = 80877104
+	CANCEL_REQUEST = 80877102
+
+	MAX_MESSAGE = 1 << 24 // longer messages are a protocol violation
+)
+
+// OIDs of the types the columns are sent as
+const (
+	OID_INT8   = 20
+	OID_INT4   = 23
+	OID_TEXT   = 25
+	OID_FLOAT8 = 701
+)
+
+var errProtocol = errors.New("protocol violation")
+
+// read a message, typ is 0 for the startup message
+func readMessage(r io.Reader, startup bool) (typ byte, body []byte, err error) {
+	var header [5]byte
+	if startup {
+		_, err = io.ReadFull(r, header[1:])
+	} else {
+		_, err = io.ReadFull(r, header[:])
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n < 4 || n > MAX_MESSAGE {
+		return 0, nil, fmt.Errorf("%w: message length %d", errProtocol, n)
+	}
+	body = make([]byte, n-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// reads the fields of a message body, the first error sticks
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: short message", errProtocol)
+	}
+	r.buf = nil
+}
+
+func (r *reader) byte() byte {
+	if len(r.buf) < 1 {
+		r.fail()
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) int16() int {
+	if len(r.buf) < 2 {
+		r.fail()
+		return 0
+	}
+	n := int16(binary.BigEndian.Uint16(r.buf))
+	r.buf = r.buf[2:]
+	return int(n)
+}
+
+func (r *reader) int32() int {
+	if len(r.buf) < 4 {
+		r.fail()
+		return 0
+	}
+	n := int32(binary.BigEndian.Uint32(r.buf))
+	r.buf = r.buf[4:]
+	return int(n)
+}
+
+func (r *reader) string() string {
+	i := bytes.IndexByte(r.buf, 0)
+	if i < 0 {
+		r.fail()
+		return ""
+	}
+	s := string(r.buf[:i])
+	r.buf = r.buf[i+1:]
+	return s
+}
+
+func (r *reader) bytes(n int) []byte {
+	if n < 0 || len(r.buf) < n {
+		r.fail()
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// builds a message, the length is filled in by done
+type message struct {
+	buf []byte
+}
+
+func newMessage(typ byte) *message {
+	return &message{buf: []byte{typ, 0, 0, 0, 0}}
+}
+
+func (m *message) byte(b byte) *message {
+	m.buf = append(m.buf, b)
+	return m
+}
+
+func (m *message) int16(n int) *message {
+	m.buf = binary.BigEndian.AppendUint16(m.buf, uint16(n))
+	return m
+}
+
+func (m *message) int32(n int) *message {
+	m.buf = binary.BigEndian.AppendUint32(m.buf, uint32(n))
+	return m
+}
+
+func (m *message) string(s string) *message {
+	m.buf = append(append(m.buf, s...), 0)
+	return m
+}
+
+func (m *message) bytes(b []byte) *message {
+	m.buf = append(m.buf, b...)
+	return m
+}
+
+func (m *message) done() []byte {
+	binary.BigEndian.PutUint32(m.buf[1:], uint32(len(m.buf)-1))
+	return m.buf
+}
@@ -0,0 +1,664 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"building-a-db/db"
+	"building-a-db/minisql"
+)
+
+// Postgres wire protocol server in front of minisql
+
+/*
+*
+Serves the SQL of package minisql to Postgres clients over the v3 protocol: the startup
+without authentication, the simple query (Query) and the extended query (Parse, Bind,
+Describe, Execute, Close, Sync). SSL is refused and the client goes on in plain text.
+
+Statements outside BEGIN ... COMMIT are committed one by one, also in the extended
+protocol. db.KV has a single transaction at a time and the connections share it: while a
+connection is in a transaction the statements of the others fail with 55P03 and can be
+retried. Calls into db.KV are serialised with a mutex.
+
+Result columns are text (OID 25), COUNT is int8 and the other aggregates are float8. Rows
+are sent in the text format unless Bind asks for binary.
+*/
+type Server struct {
+	db *db.KV
+	mu sync.Mutex // protects db
+
+	lnMu   sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+	nextID int // process ids for BackendKeyData
+}
+
+var ErrServerClosed = errors.New("pgwire: server closed")
+
+func NewServer(kv *db.KV) *Server {
+	return &Server{db: kv, conns: map[net.Conn]struct{}{}}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// accept connections until Close is called
+func (s *Server) Serve(ln net.Listener) error {
+	s.lnMu.Lock()
+	if s.closed {
+		s.lnMu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.lnMu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.lnMu.Lock()
+			closed := s.closed
+			s.lnMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.lnMu.Lock()
+		s.conns[conn] = struct{}{}
+		s.nextID++
+		id := s.nextID
+		s.lnMu.Unlock()
+		go s.handle(conn, id)
+	}
+}
+
+// stop accepting connections and close the open ones
+func (s *Server) Close() error {
+	s.lnMu.Lock()
+	defer s.lnMu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+// an error sent to the client as an ErrorResponse with its SQLSTATE code
+type pgError struct {
+	code string
+	msg  string
+}
+
+func (e *pgError) Error() string {
+	return e.msg
+}
+
+var errTxFailed = &pgError{"25P02", "current transaction is aborted, commands ignored until end of transaction block"}
+
+func toPgError(err error) *pgError {
+	var pe *pgError
+	switch {
+	case errors.As(err, &pe):
+		return pe
+	case errors.Is(err, minisql.ErrSyntax):
+		return &pgError{"42601", err.Error()}
+	case errors.Is(err, db.ErrTxInProgress):
+		return &pgError{"55P03", "another connection is in a transaction, try again"}
+	case errors.Is(err, errProtocol):
+		return &pgError{"08P01", err.Error()}
+	}
+	return &pgError{"XX000", err.Error()}
+}
+
+// the state of a client connection
+type session struct {
+	srv  *Server
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+
+	tx      *db.KVTX // between BEGIN and COMMIT
+	failed  bool     // a statement of tx failed, it can only be rolled back
+	stmts   map[string]*prepared
+	portals map[string]*portal
+	skip    bool // an extended query failed, messages are ignored until Sync
+}
+
+// a statement of Parse
+type prepared struct {
+	stmt *minisql.Stmt
+	oids []int // of the parameters, 0 when the client left it to the server
+}
+
+// a statement bound to its arguments by Bind, Execute runs it once and sends its rows
+type portal struct {
+	stmt    *minisql.Stmt
+	args    []any
+	formats []int // of the result columns, 0 text and 1 binary
+	res     *minisql.Result
+	tag     string
+	sent    int // rows sent by the previous Executes
+}
+
+func (s *Server) handle(conn net.Conn, id int) {
+	sess := &session{
+		srv:     s,
+		conn:    conn,
+		r:       bufio.NewReader(conn),
+		w:       bufio.NewWriter(conn),
+		stmts:   map[string]*prepared{},
+		portals: map[string]*portal{},
+	}
+	defer func() {
+		if sess.tx != nil {
+			s.mu.Lock()
+			sess.tx.Abort()
+			s.mu.Unlock()
+		}
+		conn.Close()
+		s.lnMu.Lock()
+		delete(s.conns, conn)
+		s.lnMu.Unlock()
+	}()
+
+	if !sess.startup(id) {
+		return
+	}
+	for {
+		typ, body, err := readMessage(sess.r, false)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				sess.sendError(err)
+				sess.w.Flush()
+			}
+			return
+		}
+		if typ == 'X' {
+			return
+		}
+		if sess.skip && typ != 'S' {
+			continue
+		}
+		if err := sess.dispatch(typ, &reader{buf: body}); err != nil {
+			sess.sendError(err)
+			if typ == 'Q' {
+				sess.readyForQuery()
+			} else {
+				sess.skip = true
+			}
+		}
+		// the client waits for ReadyForQuery after Query and Sync, or asks with Flush
+		if typ == 'Q' || typ == 'S' || typ == 'H' {
+			if err := sess.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// answer SSL requests with N and accept the startup message, there is no authentication
+func (c *session) startup(id int) bool {
+	for {
+		_, body, err := readMessage(c.r, true)
+		if err != nil {
+			return false
+		}
+		r := &reader{buf: body}
+		switch code := r.int32(); code {
+		case SSL_REQUEST, GSSENC_REQUEST:
+			if _, err := c.conn.Write([]byte{'N'}); err != nil {
+				return false
+			}
+			continue
+		case PROTOCOL_V3:
+		default:
+			// cancel requests too, a statement runs to the end
+			if code != CANCEL_REQUEST {
+				c.sendError(&pgError{"08P01", fmt.Sprintf("unsupported protocol %d", code)})
+				c.w.Flush()
+			}
+			return false
+		}
+
+		c.w.Write(newMessage('R').int32(0).done()) // AuthenticationOk
+		for _, p := range [][2]string{
+			{"server_version", "14.0"},
+			{"server_encoding", "UTF8"},
+			{"client_encoding", "UTF8"},
+			{"DateStyle", "ISO, MDY"},
+			{"integer_datetimes", "on"},
+			{"standard_conforming_strings", "on"},
+		} {
+			c.w.Write(newMessage('S').string(p[0]).string(p[1]).done())
+		}
+		c.w.Write(newMessage('K').int32(id).int32(0).done())
+		c.readyForQuery()
+		return c.w.Flush() == nil
+	}
+}
+
+func (c *session) readyForQuery() {
+	status := byte('I')
+	switch {
+	case c.failed:
+		status = 'E'
+	case c.tx != nil:
+		status = 'T'
+	}
+	c.w.Write(newMessage('Z').byte(status).done())
+}
+
+func (c *session) sendError(err error) {
+	pe := toPgError(err)
+	c.w.Write(newMessage('E').
+		byte('S').string("ERROR").byte('V').string("ERROR").
+		byte('C').string(pe.code).byte('M').string(pe.msg).byte(0).done())
+}
+
+func (c *session) dispatch(typ byte, r *reader) error {
+	switch typ {
+	case 'Q':
+		sql := r.string()
+		if r.err != nil {
+			return r.err
+		}
+		c.query(sql)
+		return nil
+	case 'P':
+		return c.parse(r)
+	case 'B':
+		return c.bind(r)
+	case 'D':
+		return c.describe(r)
+	case 'E':
+		return c.execute(r)
+	case 'C':
+		kind, name := r.byte(), r.string()
+		if r.err != nil {
+			return r.err
+		}
+		if kind == 'S' {
+			delete(c.stmts, name)
+		} else {
+			delete(c.portals, name)
+		}
+		c.w.Write(newMessage('3').done())
+	case 'S':
+		c.skip = false
+		c.readyForQuery()
+	case 'H':
+	default:
+		return fmt.Errorf("%w: unknown message %q", errProtocol, typ)
+	}
+	return nil
+}
+
+// Simple query
+
+// run the statements of a Query message, an error skips the rest
+func (c *session) query(sql string) {
+	stmts := splitStatements(sql)
+	if len(stmts) == 0 {
+		c.w.Write(newMessage('I').done()) // EmptyQueryResponse
+	}
+	for _, text := range stmts {
+		stmt, err := minisql.Parse(text)
+		if err != nil {
+			c.statementFailed()
+			c.sendError(err)
+			break
+		}
+		res, tag, err := c.run(stmt, nil)
+		if err != nil {
+			c.sendError(err)
+			break
+		}
+		if stmt.Kind() == minisql.STMT_SELECT {
+			c.sendRowDescription(res.Columns, res.Types, nil)
+			for _, row := range res.Rows {
+				c.sendDataRow(row, res.Types, nil)
+			}
+		}
+		c.w.Write(newMessage('C').string(tag).done()) // CommandComplete
+	}
+	c.readyForQuery()
+}
+
+// split on the semicolons outside of 'strings', without the empty statements
+func splitStatements(sql string) []string {
+	var out []string
+	quoted, start := false, 0
+	for i := 0; i <= len(sql); i++ {
+		if i < len(sql) && sql[i] == '\'' {
+			quoted = !quoted
+		}
+		if i == len(sql) || (sql[i] == ';' && !quoted) {
+			if text := strings.TrimSpace(sql[start:i]); text != "" {
+				out = append(out, text)
+			}
+			start = i + 1
+		}
+	}
+	return out
+}
+
+// a failed statement in a transaction fails the transaction
+func (c *session) statementFailed() {
+	if c.tx != nil {
+		c.failed = true
+	}
+}
+
+// run a statement in the transaction or in one of its own, with the tag of its
+// CommandComplete. The result is empty for BEGIN, COMMIT and ROLLBACK
+func (c *session) run(stmt *minisql.Stmt, args []any) (*minisql.Result, string, error) {
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := minisql.CheckOrder(s.db); err != nil {
+		return nil, "", err
+	}
+
+	switch stmt.Kind() {
+	case minisql.STMT_BEGIN:
+		if c.tx == nil { // else Postgres only warns
+			tx, err := s.db.Begin()
+			if err != nil {
+				return nil, "", err
+			}
+			c.tx = tx
+		}
+		return &minisql.Result{}, "BEGIN", nil
+	case minisql.STMT_COMMIT, minisql.STMT_ROLLBACK:
+		var err error
+		tag := "ROLLBACK"
+		switch {
+		case stmt.Kind() == minisql.STMT_COMMIT && !c.failed:
+			tag = "COMMIT"
+			if c.tx != nil {
+				err = c.tx.Commit()
+			}
+		case c.tx != nil:
+			c.tx.Abort()
+		}
+		c.tx, c.failed = nil, false
+		return &minisql.Result{}, tag, err
+	}
+
+	if c.failed {
+		return nil, "", errTxFailed
+	}
+	tx := c.tx
+	if tx == nil {
+		var err error
+		if tx, err = s.db.Begin(); err != nil {
+			return nil, "", err
+		}
+	}
+	res, err := stmt.Run(tx, args)
+	switch {
+	case c.tx != nil:
+		if err != nil {
+			c.failed = true
+		}
+	case err != nil:
+		tx.Abort()
+	default:
+		err = tx.Commit()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch stmt.Kind() {
+	case minisql.STMT_INSERT:
+		return res, fmt.Sprintf("INSERT 0 %d", res.RowsAffected), nil
+	case minisql.STMT_DELETE:
+		return res, fmt.Sprintf("DELETE %d", res.RowsAffected), nil
+	}
+	return res, fmt.Sprintf("SELECT %d", len(res.Rows)), nil
+}
+
+// Rows
+
+func typeOID(t minisql.Type) int {
+	switch t {
+	case minisql.TYPE_INT:
+		return OID_INT8
+	case minisql.TYPE_FLOAT:
+		return OID_FLOAT8
+	}
+	return OID_TEXT
+}
+
+// the format of column i: one code for all the columns, one per column or none for text
+func format(formats []int, i int) int {
+	switch len(formats) {
+	case 0:
+		return 0
+	case 1:
+		return formats[0]
+	}
+	return formats[i]
+}
+
+func (c *session) sendRowDescription(columns []string, types []minisql.Type, formats []int) {
+	if columns == nil {
+		c.w.Write(newMessage('n').done()) // NoData
+		return
+	}
+	m := newMessage('T').int16(len(columns))
+	for i, name := range columns {
+		size := -1
+		if types[i] != minisql.TYPE_BYTES {
+			size = 8
+		}
+		m.string(name).int32(0).int16(0).int32(typeOID(types[i])).int16(size).int32(-1).int16(format(formats, i))
+	}
+	c.w.Write(m.done())
+}
+
+func (c *session) sendDataRow(row []any, types []minisql.Type, formats []int) {
+	m := newMessage('D').int16(len(row))
+	for i, v := range row {
+		b := encodeValue(v, format(formats, i) == 1)
+		if b == nil {
+			m.int32(-1) // NULL
+			continue
+		}
+		m.int32(len(b)).bytes(b)
+	}
+	c.w.Write(m.done())
+}
+
+// a value of a result in the text or the binary format, nil for NULL
+func encodeValue(v any, binaryFormat bool) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return append([]byte{}, v...)
+	case int64:
+		if binaryFormat {
+			return binary.BigEndian.AppendUint64(nil, uint64(v))
+		}
+		return strconv.AppendInt(nil, v, 10)
+	case float64:
+		if binaryFormat {
+			return binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+		}
+		return strconv.AppendFloat(nil, v, 'f', -1, 64)
+	}
+	return nil
+}
+
+// Extended query
+
+// Parse: name, query, parameter OIDs
+func (c *session) parse(r *reader) error {
+	name, sql := r.string(), r.string()
+	oids := make([]int, max(r.int16(), 0))
+	for i := range oids {
+		oids[i] = r.int32()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(splitStatements(sql)) > 1 {
+		return &pgError{"42601", "cannot insert multiple commands into a prepared statement"}
+	}
+	stmt, err := minisql.Parse(sql)
+	if err != nil {
+		c.statementFailed()
+		return err
+	}
+	if len(oids) > stmt.NumInput() {
+		return &pgError{"08P01", fmt.Sprintf("%d parameter types for %d placeholders", len(oids), stmt.NumInput())}
+	}
+	oids = append(oids, make([]int, stmt.NumInput()-len(oids))...)
+	c.stmts[name] = &prepared{stmt: stmt, oids: oids}
+	c.w.Write(newMessage('1').done()) // ParseComplete
+	return nil
+}
+
+func readFormats(r *reader) []int {
+	formats := make([]int, max(r.int16(), 0))
+	for i := range formats {
+		formats[i] = r.int16()
+	}
+	return formats
+}
+
+// Bind: portal, statement, parameter formats, parameters, result formats
+func (c *session) bind(r *reader) error {
+	portalName, stmtName := r.string(), r.string()
+	paramFormats := readFormats(r)
+	args := make([]any, max(r.int16(), 0))
+	for i := range args {
+		if n := r.int32(); n >= 0 {
+			args[i] = r.bytes(n)
+		}
+	}
+	formats := readFormats(r)
+	if r.err != nil {
+		return r.err
+	}
+	p, ok := c.stmts[stmtName]
+	if !ok {
+		return &pgError{"26000", fmt.Sprintf("prepared statement %q does not exist", stmtName)}
+	}
+	if len(args) != len(p.oids) {
+		return &pgError{"08P01", fmt.Sprintf("%d parameters for %d placeholders", len(args), len(p.oids))}
+	}
+	for i, arg := range args {
+		if arg == nil || format(paramFormats, i) == 0 {
+			continue
+		}
+		v, err := decodeBinary(arg.([]byte), p.oids[i])
+		if err != nil {
+			return err
+		}
+		args[i] = v
+	}
+	c.portals[portalName] = &portal{stmt: p.stmt, args: args, formats: formats}
+	c.w.Write(newMessage('2').done()) // BindComplete
+	return nil
+}
+
+// a parameter in the binary format of its type, the bytes as they are for text
+func decodeBinary(b []byte, oid int) (any, error) {
+	switch {
+	case oid == OID_INT8 && len(b) == 8:
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case oid == OID_INT4 && len(b) == 4:
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case oid == OID_FLOAT8 && len(b) == 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case oid == OID_TEXT || oid == 0:
+		return b, nil
+	}
+	return nil, &pgError{"22P03", fmt.Sprintf("bad binary parameter for type %d", oid)}
+}
+
+// Describe: ParameterDescription and RowDescription of a statement, RowDescription of a portal
+func (c *session) describe(r *reader) error {
+	kind, name := r.byte(), r.string()
+	if r.err != nil {
+		return r.err
+	}
+	if kind == 'S' {
+		p, ok := c.stmts[name]
+		if !ok {
+			return &pgError{"26000", fmt.Sprintf("prepared statement %q does not exist", name)}
+		}
+		m := newMessage('t').int16(len(p.oids))
+		for _, oid := range p.oids {
+			if oid == 0 {
+				oid = OID_TEXT
+			}
+			m.int32(oid)
+		}
+		c.w.Write(m.done())
+		columns, types := p.stmt.Columns()
+		c.sendRowDescription(columns, types, nil)
+		return nil
+	}
+	p, ok := c.portals[name]
+	if !ok {
+		return &pgError{"34000", fmt.Sprintf("portal %q does not exist", name)}
+	}
+	columns, types := p.stmt.Columns()
+	c.sendRowDescription(columns, types, p.formats)
+	return nil
+}
+
+// Execute: run the portal the first time, then send up to max rows, 0 is all of them
+func (c *session) execute(r *reader) error {
+	name, limit := r.string(), r.int32()
+	if r.err != nil {
+		return r.err
+	}
+	p, ok := c.portals[name]
+	if !ok {
+		return &pgError{"34000", fmt.Sprintf("portal %q does not exist", name)}
+	}
+	if p.res == nil {
+		res, tag, err := c.run(p.stmt, p.args)
+		if err != nil {
+			return err
+		}
+		p.res, p.tag = res, tag
+	}
+	rows := p.res.Rows[p.sent:]
+	if limit > 0 && len(rows) > limit {
+		for _, row := range rows[:limit] {
+			c.sendDataRow(row, p.res.Types, p.formats)
+		}
+		p.sent += limit
+		c.w.Write(newMessage('s').done()) // PortalSuspended
+		return nil
+	}
+	for _, row := range rows {
+		c.sendDataRow(row, p.res.Types, p.formats)
+	}
+	p.sent = len(p.res.Rows)
+	c.w.Write(newMessage('C').string(p.tag).done())
+	return nil
+}
@@ -0,0 +1,307 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"building-a-db/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient speaks the frontend side of the protocol and prints the replies
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Helper: Start a server on a random port backed by a fresh database
+func startServer(t *testing.T) (*db.KV, string) {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	srv := NewServer(kv)
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Close()
+		kv.Close()
+	})
+	return kv, ln.Addr().String()
+}
+
+// Helper: Connect and finish the startup, after an SSL request that is refused
+func connect(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	c := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	conn.Write(binary.BigEndian.AppendUint32([]byte{0, 0, 0, 8}, SSL_REQUEST))
+	b, err := c.r.ReadByte()
+	assert.NoError(t, err)
+	assert.Equal(t, byte('N'), b)
+
+	startup := (&message{}).int32(PROTOCOL_V3).string("user").string("test").byte(0).buf
+	conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(startup)+4)), startup...))
+	replies := c.until('Z')
+	assert.Equal(t, "R0", replies[0], "AuthenticationOk")
+	assert.Equal(t, "Z I", replies[len(replies)-1])
+	return c
+}
+
+func (c *testClient) send(m *message) {
+	c.conn.Write(m.done())
+}
+
+// read a reply as a line of text: the type and the fields that matter to the tests
+func (c *testClient) reply() string {
+	typ, body, err := readMessage(c.r, false)
+	if err != nil {
+		return "read error: " + err.Error()
+	}
+	r := &reader{buf: body}
+	switch typ {
+	case 'R':
+		return fmt.Sprintf("R%d", r.int32())
+	case 'Z':
+		return fmt.Sprintf("Z %c", r.byte())
+	case 'C':
+		return "C " + r.string()
+	case 'E':
+		fields := map[byte]string{}
+		for t := r.byte(); t != 0; t = r.byte() {
+			fields[t] = r.string()
+		}
+		return "E " + fields['C'] + " " + fields['M']
+	case 'T':
+		var cols []string
+		for n := r.int16(); n > 0; n-- {
+			name := r.string()
+			r.int32()
+			r.int16()
+			oid := r.int32()
+			r.int16()
+			r.int32()
+			cols = append(cols, fmt.Sprintf("%s:%d:%d", name, oid, r.int16()))
+		}
+		return "T " + strings.Join(cols, " ")
+	case 'D':
+		var vals []string
+		for n := r.int16(); n > 0; n-- {
+			if size := r.int32(); size < 0 {
+				vals = append(vals, "NULL")
+			} else {
+				vals = append(vals, string(r.bytes(size)))
+			}
+		}
+		return "D " + strings.Join(vals, "|")
+	case 't':
+		var oids []string
+		for n := r.int16(); n > 0; n-- {
+			oids = append(oids, fmt.Sprint(r.int32()))
+		}
+		return "t " + strings.Join(oids, " ")
+	}
+	return string(typ)
+}
+
+// read replies up to and including one of type typ
+func (c *testClient) until(typ byte) []string {
+	var out []string
+	for {
+		reply := c.reply()
+		out = append(out, reply)
+		if reply[0] == typ || strings.HasPrefix(reply, "read error") {
+			return out
+		}
+	}
+}
+
+// send a simple query and read its replies without the final ReadyForQuery
+func (c *testClient) query(sql string) []string {
+	c.send(newMessage('Q').string(sql))
+	replies := c.until('Z')
+	return replies[:len(replies)-1]
+}
+
+func (c *testClient) status() string {
+	c.send(newMessage('S'))
+	return c.until('Z')[0]
+}
+
+func TestSimpleQuery(t *testing.T) {
+	t.Run("Insert and select", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+
+		assert.Equal(t, []string{"C INSERT 0 2"}, c.query("INSERT INTO users VALUES ('u1', 'alice'), ('u2', 'bob')"))
+		assert.Equal(t, []string{"T key:25:0 value:25:0", "D u1|alice", "D u2|bob", "C SELECT 2"}, c.query("SELECT * FROM users"))
+		assert.Equal(t, []string{"T count:20:0 max:701:0", "D 0|NULL", "C SELECT 1"},
+			c.query("SELECT COUNT(*), MAX(value) FROM users WHERE value > 'z'"))
+	})
+
+	t.Run("Several statements in a query", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+
+		got := c.query("INSERT INTO t VALUES ('a;b', '1'); SELECT key FROM t; ")
+		assert.Equal(t, []string{"C INSERT 0 1", "T key:25:0", "D a;b", "C SELECT 1"}, got)
+		assert.Equal(t, []string{"I"}, c.query("  "), "EmptyQueryResponse")
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		kv, addr := startServer(t)
+		c := connect(t, addr)
+		other := connect(t, addr)
+
+		assert.Equal(t, []string{"C BEGIN"}, c.query("BEGIN"))
+		assert.Equal(t, "Z T", c.status())
+		c.query("INSERT INTO t VALUES ('k', 'v')")
+		assert.Equal(t, []string{"T count:20:0", "D 1", "C SELECT 1"}, c.query("SELECT COUNT(*) FROM t"))
+		assert.Contains(t, other.query("SELECT * FROM t")[0], "E 55P03", "One transaction at a time")
+		_, ok := kv.Get([]byte("t\x00k"))
+		assert.False(t, ok)
+
+		assert.Equal(t, []string{"C COMMIT"}, c.query("COMMIT"))
+		assert.Equal(t, "Z I", c.status())
+		_, ok = kv.Get([]byte("t\x00k"))
+		assert.True(t, ok)
+	})
+
+	// Edge cases
+	t.Run("An error fails the transaction until ROLLBACK", func(t *testing.T) {
+		kv, addr := startServer(t)
+		c := connect(t, addr)
+
+		c.query("BEGIN; INSERT INTO t VALUES ('k', 'v')")
+		assert.Contains(t, c.query("SELECT nope FROM t; SELECT key FROM t")[0], "E XX000 no column")
+		assert.Equal(t, "Z E", c.status())
+		assert.Contains(t, c.query("SELECT key FROM t")[0], "E 25P02")
+		assert.Equal(t, []string{"C ROLLBACK"}, c.query("COMMIT"), "COMMIT of a failed transaction rolls back")
+		assert.Equal(t, "Z I", c.status())
+		_, ok := kv.Get([]byte("t\x00k"))
+		assert.False(t, ok)
+	})
+
+	t.Run("Syntax error", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+
+		assert.Contains(t, c.query("SELEC 1")[0], "E 42601")
+		assert.Equal(t, "Z I", c.status())
+	})
+
+	t.Run("A dropped connection rolls back", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+		c.query("BEGIN")
+		c.send(newMessage('X'))
+		c.conn.Close()
+
+		other := connect(t, addr)
+		assert.Eventually(t, func() bool {
+			return len(other.query("SELECT * FROM t")) == 2
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+// Helper: Parse, Bind, Describe and Execute the unnamed statement with text arguments
+func (c *testClient) extended(sql string, args []string, formats ...int) []string {
+	c.send(newMessage('P').string("").string(sql).int16(0))
+	bind := newMessage('B').string("").string("").int16(0).int16(len(args))
+	for _, arg := range args {
+		bind.int32(len(arg)).bytes([]byte(arg))
+	}
+	bind.int16(len(formats))
+	for _, f := range formats {
+		bind.int16(f)
+	}
+	c.send(bind)
+	c.send(newMessage('D').byte('P').string(""))
+	c.send(newMessage('E').string("").int32(0))
+	c.send(newMessage('S'))
+	replies := c.until('Z')
+	return replies[:len(replies)-1]
+}
+
+func TestExtendedQuery(t *testing.T) {
+	t.Run("Placeholders", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+
+		assert.Equal(t, []string{"1", "2", "n", "C INSERT 0 1"}, c.extended("INSERT INTO t VALUES ($1, $2)", []string{"k1", "5"}))
+		c.extended("INSERT INTO t VALUES ($1, $2)", []string{"k2", "7"})
+		assert.Equal(t, []string{"1", "2", "T key:25:0", "D k2", "C SELECT 1"},
+			c.extended("SELECT key FROM t WHERE value > $1", []string{"6"}))
+	})
+
+	t.Run("Describe a statement", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+
+		c.send(newMessage('P').string("s1").string("SELECT value, SUM(key) FROM t WHERE key = ? GROUP BY value").int16(0))
+		c.send(newMessage('D').byte('S').string("s1"))
+		c.send(newMessage('S'))
+		assert.Equal(t, []string{"1", "t 25", "T value:25:0 sum:701:0", "Z I"}, c.until('Z'))
+	})
+
+	t.Run("Binary results", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+		c.query("INSERT INTO t VALUES ('a', 1.5), ('b', 2)")
+
+		got := c.extended("SELECT COUNT(*), SUM(value) FROM t", nil, 1)
+		assert.Equal(t, "T count:20:1 sum:701:1", got[2])
+		row := got[3][2:]
+		assert.Equal(t, "\x00\x00\x00\x00\x00\x00\x00\x02|", row[:9])
+		assert.Equal(t, 3.5, math.Float64frombits(binary.BigEndian.Uint64([]byte(row[9:]))))
+	})
+
+	t.Run("Execute in batches", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+		c.query("INSERT INTO t VALUES ('a', '1'), ('b', '2'), ('c', '3')")
+
+		c.send(newMessage('P').string("").string("SELECT key FROM t").int16(0))
+		c.send(newMessage('B').string("p").string("").int16(0).int16(0).int16(0))
+		c.send(newMessage('E').string("p").int32(2))
+		c.send(newMessage('E').string("p").int32(2))
+		c.send(newMessage('S'))
+		assert.Equal(t, []string{"1", "2", "D a", "D b", "s", "D c", "C SELECT 3", "Z I"}, c.until('Z'))
+	})
+
+	// Edge cases
+	t.Run("An error skips the messages until Sync", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+
+		c.send(newMessage('P').string("").string("SELECT FROM").int16(0))
+		c.send(newMessage('B').string("").string("").int16(0).int16(0).int16(0))
+		c.send(newMessage('E').string("").int32(0))
+		c.send(newMessage('S'))
+		got := c.until('Z')
+		assert.Len(t, got, 2)
+		assert.Contains(t, got[0], "E 42601")
+
+		assert.Equal(t, []string{"1", "2", "n", "C INSERT 0 1"}, c.extended("INSERT INTO t VALUES (?, ?)", []string{"k", "v"}))
+	})
+
+	t.Run("Wrong number of parameters", func(t *testing.T) {
+		_, addr := startServer(t)
+		c := connect(t, addr)
+
+		got := c.extended("INSERT INTO t VALUES (?, ?)", []string{"k"})
+		assert.Equal(t, "1", got[0])
+		assert.Contains(t, got[1], "E 08P01")
+	})
+}
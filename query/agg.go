@@ -0,0 +1,358 @@
+package query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Aggregation: COUNT, SUM, AVG, MIN, MAX with GROUP BY and HAVING
+
+/*
+*
+An Aggregation groups the rows by the bytes of GroupBy and computes the Aggs of each group,
+like SELECT GroupBy, Aggs... GROUP BY GroupBy HAVING Having. NULLs are skipped as in SQL:
+COUNT(expr) counts the rows where expr is not NULL, and SUM, AVG, MIN and MAX of a group
+without values are NULL. Without GroupBy every row is in one group, which exists even when
+there are no rows, so COUNT(*) of nothing is 0.
+
+There are two operators:
+
+  - HashAggregate keeps one accumulator per group in a map, then sorts the groups
+  - SortAggregate needs the rows of a group next to each other, it finishes a group when
+    a row with another group key comes so it only keeps one group in memory. Rows from a
+    range scan are already grouped when GroupBy is a prefix of the key (KeyPrefix), set
+    KeyOrdered and nothing is sorted, otherwise the rows are read and sorted first
+
+The groups come out in GroupBy order, except with KeyOrdered where they come in the order
+of the rows: with a KeyPrefix separator other than 0 the keys a0:1 and a:1 give the groups
+a0 then a. A group whose rows are not next to each other comes out once per run of rows.
+
+Aggregate picks SortAggregate when the rows are in order and HashAggregate otherwise.
+*/
+type AggFunc int
+
+const (
+	AGG_COUNT AggFunc = iota
+	AGG_SUM
+	AGG_AVG
+	AGG_MIN
+	AGG_MAX
+)
+
+func (f AggFunc) String() string {
+	switch f {
+	case AGG_COUNT:
+		return "COUNT"
+	case AGG_SUM:
+		return "SUM"
+	case AGG_AVG:
+		return "AVG"
+	case AGG_MIN:
+		return "MIN"
+	case AGG_MAX:
+		return "MAX"
+	}
+	return fmt.Sprintf("AggFunc(%d)", int(f))
+}
+
+type Agg struct {
+	Func AggFunc
+	Expr Expr // nil only for COUNT(*)
+}
+
+func Count() Agg         { return Agg{Func: AGG_COUNT} }
+func CountOf(e Expr) Agg { return Agg{Func: AGG_COUNT, Expr: e} }
+func Sum(e Expr) Agg     { return Agg{Func: AGG_SUM, Expr: e} }
+func Avg(e Expr) Agg     { return Agg{Func: AGG_AVG, Expr: e} }
+func Min(e Expr) Agg     { return Agg{Func: AGG_MIN, Expr: e} }
+func Max(e Expr) Agg     { return Agg{Func: AGG_MAX, Expr: e} }
+func (a Agg) String() string {
+	if a.Expr == nil {
+		return a.Func.String() + "(*)"
+	}
+	return a.Func.String() + "(expr)"
+}
+
+type Aggregation struct {
+	GroupBy    KeyFunc // nil puts every row in one group
+	Aggs       []Agg
+	Having     func(g Group) bool    // nil keeps every group
+	KeyOrdered bool                  // the rows of a group come next to each other, like a key prefix of a Scan
+	Compare    func(a, b []byte) int // order of the group keys, bytes.Compare when nil. SortAggregate merges the keys it finds equal
+}
+
+// the result of an aggregate, Null when the group had no values
+type Value struct {
+	Num  float64
+	Null bool
+}
+
+type Group struct {
+	Key    []byte
+	Values []Value // one per Agg
+}
+
+// a stream of groups, Next returns false at the end
+type Groups interface {
+	Next() (Group, bool, error)
+}
+
+var ErrNoExpr = errors.New("only COUNT can be used without an expression")
+
+func (a *Aggregation) check() error {
+	for _, agg := range a.Aggs {
+		if agg.Expr == nil && agg.Func != AGG_COUNT {
+			return fmt.Errorf("%w: %v", ErrNoExpr, agg.Func)
+		}
+	}
+	return nil
+}
+
+func (a *Aggregation) compare(x, y []byte) int {
+	if a.Compare == nil {
+		return bytes.Compare(x, y)
+	}
+	return a.Compare(x, y)
+}
+
+func (a *Aggregation) groupKey(row Row) []byte {
+	if a.GroupBy == nil {
+		return []byte{}
+	}
+	return bytes.Clone(a.GroupBy(row))
+}
+
+// the running state of the aggregates of one group
+type accumulator struct {
+	key    []byte
+	counts []int // rows or values seen
+	nums   []float64
+}
+
+func (a *Aggregation) newAccumulator(key []byte) *accumulator {
+	return &accumulator{key: key, counts: make([]int, len(a.Aggs)), nums: make([]float64, len(a.Aggs))}
+}
+
+func (a *Aggregation) add(acc *accumulator, row Row) error {
+	for i, agg := range a.Aggs {
+		if agg.Expr == nil {
+			acc.counts[i]++ // COUNT(*)
+			continue
+		}
+		num, ok, err := agg.Expr(row)
+		if err != nil {
+			return fmt.Errorf("%v of row %q: %w", agg, row.Key, err)
+		}
+		if !ok {
+			continue // NULL
+		}
+		switch {
+		case acc.counts[i] == 0:
+			acc.nums[i] = num
+		case agg.Func == AGG_SUM || agg.Func == AGG_AVG:
+			acc.nums[i] += num
+		case agg.Func == AGG_MIN:
+			acc.nums[i] = min(acc.nums[i], num)
+		case agg.Func == AGG_MAX:
+			acc.nums[i] = max(acc.nums[i], num)
+		}
+		acc.counts[i]++
+	}
+	return nil
+}
+
+func (a *Aggregation) result(acc *accumulator) Group {
+	g := Group{Key: acc.key, Values: make([]Value, len(a.Aggs))}
+	for i, agg := range a.Aggs {
+		switch {
+		case agg.Func == AGG_COUNT:
+			g.Values[i] = Value{Num: float64(acc.counts[i])}
+		case acc.counts[i] == 0:
+			g.Values[i] = Value{Null: true}
+		case agg.Func == AGG_AVG:
+			g.Values[i] = Value{Num: acc.nums[i] / float64(acc.counts[i])}
+		default:
+			g.Values[i] = Value{Num: acc.nums[i]}
+		}
+	}
+	return g
+}
+
+func (a *Aggregation) keep(g Group) bool {
+	return a.Having == nil || a.Having(g)
+}
+
+// group in a map, the groups come out in GroupBy order once every row is read
+func HashAggregate(rows Rows, a *Aggregation) Groups {
+	return &hashAggregate{rows: rows, agg: a}
+}
+
+type hashAggregate struct {
+	rows   Rows
+	agg    *Aggregation
+	groups []Group
+	done   bool
+}
+
+func (h *hashAggregate) build() error {
+	if err := h.agg.check(); err != nil {
+		return err
+	}
+	accs := map[string]*accumulator{}
+	if h.agg.GroupBy == nil {
+		accs[""] = h.agg.newAccumulator([]byte{}) // the only group, even without rows
+	}
+	for {
+		row, ok, err := h.rows.Next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		key := h.agg.groupKey(row)
+		acc := accs[string(key)]
+		if acc == nil {
+			acc = h.agg.newAccumulator(key)
+			accs[string(key)] = acc
+		}
+		if err := h.agg.add(acc, row); err != nil {
+			return err
+		}
+	}
+	for _, acc := range accs {
+		if g := h.agg.result(acc); h.agg.keep(g) {
+			h.groups = append(h.groups, g)
+		}
+	}
+	slices.SortFunc(h.groups, func(x, y Group) int { return h.agg.compare(x.Key, y.Key) })
+	return nil
+}
+
+func (h *hashAggregate) Next() (Group, bool, error) {
+	if !h.done {
+		h.done = true
+		if err := h.build(); err != nil {
+			return Group{}, false, err
+		}
+	}
+	if len(h.groups) == 0 {
+		return Group{}, false, nil
+	}
+	g := h.groups[0]
+	h.groups = h.groups[1:]
+	return g, true, nil
+}
+
+// group rows that come grouped, or sort them first unless KeyOrdered is set
+func SortAggregate(rows Rows, a *Aggregation) Groups {
+	return &sortAggregate{rows: rows, agg: a}
+}
+
+type sortAggregate struct {
+	rows    Rows
+	agg     *Aggregation
+	started bool
+	cur     *accumulator // the group being read
+	done    bool
+}
+
+// sort the rows by group key, keeping the key order within a group
+func (s *sortAggregate) sort() error {
+	all, err := Collect(s.rows)
+	if err != nil {
+		return err
+	}
+	keys := make([][]byte, len(all))
+	idx := make([]int, len(all))
+	for i, row := range all {
+		keys[i], idx[i] = s.agg.groupKey(row), i
+	}
+	slices.SortStableFunc(idx, func(x, y int) int { return s.agg.compare(keys[x], keys[y]) })
+	sorted := make([]Row, len(all))
+	for i, j := range idx {
+		sorted[i] = all[j]
+	}
+	s.rows = FromSlice(sorted)
+	return nil
+}
+
+func (s *sortAggregate) Next() (Group, bool, error) {
+	if !s.started {
+		s.started = true
+		if err := s.agg.check(); err != nil {
+			return Group{}, false, err
+		}
+		if s.agg.GroupBy != nil && !s.agg.KeyOrdered {
+			if err := s.sort(); err != nil {
+				return Group{}, false, err
+			}
+		}
+		if s.agg.GroupBy == nil {
+			s.cur = s.agg.newAccumulator([]byte{}) // the only group, even without rows
+		}
+	}
+	for !s.done {
+		row, ok, err := s.rows.Next()
+		if err != nil {
+			return Group{}, false, err
+		}
+		if !ok {
+			s.done = true
+			break
+		}
+		key := s.agg.groupKey(row)
+		if s.cur != nil {
+			// only equality matters, the groups of a key prefix scan are not in GroupBy
+			// order when the separator sorts above other key bytes
+			if s.agg.compare(key, s.cur.key) != 0 {
+				// the group is complete
+				g := s.agg.result(s.cur)
+				s.cur = s.agg.newAccumulator(key)
+				if err := s.agg.add(s.cur, row); err != nil {
+					return Group{}, false, err
+				}
+				if s.agg.keep(g) {
+					return g, true, nil
+				}
+				continue
+			}
+		} else {
+			s.cur = s.agg.newAccumulator(key)
+		}
+		if err := s.agg.add(s.cur, row); err != nil {
+			return Group{}, false, err
+		}
+	}
+	if s.cur == nil {
+		return Group{}, false, nil
+	}
+	g := s.agg.result(s.cur)
+	s.cur = nil
+	if !s.agg.keep(g) {
+		return Group{}, false, nil
+	}
+	return g, true, nil
+}
+
+// SortAggregate when the rows come grouped or there is a single group, else HashAggregate
+func Aggregate(rows Rows, a *Aggregation) Groups {
+	if a.KeyOrdered || a.GroupBy == nil {
+		return SortAggregate(rows, a)
+	}
+	return HashAggregate(rows, a)
+}
+
+// read every group of a stream
+func CollectGroups(groups Groups) ([]Group, error) {
+	var out []Group
+	for {
+		g, ok, err := groups.Next()
+		if err != nil || !ok {
+			return out, err
+		}
+		out = append(out, g)
+	}
+}
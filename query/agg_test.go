@@ -0,0 +1,211 @@
+package query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A table of sales in key order, sales \0 region \0 id -> amount, an empty amount is NULL
+func sales() func() Rows {
+	var rows []Row
+	sales := []struct{ region, amount string }{
+		{"east", "10"}, {"west", "5"}, {"east", "30"}, {"north", ""},
+		{"west", "7"}, {"east", "20"}, {"south", "100"}, {"west", ""},
+	}
+	for i, s := range sales {
+		rows = append(rows, Row{Key: []byte(fmt.Sprintf("sales\x00%s\x00%02d", s.region, i)), Val: []byte(s.amount)})
+	}
+	slices.SortFunc(rows, func(a, b Row) int { return bytes.Compare(a.Key, b.Key) })
+	return func() Rows { return FromSlice(rows) }
+}
+
+// Helper: Run the aggregation with every operator, they must agree
+func aggregateAll(t *testing.T, rows func() Rows, a *Aggregation) []Group {
+	hash, err := CollectGroups(HashAggregate(rows(), a))
+	assert.NoError(t, err)
+	sorted, err := CollectGroups(SortAggregate(rows(), a))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, sorted, "Hash and sort aggregation agree")
+	picked, err := CollectGroups(Aggregate(rows(), a))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, picked)
+	return hash
+}
+
+// Helper: The keys and the numbers of groups, "NULL" for NULL values
+func summary(groups []Group) map[string][]any {
+	out := map[string][]any{}
+	for _, g := range groups {
+		var vals []any
+		for _, v := range g.Values {
+			if v.Null {
+				vals = append(vals, "NULL")
+			} else {
+				vals = append(vals, v.Num)
+			}
+		}
+		out[string(g.Key)] = vals
+	}
+	return out
+}
+
+// Helper: The keys of groups in the order they came
+func groupKeys(groups []Group) []string {
+	var keys []string
+	for _, g := range groups {
+		keys = append(keys, string(g.Key))
+	}
+	return keys
+}
+
+func TestKeyColumns(t *testing.T) {
+	row := Row{Key: []byte("sales\x00east\x0007"), Val: []byte("12.5")}
+	assert.Equal(t, []byte("east"), KeyPart(0, 1)(row))
+	assert.Equal(t, []byte("sales\x00east"), KeyPrefix(0, 2)(row))
+	num, ok, err := Number(Val)(row)
+	assert.Equal(t, 12.5, num)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	// Edge cases
+	assert.Nil(t, KeyPart(0, 3)(row))
+	assert.Equal(t, row.Key, KeyPrefix(0, 5)(row))
+	_, ok, err = Number(Val)(Row{})
+	assert.False(t, ok, "Empty is NULL")
+	assert.NoError(t, err)
+}
+
+func TestAggregate(t *testing.T) {
+	amount := Number(Val)
+
+	t.Run("GROUP BY region", func(t *testing.T) {
+		rows := sales()
+		a := &Aggregation{
+			GroupBy: KeyPart(0, 1),
+			Aggs:    []Agg{Count(), CountOf(amount), Sum(amount), Avg(amount), Min(amount), Max(amount)},
+		}
+		groups := aggregateAll(t, rows, a)
+		assert.Equal(t, map[string][]any{
+			"east":  {3.0, 3.0, 60.0, 20.0, 10.0, 30.0},
+			"north": {1.0, 0.0, "NULL", "NULL", "NULL", "NULL"},
+			"south": {1.0, 1.0, 100.0, 100.0, 100.0, 100.0},
+			"west":  {3.0, 2.0, 12.0, 6.0, 5.0, 7.0},
+		}, summary(groups))
+		var keys []string
+		for _, g := range groups {
+			keys = append(keys, string(g.Key))
+		}
+		assert.Equal(t, []string{"east", "north", "south", "west"}, keys, "In GroupBy order")
+	})
+
+	t.Run("HAVING", func(t *testing.T) {
+		rows := sales()
+		a := &Aggregation{
+			GroupBy: KeyPart(0, 1),
+			Aggs:    []Agg{Count(), Sum(amount)},
+			Having:  func(g Group) bool { return !g.Values[1].Null && g.Values[1].Num > 50 },
+		}
+		assert.Equal(t, map[string][]any{"east": {3.0, 60.0}, "south": {1.0, 100.0}}, summary(aggregateAll(t, rows, a)))
+	})
+
+	t.Run("Streaming over a key prefix", func(t *testing.T) {
+		a := &Aggregation{GroupBy: KeyPrefix(0, 2), Aggs: []Agg{Sum(amount)}, KeyOrdered: true}
+		groups := SortAggregate(sales()(), a)
+
+		// the first group comes out before the rows are all read
+		g, ok, err := groups.Next()
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, "sales\x00east", string(g.Key))
+		assert.Equal(t, 60.0, g.Values[0].Num)
+		rest, err := CollectGroups(groups)
+		assert.NoError(t, err)
+		assert.Len(t, rest, 3)
+	})
+
+	t.Run("No GROUP BY", func(t *testing.T) {
+		rows := sales()
+		a := &Aggregation{Aggs: []Agg{Count(), Sum(amount), Max(amount)}}
+		assert.Equal(t, map[string][]any{"": {8.0, 172.0, 100.0}}, summary(aggregateAll(t, rows, a)))
+	})
+
+	// Edge cases
+	t.Run("No rows", func(t *testing.T) {
+		rows := func() Rows { return FromSlice(nil) }
+		a := &Aggregation{Aggs: []Agg{Count(), Sum(amount)}}
+		assert.Equal(t, map[string][]any{"": {0.0, "NULL"}}, summary(aggregateAll(t, rows, a)), "One group without GROUP BY")
+
+		a.GroupBy = KeyPart(0, 1)
+		assert.Empty(t, aggregateAll(t, rows, a), "No groups with GROUP BY")
+	})
+
+	t.Run("Rows out of order", func(t *testing.T) {
+		rows := func() Rows { return FromSlice([]Row{{Key: []byte("b")}, {Key: []byte("a")}, {Key: []byte("b")}}) }
+		a := &Aggregation{GroupBy: func(r Row) []byte { return r.Key }, Aggs: []Agg{Count()}, KeyOrdered: true}
+		groups, err := CollectGroups(SortAggregate(rows(), a))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"b", "a", "b"}, groupKeys(groups), "One group per run of rows, in the order of the rows")
+
+		a.KeyOrdered = false
+		groups, err = CollectGroups(SortAggregate(rows(), a))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a", "b"}, groupKeys(groups), "Sorted first")
+	})
+
+	t.Run("Streaming over a key prefix with a separator above other bytes", func(t *testing.T) {
+		// ':' sorts above '0', so the scan order puts the group a0 before the group a
+		rows := FromSlice([]Row{
+			{Key: []byte("a0:1"), Val: []byte("1")},
+			{Key: []byte("a:1"), Val: []byte("2")},
+			{Key: []byte("a:2"), Val: []byte("3")},
+			{Key: []byte("b:1"), Val: []byte("4")},
+		})
+		a := &Aggregation{GroupBy: KeyPrefix(':', 1), Aggs: []Agg{Count(), Sum(amount)}, KeyOrdered: true}
+		groups, err := CollectGroups(SortAggregate(rows, a))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"a0", "a", "b"}, groupKeys(groups))
+		assert.Equal(t, map[string][]any{"a0": {1.0, 1.0}, "a": {2.0, 5.0}, "b": {1.0, 4.0}}, summary(groups))
+	})
+
+	t.Run("Group keys equal for the comparator", func(t *testing.T) {
+		rows := FromSlice([]Row{{Key: []byte("a")}, {Key: []byte("A")}, {Key: []byte("b")}})
+		a := &Aggregation{
+			GroupBy:    func(r Row) []byte { return r.Key },
+			Aggs:       []Agg{Count()},
+			KeyOrdered: true,
+			Compare:    func(a, b []byte) int { return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b)) },
+		}
+		groups, err := CollectGroups(SortAggregate(rows, a))
+		assert.NoError(t, err)
+		assert.Equal(t, map[string][]any{"a": {2.0}, "b": {1.0}}, summary(groups))
+	})
+
+	t.Run("Bad aggregates and values", func(t *testing.T) {
+		rows := func() Rows { return FromSlice([]Row{{Key: []byte("k"), Val: []byte("x")}}) }
+		_, err := CollectGroups(HashAggregate(rows(), &Aggregation{Aggs: []Agg{{Func: AGG_SUM}}}))
+		assert.ErrorIs(t, err, ErrNoExpr)
+		_, err = CollectGroups(SortAggregate(rows(), &Aggregation{Aggs: []Agg{Sum(amount)}}))
+		assert.ErrorContains(t, err, `SUM(expr) of row "k"`)
+
+		fail := errors.New("disk on fire")
+		broken := &failingRows{err: fail}
+		_, err = CollectGroups(HashAggregate(broken, &Aggregation{Aggs: []Agg{Count()}}))
+		assert.ErrorIs(t, err, fail)
+		_, err = CollectGroups(SortAggregate(broken, &Aggregation{GroupBy: Val, Aggs: []Agg{Count()}}))
+		assert.ErrorIs(t, err, fail)
+	})
+}
+
+// Helper: Rows that fail
+type failingRows struct {
+	err error
+}
+
+func (f *failingRows) Next() (Row, bool, error) {
+	return Row{}, false, f.err
+}
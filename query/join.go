@@ -0,0 +1,337 @@
+package query
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Joins: nested loop, index nested loop and hash join
+
+/*
+*
+A join pairs each left row with the right rows whose join column is equal, like
+SELECT ... FROM left JOIN right ON LeftKey(left) = RightKey(right) AND On(left, right).
+An inner join drops the left rows without a match, a left join keeps them once with
+Matched false. Empty join columns are NULL and match nothing.
+
+The three operators differ in how they find the right rows of a left row:
+
+  - NestedLoopJoin scans the whole right side again for every left row, it is the only one
+    that works without an equality (LeftKey nil and only On)
+  - IndexJoin looks the key up, with a point get on the primary key of a Table or a range
+    scan of one of its secondary indexes
+  - HashJoin reads the right side once into a map from join column to rows
+
+All of them stream the left side and keep its order, the right rows of a left row come in
+the order of the right side.
+
+A Table describes rows stored under a key prefix of a Store, PlanJoin uses it to pick the
+operator: an index join when the join column is the primary key or has an index, else a
+hash join.
+*/
+type JoinKind int
+
+const (
+	JOIN_INNER JoinKind = iota
+	JOIN_LEFT
+)
+
+type Join struct {
+	Kind     JoinKind
+	LeftKey  KeyFunc                    // join column of the left rows, nil for a join on On alone
+	RightKey KeyFunc                    // join column of the right rows, IndexJoin doesn't need it
+	On       func(left, right Row) bool // extra condition, nil is true
+}
+
+// a left row and its match, Right is empty when a left join found none
+type JoinedRow struct {
+	Left    Row
+	Right   Row
+	Matched bool
+}
+
+// a stream of joined rows, Next returns false at the end
+type JoinedRows interface {
+	Next() (JoinedRow, bool, error)
+}
+
+var ErrNoJoinKey = errors.New("join without LeftKey and RightKey")
+
+// for each left row, candidates returns right rows and match keeps the ones that join
+type joinRows struct {
+	left       Rows
+	join       *Join
+	candidates func(left Row) (Rows, error)
+	keyMatch   bool // candidates only returns rows with an equal join column
+
+	cur     Row
+	right   Rows // candidates of cur, nil before the first left row
+	matched bool
+	err     error
+}
+
+func (j *joinRows) match(left, right Row) bool {
+	if !j.keyMatch && j.join.LeftKey != nil {
+		lk := j.join.LeftKey(left)
+		if len(lk) == 0 || !bytes.Equal(lk, j.join.RightKey(right)) {
+			return false
+		}
+	}
+	return j.join.On == nil || j.join.On(left, right)
+}
+
+func (j *joinRows) Next() (JoinedRow, bool, error) {
+	if j.err != nil {
+		return JoinedRow{}, false, j.err
+	}
+	for {
+		if j.right != nil {
+			row, ok, err := j.right.Next()
+			if err != nil {
+				j.err = err
+				return JoinedRow{}, false, err
+			}
+			if ok {
+				if j.match(j.cur, row) {
+					j.matched = true
+					return JoinedRow{Left: j.cur, Right: row, Matched: true}, true, nil
+				}
+				continue
+			}
+			j.right = nil
+			if !j.matched && j.join.Kind == JOIN_LEFT {
+				return JoinedRow{Left: j.cur}, true, nil
+			}
+		}
+
+		row, ok, err := j.left.Next()
+		if err != nil || !ok {
+			j.err = err
+			return JoinedRow{}, false, err
+		}
+		j.cur, j.matched = row, false
+		if j.right, err = j.candidates(row); err != nil {
+			j.err = err
+			return JoinedRow{}, false, err
+		}
+	}
+}
+
+// a join that fails on the first call to Next
+type joinError struct {
+	err error
+}
+
+func (e joinError) Next() (JoinedRow, bool, error) {
+	return JoinedRow{}, false, e.err
+}
+
+// rows that fail on the first call to Next
+type rowsError struct {
+	err error
+}
+
+func (e rowsError) Next() (Row, bool, error) {
+	return Row{}, false, e.err
+}
+
+// scan right() again for every left row
+func NestedLoopJoin(left Rows, right func() Rows, j *Join) JoinedRows {
+	if (j.LeftKey == nil) != (j.RightKey == nil) {
+		return joinError{ErrNoJoinKey}
+	}
+	return &joinRows{left: left, join: j, candidates: func(Row) (Rows, error) { return right(), nil }}
+}
+
+// look up the right rows of each left row by its join column
+func IndexJoin(left Rows, lookup func(key []byte) Rows, j *Join) JoinedRows {
+	if j.LeftKey == nil {
+		return joinError{ErrNoJoinKey}
+	}
+	return &joinRows{left: left, join: j, keyMatch: true, candidates: func(row Row) (Rows, error) {
+		key := j.LeftKey(row)
+		if len(key) == 0 {
+			return FromSlice(nil), nil // NULL
+		}
+		return lookup(key), nil
+	}}
+}
+
+// read the right rows into a map by join column, then probe it with each left row
+func HashJoin(left Rows, right Rows, j *Join) JoinedRows {
+	if j.LeftKey == nil || j.RightKey == nil {
+		return joinError{ErrNoJoinKey}
+	}
+	var table map[string][]Row
+	return &joinRows{left: left, join: j, keyMatch: true, candidates: func(row Row) (Rows, error) {
+		if table == nil {
+			// built on the first left row, an empty left side never reads the right one
+			table = map[string][]Row{}
+			for {
+				r, ok, err := right.Next()
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					break
+				}
+				if key := j.RightKey(r); len(key) > 0 {
+					table[string(key)] = append(table[string(key)], r)
+				}
+			}
+		}
+		key := j.LeftKey(row)
+		if len(key) == 0 {
+			return FromSlice(nil), nil // NULL
+		}
+		return FromSlice(table[string(key)]), nil
+	}}
+}
+
+// the reads a Table makes where its rows are stored: point gets and range scans in key order
+type Store interface {
+	Get(key []byte) ([]byte, bool)
+	Scan(start, end []byte) Rows // keys in [start, end), a nil end means no upper bound
+}
+
+// rows stored under a key prefix, the rest of the key is the primary key
+type Table struct {
+	Store   Store
+	Prefix  []byte
+	Columns map[string]KeyFunc // columns that can be joined on, besides the primary key
+	Indexes map[string][]byte  // prefix of the secondary index of a column
+}
+
+/*
+*
+A secondary index has an entry per row, its key is the index prefix, the value of the
+column, a 0 byte and the primary key, and its value is empty. Column values with a 0 byte
+can't be indexed this way, the caller keeps the entries in sync with the rows.
+*/
+func IndexEntry(prefix, value, primaryKey []byte) []byte {
+	return append(append(append(bytes.Clone(prefix), value...), 0), primaryKey...)
+}
+
+// the primary key of a row of the table
+func (t *Table) PrimaryKey(row Row) []byte {
+	return row.Key[len(t.Prefix):]
+}
+
+func (t *Table) Scan() Rows {
+	end := bytes.Clone(t.Prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) == 0 {
+		return t.Store.Scan(t.Prefix, nil)
+	}
+	end[len(end)-1]++
+	return t.Store.Scan(t.Prefix, end)
+}
+
+// the row with this primary key, a point get
+func (t *Table) LookupPrimaryKey(key []byte) Rows {
+	full := append(bytes.Clone(t.Prefix), key...)
+	val, ok := t.Store.Get(full)
+	if !ok {
+		return FromSlice(nil)
+	}
+	return FromSlice([]Row{{Key: full, Val: bytes.Clone(val)}})
+}
+
+// the rows whose column has this value, through its index
+func (t *Table) LookupIndex(column string) func(value []byte) Rows {
+	prefix, ok := t.Indexes[column]
+	return func(value []byte) Rows {
+		if !ok {
+			return rowsError{fmt.Errorf("table %q has no index on %q", t.Prefix, column)}
+		}
+		start := IndexEntry(prefix, value, nil)
+		end := bytes.Clone(start)
+		end[len(end)-1] = 1
+		return &indexRows{table: t, entries: t.Store.Scan(start, end), skip: len(start)}
+	}
+}
+
+// the rows of the entries of an index scan
+type indexRows struct {
+	table   *Table
+	entries Rows
+	skip    int // bytes before the primary key in an entry
+}
+
+func (r *indexRows) Next() (Row, bool, error) {
+	entry, ok, err := r.entries.Next()
+	if err != nil || !ok {
+		return Row{}, false, err
+	}
+	rows, err := Collect(r.table.LookupPrimaryKey(entry.Key[r.skip:]))
+	if err != nil {
+		return Row{}, false, err
+	}
+	if len(rows) == 0 {
+		return Row{}, false, fmt.Errorf("index entry %q points to a missing row", entry.Key)
+	}
+	return rows[0], true, nil
+}
+
+type JoinMethod int
+
+const (
+	JOIN_NESTED_LOOP JoinMethod = iota
+	JOIN_INDEX_PRIMARY
+	JOIN_INDEX_SECONDARY
+	JOIN_HASH
+)
+
+func (m JoinMethod) String() string {
+	switch m {
+	case JOIN_NESTED_LOOP:
+		return "nested loop join"
+	case JOIN_INDEX_PRIMARY:
+		return "index join on the primary key"
+	case JOIN_INDEX_SECONDARY:
+		return "index join on a secondary index"
+	case JOIN_HASH:
+		return "hash join"
+	}
+	return fmt.Sprintf("JoinMethod(%d)", int(m))
+}
+
+// the column name of the primary key for PlanJoin
+const PRIMARY_KEY = ""
+
+// join the left rows with the table on column, with the cheapest operator for its indexes.
+// j.RightKey is set from the column, a join on On alone is a nested loop join
+func PlanJoin(left Rows, right *Table, column string, j *Join) (JoinedRows, JoinMethod, error) {
+	plan := *j
+	if j.LeftKey == nil {
+		return NestedLoopJoin(left, right.Scan, &plan), JOIN_NESTED_LOOP, nil
+	}
+	if column == PRIMARY_KEY {
+		plan.RightKey = right.PrimaryKey
+		return IndexJoin(left, right.LookupPrimaryKey, &plan), JOIN_INDEX_PRIMARY, nil
+	}
+	col, ok := right.Columns[column]
+	if !ok {
+		return nil, 0, fmt.Errorf("table %q has no column %q", right.Prefix, column)
+	}
+	plan.RightKey = col
+	if _, ok := right.Indexes[column]; ok {
+		return IndexJoin(left, right.LookupIndex(column), &plan), JOIN_INDEX_SECONDARY, nil
+	}
+	return HashJoin(left, right.Scan(), &plan), JOIN_HASH, nil
+}
+
+// read every joined row of a stream
+func CollectJoined(rows JoinedRows) ([]JoinedRow, error) {
+	var out []JoinedRow
+	for {
+		row, ok, err := rows.Next()
+		if err != nil || !ok {
+			return out, err
+		}
+		out = append(out, row)
+	}
+}
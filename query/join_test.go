@@ -0,0 +1,169 @@
+package query
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: A Store in a map, scans sort the keys
+type mapStore map[string][]byte
+
+func (m mapStore) Get(key []byte) ([]byte, bool) {
+	val, ok := m[string(key)]
+	return val, ok
+}
+
+func (m mapStore) Scan(start, end []byte) Rows {
+	var rows []Row
+	for k, v := range m {
+		if k >= string(start) && (end == nil || k < string(end)) {
+			rows = append(rows, Row{Key: []byte(k), Val: v})
+		}
+	}
+	slices.SortFunc(rows, func(a, b Row) int { return bytes.Compare(a.Key, b.Key) })
+	return FromSlice(rows)
+}
+
+// Helper: users \0 id -> name, orders \0 id -> user id, and an index of the orders by user
+func shop(store Store, set func(key, val []byte)) (*Table, *Table) {
+	users := &Table{Store: store, Prefix: []byte("users\x00"), Columns: map[string]KeyFunc{"name": Val}}
+	orders := &Table{
+		Store:   store,
+		Prefix:  []byte("orders\x00"),
+		Columns: map[string]KeyFunc{"user": Val},
+		Indexes: map[string][]byte{"user": []byte("idx\x00orders\x00user\x00")},
+	}
+	for id, name := range map[string]string{"1": "ann", "2": "bob", "3": "cid"} {
+		set(append(bytes.Clone(users.Prefix), id...), []byte(name))
+	}
+	for id, user := range map[string]string{"a": "1", "b": "2", "c": "1", "d": "9", "e": ""} {
+		set(append(bytes.Clone(orders.Prefix), id...), []byte(user))
+		if user != "" {
+			set(IndexEntry(orders.Indexes["user"], []byte(user), []byte(id)), nil)
+		}
+	}
+	return users, orders
+}
+
+// Helper: The shop in a mapStore
+func shopMap() (mapStore, *Table, *Table) {
+	m := mapStore{}
+	users, orders := shop(m, func(key, val []byte) { m[string(key)] = val })
+	return m, users, orders
+}
+
+// Helper: Joined rows as "left key = right key" strings, "-" for no match
+func pairs(t *testing.T, rows JoinedRows) []string {
+	joined, err := CollectJoined(rows)
+	assert.NoError(t, err)
+	var out []string
+	for _, row := range joined {
+		right := "-"
+		if row.Matched {
+			right = string(row.Right.Key)
+		}
+		out = append(out, string(row.Left.Key)+" = "+right)
+	}
+	return out
+}
+
+func TestJoin(t *testing.T) {
+	t.Run("Orders with their user, every operator", func(t *testing.T) {
+		_, users, orders := shopMap()
+		for _, kind := range []JoinKind{JOIN_INNER, JOIN_LEFT} {
+			j := &Join{Kind: kind, LeftKey: Val, RightKey: users.PrimaryKey}
+			want := []string{"orders\x00a = users\x001", "orders\x00b = users\x002", "orders\x00c = users\x001"}
+			if kind == JOIN_LEFT {
+				want = []string{"orders\x00a = users\x001", "orders\x00b = users\x002", "orders\x00c = users\x001", "orders\x00d = -", "orders\x00e = -"}
+			}
+			assert.Equal(t, want, pairs(t, NestedLoopJoin(orders.Scan(), users.Scan, j)), "nested loop")
+			assert.Equal(t, want, pairs(t, IndexJoin(orders.Scan(), users.LookupPrimaryKey, j)), "index")
+			assert.Equal(t, want, pairs(t, HashJoin(orders.Scan(), users.Scan(), j)), "hash")
+
+			rows, method, err := PlanJoin(orders.Scan(), users, PRIMARY_KEY, &Join{Kind: kind, LeftKey: Val})
+			assert.NoError(t, err)
+			assert.Equal(t, JOIN_INDEX_PRIMARY, method)
+			assert.Equal(t, want, pairs(t, rows))
+		}
+	})
+
+	t.Run("Users with their orders through the secondary index", func(t *testing.T) {
+		_, users, orders := shopMap()
+		j := &Join{Kind: JOIN_LEFT, LeftKey: users.PrimaryKey}
+		rows, method, err := PlanJoin(users.Scan(), orders, "user", j)
+		assert.NoError(t, err)
+		assert.Equal(t, JOIN_INDEX_SECONDARY, method)
+		want := []string{"users\x001 = orders\x00a", "users\x001 = orders\x00c", "users\x002 = orders\x00b", "users\x003 = -"}
+		assert.Equal(t, want, pairs(t, rows))
+
+		j.RightKey = Val
+		assert.Equal(t, want, pairs(t, HashJoin(users.Scan(), orders.Scan(), j)))
+	})
+
+	t.Run("Hash join on a column without an index", func(t *testing.T) {
+		_, users, orders := shopMap()
+		delete(orders.Indexes, "user")
+		rows, method, err := PlanJoin(users.Scan(), orders, "user", &Join{LeftKey: users.PrimaryKey})
+		assert.NoError(t, err)
+		assert.Equal(t, JOIN_HASH, method)
+		assert.Equal(t, []string{"users\x001 = orders\x00a", "users\x001 = orders\x00c", "users\x002 = orders\x00b"}, pairs(t, rows))
+	})
+
+	t.Run("Extra condition and join on a condition alone", func(t *testing.T) {
+		_, users, orders := shopMap()
+		notC := func(left, right Row) bool { return !bytes.HasSuffix(left.Key, []byte("c")) }
+		j := &Join{LeftKey: Val, On: notC}
+		rows, _, err := PlanJoin(orders.Scan(), users, PRIMARY_KEY, j)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"orders\x00a = users\x001", "orders\x00b = users\x002"}, pairs(t, rows))
+
+		// users whose name sorts after the name of another user
+		after := func(left, right Row) bool { return bytes.Compare(left.Val, right.Val) > 0 }
+		rows, method, err := PlanJoin(users.Scan(), users, "name", &Join{On: after})
+		assert.NoError(t, err)
+		assert.Equal(t, JOIN_NESTED_LOOP, method)
+		assert.Equal(t, []string{"users\x002 = users\x001", "users\x003 = users\x001", "users\x003 = users\x002"}, pairs(t, rows))
+	})
+
+	// Edge cases
+	t.Run("Empty sides", func(t *testing.T) {
+		_, users, _ := shopMap()
+		j := &Join{Kind: JOIN_LEFT, LeftKey: Val, RightKey: users.PrimaryKey}
+		assert.Empty(t, pairs(t, HashJoin(FromSlice(nil), rowsError{assert.AnError}, j)), "The right side is not read")
+		assert.Equal(t, []string{"x = -"}, pairs(t, NestedLoopJoin(FromSlice([]Row{{Key: []byte("x"), Val: []byte("1")}}), func() Rows { return FromSlice(nil) }, j)))
+	})
+
+	t.Run("Missing join keys and columns", func(t *testing.T) {
+		_, users, orders := shopMap()
+		_, err := CollectJoined(HashJoin(orders.Scan(), users.Scan(), &Join{LeftKey: Val}))
+		assert.ErrorIs(t, err, ErrNoJoinKey)
+		_, err = CollectJoined(NestedLoopJoin(orders.Scan(), users.Scan, &Join{RightKey: Val}))
+		assert.ErrorIs(t, err, ErrNoJoinKey)
+		_, err = CollectJoined(IndexJoin(orders.Scan(), users.LookupPrimaryKey, &Join{}))
+		assert.ErrorIs(t, err, ErrNoJoinKey)
+		_, _, err = PlanJoin(orders.Scan(), users, "age", &Join{LeftKey: Val})
+		assert.ErrorContains(t, err, `has no column "age"`)
+		_, err = Collect(users.LookupIndex("name")([]byte("ann")))
+		assert.ErrorContains(t, err, `has no index on "name"`)
+	})
+
+	t.Run("Index entry of a missing row", func(t *testing.T) {
+		m, users, orders := shopMap()
+		delete(m, "orders\x00a")
+		_, err := CollectJoined(IndexJoin(users.Scan(), orders.LookupIndex("user"), &Join{LeftKey: users.PrimaryKey}))
+		assert.ErrorContains(t, err, "points to a missing row")
+	})
+
+	t.Run("Errors stop the join", func(t *testing.T) {
+		_, users, _ := shopMap()
+		j := &Join{LeftKey: Val, RightKey: users.PrimaryKey}
+		rows := HashJoin(FromSlice([]Row{{Key: []byte("x"), Val: []byte("1")}}), rowsError{assert.AnError}, j)
+		_, _, err := rows.Next()
+		assert.ErrorIs(t, err, assert.AnError)
+		_, _, err = rows.Next()
+		assert.ErrorIs(t, err, assert.AnError, "The error sticks")
+	})
+}
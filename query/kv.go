@@ -0,0 +1,62 @@
+package query
+
+import (
+	"bytes"
+
+	"building-a-db/db"
+)
+
+// Rows from db.KV
+
+// the reads of db.KV and db.KVTX, a scan in a transaction sees its writes
+type Reader interface {
+	Get(key []byte) ([]byte, bool)
+	Seek(key []byte, cmp int) *db.BIter
+	Compare(a, b []byte) int // the order of the keys
+}
+
+// the rows of kv with keys in [start, end) in the order of its comparator, a nil end means
+// no upper bound
+func Scan(kv Reader, start, end []byte) Rows {
+	return &scanRows{kv: kv, start: start, end: end}
+}
+
+type scanRows struct {
+	kv         Reader
+	start, end []byte
+	iter       *db.BIter
+}
+
+func (s *scanRows) Next() (Row, bool, error) {
+	if s.iter == nil {
+		s.iter = s.kv.Seek(s.start, db.CMP_GE)
+	} else {
+		s.iter.Next()
+	}
+	if !s.iter.Valid() {
+		return Row{}, false, nil
+	}
+	key, val := s.iter.Deref()
+	if s.end != nil && s.kv.Compare(key, s.end) >= 0 {
+		return Row{}, false, nil
+	}
+	return Row{Key: bytes.Clone(key), Val: bytes.Clone(val)}, true, nil
+}
+
+// kv as the Store of a Table
+func KV(kv Reader) Store {
+	return kvStore{kv}
+}
+
+type kvStore struct {
+	kv Reader
+}
+
+func (s kvStore) Get(key []byte) ([]byte, bool) {
+	val, ok := s.kv.Get(key)
+	return bytes.Clone(val), ok
+}
+
+func (s kvStore) Scan(start, end []byte) Rows {
+	return Scan(s.kv, start, end)
+}
@@ -0,0 +1,99 @@
+package query
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"building-a-db/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Open a database in a fresh directory
+func openKV(t *testing.T) *db.KV {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	t.Cleanup(func() { kv.Close() })
+	return kv
+}
+
+// Helper: The sales of sales() in a database, next to another table
+func salesKV(t *testing.T) *db.KV {
+	kv := openKV(t)
+	tx, _ := kv.Begin()
+	rows, err := Collect(sales()())
+	assert.NoError(t, err)
+	for _, row := range rows {
+		assert.NoError(t, tx.Set(row.Key, row.Val))
+	}
+	tx.Set([]byte("other\x00x"), []byte("1"))
+	assert.NoError(t, tx.Commit())
+	return kv
+}
+
+func TestScan(t *testing.T) {
+	t.Run("Range of keys", func(t *testing.T) {
+		kv := salesKV(t)
+		rows, err := Collect(Scan(kv, []byte("sales\x00"), []byte("sales\x01")))
+		assert.NoError(t, err)
+		want, _ := Collect(sales()())
+		assert.Equal(t, want, rows)
+
+		rows, _ = Collect(Scan(kv, nil, nil))
+		assert.Len(t, rows, 9, "Whole database")
+	})
+
+	t.Run("Aggregation streams over the scan", func(t *testing.T) {
+		kv := salesKV(t)
+		a := &Aggregation{GroupBy: KeyPrefix(0, 2), Aggs: []Agg{Count()}, KeyOrdered: true}
+		groups, err := CollectGroups(Aggregate(Scan(kv, []byte("sales\x00"), []byte("sales\x01")), a))
+		assert.NoError(t, err)
+		var got []string
+		for _, g := range groups {
+			got = append(got, fmt.Sprintf("%q %v", g.Key, g.Values[0].Num))
+		}
+		assert.Equal(t, []string{`"sales\x00east" 3`, `"sales\x00north" 1`, `"sales\x00south" 1`, `"sales\x00west" 3`}, got)
+	})
+
+	t.Run("End in the order of the KV", func(t *testing.T) {
+		kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db"), Comparator: db.COMPARE_REVERSE}
+		assert.NoError(t, kv.Open())
+		defer kv.Close()
+		for _, k := range []string{"a", "b", "c", "d"} {
+			assert.NoError(t, kv.Set([]byte(k), nil))
+		}
+		rows, err := Collect(Scan(kv, []byte("c"), []byte("a")))
+		assert.NoError(t, err)
+		assert.Equal(t, []Row{{Key: []byte("c"), Val: []byte{}}, {Key: []byte("b"), Val: []byte{}}}, rows)
+	})
+
+	// Edge cases
+	t.Run("Empty range", func(t *testing.T) {
+		kv := salesKV(t)
+		rows, err := Collect(Scan(kv, []byte("nothing"), []byte("nothinh")))
+		assert.NoError(t, err)
+		assert.Empty(t, rows)
+	})
+}
+
+func TestKVStore(t *testing.T) {
+	kv := openKV(t)
+	tx, _ := kv.Begin()
+	users, orders := shop(KV(kv), func(key, val []byte) { assert.NoError(t, tx.Set(key, val)) })
+	assert.NoError(t, tx.Commit())
+	_, memUsers, memOrders := shopMap()
+
+	// the joins find the same rows in the database as in memory
+	rows, method, err := PlanJoin(orders.Scan(), users, PRIMARY_KEY, &Join{Kind: JOIN_LEFT, LeftKey: Val})
+	assert.NoError(t, err)
+	assert.Equal(t, JOIN_INDEX_PRIMARY, method)
+	want, _, _ := PlanJoin(memOrders.Scan(), memUsers, PRIMARY_KEY, &Join{Kind: JOIN_LEFT, LeftKey: Val})
+	assert.Equal(t, pairs(t, want), pairs(t, rows))
+
+	rows, method, err = PlanJoin(users.Scan(), orders, "user", &Join{LeftKey: users.PrimaryKey})
+	assert.NoError(t, err)
+	assert.Equal(t, JOIN_INDEX_SECONDARY, method)
+	want, _, _ = PlanJoin(memUsers.Scan(), memOrders, "user", &Join{LeftKey: memUsers.PrimaryKey})
+	assert.Equal(t, pairs(t, want), pairs(t, rows))
+}
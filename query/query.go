@@ -0,0 +1,122 @@
+package query
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Query operators over key-value rows
+
+/*
+*
+A query is a pipeline of operators, package minisql builds them from SQL and other callers
+by hand. Operators read a stream of rows, a row is a key-value pair, in key order when it
+comes from a range scan:
+
+	rows  ->  HashAggregate / SortAggregate  ->  groups
+	rows x Table  ->  NestedLoopJoin / IndexJoin / HashJoin  ->  joined rows
+
+Columns are whatever the caller makes of the key and the value, an Expr or a KeyFunc pulls
+them out of a row. The helpers here cover the layout of the other packages: key parts split
+by a separator byte, with the table name first, and decimal numbers in values.
+
+Rows are not reused, an operator can keep them after the next call to Next. Sources that
+read from a tree copy the keys and values they return.
+*/
+type Row struct {
+	Key []byte
+	Val []byte
+}
+
+// a stream of rows, Next returns false at the end
+type Rows interface {
+	Next() (Row, bool, error)
+}
+
+// rows from a slice
+func FromSlice(rows []Row) Rows {
+	return &sliceRows{rows: rows}
+}
+
+type sliceRows struct {
+	rows []Row
+	next int
+}
+
+func (s *sliceRows) Next() (Row, bool, error) {
+	if s.next == len(s.rows) {
+		return Row{}, false, nil
+	}
+	s.next++
+	return s.rows[s.next-1], true, nil
+}
+
+// read every row of a stream
+func Collect(rows Rows) ([]Row, error) {
+	var out []Row
+	for {
+		row, ok, err := rows.Next()
+		if err != nil || !ok {
+			return out, err
+		}
+		out = append(out, row)
+	}
+}
+
+// a column of a row, nil is NULL
+type KeyFunc func(row Row) []byte
+
+// the value of the row
+func Val(row Row) []byte {
+	return row.Val
+}
+
+// part i of the key split on sep
+func KeyPart(sep byte, i int) KeyFunc {
+	return func(row Row) []byte {
+		parts := bytes.Split(row.Key, []byte{sep})
+		if i >= len(parts) {
+			return nil
+		}
+		return parts[i]
+	}
+}
+
+// the first n parts of the key split on sep, with the separators between them. Rows in
+// key order are grouped by a key prefix, so an aggregation grouped by it can stream. The
+// groups are in key order only when sep is 0, and with another sep the keys must all have
+// n parts: a key with fewer is its own prefix and a is not next to a:1 when a0:1 exists
+func KeyPrefix(sep byte, n int) KeyFunc {
+	return func(row Row) []byte {
+		end := 0
+		for i := 0; i < n; i++ {
+			j := bytes.IndexByte(row.Key[end:], sep)
+			if j < 0 {
+				return row.Key
+			}
+			if i == n-1 {
+				return row.Key[:end+j]
+			}
+			end += j + 1
+		}
+		return row.Key[:end]
+	}
+}
+
+// a number computed from a row, ok is false for NULL
+type Expr func(row Row) (num float64, ok bool, err error)
+
+// a column read as a decimal number, an empty or missing column is NULL
+func Number(col KeyFunc) Expr {
+	return func(row Row) (float64, bool, error) {
+		b := col(row)
+		if len(b) == 0 {
+			return 0, false, nil
+		}
+		num, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			return 0, false, err
+		}
+		return num, true, nil
+	}
+}
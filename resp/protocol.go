@@ -0,0 +1,144 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RESP2 encoding, see https://redis.io/docs/latest/develop/reference/protocol-spec/
+
+/*
+*
+A request is an array of bulk strings:
+
+	*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n
+
+Clients like telnet send "inline" commands instead, a line of space separated words:
+
+	GET key\r\n
+
+Replies:
+
+	+OK\r\n            simple string
+	-ERR message\r\n   error
+	:42\r\n            integer
+	$5\r\nhello\r\n    bulk string, $-1\r\n is nil
+	*2\r\n...          array of replies, *-1\r\n is nil
+*/
+
+// bigger requests are rejected instead of allocating whatever the client asks for
+const MAX_BULK_SIZE = 1 << 20
+const MAX_ARRAY_LEN = 1 << 16
+
+var errProtocol = errors.New("protocol error")
+
+// read a line without the trailing \r\n
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: line should end with \\r\\n", errProtocol)
+	}
+	return line[:len(line)-2], nil
+}
+
+// parse the length after a * or $ prefix
+func readLength(r *bufio.Reader, prefix byte, max int) (int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != prefix {
+		return 0, fmt.Errorf("%w: expected '%c'", errProtocol, prefix)
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("%w: bad length %q", errProtocol, line[1:])
+	}
+	return n, nil
+}
+
+// read one command, either a RESP array of bulk strings or an inline command
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Fields(line), nil
+	}
+
+	n, err := readLength(r, '*', MAX_ARRAY_LEN)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		size, err := readLength(r, '$', MAX_BULK_SIZE)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string should end with \\r\\n", errProtocol)
+		}
+		args[i] = buf[:size]
+	}
+	return args, nil
+}
+
+// Reply encoding, replies are built as bytes so that EXEC can collect them before writing
+
+func simpleString(s string) []byte {
+	return []byte("+" + s + "\r\n")
+}
+
+func errorReply(msg string) []byte {
+	return []byte("-" + msg + "\r\n")
+}
+
+func integer(n int) []byte {
+	return []byte(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func bulk(b []byte) []byte {
+	if b == nil {
+		return []byte("$-1\r\n")
+	}
+	out := []byte("$" + strconv.Itoa(len(b)) + "\r\n")
+	out = append(out, b...)
+	return append(out, '\r', '\n')
+}
+
+func array(items [][]byte) []byte {
+	if items == nil {
+		return []byte("*-1\r\n")
+	}
+	out := []byte("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+// an array of bulk strings
+func bulkArray(items [][]byte) []byte {
+	replies := make([][]byte, len(items))
+	for i, item := range items {
+		replies[i] = bulk(item)
+	}
+	return array(replies)
+}
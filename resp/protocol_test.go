@@ -0,0 +1,92 @@
+package resp
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reader(s string) *bufio.Reader {
+	return bufio.NewReader(strings.NewReader(s))
+}
+
+func TestReadCommand(t *testing.T) {
+	t.Run("Array of bulk strings", func(t *testing.T) {
+		args, err := readCommand(reader("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\na\r\nb!\r\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("SET"), []byte("key"), []byte("a\r\nb!")}, args)
+	})
+
+	t.Run("Inline command", func(t *testing.T) {
+		args, err := readCommand(reader("GET  key\r\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, [][]byte{[]byte("GET"), []byte("key")}, args)
+	})
+
+	t.Run("Empty bulk string", func(t *testing.T) {
+		args, err := readCommand(reader("*2\r\n$3\r\nGET\r\n$0\r\n\r\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{}, args[1])
+	})
+
+	// Edge cases
+	t.Run("Protocol errors", func(t *testing.T) {
+		bad := []string{
+			"*1\r\n+GET\r\n",         // not a bulk string
+			"*x\r\n",                 // bad array length
+			"*1\r\n$-1\r\n",          // negative bulk length
+			"*1\r\n$3\r\nGETX\r\n",   // bulk string longer than announced
+			"*1\r\n$99999999999\r\n", // bulk string too big
+			"GET key\n",              // missing \r
+		}
+		for _, input := range bad {
+			_, err := readCommand(reader(input))
+			assert.ErrorIs(t, err, errProtocol, "input %q", input)
+		}
+	})
+
+	t.Run("Truncated input", func(t *testing.T) {
+		_, err := readCommand(reader("*2\r\n$3\r\nGET\r\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestReplies(t *testing.T) {
+	assert.Equal(t, "+OK\r\n", string(simpleString("OK")))
+	assert.Equal(t, "-ERR bad\r\n", string(errorReply("ERR bad")))
+	assert.Equal(t, ":42\r\n", string(integer(42)))
+	assert.Equal(t, "$2\r\nhi\r\n", string(bulk([]byte("hi"))))
+	assert.Equal(t, "$0\r\n\r\n", string(bulk([]byte{})))
+	assert.Equal(t, "$-1\r\n", string(bulk(nil)))
+	assert.Equal(t, "*-1\r\n", string(array(nil)))
+	assert.Equal(t, "*2\r\n$1\r\na\r\n$-1\r\n", string(bulkArray([][]byte{[]byte("a"), nil})))
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"user:*", "user:1", true},
+		{"user:*", "users", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"a/*", "a/b/c", true},
+		{"*c", "a/b/c", true},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.match, globMatch([]byte(tc.pattern), []byte(tc.s)), "%q ~ %q", tc.pattern, tc.s)
+	}
+}
@@ -0,0 +1,458 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"building-a-db/db"
+)
+
+// Redis compatible server in front of db.KV
+
+/*
+*
+Every command runs in its own db transaction, MULTI/EXEC runs all the queued commands
+in one transaction so they are committed together.
+
+db.KV only allows a single transaction at a time, so commands from all the connections
+are serialised with a mutex.
+
+Supported commands: PING, ECHO, GET, SET, DEL, EXISTS, MGET, MSET, SCAN, MULTI, EXEC, DISCARD, QUIT
+
+A command either does all of its work or none of it: the keys and values are checked before
+the first write, and a command run on its own that still fails is rolled back. Inside EXEC a
+failed command replies with its error and the others are committed, as in Redis.
+
+Unlike Redis the empty key is not a valid key, db.KV keeps it for the first key of the tree.
+SET, MSET and DEL of an empty key reply with an error, GET and MGET find nothing and EXISTS
+counts 0.
+*/
+type Server struct {
+	db *db.KV
+	mu sync.Mutex // protects db
+
+	lnMu   sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// the state of a client connection
+type client struct {
+	conn   net.Conn
+	multi  bool       // between MULTI and EXEC
+	queued [][][]byte // commands queued by MULTI
+	failed bool       // a command could not be queued, EXEC will abort
+}
+
+var ErrServerClosed = errors.New("resp: server closed")
+
+func NewServer(kv *db.KV) *Server {
+	return &Server{db: kv, conns: map[net.Conn]struct{}{}}
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// accept connections until Close is called
+func (s *Server) Serve(ln net.Listener) error {
+	s.lnMu.Lock()
+	if s.closed {
+		s.lnMu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.lnMu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.lnMu.Lock()
+			closed := s.closed
+			s.lnMu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.lnMu.Lock()
+		s.conns[conn] = struct{}{}
+		s.lnMu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// stop accepting connections and close the open ones
+func (s *Server) Close() error {
+	s.lnMu.Lock()
+	defer s.lnMu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.lnMu.Lock()
+		delete(s.conns, conn)
+		s.lnMu.Unlock()
+	}()
+
+	c := &client{conn: conn}
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.Write(errorReply("ERR " + err.Error()))
+				w.Flush()
+			}
+			return // io.EOF or a broken connection
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		reply, quit := s.dispatch(c, args)
+		w.Write(reply)
+		if r.Buffered() == 0 || quit {
+			// flush once the pipelined commands are done
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// handle the connection level commands, the rest runs against the database
+func (s *Server) dispatch(c *client, args [][]byte) ([]byte, bool) {
+	name := strings.ToUpper(string(args[0]))
+	switch name {
+	case "QUIT":
+		return simpleString("OK"), true
+	case "MULTI":
+		if c.multi {
+			return errorReply("ERR MULTI calls can not be nested"), false
+		}
+		c.multi, c.queued, c.failed = true, nil, false
+		return simpleString("OK"), false
+	case "DISCARD":
+		if !c.multi {
+			return errorReply("ERR DISCARD without MULTI"), false
+		}
+		c.multi, c.queued = false, nil
+		return simpleString("OK"), false
+	case "EXEC":
+		if !c.multi {
+			return errorReply("ERR EXEC without MULTI"), false
+		}
+		queued, failed := c.queued, c.failed
+		c.multi, c.queued = false, nil
+		if failed {
+			return errorReply("EXECABORT Transaction discarded because of previous errors."), false
+		}
+		replies, err := s.execTx(queued)
+		if err != nil {
+			return errorReply("ERR " + err.Error()), false
+		}
+		return array(replies), false
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		c.failed = c.multi
+		return errorReply("ERR unknown command '" + string(args[0]) + "'"), false
+	}
+	if !cmd.arity(len(args)) {
+		c.failed = c.multi
+		return errorReply("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command"), false
+	}
+	if c.multi {
+		c.queued = append(c.queued, args)
+		return simpleString("QUEUED"), false
+	}
+
+	replies, err := s.execTx([][][]byte{args})
+	if err != nil {
+		return errorReply("ERR " + err.Error()), false
+	}
+	return replies[0], false
+}
+
+// run commands in a single transaction
+func (s *Server) execTx(cmds [][][]byte) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	replies := make([][]byte, len(cmds))
+	for i, args := range cmds {
+		cmd := commands[strings.ToUpper(string(args[0]))]
+		replies[i] = cmd.run(tx, args[1:])
+	}
+	if len(cmds) == 1 && replies[0][0] == '-' {
+		// a failed command changes nothing
+		tx.Abort()
+		return replies, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// Commands
+
+type command struct {
+	arity func(nargs int) bool // nargs includes the command name
+	run   func(tx *db.KVTX, args [][]byte) []byte
+}
+
+func exactly(n int) func(int) bool { return func(nargs int) bool { return nargs == n } }
+func atLeast(n int) func(int) bool { return func(nargs int) bool { return nargs >= n } }
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":   {atLeast(1), cmdPing},
+		"ECHO":   {exactly(2), cmdEcho},
+		"GET":    {exactly(2), cmdGet},
+		"SET":    {exactly(3), cmdSet},
+		"DEL":    {atLeast(2), cmdDel},
+		"EXISTS": {atLeast(2), cmdExists},
+		"MGET":   {atLeast(2), cmdMGet},
+		"MSET":   {func(nargs int) bool { return nargs >= 3 && nargs%2 == 1 }, cmdMSet},
+		"SCAN":   {atLeast(2), cmdScan},
+	}
+}
+
+func cmdPing(tx *db.KVTX, args [][]byte) []byte {
+	if len(args) > 0 {
+		return bulk(args[0])
+	}
+	return simpleString("PONG")
+}
+
+func cmdEcho(tx *db.KVTX, args [][]byte) []byte {
+	return bulk(args[0])
+}
+
+func cmdGet(tx *db.KVTX, args [][]byte) []byte {
+	val, ok := tx.Get(args[0])
+	if !ok {
+		return bulk(nil)
+	}
+	return bulk(val)
+}
+
+func cmdSet(tx *db.KVTX, args [][]byte) []byte {
+	if err := tx.Set(args[0], args[1]); err != nil {
+		return errorReply("ERR " + err.Error())
+	}
+	return simpleString("OK")
+}
+
+func cmdDel(tx *db.KVTX, args [][]byte) []byte {
+	// check every key before the first delete, like MSET
+	for _, key := range args {
+		if err := tx.CheckLimit(key, nil); err != nil {
+			return errorReply("ERR " + err.Error())
+		}
+	}
+	n := 0
+	for _, key := range args {
+		deleted, err := tx.Del(key)
+		if err != nil {
+			return errorReply("ERR " + err.Error())
+		}
+		if deleted {
+			n++
+		}
+	}
+	return integer(n)
+}
+
+func cmdExists(tx *db.KVTX, args [][]byte) []byte {
+	n := 0
+	for _, key := range args {
+		if _, ok := tx.Get(key); ok {
+			n++
+		}
+	}
+	return integer(n)
+}
+
+func cmdMGet(tx *db.KVTX, args [][]byte) []byte {
+	vals := make([][]byte, len(args))
+	for i, key := range args {
+		if val, ok := tx.Get(key); ok {
+			vals[i] = val
+		}
+	}
+	return bulkArray(vals)
+}
+
+func cmdMSet(tx *db.KVTX, args [][]byte) []byte {
+	// MSET is all or nothing, check the limits before the first write
+	for i := 0; i < len(args); i += 2 {
+		if tx.CheckLimit(args[i], args[i+1]) != nil {
+			return errorReply("ERR key or value size out of range")
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		if err := tx.Set(args[i], args[i+1]); err != nil {
+			return errorReply("ERR " + err.Error())
+		}
+	}
+	return simpleString("OK")
+}
+
+/*
+*
+SCAN cursor [MATCH pattern] [COUNT count]
+
+Like Redis the cursor is opaque, 0 starts the iteration and the returned cursor is 0 when it is
+complete. Here it is the hex of the last visited key, so the next call seeks right after it and
+keys deleted or added in between don't shift the iteration. Each call visits up to COUNT keys
+(10 by default) and returns the ones matching the pattern.
+*/
+func cmdScan(tx *db.KVTX, args [][]byte) []byte {
+	var last []byte
+	if string(args[0]) != "0" {
+		var err error
+		if last, err = hex.DecodeString(string(args[0])); err != nil || len(last) == 0 {
+			return errorReply("ERR invalid cursor")
+		}
+	}
+	pattern, count := []byte("*"), 10
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errorReply("ERR syntax error")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			var err error
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil || count < 1 {
+				return errorReply("ERR value is not an integer or out of range")
+			}
+		default:
+			return errorReply("ERR syntax error")
+		}
+	}
+
+	iter := tx.Seek(nil, db.CMP_GE)
+	if last != nil {
+		iter = tx.Seek(last, db.CMP_GT)
+	}
+	keys := [][]byte{}
+	for visited := 0; visited < count && iter.Valid(); visited++ {
+		key, _ := iter.Deref()
+		if globMatch(pattern, key) {
+			keys = append(keys, bytes.Clone(key))
+		}
+		last = bytes.Clone(key)
+		iter.Next()
+	}
+	cursor := "0"
+	if iter.Valid() {
+		cursor = hex.EncodeToString(last)
+	}
+	return array([][]byte{bulk([]byte(cursor)), bulkArray(keys)})
+}
+
+// Redis style glob: * ? [abc] [a-z] [^a] and \ to escape
+func globMatch(pattern, s []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := bytes.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return false // unterminated class matches nothing
+			}
+			class := pattern[1 : end+1]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			if classMatch(class, s[0]) == negate {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+func classMatch(class []byte, c byte) bool {
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= c && c <= class[i+2] {
+				return true
+			}
+			i += 2
+		} else if class[i] == c {
+			return true
+		}
+	}
+	return false
+}
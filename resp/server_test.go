@@ -0,0 +1,302 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"building-a-db/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient sends commands as RESP arrays and reads raw replies
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Helper: Start a server on a random port backed by a fresh database
+func startServer(t *testing.T) (*Server, *db.KV, string) {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	srv := NewServer(kv)
+	go srv.Serve(ln)
+	t.Cleanup(func() {
+		srv.Close()
+		kv.Close()
+	})
+	return srv, kv, ln.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *testClient) send(args ...string) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	c.conn.Write([]byte(sb.String()))
+}
+
+// read one reply, including the nested replies of arrays, as raw RESP
+func (c *testClient) reply() string {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "read error: " + err.Error()
+	}
+	switch line[0] {
+	case '$':
+		var n int
+		fmt.Sscanf(line, "$%d", &n)
+		if n < 0 {
+			return line
+		}
+		buf := make([]byte, n+2)
+		c.r.Read(buf)
+		return line + string(buf)
+	case '*':
+		var n int
+		fmt.Sscanf(line, "*%d", &n)
+		for i := 0; i < n; i++ {
+			line += c.reply()
+		}
+	}
+	return line
+}
+
+func (c *testClient) do(args ...string) string {
+	c.send(args...)
+	return c.reply()
+}
+
+func TestServerCommands(t *testing.T) {
+	t.Run("GET, SET, DEL, EXISTS", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		assert.Equal(t, "+PONG\r\n", c.do("PING"))
+		assert.Equal(t, "$-1\r\n", c.do("GET", "k"))
+		assert.Equal(t, "+OK\r\n", c.do("SET", "k", "v"))
+		assert.Equal(t, "$1\r\nv\r\n", c.do("get", "k"))
+		assert.Equal(t, ":1\r\n", c.do("EXISTS", "k", "missing"))
+		assert.Equal(t, ":1\r\n", c.do("DEL", "k", "missing"))
+		assert.Equal(t, ":0\r\n", c.do("EXISTS", "k"))
+	})
+
+	t.Run("MSET and MGET", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		assert.Equal(t, "+OK\r\n", c.do("MSET", "a", "1", "b", "2"))
+		assert.Equal(t, "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n2\r\n", c.do("MGET", "a", "x", "b"))
+	})
+
+	t.Run("SCAN with cursor, MATCH and COUNT", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+		for i := 0; i < 25; i++ {
+			c.do("SET", fmt.Sprintf("user:%02d", i), "x")
+			c.do("SET", fmt.Sprintf("post:%02d", i), "x")
+		}
+
+		var users []string
+		cursor := "0"
+		for {
+			reply := c.do("SCAN", cursor, "MATCH", "user:*", "COUNT", "7")
+			lines := strings.Split(reply, "\r\n")
+			// *2, $len, cursor, *n, ($len, key)...
+			cursor = lines[2]
+			for i := 5; i < len(lines)-1; i += 2 {
+				users = append(users, lines[i])
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+		assert.Len(t, users, 25)
+		assert.Equal(t, "user:00", users[0])
+		assert.Equal(t, "user:24", users[24])
+	})
+
+	t.Run("Inline commands", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		c.conn.Write([]byte("SET k v\r\nGET k\r\n"))
+		assert.Equal(t, "+OK\r\n", c.reply())
+		assert.Equal(t, "$1\r\nv\r\n", c.reply())
+	})
+
+	t.Run("Data is persisted in the database", func(t *testing.T) {
+		_, kv, addr := startServer(t)
+		c := dial(t, addr)
+
+		c.do("SET", "k", "v")
+		val, ok := kv.Get([]byte("k"))
+		assert.True(t, ok)
+		assert.Equal(t, []byte("v"), val)
+	})
+
+	// Edge cases
+	t.Run("SCAN resumes after the last key when keys are deleted in between", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+		for i := 0; i < 6; i++ {
+			c.do("SET", fmt.Sprintf("k%d", i), "x")
+		}
+
+		lines := strings.Split(c.do("SCAN", "0", "COUNT", "3"), "\r\n")
+		assert.Equal(t, []string{"k0", "k1", "k2"}, []string{lines[5], lines[7], lines[9]})
+		cursor := lines[2]
+		assert.NotEqual(t, "0", cursor)
+
+		c.do("DEL", "k0", "k1", "k2")
+		lines = strings.Split(c.do("SCAN", cursor, "COUNT", "3"), "\r\n")
+		assert.Equal(t, "0", lines[2])
+		assert.Equal(t, []string{"k3", "k4", "k5"}, []string{lines[5], lines[7], lines[9]})
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		assert.Equal(t, "-ERR unknown command 'FOO'\r\n", c.do("FOO"))
+		assert.Equal(t, "-ERR wrong number of arguments for 'get' command\r\n", c.do("GET"))
+		assert.Equal(t, "-ERR wrong number of arguments for 'mset' command\r\n", c.do("MSET", "a", "1", "b"))
+		assert.Equal(t, "-ERR empty key\r\n", c.do("SET", "", "v"))
+		assert.Equal(t, "$-1\r\n", c.do("GET", ""))
+		assert.Equal(t, "-ERR invalid cursor\r\n", c.do("SCAN", "abc"))
+		assert.Equal(t, "-ERR invalid cursor\r\n", c.do("SCAN", ""))
+	})
+
+	t.Run("MSET is all or nothing", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		big := strings.Repeat("x", db.BTREE_MAX_KEY_SIZE+1)
+		assert.Contains(t, c.do("MSET", "a", "1", big, "2"), "-ERR")
+		assert.Equal(t, ":0\r\n", c.do("EXISTS", "a"))
+	})
+
+	t.Run("DEL is all or nothing", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		c.do("MSET", "a", "1", "b", "2")
+		assert.Equal(t, "-ERR empty key\r\n", c.do("DEL", "a", "", "b"))
+		big := strings.Repeat("x", db.BTREE_MAX_KEY_SIZE+1)
+		assert.Equal(t, "-ERR key too long\r\n", c.do("DEL", "a", big))
+		assert.Equal(t, ":2\r\n", c.do("EXISTS", "a", "b"))
+
+		// in a transaction the other commands still run
+		c.do("MULTI")
+		c.do("DEL", "a")
+		c.do("DEL", "b", "")
+		assert.Equal(t, "*2\r\n:1\r\n-ERR empty key\r\n", c.do("EXEC"))
+		assert.Equal(t, ":1\r\n", c.do("EXISTS", "a", "b"))
+	})
+
+	t.Run("Empty keys", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		assert.Equal(t, "-ERR empty key\r\n", c.do("SET", "", "v"))
+		assert.Equal(t, "-ERR key or value size out of range\r\n", c.do("MSET", "", "v"))
+		assert.Equal(t, "-ERR empty key\r\n", c.do("DEL", ""))
+		assert.Equal(t, "$-1\r\n", c.do("GET", ""))
+		assert.Equal(t, "*1\r\n$-1\r\n", c.do("MGET", ""))
+		assert.Equal(t, ":0\r\n", c.do("EXISTS", ""))
+	})
+
+	t.Run("QUIT closes the connection", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		assert.Equal(t, "+OK\r\n", c.do("QUIT"))
+		_, err := c.r.ReadByte()
+		assert.Error(t, err)
+	})
+}
+
+func TestServerMultiExec(t *testing.T) {
+	t.Run("Queued commands run together", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+		other := dial(t, addr)
+
+		assert.Equal(t, "+OK\r\n", c.do("MULTI"))
+		assert.Equal(t, "+QUEUED\r\n", c.do("SET", "a", "1"))
+		assert.Equal(t, "+QUEUED\r\n", c.do("GET", "a"))
+
+		// Nothing runs before EXEC
+		assert.Equal(t, "$-1\r\n", other.do("GET", "a"))
+
+		assert.Equal(t, "*2\r\n+OK\r\n$1\r\n1\r\n", c.do("EXEC"))
+		assert.Equal(t, "$1\r\n1\r\n", other.do("GET", "a"))
+	})
+
+	t.Run("DISCARD drops the queue", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		c.do("MULTI")
+		c.do("SET", "a", "1")
+		assert.Equal(t, "+OK\r\n", c.do("DISCARD"))
+		assert.Equal(t, "$-1\r\n", c.do("GET", "a"))
+	})
+
+	// Edge cases
+	t.Run("Bad command aborts EXEC", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		c.do("MULTI")
+		c.do("SET", "a", "1")
+		assert.Contains(t, c.do("GET"), "wrong number of arguments")
+		assert.Contains(t, c.do("EXEC"), "EXECABORT")
+		assert.Equal(t, "$-1\r\n", c.do("GET", "a"))
+	})
+
+	t.Run("EXEC and DISCARD without MULTI", func(t *testing.T) {
+		_, _, addr := startServer(t)
+		c := dial(t, addr)
+
+		assert.Equal(t, "-ERR EXEC without MULTI\r\n", c.do("EXEC"))
+		assert.Equal(t, "-ERR DISCARD without MULTI\r\n", c.do("DISCARD"))
+		c.do("MULTI")
+		assert.Equal(t, "-ERR MULTI calls can not be nested\r\n", c.do("MULTI"))
+	})
+}
+
+func TestServerClose(t *testing.T) {
+	kv := &db.KV{Path: filepath.Join(t.TempDir(), "test.db")}
+	assert.NoError(t, kv.Open())
+	defer kv.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	srv := NewServer(kv)
+	done := make(chan error)
+	go func() { done <- srv.Serve(ln) }()
+
+	c := dial(t, ln.Addr().String())
+	assert.Equal(t, "+PONG\r\n", c.do("PING"))
+
+	assert.NoError(t, srv.Close())
+	assert.ErrorIs(t, <-done, ErrServerClosed)
+	_, err = c.r.ReadByte()
+	assert.Error(t, err, "Open connections are closed")
+}
@@ -0,0 +1,308 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"building-a-db/db"
+	"building-a-db/minisql"
+)
+
+// database/sql driver for embedded use
+
+/*
+*
+Registers the driver "buildingdb", the data source name is the path of a database file:
+
+	import _ "building-a-db/sqldriver"
+
+	sqlDB, err := sql.Open("buildingdb", "data.db")
+	sqlDB.Exec("INSERT INTO users VALUES (?, ?)", "u1", "alice")
+
+The statements are the SQL of package minisql, with ? or $n placeholders. The connections
+of a process share one db.KV per file. It has a single transaction at a time, so a
+transaction holds the file until it ends and a statement outside of it waits, or fails when
+its context is done. Don't run statements on the *sql.DB while the same goroutine holds a
+*sql.Tx, they would wait for each other.
+*/
+type Driver struct{}
+
+func init() {
+	sql.Register("buildingdb", &Driver{})
+}
+
+var (
+	ErrIsolation  = errors.New("buildingdb: transactions are serializable, other isolation levels are not supported")
+	ErrTxControl  = errors.New("buildingdb: use BeginTx, Commit and Rollback instead of BEGIN, COMMIT and ROLLBACK")
+	ErrNoInsertID = errors.New("buildingdb: there are no auto increment keys")
+)
+
+// a database file opened by the connections of the process
+type database struct {
+	path string
+	kv   *db.KV
+	refs int
+	lock chan struct{} // held by a transaction, or by a statement that runs on its own
+}
+
+var (
+	mu        sync.Mutex // protects databases
+	databases = map[string]*database{}
+)
+
+func open(path string) (*database, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if d, ok := databases[abs]; ok {
+		d.refs++
+		return d, nil
+	}
+	kv := &db.KV{Path: abs}
+	if err := kv.Open(); err != nil {
+		return nil, err
+	}
+	if err := minisql.CheckOrder(kv); err != nil {
+		kv.Close()
+		return nil, err
+	}
+	d := &database{path: abs, kv: kv, refs: 1, lock: make(chan struct{}, 1)}
+	databases[abs] = d
+	return d, nil
+}
+
+func (d *database) release() error {
+	mu.Lock()
+	defer mu.Unlock()
+	d.refs--
+	if d.refs > 0 {
+		return nil
+	}
+	delete(databases, d.path)
+	return d.kv.Close()
+}
+
+func (d *database) acquire(ctx context.Context) error {
+	select {
+	case d.lock <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *database) unlock() {
+	<-d.lock
+}
+
+// open a connection to the database file at name
+func (Driver) Open(name string) (driver.Conn, error) {
+	d, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{db: d}, nil
+}
+
+type conn struct {
+	db *database
+	tx *db.KVTX // of BeginTx, the connection holds db.lock while it is set
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := minisql.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if s.Kind() != minisql.STMT_SELECT && s.Kind() != minisql.STMT_INSERT && s.Kind() != minisql.STMT_DELETE {
+		return nil, ErrTxControl
+	}
+	return &stmt{conn: c, stmt: s}, nil
+}
+
+func (c *conn) Close() error {
+	if c.tx != nil {
+		c.tx.Abort()
+		c.tx = nil
+		c.db.unlock()
+	}
+	return c.db.release()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	level := sql.IsolationLevel(opts.Isolation)
+	if level != sql.LevelDefault && level != sql.LevelSerializable {
+		return nil, ErrIsolation
+	}
+	if err := c.db.acquire(ctx); err != nil {
+		return nil, err
+	}
+	tx, err := c.db.kv.Begin()
+	if err != nil {
+		c.db.unlock()
+		return nil, err
+	}
+	c.tx = tx
+	return &transaction{conn: c}, nil
+}
+
+type transaction struct {
+	conn *conn
+}
+
+func (t *transaction) Commit() error {
+	c := t.conn
+	if c.tx == nil {
+		return sql.ErrTxDone
+	}
+	err := c.tx.Commit()
+	c.tx = nil
+	c.db.unlock()
+	return err
+}
+
+func (t *transaction) Rollback() error {
+	c := t.conn
+	if c.tx == nil {
+		return sql.ErrTxDone
+	}
+	c.tx.Abort()
+	c.tx = nil
+	c.db.unlock()
+	return nil
+}
+
+type stmt struct {
+	conn *conn
+	stmt *minisql.Stmt
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+// run the statement in the transaction of the connection, or in one of its own
+func (s *stmt) run(ctx context.Context, args []driver.NamedValue) (*minisql.Result, error) {
+	vals := make([]any, len(args))
+	for i, arg := range args {
+		vals[i] = arg.Value
+	}
+	c := s.conn
+	if c.tx != nil {
+		return s.stmt.Run(c.tx, vals)
+	}
+
+	if err := c.db.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer c.db.unlock()
+	tx, err := c.db.kv.Begin()
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.stmt.Run(tx, vals)
+	if err != nil {
+		tx.Abort()
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	res, err := s.run(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return result{res.RowsAffected}, nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := s.run(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{res: res}, nil
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
+
+type result struct {
+	affected int64
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, ErrNoInsertID
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
+
+// the rows of a result, the statement has already run
+type rows struct {
+	res  *minisql.Result
+	next int
+}
+
+func (r *rows) Columns() []string {
+	return r.res.Columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next == len(r.res.Rows) {
+		return io.EOF
+	}
+	for i, v := range r.res.Rows[r.next] {
+		dest[i] = v
+	}
+	r.next++
+	return nil
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(i int) string {
+	switch r.res.Types[i] {
+	case minisql.TYPE_INT:
+		return "INT8"
+	case minisql.TYPE_FLOAT:
+		return "FLOAT8"
+	}
+	return "TEXT"
+}
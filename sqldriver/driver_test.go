@@ -0,0 +1,161 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper: Open a database file in a fresh directory through database/sql
+func openDB(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	sqlDB, err := sql.Open("buildingdb", path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	return sqlDB, path
+}
+
+// Helper: The keys and values of a table
+func table(t *testing.T, q interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}, name string) map[string]string {
+	rows, err := q.Query("SELECT key, value FROM " + name)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		assert.NoError(t, rows.Scan(&k, &v))
+		out[k] = v
+	}
+	assert.NoError(t, rows.Err())
+	return out
+}
+
+func TestDriver(t *testing.T) {
+	t.Run("Exec and Query", func(t *testing.T) {
+		sqlDB, _ := openDB(t)
+
+		res, err := sqlDB.Exec("INSERT INTO users VALUES (?, ?), (?, ?)", "u1", "alice", "u2", []byte("bob"))
+		assert.NoError(t, err)
+		n, _ := res.RowsAffected()
+		assert.EqualValues(t, 2, n)
+		assert.Equal(t, map[string]string{"u1": "alice", "u2": "bob"}, table(t, sqlDB, "users"))
+
+		var count int64
+		var avg float64
+		_, err = sqlDB.Exec("INSERT INTO amounts VALUES ('a', ?), ('b', ?)", 1, 2.5)
+		assert.NoError(t, err)
+		assert.NoError(t, sqlDB.QueryRow("SELECT COUNT(*), AVG(value) FROM amounts").Scan(&count, &avg))
+		assert.EqualValues(t, 2, count)
+		assert.Equal(t, 1.75, avg)
+	})
+
+	t.Run("Prepared statements", func(t *testing.T) {
+		sqlDB, _ := openDB(t)
+
+		insert, err := sqlDB.Prepare("INSERT INTO t VALUES (?, ?)")
+		assert.NoError(t, err)
+		defer insert.Close()
+		for _, k := range []string{"a", "b", "c"} {
+			_, err := insert.Exec(k, "v"+k)
+			assert.NoError(t, err)
+		}
+
+		get, err := sqlDB.Prepare("SELECT value FROM t WHERE key = $1")
+		assert.NoError(t, err)
+		defer get.Close()
+		var v string
+		assert.NoError(t, get.QueryRow("b").Scan(&v))
+		assert.Equal(t, "vb", v)
+		assert.ErrorIs(t, get.QueryRow("x").Scan(&v), sql.ErrNoRows)
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		sqlDB, _ := openDB(t)
+
+		tx, err := sqlDB.Begin()
+		assert.NoError(t, err)
+		tx.Exec("INSERT INTO t VALUES ('k', 'v')")
+		assert.Equal(t, map[string]string{"k": "v"}, table(t, tx, "t"), "A transaction sees its writes")
+		assert.NoError(t, tx.Rollback())
+		assert.Empty(t, table(t, sqlDB, "t"))
+
+		tx, err = sqlDB.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+		assert.NoError(t, err)
+		tx.Exec("INSERT INTO t VALUES ('k', 'v')")
+		assert.NoError(t, tx.Commit())
+		assert.Equal(t, map[string]string{"k": "v"}, table(t, sqlDB, "t"))
+	})
+
+	t.Run("A statement waits for the transaction", func(t *testing.T) {
+		sqlDB, _ := openDB(t)
+
+		tx, err := sqlDB.Begin()
+		assert.NoError(t, err)
+		done := make(chan error)
+		go func() {
+			_, err := sqlDB.Exec("INSERT INTO t VALUES ('other', '2')")
+			done <- err
+		}()
+		tx.Exec("INSERT INTO t VALUES ('tx', '1')")
+		select {
+		case <-done:
+			t.Fatal("the statement ran during the transaction")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.NoError(t, tx.Commit())
+		assert.NoError(t, <-done)
+		assert.Equal(t, map[string]string{"tx": "1", "other": "2"}, table(t, sqlDB, "t"))
+	})
+
+	t.Run("Reopen the file", func(t *testing.T) {
+		sqlDB, path := openDB(t)
+		sqlDB.Exec("INSERT INTO t VALUES ('k', 'v')")
+		assert.NoError(t, sqlDB.Close())
+
+		sqlDB, err := sql.Open("buildingdb", path)
+		assert.NoError(t, err)
+		defer sqlDB.Close()
+		assert.Equal(t, map[string]string{"k": "v"}, table(t, sqlDB, "t"))
+	})
+
+	// Edge cases
+	t.Run("Errors", func(t *testing.T) {
+		sqlDB, _ := openDB(t)
+
+		_, err := sqlDB.Exec("BEGIN")
+		assert.ErrorIs(t, err, ErrTxControl)
+		_, err = sqlDB.Exec("SELEC 1")
+		assert.Error(t, err)
+		_, err = sqlDB.Exec("INSERT INTO t VALUES (?, ?)", "k")
+		assert.Error(t, err)
+		_, err = sqlDB.Exec("INSERT INTO t VALUES (?, ?)", "k", nil)
+		assert.Error(t, err)
+		res, _ := sqlDB.Exec("INSERT INTO t VALUES ('k', 'v')")
+		_, err = res.LastInsertId()
+		assert.ErrorIs(t, err, ErrNoInsertID)
+
+		_, err = sqlDB.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+		assert.ErrorIs(t, err, ErrIsolation)
+	})
+
+	t.Run("A canceled statement does not wait", func(t *testing.T) {
+		sqlDB, _ := openDB(t)
+		sqlDB.SetMaxOpenConns(2)
+
+		tx, err := sqlDB.Begin()
+		assert.NoError(t, err)
+		defer tx.Rollback()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = sqlDB.ExecContext(ctx, "INSERT INTO t VALUES ('k', 'v')")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
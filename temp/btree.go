@@ -379,7 +379,8 @@ func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
 	}
 	tree.del(kptr)
 
-	new := BNode(make([]byte, BTREE_PAGE_SIZE))
+	// can be bigger than a page, the caller splits it
+	new := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
 	// check for merging
 	mergeDir, sibling := shouldMerge(tree, node, idx, updated)
 	switch {
@@ -397,7 +398,9 @@ func nodeDelete(tree *BTree, node BNode, idx uint16, key []byte) BNode {
 		assert(node.nkeys() == 1 && idx == 0) // 1 empty child but no sibling
 		new.setHeader(BNODE_NODE, 0)          // the parent becomes empty too
 	case mergeDir == 0 && updated.nkeys() > 0: // no merge
-		nodeReplaceKidN(tree, new, node, idx, updated)
+		// the kid may have grown: its first key is now the next key, which can be longer
+		nsplit, split := nodeSplit3(updated)
+		nodeReplaceKidN(tree, new, node, idx, split[:nsplit]...)
 	}
 	return new
 }
@@ -460,8 +463,14 @@ func (tree *BTree) Insert(key []byte, val []byte) error {
 	}
 
 	node := treeInsert(tree, tree.get(tree.root), key, val)
-	nsplit, split := nodeSplit3(node)
 	tree.del(tree.root)
+	tree.setRoot(node)
+	return nil
+}
+
+// store the new root node, split it if needed
+func (tree *BTree) setRoot(node BNode) {
+	nsplit, split := nodeSplit3(node)
 	if nsplit > 1 {
 		// the root was split, add a new level.
 		root := BNode(make([]byte, BTREE_PAGE_SIZE))
@@ -474,7 +483,6 @@ func (tree *BTree) Insert(key []byte, val []byte) error {
 	} else {
 		tree.root = tree.new(split[0])
 	}
-	return nil
 }
 
 func (tree *BTree) Delete(key []byte) (bool, error) {
@@ -496,7 +504,7 @@ func (tree *BTree) Delete(key []byte) (bool, error) {
 		// remove a level
 		tree.root = updated.getPtr(0)
 	} else {
-		tree.root = tree.new(updated)
+		tree.setRoot(updated)
 	}
 	return true, nil
 }
@@ -523,3 +531,52 @@ func (tree *BTree) Get(key []byte) ([]byte, bool) {
 	}
 	return nodeGetKey(tree, tree.get(tree.root), key)
 }
+
+// create a tree on top of a page store, the callbacks work like the fields of BTree
+func NewBTree(get func(uint64) []byte, new func([]byte) uint64, del func(uint64)) *BTree {
+	return &BTree{get: get, new: new, del: del}
+}
+
+// the root page, 0 for an empty tree
+func (tree *BTree) Root() uint64 {
+	return tree.root
+}
+
+// call fn for the keys in [start, end) in order until it returns false, nil end means no
+// upper bound
+func (tree *BTree) Scan(start, end []byte, fn func(key, val []byte) bool) {
+	if tree.root != 0 {
+		nodeScan(tree, tree.get(tree.root), start, end, fn)
+	}
+}
+
+// returns false once fn asked to stop or the end was reached
+func nodeScan(tree *BTree, node BNode, start, end []byte, fn func(key, val []byte) bool) bool {
+	for i := uint16(0); i < node.nkeys(); i++ {
+		key := node.getKey(i)
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			return false
+		}
+		switch node.btype() {
+		case BNODE_LEAF:
+			// skip the dummy key and the keys before start
+			if len(key) == 0 || bytes.Compare(key, start) < 0 {
+				continue
+			}
+			if !fn(key, node.getVal(i)) {
+				return false
+			}
+		case BNODE_NODE:
+			// the kid covers [key, next key), skip it if start is past that
+			if i+1 < node.nkeys() && bytes.Compare(node.getKey(i+1), start) <= 0 {
+				continue
+			}
+			if !nodeScan(tree, tree.get(node.getPtr(i)), start, end, fn) {
+				return false
+			}
+		default:
+			panic("bad node!")
+		}
+	}
+	return true
+}
@@ -155,10 +155,10 @@ func (c *C) collectKeysRecursive(ptr uint64, keys *[][]byte) {
 	node := BNode(c.tree.get(ptr))
 
 	if node.btype() == BNODE_LEAF {
-		// Collect keys from leaf (skip sentinel at index 0)
-		for i := uint16(1); i < node.nkeys(); i++ {
+		// Collect keys from leaf, the sentinel is only the first key of the first leaf
+		for i := uint16(0); i < node.nkeys(); i++ {
 			key := node.getKey(i)
-			if len(key) > 0 { // Skip empty keys
+			if len(key) > 0 { // Skip the empty sentinel key
 				*keys = append(*keys, key)
 			}
 		}